@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+)
+
+type priority int
+
+const (
+	priorityLow priority = iota
+	priorityNormal
+	priorityHigh
+	numPriorities
+)
+
+// priorityWeights is how many queued requests of each priority are admitted
+// per scheduling round. Lower priorities keep a share, so a steady stream of
+// high-priority traffic cannot starve them.
+var priorityWeights = [numPriorities]int{
+	priorityLow:    1,
+	priorityNormal: 2,
+	priorityHigh:   4,
+}
+
+func parsePriority(s string) priority {
+	switch s {
+	case config.PriorityHigh:
+		return priorityHigh
+	case config.PriorityLow:
+		return priorityLow
+	default:
+		return priorityNormal
+	}
+}
+
+// admission limits how many chat completions run at once. Requests beyond
+// the limit wait in a queue per priority and are admitted by weighted round
+// robin: each round, a priority may admit up to its weight in requests, and
+// the round restarts once every waiting priority has used its share.
+type admission struct {
+	limit int
+
+	mu      sync.Mutex
+	active  int
+	queues  [numPriorities][]chan struct{}
+	credits [numPriorities]int
+
+	priorities map[string]priority // client ID -> priority
+}
+
+func newAdmission(cfg *config.Config) *admission {
+	a := &admission{
+		limit:      cfg.MaxConcurrentRequests,
+		credits:    priorityWeights,
+		priorities: make(map[string]priority, len(cfg.Clients)),
+	}
+	for _, client := range cfg.Clients {
+		a.priorities[api.ClientIdentity(client.Key)] = parsePriority(client.Priority)
+	}
+	return a
+}
+
+// acquire waits for a slot for the calling client. Each successful acquire
+// must be followed by release.
+func (a *admission) acquire(ctx context.Context) error {
+	if a.limit <= 0 {
+		return nil
+	}
+
+	p, ok := a.priorities[api.ClientIDFromContext(ctx)]
+	if !ok {
+		p = priorityNormal
+	}
+
+	a.mu.Lock()
+	if a.active < a.limit && a.waitingLocked() == 0 {
+		a.active++
+		a.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	a.queues[p] = append(a.queues[p], ready)
+	a.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	a.mu.Lock()
+	for i, ch := range a.queues[p] {
+		if ch == ready {
+			a.queues[p] = append(a.queues[p][:i], a.queues[p][i+1:]...)
+			a.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	a.mu.Unlock()
+	// Admitted while giving up: pass the slot on.
+	a.release()
+	return ctx.Err()
+}
+
+// release frees a slot, handing it straight to the next queued request.
+func (a *admission) release() {
+	if a.limit <= 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if next := a.nextLocked(); next != nil {
+		close(next)
+		return
+	}
+	a.active--
+}
+
+func (a *admission) nextLocked() chan struct{} {
+	if a.waitingLocked() == 0 {
+		return nil
+	}
+	for {
+		for p := numPriorities - 1; p >= 0; p-- {
+			if len(a.queues[p]) > 0 && a.credits[p] > 0 {
+				a.credits[p]--
+				next := a.queues[p][0]
+				a.queues[p] = a.queues[p][1:]
+				return next
+			}
+		}
+		a.credits = priorityWeights
+	}
+}
+
+func (a *admission) waitingLocked() int {
+	n := 0
+	for _, q := range a.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// clientConcurrency caps the chat completions each client has open at once,
+// queued ones included, so one misbehaving client can't take every slot.
+// Requests over the cap are refused rather than queued. Peers' requests,
+// which carry no client ID, are not counted.
+type clientConcurrency struct {
+	defaultLimit int            // for clients without their own, 0 = unlimited
+	limits       map[string]int // client ID -> limit
+	names        map[string]string
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newClientConcurrency(cfg *config.Config) *clientConcurrency {
+	c := &clientConcurrency{
+		defaultLimit: cfg.MaxConcurrentPerClient,
+		limits:       make(map[string]int, len(cfg.Clients)),
+		names:        make(map[string]string, len(cfg.Clients)),
+		active:       make(map[string]int),
+	}
+	for _, client := range cfg.Clients {
+		id := api.ClientIdentity(client.Key)
+		c.names[id] = client.Name
+		if client.MaxConcurrent > 0 {
+			c.limits[id] = client.MaxConcurrent
+		}
+	}
+	return c
+}
+
+func (c *clientConcurrency) limit(clientID string) int {
+	if limit, ok := c.limits[clientID]; ok {
+		return limit
+	}
+	return c.defaultLimit
+}
+
+// acquire takes one of the calling client's slots, refusing the request if
+// it has none left. Each successful acquire must be followed by release.
+func (c *clientConcurrency) acquire(ctx context.Context) error {
+	clientID := api.ClientIDFromContext(ctx)
+	if clientID == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	limit := c.limit(clientID)
+	if limit > 0 && c.active[clientID] >= limit {
+		return fmt.Errorf("%w: at most %d concurrent chat completions per client", api.ErrTooManyRequests, limit)
+	}
+	c.active[clientID]++
+	return nil
+}
+
+func (c *clientConcurrency) release(ctx context.Context) {
+	clientID := api.ClientIDFromContext(ctx)
+	if clientID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active[clientID]--; c.active[clientID] <= 0 {
+		delete(c.active, clientID)
+	}
+}
+
+// snapshot lists the clients with completions open, by client ID.
+func (c *clientConcurrency) snapshot() []api.ClientConcurrency {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clients := make([]api.ClientConcurrency, 0, len(c.active))
+	for id, n := range c.active {
+		clients = append(clients, api.ClientConcurrency{
+			ClientID: id,
+			Name:     c.names[id],
+			InFlight: n,
+			Limit:    c.limit(id),
+		})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ClientID < clients[j].ClientID
+	})
+	return clients
+}
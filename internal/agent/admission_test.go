@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+)
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		in   string
+		want priority
+	}{
+		{in: config.PriorityHigh, want: priorityHigh},
+		{in: config.PriorityNormal, want: priorityNormal},
+		{in: config.PriorityLow, want: priorityLow},
+		{in: "", want: priorityNormal},
+	}
+	for _, tt := range tests {
+		if got := parsePriority(tt.in); got != tt.want {
+			t.Errorf("parsePriority(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// clientContext is a request context authenticated with key.
+func clientContext(key string) context.Context {
+	return api.WithClientID(context.Background(), api.ClientIdentity(key))
+}
+
+// TestAdmissionOrder fills a one-slot admission, queues requests of each
+// priority behind it and checks the order they are admitted in as slots are
+// released.
+func TestAdmissionOrder(t *testing.T) {
+	a := newAdmission(&config.Config{
+		MaxConcurrentRequests: 1,
+		Clients: []config.ClientKey{
+			{Name: "ui", Key: "high", Priority: config.PriorityHigh},
+			{Name: "batch", Key: "low", Priority: config.PriorityLow},
+		},
+	})
+	if err := a.acquire(context.Background()); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	// Six of each priority, the --api-key's (no client ID) being normal.
+	queued := map[string]context.Context{"high": clientContext("high"), "normal": context.Background(), "low": clientContext("low")}
+	admitted := make(chan string)
+	for _, name := range []string{"low", "normal", "high"} {
+		for i := 0; i < 6; i++ {
+			go func(name string) {
+				if err := a.acquire(queued[name]); err != nil {
+					t.Errorf("acquire: %v", err)
+				}
+				admitted <- name
+			}(name)
+		}
+	}
+	waitQueued(t, a, 18)
+
+	// Each round admits up to 4 high, 2 normal and 1 low.
+	want := []string{
+		"high", "high", "high", "high", "normal", "normal", "low",
+		"high", "high", "normal", "normal", "low",
+		"normal", "normal", "low",
+		"low", "low", "low",
+	}
+	for i, w := range want {
+		a.release()
+		if got := <-admitted; got != w {
+			t.Fatalf("admission %d went to %s, want %s", i, got, w)
+		}
+	}
+	if a.active != 1 {
+		t.Fatalf("%d active, want the last one admitted", a.active)
+	}
+	a.release()
+	if a.active != 0 {
+		t.Fatalf("%d active after releasing everything", a.active)
+	}
+}
+
+// waitQueued waits until n requests are queued on a.
+func waitQueued(t *testing.T, a *admission, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.Lock()
+		waiting := a.waitingLocked()
+		a.mu.Unlock()
+		if waiting == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", waiting, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdmissionLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		acquires  int
+		wantQueue int
+	}{
+		{name: "unlimited", limit: 0, acquires: 5},
+		{name: "within the limit", limit: 3, acquires: 3},
+		{name: "over the limit", limit: 3, acquires: 5, wantQueue: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdmission(&config.Config{MaxConcurrentRequests: tt.limit})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan error, tt.acquires)
+			for i := 0; i < tt.acquires; i++ {
+				go func() { done <- a.acquire(ctx) }()
+			}
+			for i := 0; i < tt.acquires-tt.wantQueue; i++ {
+				if err := <-done; err != nil {
+					t.Fatalf("acquire: %v", err)
+				}
+			}
+			waitQueued(t, a, tt.wantQueue)
+
+			// Giving up leaves the queue, and frees nobody else's slot.
+			cancel()
+			for i := 0; i < tt.wantQueue; i++ {
+				if err := <-done; !errors.Is(err, context.Canceled) {
+					t.Fatalf("queued acquire got %v, want it cancelled", err)
+				}
+			}
+			waitQueued(t, a, 0)
+			if tt.limit > 0 && a.active != tt.acquires-tt.wantQueue {
+				t.Fatalf("%d active, want %d", a.active, tt.acquires-tt.wantQueue)
+			}
+		})
+	}
+}
+
+// TestAdmissionCancelAfterAdmit has a queued request give up just as it is
+// handed a slot, and checks the slot passes to the next in line rather than
+// being lost.
+func TestAdmissionCancelAfterAdmit(t *testing.T) {
+	a := newAdmission(&config.Config{MaxConcurrentRequests: 1})
+	a.acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() { first <- a.acquire(ctx) }()
+	waitQueued(t, a, 1)
+	second := make(chan error, 1)
+	go func() { second <- a.acquire(context.Background()) }()
+	waitQueued(t, a, 2)
+
+	// Hand the first waiter the slot and cancel it before it can notice;
+	// whichever it sees, its slot must not be lost.
+	a.mu.Lock()
+	cancel()
+	close(a.nextLocked())
+	a.mu.Unlock()
+
+	if err := <-first; err == nil {
+		a.release()
+	}
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatalf("second acquire: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("slot lost when its waiter gave up")
+	}
+}
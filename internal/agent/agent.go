@@ -1,100 +1,399 @@
 package agent
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/denizumutdereli/agents-p2p-network/internal/api"
 	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/events"
+	"github.com/denizumutdereli/agents-p2p-network/internal/logstream"
+	"github.com/denizumutdereli/agents-p2p-network/internal/mockupstream"
 	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
 	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/http/httpproxy"
 )
 
 type Agent struct {
 	config     *config.Config
+	retries    config.RetryConfig // config.Retry with defaults filled in
 	p2pHost    *p2p.Host
 	apiServer  *api.Server
 	logger     *zap.Logger
 	httpClient *http.Client
+	events     *events.Bus
+	usage      *usageTracker
+	providers  map[string]Provider
+	breakers   map[string]*circuitBreaker
+	admission  *admission
+	policies   policies
+	tags       tagVocabulary
+	subscribed *subscription
+	ctx        context.Context
+	mock       *mockupstream.Server
 
+	announcements *announcementStore
+	identity      *localIdentity
+	logs          *logstream.Hub
+	reputation    *reputationStore
+	registrations *registrationTracker
+	stats         *runStats
+	concurrency   *clientConcurrency
+	latency       *latencyTrackers
+	topology      *topologyStore
+
+	providerModels *providerModels
+
+	// registryMu guards agentRegistry, peerKinds and probing, which P2P
+	// handlers write while HTTP handlers read them.
+	registryMu    sync.RWMutex
 	agentRegistry map[string]*AgentRecord
+	peerKinds     map[string]string
+	probing       map[peer.ID]bool
 }
 
+const (
+	probeTimeout      = 3 * time.Second
+	rediscoverTimeout = 30 * time.Second
+	eventBufferSize   = 64
+	logHistorySize    = 500
+)
+
+// AgentRecord is a peer's registration. Records are replaced, never modified,
+// when an agent re-registers, so they can be read without holding registryMu.
 type AgentRecord struct {
-	PeerID   peer.ID
-	Name     string
-	Endpoint string
-	Models   []string
+	PeerID          peer.ID
+	Name            string
+	Endpoint        string
+	Models          []string
+	Labels          map[string]string
+	UpstreamHealthy bool
 }
 
 func New(cfg *config.Config) (*Agent, error) {
-	logger, _ := zap.NewProduction()
+	logs := logstream.NewHub(logHistorySize)
+	logger, _ := zap.NewProduction(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, logs.Core(zapcore.DebugLevel))
+	}))
+
+	providers := buildProviders(cfg)
+	httpClient := newUpstreamClient(cfg)
+	retries := cfg.Retry.WithDefaults()
 
 	a := &Agent{
 		config:        cfg,
+		retries:       retries,
 		logger:        logger,
-		httpClient:    &http.Client{Timeout: 30 * time.Second},
+		httpClient:    httpClient,
+		events:        events.NewBus(eventBufferSize),
+		usage:         newUsageTracker(cfg.ModelPrices(), logger),
+		providers:     newProviders(providers, httpClient),
+		breakers:      buildBreakers(cfg, providers),
+		admission:     newAdmission(cfg),
+		policies:      newPolicies(cfg.Clients),
+		tags:          newTagVocabulary(cfg.AnnounceTags),
+		subscribed:    newSubscription(cfg.SubscribeTypes, cfg.SubscribeTags),
+		ctx:           context.Background(),
 		agentRegistry: make(map[string]*AgentRecord),
+		peerKinds:     make(map[string]string),
+		probing:       make(map[peer.ID]bool),
+		announcements: newAnnouncementStore(),
+		identity:      newLocalIdentity(cfg),
+		logs:          logs,
+		reputation:    newReputationStore(),
+		registrations: newRegistrationTracker(retries.Registration),
+		stats:         newRunStats(),
+		concurrency:   newClientConcurrency(cfg),
+		latency:       newLatencyTrackers(),
+		topology:      newTopologyStore(),
+
+		providerModels: newProviderModels(),
 	}
 
 	return a, nil
 }
 
-func (a *Agent) Start(ctx context.Context) error {
-	var err error
-	a.p2pHost, err = p2p.NewHost(ctx, a.config.P2PPort, a.logger)
-	if err != nil {
-		return fmt.Errorf("failed to create P2P host: %w", err)
+// newUpstreamClient builds the provider HTTP client. It carries no overall
+// timeout of its own: the header timeout bounds connect and time to first
+// byte, while complete applies a per-request deadline that depends on
+// whether the call streams.
+func newUpstreamClient(cfg *config.Config) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.UpstreamHeaderTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = cfg.UpstreamHeaderTimeout
+	transport.ResponseHeaderTimeout = cfg.UpstreamHeaderTimeout
+
+	if cfg.UpstreamProxy != "" {
+		transport.Proxy = upstreamProxy(cfg)
 	}
 
-	a.p2pHost.SetLocalName(a.config.AgentName)
-	a.p2pHost.SetMessageHandler(a.handleP2PMessage)
+	return &http.Client{Transport: transport}
+}
 
-	if err := a.p2pHost.StartMDNS(); err != nil {
-		a.logger.Warn("Failed to start mDNS discovery", zap.Error(err))
+// upstreamProxy routes provider calls through --upstream-proxy. Hosts listed
+// in --upstream-no-proxy or NO_PROXY, and loopback addresses such as a local
+// Ollama or the mock upstream, are dialled directly.
+func upstreamProxy(cfg *config.Config) func(*http.Request) (*url.URL, error) {
+	noProxy := append([]string{}, cfg.UpstreamNoProxy...)
+	if env := httpproxy.FromEnvironment().NoProxy; env != "" {
+		noProxy = append(noProxy, env)
 	}
 
-	a.p2pHost.StartDHTDiscovery()
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  cfg.UpstreamProxy,
+		HTTPSProxy: cfg.UpstreamProxy,
+		NoProxy:    strings.Join(noProxy, ","),
+	}).ProxyFunc()
 
-	if a.config.BootstrapPeer != "" {
-		if err := a.p2pHost.ConnectBootstrap(a.config.BootstrapPeer); err != nil {
-			a.logger.Warn("Failed to connect to bootstrap peer", zap.Error(err))
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+func (a *Agent) Start(ctx context.Context) error {
+	a.ctx = ctx
+
+	if a.config.BootstrapNode {
+		return a.startHost(ctx, a.handleSeedMessage, nil)
+	}
+
+	var err error
+	if a.config.MockUpstream {
+		a.mock, err = mockupstream.Start(a.logger)
+		if err != nil {
+			return err
 		}
+		provider := a.providers[config.DefaultProvider].Config()
+		provider.BaseURL = a.mock.BaseURL()
+		a.providers[config.DefaultProvider] = newProvider(provider, a.httpClient)
+		a.logger.Warn("Mock upstream enabled, completions are fake. Do not use in production",
+			zap.String("base_url", provider.BaseURL))
+	}
+
+	if err := a.startHost(ctx, a.handleP2PMessage, a.handleP2PStream); err != nil {
+		return err
+	}
+
+	if a.serves() {
+		a.identity.mu.RLock()
+		a.p2pHost.SetAdvertisedModels(a.identity.models)
+		a.identity.mu.RUnlock()
 	}
 
-	a.apiServer = api.NewServer(a.config.HTTPPort, a.config.APIKey, a, a.logger)
+	a.apiServer = api.NewServer(api.Options{
+		Port:           a.config.HTTPPort,
+		APIKey:         a.config.APIKey,
+		AdminKey:       a.config.AdminKey,
+		ClientKeys:     a.clientKeys(),
+		IdempotencyTTL: a.config.IdempotencyTTL,
+		MaxBodySize:    a.config.MaxBodySize,
+
+		IdempotencyTimeout: a.config.UpstreamTimeout,
+
+		ReadHeaderTimeout: a.config.HTTPReadHeaderTimeout,
+		WriteTimeout:      a.config.HTTPWriteTimeout,
+		IdleTimeout:       a.config.HTTPIdleTimeout,
+		HTTP2:             a.config.HTTP2,
+	}, a, a.logger)
 	if err := a.apiServer.Start(); err != nil {
+		// Don't linger on the network as a node whose API is down.
+		a.p2pHost.Close()
 		return fmt.Errorf("failed to start API server: %w", err)
 	}
 
-	a.broadcastRegistration(ctx)
+	if len(a.config.Webhooks) > 0 {
+		notifier := events.NewWebhookNotifier(a.config.Webhooks, a.config.WebhookSecret, a.retries.Webhook, a.logger)
+		go notifier.Run(ctx, a.events)
+	}
+
+	if a.serves() {
+		go a.broadcastRegistration(ctx)
+		go a.maintainRegistration(ctx)
+	}
+	if a.listsModels() {
+		go a.watchProviderModels(ctx)
+	}
+	go a.gossipReputation(ctx)
+	if a.config.TopologyDepth > 0 {
+		go a.gossipTopology(ctx)
+	}
 
 	return nil
 }
 
-func (a *Agent) Stop() {
+// ready reports whether the agent can serve requests: the host is up and
+// the API server is accepting connections.
+func (a *Agent) ready() bool {
+	return a.p2pHost != nil && a.apiServer != nil && a.apiServer.Serving()
+}
+
+// serves reports whether the agent answers chat requests from peers and
+// registers with them, which --role client turns off.
+func (a *Agent) serves() bool {
+	return a.config.Role != config.RoleClient
+}
+
+// consumes reports whether the agent sends requests to other agents, which
+// --role server turns off.
+func (a *Agent) consumes() bool {
+	return a.config.Role != config.RoleServer
+}
+
+// errClientOnly refuses chat requests from peers under --role client.
+var errClientOnly = errors.New("this agent runs with --role client and does not serve chat completions")
+
+func (a *Agent) clientKeys() []string {
+	keys := make([]string, 0, len(a.config.Clients))
+	for _, client := range a.config.Clients {
+		keys = append(keys, client.Key)
+	}
+	return keys
+}
+
+// Stop shuts the agent down and logs a report of the run. Requests still in
+// flight once the API server and host have stopped count as dropped.
+func (a *Agent) Stop() ShutdownReport {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	peersConnected := 0
+	if a.p2pHost != nil {
+		peersConnected = a.connectedPeers()
+	}
+
 	if a.apiServer != nil {
 		a.apiServer.Stop(ctx)
 	}
 	if a.p2pHost != nil {
 		a.p2pHost.Close()
 	}
+	report := a.shutdownReport(peersConnected)
+	if a.mock != nil {
+		a.mock.Close(ctx)
+	}
+
+	report.log(a.logger)
+	return report
 }
 
 func (a *Agent) PeerID() string {
 	return a.p2pHost.ID().String()
 }
 
+func (a *Agent) MultiAddrs() []string {
+	return a.p2pHost.MultiAddrs()
+}
+
+// startHost creates the P2P host and joins the network via mDNS, the DHT and
+// the bootstrap peer.
+func (a *Agent) startHost(ctx context.Context, handler p2p.MessageHandler, streamer p2p.StreamHandler) error {
+	// A seed node exists to answer DHT queries, so it always serves.
+	dhtMode := a.config.DHTMode
+	if a.config.BootstrapNode {
+		dhtMode = p2p.DHTModeServer
+	}
+
+	var blocked []peer.ID
+	for _, raw := range a.config.BlockedPeers {
+		id, err := peer.Decode(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("invalid blocked peer %q: %w", raw, err)
+		}
+		blocked = append(blocked, id)
+	}
+
+	var err error
+	a.p2pHost, err = p2p.NewHost(ctx, p2p.Options{
+		Port:         a.config.P2PPort,
+		Security:     a.config.Security,
+		ReplayWindow: a.config.ReplayWindow,
+		NATPortMap:   a.config.NATPortMap,
+		RelayService: a.config.RelayService,
+		DHTMode:      dhtMode,
+		NoDHT:        a.config.NoDHT,
+		Sessions:     a.config.PersistentStreams,
+		PeerExchange: a.config.PeerExchange,
+		BlockedPeers: blocked,
+		Retry: p2p.RetryPolicies{
+			Send:      a.retries.Send,
+			Session:   a.retries.Session,
+			Reconnect: a.retries.Reconnect,
+			Flap:      a.retries.Flap,
+		},
+	}, a.logger)
+	if err != nil {
+		return fmt.Errorf("failed to create P2P host: %w", err)
+	}
+
+	a.p2pHost.SetLocalName(a.identity.name)
+	a.p2pHost.SetMessageHandler(handler)
+	a.p2pHost.SetStreamHandler(streamer)
+	a.p2pHost.SetEventBus(a.events)
+	a.p2pHost.SetMaxStreamsPerPeer(a.config.MaxStreamsPerPeer)
+	a.p2pHost.SetMaxMessageSize(a.config.MaxMessageSize)
+	a.p2pHost.SetBroadcastFanout(a.config.BroadcastFanout)
+	a.p2pHost.SetHandlerTimeout(a.config.HandlerTimeout)
+	a.p2pHost.SetLogPeerIDs(a.config.LogPeerIDs)
+
+	var pins []peer.AddrInfo
+	for _, raw := range a.config.PinnedPeers {
+		info, err := p2p.ParsePinnedPeer(raw)
+		if err != nil {
+			return err
+		}
+		pins = append(pins, info)
+	}
+	a.p2pHost.PinPeers(pins)
+
+	if err := a.p2pHost.StartMDNS(); err != nil {
+		a.logger.Warn("Failed to start mDNS discovery", zap.Error(err))
+	}
+
+	a.p2pHost.StartDHTDiscovery()
+	a.p2pHost.StartPeerExchange()
+
+	if a.config.BootstrapPeer != "" {
+		if err := a.p2pHost.ConnectBootstrap(a.config.BootstrapPeer); err != nil {
+			a.logger.Warn("Failed to connect to bootstrap peer", zap.Error(err))
+		}
+	}
+
+	a.p2pHost.WatchNetwork(a.onNetworkChange)
+	return nil
+}
+
+// onNetworkChange rediscovers peers after a sleep or a change of network,
+// as POST /v1/admin/rediscover does.
+func (a *Agent) onNetworkChange() {
+	ctx, cancel := context.WithTimeout(a.ctx, rediscoverTimeout)
+	defer cancel()
+
+	var err error
+	if a.config.BootstrapNode {
+		err = a.p2pHost.Rediscover(ctx)
+	} else {
+		err = a.HandleRediscover(ctx)
+	}
+	if err != nil {
+		a.logger.Warn("Rediscovery after network change failed", zap.Error(err))
+	}
+}
+
 func (a *Agent) handleP2PMessage(ctx context.Context, from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
 	switch msg.Type {
 	case p2p.MessageTypeRegister:
@@ -105,9 +404,13 @@ func (a *Agent) handleP2PMessage(ctx context.Context, from peer.ID, msg *p2p.Mes
 		return a.handlePing(from, msg)
 	case p2p.MessageTypeAnnounce:
 		return a.handleAnnounce(from, msg)
+	case p2p.MessageTypeReputation:
+		return a.handleReputation(from, msg)
+	case p2p.MessageTypeTopology:
+		return a.handleTopology(from, msg)
 	default:
-		a.logger.Warn("Unknown message type", zap.String("type", string(msg.Type)))
-		return nil, nil
+		a.logger.Warn("Unknown message type", a.p2pHost.PeerField("from", from), zap.String("type", string(msg.Type)))
+		return nil, fmt.Errorf("%w: %s", p2p.ErrUnsupportedMessage, msg.Type)
 	}
 }
 
@@ -117,46 +420,109 @@ func (a *Agent) handleRegister(from peer.ID, msg *p2p.Message) (*p2p.Message, er
 		return nil, err
 	}
 
-	// Check for duplicate agent name
-	if err := a.p2pHost.RegisterAgentName(payload.AgentName, from); err != nil {
-		a.logger.Warn("Duplicate agent name rejected", 
-			zap.String("name", payload.AgentName), 
-			zap.String("peer_id", from.String()),
-			zap.Error(err))
-		
-		errPayload, _ := json.Marshal(map[string]string{"error": err.Error()})
-		return &p2p.Message{
-			Type:    p2p.MessageTypeError,
-			From:    a.p2pHost.ID().String(),
-			Payload: errPayload,
-		}, nil
+	if err := a.registerAgent(from, &payload); err != nil {
+		return a.p2pHost.ErrorMessage(err.Error()), nil
 	}
 
-	a.agentRegistry[from.String()] = &AgentRecord{
-		PeerID:   from,
-		Name:     payload.AgentName,
-		Endpoint: payload.Endpoint,
-		Models:   payload.Models,
-	}
-
-	a.logger.Info("Agent registered", zap.String("name", payload.AgentName), zap.String("peer_id", from.String()))
-
 	return &p2p.Message{
 		Type: p2p.MessageTypePong,
 		From: a.p2pHost.ID().String(),
 	}, nil
 }
 
+func (a *Agent) registerAgent(from peer.ID, payload *p2p.RegisterPayload) error {
+	// The name claim and the record are updated under one lock, so two
+	// registrations from the same peer can't leave them naming different
+	// agents.
+	a.registryMu.Lock()
+	defer a.registryMu.Unlock()
+
+	if err := a.p2pHost.ClaimName(payload.AgentName, from); err != nil {
+		if errors.Is(err, p2p.ErrNameTaken) {
+			a.logger.Warn("Duplicate agent name rejected",
+				zap.String("name", payload.AgentName),
+				a.p2pHost.PeerField("peer", from),
+				zap.Error(err))
+		} else {
+			a.logger.Debug("Registration not accepted", a.p2pHost.PeerField("peer", from), zap.Error(err))
+		}
+		return err
+	}
+
+	a.agentRegistry[from.String()] = &AgentRecord{
+		PeerID:          from,
+		Name:            payload.AgentName,
+		Endpoint:        payload.Endpoint,
+		Models:          payload.Models,
+		Labels:          payload.Labels,
+		UpstreamHealthy: payload.UpstreamHealthy == nil || *payload.UpstreamHealthy,
+	}
+
+	a.logger.Info("Agent registered", zap.String("name", payload.AgentName), a.p2pHost.PeerField("peer", from))
+	a.events.Publish(events.Event{Type: events.TypeAgentRegistered, PeerID: from.String(), Data: payload})
+	return nil
+}
+
+// refuseChat is the reply to a chat request under --role client.
+func (a *Agent) refuseChat(from peer.ID) *p2p.Message {
+	a.logger.Debug("Refusing chat request, this agent is client-only", a.p2pHost.PeerField("from", from))
+	return a.p2pHost.ErrorMessage(errClientOnly.Error())
+}
+
+// agentRecord looks up a registered agent by peer ID.
+func (a *Agent) agentRecord(peerID string) (*AgentRecord, bool) {
+	a.registryMu.RLock()
+	defer a.registryMu.RUnlock()
+
+	record, exists := a.agentRegistry[peerID]
+	return record, exists
+}
+
+// agentRecords returns the registered agents, in no particular order.
+func (a *Agent) agentRecords() []*AgentRecord {
+	a.registryMu.RLock()
+	defer a.registryMu.RUnlock()
+
+	records := make([]*AgentRecord, 0, len(a.agentRegistry))
+	for _, record := range a.agentRegistry {
+		records = append(records, record)
+	}
+	return records
+}
+
+// peerKind is what probing found a peer to be, if it has been probed.
+func (a *Agent) peerKind(peerID string) (string, bool) {
+	a.registryMu.RLock()
+	defer a.registryMu.RUnlock()
+
+	kind, probed := a.peerKinds[peerID]
+	return kind, probed
+}
+
+func (a *Agent) setPeerKind(peerID, kind string) {
+	a.registryMu.Lock()
+	defer a.registryMu.Unlock()
+
+	a.peerKinds[peerID] = kind
+}
+
 func (a *Agent) handleChatRequest(ctx context.Context, from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+	if !a.serves() {
+		return a.refuseChat(from), nil
+	}
+
 	var chatReq api.ChatCompletionRequest
 	if err := json.Unmarshal(msg.Payload, &chatReq); err != nil {
 		return nil, err
 	}
 
-	resp, err := a.forwardToOpenAI(ctx, &chatReq)
+	done := a.stats.track(true)
+	resp, err := a.completeWithFallback(ctx, &chatReq, false)
+	done(err)
 	if err != nil {
 		return nil, err
 	}
+	a.stats.recordPeerUsage(&resp.Usage)
 
 	respPayload, _ := json.Marshal(resp)
 	return &p2p.Message{
@@ -167,113 +533,333 @@ func (a *Agent) handleChatRequest(ctx context.Context, from peer.ID, msg *p2p.Me
 	}, nil
 }
 
+// handlePing answers with our registration details so that peers probing an
+// unknown connection learn who we are in a single round trip.
 func (a *Agent) handlePing(from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+	payloadBytes, _ := json.Marshal(a.registrationPayload())
 	return &p2p.Message{
-		Type: p2p.MessageTypePong,
-		From: a.p2pHost.ID().String(),
+		Type:    p2p.MessageTypePong,
+		From:    a.p2pHost.ID().String(),
+		Payload: payloadBytes,
 	}, nil
 }
 
+func (a *Agent) pingPeer(ctx context.Context, peerID peer.ID) (*p2p.Message, error) {
+	msg := &p2p.Message{
+		Type: p2p.MessageTypePing,
+		From: a.p2pHost.ID().String(),
+	}
+
+	started := time.Now()
+	resp, err := a.p2pHost.SendMessage(ctx, peerID, msg)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Type != p2p.MessageTypePong {
+		return nil, fmt.Errorf("unexpected ping response from %s", peerID)
+	}
+	a.latency.ping.Record(peerID.String(), time.Since(started))
+	return resp, nil
+}
+
+// probeUnregistered pings connected peers we hold no registration for. Agents
+// reply with their registration details; peers that don't answer (DHT nodes,
+// relays) are remembered as plain peers so they aren't probed again.
+func (a *Agent) probeUnregistered(ctx context.Context, peers []*p2p.PeerInfo) {
+	pending := a.claimProbes(peers)
+	if len(pending) == 0 {
+		return
+	}
+	defer a.releaseProbes(pending)
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	type probeResult struct {
+		peerID peer.ID
+		resp   *p2p.Message
+		err    error
+	}
+	results := make(chan probeResult, len(pending))
+	for _, peerID := range pending {
+		go func(pid peer.ID) {
+			resp, err := a.pingPeer(ctx, pid)
+			results <- probeResult{peerID: pid, resp: resp, err: err}
+		}(peerID)
+	}
+
+	for range pending {
+		r := <-results
+		a.recordProbe(r.peerID, r.resp, r.err)
+	}
+}
+
+// claimProbes picks the connected peers that have neither registered nor been
+// probed, skipping any another caller is already probing, and marks them as
+// being probed.
+func (a *Agent) claimProbes(peers []*p2p.PeerInfo) []peer.ID {
+	a.registryMu.Lock()
+	defer a.registryMu.Unlock()
+
+	var pending []peer.ID
+	for _, p := range peers {
+		if !p.Connected || a.probing[p.ID] {
+			continue
+		}
+		if _, exists := a.agentRegistry[p.ID.String()]; exists {
+			continue
+		}
+		if _, probed := a.peerKinds[p.ID.String()]; probed {
+			continue
+		}
+		a.probing[p.ID] = true
+		pending = append(pending, p.ID)
+	}
+	return pending
+}
+
+func (a *Agent) releaseProbes(peerIDs []peer.ID) {
+	a.registryMu.Lock()
+	defer a.registryMu.Unlock()
+
+	for _, peerID := range peerIDs {
+		delete(a.probing, peerID)
+	}
+}
+
+// recordProbe remembers what a probed peer turned out to be and registers it
+// if it answered with registration details.
+func (a *Agent) recordProbe(peerID peer.ID, resp *p2p.Message, err error) {
+	if err != nil {
+		a.logger.Debug("Peer did not answer agent probe", a.p2pHost.PeerField("peer", peerID), zap.Error(err))
+		a.setPeerKind(peerID.String(), api.AgentKindPeer)
+		return
+	}
+
+	a.setPeerKind(peerID.String(), api.AgentKindAgent)
+	var payload p2p.RegisterPayload
+	if err := json.Unmarshal(resp.Payload, &payload); err == nil && payload.AgentName != "" {
+		a.registerAgent(peerID, &payload)
+	}
+}
+
 func (a *Agent) handleAnnounce(from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
 	var payload p2p.AnnouncePayload
 	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
 		return nil, err
 	}
 
+	sanitizeAnnouncement(&payload)
+	if err := checkAnnouncementLimits(&payload, a.config.AnnounceLimits); err != nil {
+		a.logger.Warn("Dropped announcement over the size limits", a.p2pHost.PeerField("from", from), zap.Error(err))
+		return nil, err
+	}
+
+	pong := &p2p.Message{
+		Type: p2p.MessageTypePong,
+		From: a.p2pHost.ID().String(),
+	}
+	if payload.ID != "" && !a.announcements.markSeen(payload.ID, time.Now()) {
+		return pong, nil
+	}
+	if payload.Hops > 0 {
+		go a.relayAnnouncement(from, payload)
+	}
+	if !a.subscribed.matches(&payload) {
+		a.logger.Debug("Ignored announcement outside the subscription",
+			a.p2pHost.PeerField("from", from),
+			zap.String("type", payload.Type),
+			zap.String("name", payload.Name))
+		return pong, nil
+	}
+
 	a.logger.Info("📢 Received announcement",
-		zap.String("from", from.String()[:12]),
+		a.p2pHost.PeerField("from", from),
 		zap.String("type", payload.Type),
 		zap.String("name", payload.Name),
 		zap.String("url", payload.URL),
 		zap.Strings("tags", payload.Tags))
+	a.events.Publish(events.Event{Type: events.TypeAnnouncement, PeerID: from.String(), Data: payload})
 
-	return &p2p.Message{
-		Type: p2p.MessageTypePong,
-		From: a.p2pHost.ID().String(),
-	}, nil
+	return pong, nil
 }
 
-func (a *Agent) broadcastRegistration(ctx context.Context) {
-	payload := p2p.RegisterPayload{
-		AgentName: a.config.AgentName,
-		Endpoint:  fmt.Sprintf("http://localhost:%d", a.config.HTTPPort),
-		Models:    []string{"gpt-4", "gpt-3.5-turbo"},
-	}
+// registrationPayload describes this agent to peers. The endpoint is left
+// out while the API server is not serving, so peers don't route to it.
+func (a *Agent) registrationPayload() p2p.RegisterPayload {
+	healthy := a.upstreamHealthy()
 
-	payloadBytes, _ := json.Marshal(payload)
-	msg := &p2p.Message{
-		Type:    p2p.MessageTypeRegister,
-		From:    a.p2pHost.ID().String(),
-		Payload: payloadBytes,
+	a.identity.mu.RLock()
+	defer a.identity.mu.RUnlock()
+	endpoint := a.identity.endpoint
+	if !a.ready() {
+		endpoint = ""
+	}
+	return p2p.RegisterPayload{
+		AgentName:       a.identity.name,
+		Endpoint:        endpoint,
+		Models:          a.identity.models,
+		Labels:          a.identity.labels,
+		UpstreamHealthy: &healthy,
 	}
-
-	a.p2pHost.Broadcast(ctx, msg)
 }
 
-func (a *Agent) forwardToOpenAI(ctx context.Context, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error) {
-	body, _ := json.Marshal(req)
+// complete asks provider for a completion under the upstream timeout, and
+// reports the model and headers as configured.
+func (a *Agent) complete(ctx context.Context, provider Provider, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error) {
+	timeout := a.config.UpstreamTimeout
+	if req.Stream {
+		timeout = a.config.UpstreamStreamTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewReader(body))
+	cfg := provider.Config()
+	started := time.Now()
+	resp, err := provider.ChatCompletion(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+a.config.APIKey)
+	a.latency.completion.Record(cfg.Name, time.Since(started))
+	resp.Headers = a.passthroughHeaders(resp.Headers)
+	resp.Model = cfg.ReportedModel(req.Model, resp.Model)
+	return resp, nil
+}
 
-	resp, err := a.httpClient.Do(httpReq)
-	if err != nil {
-		return nil, err
+// passthroughHeaders picks the configured --passthrough-header values out of
+// an upstream response.
+func (a *Agent) passthroughHeaders(header http.Header) http.Header {
+	var out http.Header
+	for _, name := range a.config.PassthroughHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			if out == nil {
+				out = make(http.Header)
+			}
+			out[http.CanonicalHeaderKey(name)] = values
+		}
 	}
-	defer resp.Body.Close()
+	return out
+}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
+func (a *Agent) HandleChatCompletion(ctx context.Context, req *api.ChatCompletionRequest) (resp *api.ChatCompletionResponse, err error) {
+	done := a.stats.track(false)
+	defer func() { done(err) }()
+
+	if err := a.policies.checkModel(ctx, req.Model); err != nil {
 		return nil, err
 	}
+	if err := a.concurrency.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer a.concurrency.release(ctx)
+	if err := a.admission.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer a.admission.release()
 
-	var chatResp api.ChatCompletionResponse
-	if err := json.Unmarshal(respBody, &chatResp); err != nil {
-		return nil, fmt.Errorf("failed to parse OpenAI response: %w", err)
+	resp, err = a.completeWithFallback(ctx, req, a.consumes())
+	if err != nil {
+		return nil, err
 	}
 
-	return &chatResp, nil
+	a.usage.Record(api.ClientIDFromContext(ctx), req.Model, resp.Usage)
+	return resp, nil
 }
 
-func (a *Agent) HandleChatCompletion(ctx context.Context, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error) {
-	return a.forwardToOpenAI(ctx, req)
+func (a *Agent) HandleUsage(ctx context.Context) (*api.UsageResponse, error) {
+	return a.usage.Snapshot(), nil
 }
 
+// HandleListModels lists the models we advertise, including those providers
+// configured with list_models last listed.
 func (a *Agent) HandleListModels(ctx context.Context) (*api.ModelsResponse, error) {
+	a.identity.mu.RLock()
+	models := append([]string(nil), a.identity.models...)
+	a.identity.mu.RUnlock()
+
+	data := make([]api.Model, 0, len(models))
+	for _, m := range models {
+		data = append(data, api.Model{ID: m, Object: "model", Created: time.Now().Unix(), OwnedBy: a.modelOwner(m)})
+	}
+
 	return &api.ModelsResponse{
 		Object: "list",
-		Data: []api.Model{
-			{ID: "gpt-4", Object: "model", Created: time.Now().Unix(), OwnedBy: "openai"},
-			{ID: "gpt-3.5-turbo", Object: "model", Created: time.Now().Unix(), OwnedBy: "openai"},
-		},
+		Data:   data,
 	}, nil
 }
 
+// HandleListNetworkModels merges this agent's models with those advertised by
+// connected agents, counting how many agents serve each.
+func (a *Agent) HandleListNetworkModels(ctx context.Context) (*api.ModelsResponse, error) {
+	local, err := a.HandleListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	agents, err := a.HandleListAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[string]*api.Model)
+	for _, m := range local.Data {
+		m := m
+		m.Agents = 1
+		byID[m.ID] = &m
+	}
+	for _, info := range agents.Data {
+		if info.Kind != api.AgentKindAgent || !info.Connected {
+			continue
+		}
+		for _, id := range info.Models {
+			if m, exists := byID[id]; exists {
+				m.Agents++
+				continue
+			}
+			byID[id] = &api.Model{ID: id, Object: "model", Created: time.Now().Unix(), OwnedBy: "peer", Agents: 1}
+		}
+	}
+
+	data := make([]api.Model, 0, len(byID))
+	for _, m := range byID {
+		data = append(data, *m)
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].ID < data[j].ID
+	})
+
+	return &api.ModelsResponse{
+		Object: "list",
+		Data:   data,
+	}, nil
+}
+
+// HandleListAgents serves what the registry knows now. Peers not yet probed
+// are listed with kind unknown while a background probe finds out what they
+// are, so a slow or silent peer never holds up the listing.
 func (a *Agent) HandleListAgents(ctx context.Context) (*api.AgentsResponse, error) {
 	peers := a.p2pHost.GetPeers()
-	agents := make([]api.AgentInfo, 0)
+	go a.probeUnregistered(a.ctx, peers)
 
+	agents := make([]api.AgentInfo, 0)
+	tracked := make(map[peer.ID]bool, len(peers))
 	for _, p := range peers {
-		record, exists := a.agentRegistry[p.ID.String()]
-		agentInfo := api.AgentInfo{
-			ID:        p.ID.String(),
-			PeerID:    p.ID.String(),
-			Connected: p.Connected,
-		}
-
-		if exists {
-			agentInfo.Name = record.Name
-			agentInfo.Endpoint = record.Endpoint
-			agentInfo.Models = record.Models
+		tracked[p.ID] = true
+		agents = append(agents, a.agentInfo(p))
+	}
+	// The host may not (or no longer) track a registered agent; list it as
+	// disconnected rather than leave it out.
+	for _, record := range a.agentRecords() {
+		if !tracked[record.PeerID] {
+			agents = append(agents, a.agentInfo(untrackedPeer(record.PeerID)))
 		}
-
-		agents = append(agents, agentInfo)
 	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].ID < agents[j].ID
+	})
 
 	return &api.AgentsResponse{
 		Object: "list",
@@ -281,22 +867,163 @@ func (a *Agent) HandleListAgents(ctx context.Context) (*api.AgentsResponse, erro
 	}, nil
 }
 
-func (a *Agent) HandleSendToAgent(ctx context.Context, agentID string, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error) {
-	peerID, err := peer.Decode(agentID)
+func (a *Agent) HandleGetAgent(ctx context.Context, agentID string) (*api.AgentInfo, error) {
+	// Details are still useful for peers we can't currently reach.
+	peerID, err := a.ResolveAgent(agentID)
+	if err != nil && !errors.Is(err, api.ErrAgentUnreachable) {
+		return nil, err
+	}
+
+	p, exists := a.p2pHost.GetPeer(peerID)
+	if !exists {
+		if _, registered := a.agentRecord(peerID.String()); !registered {
+			return nil, fmt.Errorf("%w: %s", api.ErrAgentNotFound, agentID)
+		}
+		info := a.agentInfo(untrackedPeer(peerID))
+		return &info, nil
+	}
+	a.probeUnregistered(ctx, []*p2p.PeerInfo{p})
+
+	info := a.agentInfo(p)
+	return &info, nil
+}
+
+// untrackedPeer stands in for a registered agent the host has no PeerInfo
+// for.
+func untrackedPeer(peerID peer.ID) *p2p.PeerInfo {
+	return &p2p.PeerInfo{ID: peerID, State: p2p.ConnStateDisconnected}
+}
+
+func (a *Agent) agentInfo(p *p2p.PeerInfo) api.AgentInfo {
+	info := api.AgentInfo{
+		ID:        p.ID.String(),
+		PeerID:    p.ID.String(),
+		Connected: p.Connected,
+		State:     string(p.State),
+	}
+
+	if record, exists := a.agentRecord(p.ID.String()); exists {
+		info.Kind = api.AgentKindAgent
+		info.Registered = true
+		info.Name = record.Name
+		info.Endpoint = record.Endpoint
+		info.Models = record.Models
+		info.Labels = record.Labels
+		info.UpstreamHealthy = &record.UpstreamHealthy
+		if score, known := a.reputation.score(p.ID, time.Now()); known {
+			info.Reputation = &score
+		}
+	} else if kind, probed := a.peerKind(p.ID.String()); probed {
+		info.Kind = kind
+	} else {
+		info.Kind = api.AgentKindUnknown
+	}
+
+	for _, addr := range p.Addrs {
+		info.Addrs = append(info.Addrs, addr.String())
+	}
+	info.Pinned = a.p2pHost.IsPinned(p.ID)
+	if p.Connected {
+		info.ConnectionType = a.p2pHost.ConnectionType(p.ID)
+		info.ConnectionAddr = a.p2pHost.ConnectionAddr(p.ID)
+	}
+	if !p.LastSeen.IsZero() {
+		info.LastSeen = p.LastSeen.Unix()
+	}
+	info.Flaps = p.Flaps
+	if p.LearnedFrom != "" {
+		info.LearnedFrom = p.LearnedFrom.String()
+	}
+	if !p.CooldownUntil.IsZero() {
+		info.CooldownUntil = p.CooldownUntil.Unix()
+	}
+
+	return info
+}
+
+func (a *Agent) HandleConnectPeer(ctx context.Context, req *api.ConnectPeerRequest) (*api.ConnectPeerResponse, error) {
+	peerID, err := a.p2pHost.ConnectAddr(ctx, req.Addr)
+	if err != nil {
+		var addrErr *p2p.AddrError
+		if errors.As(err, &addrErr) {
+			return nil, fmt.Errorf("%w: %v", api.ErrInvalidRequest, addrErr)
+		}
+		return nil, err
+	}
+
+	return &api.ConnectPeerResponse{
+		Status: "connected",
+		PeerID: peerID.String(),
+	}, nil
+}
+
+// ResolveAgent maps a peer ID or registered agent name to a peer ID. It
+// returns api.ErrAgentNotFound when nothing matches, and api.ErrAgentUnreachable
+// (alongside the resolved ID) when the peer is known but can't be dialled.
+func (a *Agent) ResolveAgent(idOrName string) (peer.ID, error) {
+	peerID, decodeErr := peer.Decode(idOrName)
+	if decodeErr != nil {
+		var found bool
+		if found, peerID = a.p2pHost.IsNameTaken(idOrName); !found {
+			for _, record := range a.agentRecords() {
+				if record.Name == idOrName {
+					peerID, found = record.PeerID, true
+					break
+				}
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("%w: %q is neither a valid peer ID nor a registered agent name", api.ErrAgentNotFound, idOrName)
+		}
+	}
+
+	if !a.p2pHost.IsReachable(peerID) {
+		return peerID, fmt.Errorf("%w: %s is not connected and has no known addresses", api.ErrAgentUnreachable, idOrName)
+	}
+	return peerID, nil
+}
+
+func (a *Agent) HandleSendToAgent(ctx context.Context, agentID string, req *api.ChatCompletionRequest) (chatResp *api.ChatCompletionResponse, err error) {
+	done := a.stats.track(false)
+	defer func() { done(err) }()
+
+	if err := a.authorizePeer(ctx, req.Model); err != nil {
+		return nil, err
+	}
+	if err := a.checkContext(req); err != nil {
+		return nil, err
+	}
+	peerID, err := a.ResolveAgent(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.concurrency.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer a.concurrency.release(ctx)
+
+	chatResp, err = a.sendChatToPeer(ctx, peerID, req)
 	if err != nil {
-		return nil, fmt.Errorf("invalid agent ID: %w", err)
+		return nil, err
 	}
 
+	a.usage.Record(api.ClientIDFromContext(ctx), req.Model, chatResp.Usage)
+	return chatResp, nil
+}
+
+func (a *Agent) sendChatToPeer(ctx context.Context, peerID peer.ID, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error) {
 	payload, _ := json.Marshal(req)
 	msg := &p2p.Message{
 		Type:      p2p.MessageTypeChat,
 		From:      a.p2pHost.ID().String(),
-		To:        agentID,
+		To:        peerID.String(),
 		RequestID: uuid.New().String(),
 		Payload:   payload,
 	}
 
+	started := time.Now()
 	resp, err := a.p2pHost.SendMessage(ctx, peerID, msg)
+	a.observePeer(ctx, peerID, started, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send to agent: %w", err)
 	}
@@ -313,26 +1040,89 @@ func (a *Agent) HandleSendToAgent(ctx context.Context, agentID string, req *api.
 	return &chatResp, nil
 }
 
-func (a *Agent) HandleAnnounce(ctx context.Context, req *api.AnnounceRequest) error {
+func (a *Agent) HandleAnnounce(ctx context.Context, req *api.AnnounceRequest) (*api.AnnounceResponse, error) {
 	payload := p2p.AnnouncePayload{
 		Type:        req.Type,
 		Name:        req.Name,
 		URL:         req.URL,
 		Description: req.Description,
-		Tags:        req.Tags,
+		Tags:        append([]string(nil), req.Tags...),
+	}
+	sanitizeAnnouncement(&payload)
+	if payload.Name == "" || payload.URL == "" {
+		return nil, fmt.Errorf("%w: announcement needs a name and a url", api.ErrInvalidRequest)
 	}
 
-	payloadBytes, _ := json.Marshal(payload)
-	msg := &p2p.Message{
-		Type:    p2p.MessageTypeAnnounce,
-		From:    a.p2pHost.ID().String(),
-		Payload: payloadBytes,
+	var interval time.Duration
+	if req.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(req.Interval); err != nil {
+			return nil, fmt.Errorf("%w: invalid interval %q", api.ErrInvalidRequest, req.Interval)
+		}
+		if interval < minAnnounceInterval {
+			return nil, fmt.Errorf("%w: interval must be at least %s", api.ErrInvalidRequest, minAnnounceInterval)
+		}
+	}
+
+	tags, unknown := a.tags.check(payload.Tags)
+	payload.Tags = tags
+	if err := checkAnnouncementLimits(&payload, a.config.AnnounceLimits); err != nil {
+		return nil, fmt.Errorf("%w: %v", api.ErrInvalidRequest, err)
+	}
+	if len(unknown) > 0 {
+		if a.config.StrictTags {
+			return nil, fmt.Errorf("%w: unknown tags %s, allowed tags are %s", api.ErrInvalidRequest,
+				strings.Join(unknown, ", "), strings.Join(a.tags.list(), ", "))
+		}
+		a.logger.Warn("Announcement uses tags outside the vocabulary", zap.Strings("tags", unknown))
 	}
 
 	a.logger.Info("Broadcasting announcement",
-		zap.String("type", req.Type),
-		zap.String("name", req.Name),
-		zap.String("url", req.URL))
+		zap.String("type", payload.Type),
+		zap.String("name", payload.Name),
+		zap.String("url", payload.URL))
+
+	filter := a.announceFilter(req.Target)
+	result := a.broadcastAnnouncement(ctx, payload, filter)
+	resp := &api.AnnounceResponse{
+		Status:      "announced",
+		Peers:       result.Peers,
+		Delivered:   result.Delivered,
+		Failed:      result.Failed,
+		Tags:        tags,
+		UnknownTags: unknown,
+	}
+	if interval > 0 {
+		resp.ID = a.repeatAnnouncement(payload, interval, filter)
+		resp.Interval = interval.String()
+	}
+	return resp, nil
+}
+
+func (a *Agent) HandleWithdrawAnnouncement(ctx context.Context, id string) error {
+	if !a.announcements.remove(id) {
+		return fmt.Errorf("%w: no repeating announcement %q", api.ErrNotFound, id)
+	}
 
-	return a.p2pHost.Broadcast(ctx, msg)
+	a.logger.Info("Withdrew announcement", zap.String("id", id))
+	return nil
+}
+
+func (a *Agent) SubscribeEvents() (<-chan events.Event, func()) {
+	return a.events.Subscribe()
+}
+
+func (a *Agent) SubscribeLogs(min zapcore.Level) ([]logstream.Entry, <-chan logstream.Entry, func()) {
+	return a.logs.Subscribe(min)
+}
+
+// HandleRediscover restarts peer discovery and re-announces this agent to the
+// peers it finds, keeping existing connections.
+func (a *Agent) HandleRediscover(ctx context.Context) error {
+	if err := a.p2pHost.Rediscover(ctx); err != nil {
+		return err
+	}
+
+	a.broadcastRegistration(ctx)
+	return nil
 }
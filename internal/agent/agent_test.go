@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// newTestAgent builds an agent that is never started, logging nowhere.
+// Config fields left zero that the agent relies on get their defaults.
+func newTestAgent(t *testing.T, cfg *config.Config) *Agent {
+	t.Helper()
+	if cfg.AnnounceLimits == (config.AnnounceLimits{}) {
+		cfg.AnnounceLimits = config.DefaultAnnounceLimits
+	}
+	a, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	a.logger = zap.NewNop()
+	return a
+}
+
+// newTestUpstream serves OpenAI-style chat completions naming their model,
+// failing with status instead when it is set. It counts the requests it gets.
+func newTestUpstream(t *testing.T, status int) (url string, requests *atomic.Int32) {
+	t.Helper()
+	requests = new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if status != 0 {
+			http.Error(w, `{"error":{"message":"upstream failed"}}`, status)
+			return
+		}
+		var req api.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.ChatCompletionResponse{ID: "chatcmpl-test", Object: "chat.completion", Model: req.Model})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, requests
+}
+
+// connectPeer connects h to remote and waits until h sees it connected.
+func connectPeer(t *testing.T, h, remote *p2p.Host) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := h.Connect(ctx, peer.AddrInfo{ID: remote.ID(), Addrs: remote.Addrs()}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	for {
+		if p, ok := h.GetPeer(remote.ID()); ok && p.Connected {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("peer did not connect")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// TestRegistryConcurrent registers peers under clashing names while their
+// records, kinds and names are read, as P2P and HTTP handlers do. Run with
+// -race; afterwards each record's name must be claimed by its own peer.
+func TestRegistryConcurrent(t *testing.T) {
+	a := newTestAgent(t, &config.Config{})
+	a.p2pHost = newTestPeer(t)
+
+	var remotes []peer.ID
+	for i := 0; i < 4; i++ {
+		remote := newTestPeer(t)
+		connectPeer(t, a.p2pHost, remote)
+		remotes = append(remotes, remote.ID())
+	}
+
+	var wg sync.WaitGroup
+	for i, id := range remotes {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				name := fmt.Sprintf("agent-%d", (i+n)%3)
+				a.registerAgent(id, &p2p.RegisterPayload{AgentName: name, Models: []string{"gpt-4"}})
+				a.setPeerKind(id.String(), api.AgentKindAgent)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				a.agentRecords()
+				a.agentRecord(id.String())
+				a.peerKind(id.String())
+				a.ResolveAgent(fmt.Sprintf("agent-%d", n%3))
+				if p, ok := a.p2pHost.GetPeer(id); ok {
+					a.agentInfo(p)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, record := range a.agentRecords() {
+		if taken, owner := a.p2pHost.IsNameTaken(record.Name); !taken || owner != record.PeerID {
+			t.Errorf("%s is registered as %q, which is claimed by %q", record.PeerID, record.Name, owner)
+		}
+	}
+}
+
+// TestErrorReplies checks refused registrations and chat requests reach the
+// sender as the host's error replies, which it reads as a *p2p.PeerError.
+func TestErrorReplies(t *testing.T) {
+	a := newTestAgent(t, &config.Config{Role: config.RoleClient})
+	a.p2pHost = newTestPeer(t)
+	a.p2pHost.SetMessageHandler(a.handleP2PMessage)
+	owner, remote := newTestPeer(t), newTestPeer(t)
+	connectPeer(t, a.p2pHost, owner)
+	connectPeer(t, a.p2pHost, remote)
+	if err := a.registerAgent(owner.ID(), &p2p.RegisterPayload{AgentName: "taken"}); err != nil {
+		t.Fatalf("registerAgent: %v", err)
+	}
+
+	register, _ := json.Marshal(p2p.RegisterPayload{AgentName: "taken"})
+	chat, _ := json.Marshal(api.ChatCompletionRequest{Model: "gpt-4"})
+	tests := []struct {
+		name   string
+		msg    *p2p.Message
+		reason string
+	}{
+		{name: "name taken", msg: &p2p.Message{Type: p2p.MessageTypeRegister, Payload: register}, reason: p2p.ErrNameTaken.Error()},
+		{name: "client only", msg: &p2p.Message{Type: p2p.MessageTypeChat, Payload: chat}, reason: errClientOnly.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			tt.msg.From = remote.ID().String()
+
+			resp, err := remote.SendMessage(ctx, a.p2pHost.ID(), tt.msg)
+			var peerErr *p2p.PeerError
+			if !errors.As(err, &peerErr) {
+				t.Fatalf("got %+v, %v; want a *p2p.PeerError", resp, err)
+			}
+			if !strings.Contains(peerErr.Reason, tt.reason) {
+				t.Fatalf("reason %q does not contain %q", peerErr.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+// TestListAgentsProbesInBackground lists agents while a peer sits on the
+// probe: the listing returns at once with the peer's kind unknown, the peer is
+// pinged once however often the list is read, and its registration shows up
+// once it answers.
+func TestListAgentsProbesInBackground(t *testing.T) {
+	a := newTestAgent(t, &config.Config{})
+	a.p2pHost = newTestPeer(t)
+	remote := newTestPeer(t)
+
+	release := make(chan struct{})
+	var pings atomic.Int32
+	remote.SetMessageHandler(func(ctx context.Context, from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+		if msg.Type != p2p.MessageTypePing {
+			return nil, nil
+		}
+		pings.Add(1)
+		<-release
+		payload, _ := json.Marshal(p2p.RegisterPayload{AgentName: "slow"})
+		return &p2p.Message{Type: p2p.MessageTypePong, From: remote.ID().String(), Payload: payload}, nil
+	})
+	connectPeer(t, a.p2pHost, remote)
+
+	kindOf := func() string {
+		t.Helper()
+		started := time.Now()
+		resp, err := a.HandleListAgents(context.Background())
+		if err != nil {
+			t.Fatalf("HandleListAgents: %v", err)
+		}
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Fatalf("listing took %v", elapsed)
+		}
+		for _, info := range resp.Data {
+			if info.ID == remote.ID().String() {
+				return info.Kind
+			}
+		}
+		t.Fatal("remote peer not listed")
+		return ""
+	}
+
+	for i := 0; i < 3; i++ {
+		if kind := kindOf(); kind != api.AgentKindUnknown {
+			t.Fatalf("kind = %q while the probe is pending, want %q", kind, api.AgentKindUnknown)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for kindOf() != api.AgentKindAgent {
+		if time.Now().After(deadline) {
+			t.Fatal("probe result never listed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if record, ok := a.agentRecord(remote.ID().String()); !ok || record.Name != "slow" {
+		t.Fatalf("record = %+v, want the probed registration", record)
+	}
+	if got := pings.Load(); got != 1 {
+		t.Fatalf("peer pinged %d times, want 1", got)
+	}
+}
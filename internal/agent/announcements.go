@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	// minAnnounceInterval keeps repeating announcements from flooding peers.
+	minAnnounceInterval = 10 * time.Second
+
+	// announceGossipHops is how many times an announcement may be relayed
+	// when --broadcast-fanout limits who the origin sends it to.
+	announceGossipHops = 5
+
+	// Announcement IDs are remembered for seenAnnouncementTTL so relayed
+	// copies are dropped, keeping at most maxSeenAnnouncements.
+	seenAnnouncementTTL  = 10 * time.Minute
+	maxSeenAnnouncements = 4096
+)
+
+// announcementStore tracks announcements that are re-broadcast until
+// withdrawn, and the IDs of announcements already seen.
+type announcementStore struct {
+	mu      sync.Mutex
+	entries map[string]context.CancelFunc
+	seen    map[string]time.Time
+}
+
+func newAnnouncementStore() *announcementStore {
+	return &announcementStore{
+		entries: make(map[string]context.CancelFunc),
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// tagVocabulary is the set of tags announcements may use, nil for any.
+type tagVocabulary map[string]bool
+
+func newTagVocabulary(tags []string) tagVocabulary {
+	if len(tags) == 0 {
+		return nil
+	}
+	v := make(tagVocabulary, len(tags))
+	for _, tag := range tags {
+		if tag = normalizeTag(tag); tag != "" {
+			v[tag] = true
+		}
+	}
+	return v
+}
+
+// normalizeTag folds case and surrounding space so "AI" and " ai" are one tag.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// check normalizes tags, dropping empty and repeated ones, and returns those
+// outside the vocabulary separately.
+func (v tagVocabulary) check(tags []string) (normalized, unknown []string) {
+	normalized = make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || slices.Contains(normalized, tag) {
+			continue
+		}
+		normalized = append(normalized, tag)
+		if v != nil && !v[tag] {
+			unknown = append(unknown, tag)
+		}
+	}
+	return normalized, unknown
+}
+
+func (v tagVocabulary) list() []string {
+	tags := make([]string, 0, len(v))
+	for tag := range v {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// sanitizeAnnouncement strips control characters, which have no business in
+// announcements and can garble logs and terminals. Descriptions keep line
+// breaks and tabs.
+func sanitizeAnnouncement(p *p2p.AnnouncePayload) {
+	p.Type = stripControl(p.Type, false)
+	p.Name = stripControl(p.Name, false)
+	p.URL = stripControl(p.URL, false)
+	p.Description = stripControl(p.Description, true)
+	for i, tag := range p.Tags {
+		p.Tags[i] = stripControl(tag, false)
+	}
+}
+
+func stripControl(s string, keepLayout bool) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && !(keepLayout && (r == '\n' || r == '\t')) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// checkAnnouncementLimits reports the first field of p over its limit.
+func checkAnnouncementLimits(p *p2p.AnnouncePayload, limits config.AnnounceLimits) error {
+	switch {
+	case len(p.Name) > limits.Name:
+		return fmt.Errorf("announcement name is %d bytes, the limit is %d", len(p.Name), limits.Name)
+	case len(p.Description) > limits.Description:
+		return fmt.Errorf("announcement description is %d bytes, the limit is %d", len(p.Description), limits.Description)
+	case len(p.URL) > limits.URL:
+		return fmt.Errorf("announcement url is %d bytes, the limit is %d", len(p.URL), limits.URL)
+	case len(p.Tags) > limits.Tags:
+		return fmt.Errorf("announcement has %d tags, the limit is %d", len(p.Tags), limits.Tags)
+	}
+	for _, tag := range p.Tags {
+		if len(tag) > limits.Tag {
+			return fmt.Errorf("announcement tag %.16q... is %d bytes, the limit is %d", tag, len(tag), limits.Tag)
+		}
+	}
+	return nil
+}
+
+// markSeen records an announcement ID and reports whether it is new.
+func (s *announcementStore) markSeen(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seenAt, ok := s.seen[id]; ok && now.Sub(seenAt) < seenAnnouncementTTL {
+		return false
+	}
+	if len(s.seen) >= maxSeenAnnouncements {
+		for seenID, seenAt := range s.seen {
+			if now.Sub(seenAt) >= seenAnnouncementTTL {
+				delete(s.seen, seenID)
+			}
+		}
+		if len(s.seen) >= maxSeenAnnouncements {
+			s.seen = make(map[string]time.Time)
+		}
+	}
+	s.seen[id] = now
+	return true
+}
+
+func (s *announcementStore) add(cancel context.CancelFunc) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := uuid.New().String()
+	s.entries[id] = cancel
+	return id
+}
+
+// remove stops the announcement with the given ID. It reports false if there
+// is none.
+func (s *announcementStore) remove(id string) bool {
+	s.mu.Lock()
+	cancel, exists := s.entries[id]
+	delete(s.entries, id)
+	s.mu.Unlock()
+
+	if exists {
+		cancel()
+	}
+	return exists
+}
+
+// broadcastAnnouncement sends payload under a fresh ID. With a broadcast
+// fanout set, untargeted announcements carry hops so receivers relay them to
+// the peers the sample missed.
+func (a *Agent) broadcastAnnouncement(ctx context.Context, payload p2p.AnnouncePayload, filter func(*p2p.PeerInfo) bool) p2p.BroadcastResult {
+	payload.ID = uuid.New().String()
+	payload.Origin = a.p2pHost.ID().String()
+	if a.config.BroadcastFanout > 0 && filter == nil {
+		payload.Hops = announceGossipHops
+	}
+	a.announcements.markSeen(payload.ID, time.Now())
+
+	payloadBytes, _ := json.Marshal(payload)
+	return a.p2pHost.BroadcastTo(ctx, &p2p.Message{
+		Type:    p2p.MessageTypeAnnounce,
+		From:    a.p2pHost.ID().String(),
+		Payload: payloadBytes,
+	}, filter)
+}
+
+// relayAnnouncement passes on an announcement received from a peer, to
+// everyone but that peer and the origin.
+func (a *Agent) relayAnnouncement(from peer.ID, payload p2p.AnnouncePayload) {
+	payload.Hops--
+	payloadBytes, _ := json.Marshal(payload)
+	msg := &p2p.Message{
+		Type:    p2p.MessageTypeAnnounce,
+		From:    a.p2pHost.ID().String(),
+		Payload: payloadBytes,
+	}
+
+	result := a.p2pHost.BroadcastTo(a.ctx, msg, func(p *p2p.PeerInfo) bool {
+		return p.ID != from && p.ID.String() != payload.Origin
+	})
+	a.logger.Debug("Relayed announcement",
+		zap.String("id", payload.ID),
+		zap.Int("hops", payload.Hops),
+		zap.Int("peers", result.Peers),
+		zap.Int("delivered", result.Delivered))
+}
+
+// repeatAnnouncement re-broadcasts payload every interval until withdrawn or
+// the agent stops, so peers that join later still receive it. filter is
+// applied afresh on every round.
+func (a *Agent) repeatAnnouncement(payload p2p.AnnouncePayload, interval time.Duration, filter func(*p2p.PeerInfo) bool) string {
+	ctx, cancel := context.WithCancel(a.ctx)
+	id := a.announcements.add(cancel)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				result := a.broadcastAnnouncement(ctx, payload, filter)
+				a.logger.Debug("Re-broadcast announcement",
+					zap.String("id", id),
+					zap.Int("peers", result.Peers),
+					zap.Int("delivered", result.Delivered))
+			}
+		}
+	}()
+
+	return id
+}
+
+// announceFilter turns an announcement target into a BroadcastTo filter. No
+// target means every peer.
+func (a *Agent) announceFilter(target *api.AnnounceTarget) func(*p2p.PeerInfo) bool {
+	if target == nil || (target.Model == "" && len(target.Labels) == 0 && !target.Pinned) {
+		return nil
+	}
+
+	return func(p *p2p.PeerInfo) bool {
+		if target.Pinned && !a.p2pHost.IsPinned(p.ID) {
+			return false
+		}
+		if target.Model == "" && len(target.Labels) == 0 {
+			return true
+		}
+
+		record, exists := a.agentRecord(p.ID.String())
+		if !exists {
+			return false
+		}
+		if target.Model != "" && !slices.Contains(record.Models, target.Model) {
+			return false
+		}
+		for k, v := range target.Labels {
+			if record.Labels[k] != v {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func manyTags(n int) []string {
+	tags := make([]string, n)
+	for i := range tags {
+		tags[i] = "tag" + strings.Repeat("x", i)
+	}
+	return tags
+}
+
+func TestCheckAnnouncementLimits(t *testing.T) {
+	limits := config.AnnounceLimits{Name: 8, Description: 16, URL: 24, Tags: 2, Tag: 4}
+	tests := []struct {
+		name    string
+		payload p2p.AnnouncePayload
+		wantErr string
+	}{
+		{name: "within limits", payload: p2p.AnnouncePayload{Name: "svc", URL: "https://x", Tags: []string{"ai"}}},
+		{
+			name: "at every limit",
+			payload: p2p.AnnouncePayload{
+				Name:        strings.Repeat("n", 8),
+				Description: strings.Repeat("d", 16),
+				URL:         strings.Repeat("u", 24),
+				Tags:        []string{"abcd", "efgh"},
+			},
+		},
+		{name: "name", payload: p2p.AnnouncePayload{Name: strings.Repeat("n", 9)}, wantErr: "name is 9 bytes, the limit is 8"},
+		{name: "description", payload: p2p.AnnouncePayload{Description: strings.Repeat("d", 17)}, wantErr: "description is 17 bytes, the limit is 16"},
+		{name: "url", payload: p2p.AnnouncePayload{URL: strings.Repeat("u", 25)}, wantErr: "url is 25 bytes, the limit is 24"},
+		{name: "tag count", payload: p2p.AnnouncePayload{Tags: []string{"a", "b", "c"}}, wantErr: "has 3 tags, the limit is 2"},
+		{name: "tag length", payload: p2p.AnnouncePayload{Tags: []string{"ok", "toolong"}}, wantErr: "is 7 bytes, the limit is 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAnnouncementLimits(&tt.payload, limits)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("want error containing %q, got nil", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("error %q does not contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSanitizeAnnouncement(t *testing.T) {
+	p := p2p.AnnouncePayload{
+		Type:        "mcp\x00",
+		Name:        "ser\x1b[31mver",
+		URL:         "https://x\r\n/y",
+		Description: "line one\n\tline two\x07",
+		Tags:        []string{"a\x7fi"},
+	}
+	sanitizeAnnouncement(&p)
+
+	want := p2p.AnnouncePayload{
+		Type:        "mcp",
+		Name:        "ser[31mver",
+		URL:         "https://x/y",
+		Description: "line one\n\tline two",
+		Tags:        []string{"ai"},
+	}
+	if p.Type != want.Type || p.Name != want.Name || p.URL != want.URL || p.Description != want.Description || p.Tags[0] != want.Tags[0] {
+		t.Fatalf("sanitized to %+v, want %+v", p, want)
+	}
+}
+
+// TestHandleAnnounceRejects covers every way POST /v1/announce refuses a
+// request. Each is ErrInvalidRequest, which the API answers with 400, and is
+// refused before anything is broadcast.
+func TestHandleAnnounceRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.Config
+		req    api.AnnounceRequest
+		reason string
+	}{
+		{name: "missing name", req: api.AnnounceRequest{URL: "https://x"}, reason: "needs a name and a url"},
+		{name: "missing url", req: api.AnnounceRequest{Name: "svc"}, reason: "needs a name and a url"},
+		{name: "name of control characters", req: api.AnnounceRequest{Name: "\x00\x1b", URL: "https://x"}, reason: "needs a name and a url"},
+		{name: "unparseable interval", req: api.AnnounceRequest{Name: "svc", URL: "https://x", Interval: "often"}, reason: `invalid interval "often"`},
+		{name: "interval too short", req: api.AnnounceRequest{Name: "svc", URL: "https://x", Interval: "5s"}, reason: "interval must be at least 10s"},
+		{
+			name:   "name over the limit",
+			req:    api.AnnounceRequest{Name: strings.Repeat("n", config.DefaultAnnounceLimits.Name+1), URL: "https://x"},
+			reason: "announcement name is",
+		},
+		{
+			name:   "description over the limit",
+			req:    api.AnnounceRequest{Name: "svc", URL: "https://x", Description: strings.Repeat("d", config.DefaultAnnounceLimits.Description+1)},
+			reason: "announcement description is",
+		},
+		{
+			name:   "url over the limit",
+			req:    api.AnnounceRequest{Name: "svc", URL: "https://x/" + strings.Repeat("u", config.DefaultAnnounceLimits.URL)},
+			reason: "announcement url is",
+		},
+		{
+			name:   "too many tags",
+			req:    api.AnnounceRequest{Name: "svc", URL: "https://x", Tags: manyTags(config.DefaultAnnounceLimits.Tags + 1)},
+			reason: "tags, the limit is",
+		},
+		{
+			name:   "tag over the limit",
+			req:    api.AnnounceRequest{Name: "svc", URL: "https://x", Tags: []string{strings.Repeat("t", config.DefaultAnnounceLimits.Tag+1)}},
+			reason: "announcement tag",
+		},
+		{
+			name:   "custom limits",
+			cfg:    config.Config{AnnounceLimits: config.AnnounceLimits{Name: 2, Description: 1, URL: 64, Tags: 1, Tag: 8}},
+			req:    api.AnnounceRequest{Name: "svc", URL: "https://x"},
+			reason: "name is 3 bytes, the limit is 2",
+		},
+		{
+			name:   "unknown tag with strict tags",
+			cfg:    config.Config{AnnounceTags: []string{"ai", "data"}, StrictTags: true},
+			req:    api.AnnounceRequest{Name: "svc", URL: "https://x", Tags: []string{"AI", "video"}},
+			reason: "unknown tags video, allowed tags are ai, data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			a := newTestAgent(t, &cfg)
+
+			resp, err := a.HandleAnnounce(context.Background(), &tt.req)
+			if err == nil {
+				t.Fatalf("announcement accepted: %+v", resp)
+			}
+			if !errors.Is(err, api.ErrInvalidRequest) {
+				t.Fatalf("error %q is not ErrInvalidRequest", err)
+			}
+			if !strings.Contains(err.Error(), tt.reason) {
+				t.Fatalf("error %q does not contain %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+// TestHandleAnnounceFromPeerEnforcesLimits checks announcements arriving
+// from peers are held to the same limits.
+func TestHandleAnnounceFromPeerEnforcesLimits(t *testing.T) {
+	limits := config.AnnounceLimits{Name: 4, Description: 8, URL: 32, Tags: 1, Tag: 4}
+	tests := []struct {
+		name    string
+		payload p2p.AnnouncePayload
+	}{
+		{name: "name", payload: p2p.AnnouncePayload{Name: "names"}},
+		{name: "description", payload: p2p.AnnouncePayload{Name: "svc", Description: "too long!"}},
+		{name: "url", payload: p2p.AnnouncePayload{Name: "svc", URL: "https://example.com/" + strings.Repeat("u", 13)}},
+		{name: "tag count", payload: p2p.AnnouncePayload{Name: "svc", Tags: []string{"a", "b"}}},
+		{name: "tag length", payload: p2p.AnnouncePayload{Name: "svc", Tags: []string{"video"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, &config.Config{AnnounceLimits: limits})
+			data, _ := json.Marshal(tt.payload)
+
+			resp, err := a.handleAnnounce(peer.ID("peer"), &p2p.Message{Type: p2p.MessageTypeAnnounce, Payload: data})
+			if err == nil {
+				t.Fatalf("announcement accepted: %+v", resp)
+			}
+			if !strings.Contains(err.Error(), "the limit is") {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
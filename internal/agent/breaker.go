@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// circuitBreaker trips after a run of consecutive failures and stays open for
+// a cooldown. After the cooldown a single trial request is let through; its
+// outcome closes or re-opens the breaker.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     breakerState
+	openedAt  time.Time
+	trialBusy bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+// Allow reports whether a request may be attempted right now.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.trialBusy = true
+		return true
+	case breakerHalfOpen:
+		if b.trialBusy {
+			return false
+		}
+		b.trialBusy = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a successful call and reports whether health changed.
+func (b *circuitBreaker) Success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasHealthy := b.state == breakerClosed
+	b.failures = 0
+	b.trialBusy = false
+	b.state = breakerClosed
+	return !wasHealthy
+}
+
+// Failure records a failed call and reports whether health changed.
+func (b *circuitBreaker) Failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasHealthy := b.state == breakerClosed
+	b.failures++
+	b.trialBusy = false
+	if b.state == breakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+	return wasHealthy && b.state != breakerClosed
+}
+
+// Abandon releases a trial slot taken by Allow without recording an outcome.
+func (b *circuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialBusy = false
+}
+
+func (b *circuitBreaker) Healthy() bool {
+	return b.State() == breakerClosed
+}
+
+func (b *circuitBreaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// healthCheckTimeout bounds each ping of a health sweep.
+const healthCheckTimeout = 5 * time.Second
+
+// HandleHealthCheck pings every registered agent concurrently, connected or
+// not, so the result reflects who answers now rather than the last known
+// connection state.
+func (a *Agent) HandleHealthCheck(ctx context.Context) (*api.HealthCheckResponse, error) {
+	type target struct {
+		peerID peer.ID
+		name   string
+	}
+	var targets []target
+	for _, record := range a.agentRecords() {
+		targets = append(targets, target{peerID: record.PeerID, name: record.Name})
+	}
+
+	type result struct {
+		peerID peer.ID
+		health api.PeerHealth
+	}
+	results := make(chan result, len(targets))
+	for _, t := range targets {
+		go func(t target) {
+			pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			health := api.PeerHealth{Name: t.name}
+			started := time.Now()
+			if _, err := a.pingPeer(pingCtx, t.peerID); err != nil {
+				health.Error = err.Error()
+			} else {
+				health.Reachable = true
+				health.LatencyMs = millis(time.Since(started))
+			}
+			results <- result{peerID: t.peerID, health: health}
+		}(t)
+	}
+
+	resp := &api.HealthCheckResponse{
+		Object: "healthcheck",
+		Peers:  make(map[string]api.PeerHealth, len(targets)),
+	}
+	for range targets {
+		r := <-results
+		resp.Peers[r.peerID.String()] = r.health
+		if r.health.Reachable {
+			resp.Reachable++
+		} else {
+			resp.Unreachable++
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"go.uber.org/zap"
+)
+
+// localIdentity is what this agent advertises to its peers. It starts from
+// the config and can be changed at runtime via POST /v1/admin/register.
+type localIdentity struct {
+	mu       sync.RWMutex
+	name     string
+	endpoint string
+	labels   map[string]string
+
+	// configured are the catalog's models, or those last set through
+	// /v1/admin/register. models adds the ones providers list to them.
+	configured []string
+	models     []string
+}
+
+func newLocalIdentity(cfg *config.Config) *localIdentity {
+	endpoint := cfg.AdvertiseEndpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("http://localhost:%d", cfg.HTTPPort)
+	}
+	models := []string{"gpt-4", "gpt-3.5-turbo"}
+	if len(cfg.Models) > 0 {
+		models = make([]string, 0, len(cfg.Models))
+		for _, route := range cfg.Models {
+			models = append(models, route.Name)
+		}
+	}
+	return &localIdentity{
+		name:       cfg.AgentName,
+		endpoint:   endpoint,
+		configured: models,
+		models:     slices.Clone(models),
+	}
+}
+
+func (a *Agent) HandleRegister(ctx context.Context, req *api.RegisterRequest) (*api.RegisterResponse, error) {
+	if req.Name != "" {
+		if err := config.ValidateAgentName(req.Name); err != nil {
+			return nil, fmt.Errorf("%w: %v", api.ErrInvalidRequest, err)
+		}
+		if taken, owner := a.p2pHost.IsNameTaken(req.Name); taken && owner != a.p2pHost.ID() {
+			return nil, fmt.Errorf("%w: agent name %q is already taken by peer %s", api.ErrConflict, req.Name, owner)
+		}
+	}
+	if req.Endpoint != "" {
+		if err := config.ValidateEndpoint(req.Endpoint); err != nil {
+			return nil, fmt.Errorf("%w: %v", api.ErrInvalidRequest, err)
+		}
+	}
+
+	id := a.identity
+	id.mu.Lock()
+	if req.Name != "" {
+		id.name = req.Name
+	}
+	if req.Endpoint != "" {
+		id.endpoint = req.Endpoint
+	}
+	if req.Models != nil {
+		id.configured = slices.Clone(req.Models)
+	}
+	if req.Labels != nil {
+		id.labels = make(map[string]string, len(req.Labels))
+		for k, v := range req.Labels {
+			id.labels[k] = v
+		}
+	}
+	id.mu.Unlock()
+	if req.Models != nil {
+		a.updateIdentityModels()
+	}
+
+	id.mu.RLock()
+	resp := &api.RegisterResponse{
+		Status:   "registered",
+		Name:     id.name,
+		Endpoint: id.endpoint,
+		Models:   id.models,
+		Labels:   id.labels,
+	}
+	id.mu.RUnlock()
+
+	a.p2pHost.SetLocalName(resp.Name)
+	if req.Models != nil {
+		a.p2pHost.SetAdvertisedModels(resp.Models)
+	}
+	a.logger.Info("Updated local identity",
+		zap.String("name", resp.Name),
+		zap.Strings("models", resp.Models))
+
+	result := a.broadcastRegistration(ctx)
+	resp.Peers = result.Peers
+	resp.Delivered = result.Delivered
+	return resp, nil
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/latency"
+)
+
+// latencyTrackers hold the samples behind /v1/debug/latency. Peers are keyed
+// by peer ID and providers by name.
+type latencyTrackers struct {
+	ping       *latency.Tracker // ping round trips
+	chat       *latency.Tracker // successful chat calls to peers
+	completion *latency.Tracker // non-streaming provider calls
+	firstChunk *latency.Tracker // time to the first chunk of a provider stream
+}
+
+func newLatencyTrackers() *latencyTrackers {
+	return &latencyTrackers{
+		ping:       latency.NewTracker(),
+		chat:       latency.NewTracker(),
+		completion: latency.NewTracker(),
+		firstChunk: latency.NewTracker(),
+	}
+}
+
+func (a *Agent) HandleLatency(ctx context.Context) (*api.LatencyResponse, error) {
+	resp := &api.LatencyResponse{
+		Object:    "latency",
+		Peers:     []api.PeerLatency{},
+		Providers: []api.ProviderLatency{},
+	}
+
+	peers := make(map[string]*api.PeerLatency)
+	peer := func(id string) *api.PeerLatency {
+		if p, ok := peers[id]; ok {
+			return p
+		}
+		p := &api.PeerLatency{PeerID: id}
+		if record, exists := a.agentRecord(id); exists {
+			p.Name = record.Name
+		}
+		peers[id] = p
+		return p
+	}
+	for id, stats := range a.latency.ping.Snapshot() {
+		peer(id).Ping = latencyStats(stats)
+	}
+	for id, stats := range a.latency.chat.Snapshot() {
+		peer(id).Chat = latencyStats(stats)
+	}
+	for _, p := range peers {
+		resp.Peers = append(resp.Peers, *p)
+	}
+	sort.Slice(resp.Peers, func(i, j int) bool {
+		return resp.Peers[i].PeerID < resp.Peers[j].PeerID
+	})
+
+	providers := make(map[string]*api.ProviderLatency)
+	provider := func(name string) *api.ProviderLatency {
+		if p, ok := providers[name]; ok {
+			return p
+		}
+		p := &api.ProviderLatency{Provider: name}
+		providers[name] = p
+		return p
+	}
+	for name, stats := range a.latency.completion.Snapshot() {
+		provider(name).Completion = latencyStats(stats)
+	}
+	for name, stats := range a.latency.firstChunk.Snapshot() {
+		provider(name).FirstChunk = latencyStats(stats)
+	}
+	for _, p := range providers {
+		resp.Providers = append(resp.Providers, *p)
+	}
+	sort.Slice(resp.Providers, func(i, j int) bool {
+		return resp.Providers[i].Provider < resp.Providers[j].Provider
+	})
+
+	return resp, nil
+}
+
+func latencyStats(s latency.Stats) *api.LatencyStats {
+	return &api.LatencyStats{
+		Count: int(s.Count),
+		P50Ms: millis(s.P50),
+		P90Ms: millis(s.P90),
+		P99Ms: millis(s.P99),
+		MaxMs: millis(s.Max),
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
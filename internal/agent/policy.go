@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+)
+
+// clientPolicy is what one client key may use. A nil set allows anything.
+type clientPolicy struct {
+	name      string
+	models    map[string]bool
+	providers map[string]bool
+}
+
+// policies maps client IDs to their policy. Clients without one, including
+// the --api-key and requests from peers, are unrestricted.
+type policies map[string]clientPolicy
+
+func newPolicies(clients []config.ClientKey) policies {
+	p := make(policies)
+	for _, client := range clients {
+		if len(client.Models) == 0 && len(client.Providers) == 0 {
+			continue
+		}
+		p[api.ClientIdentity(client.Key)] = clientPolicy{
+			name:      client.Name,
+			models:    toSet(client.Models),
+			providers: toSet(client.Providers),
+		}
+	}
+	return p
+}
+
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// checkModel refuses a model the calling client may not use.
+func (p policies) checkModel(ctx context.Context, model string) error {
+	policy, ok := p[api.ClientIDFromContext(ctx)]
+	if !ok || policy.models == nil || policy.models[model] {
+		return nil
+	}
+	return fmt.Errorf("%w: client %s may not use model %s", api.ErrForbidden, policy.name, model)
+}
+
+// authorizePeer checks that the calling client may send model to another
+// agent directly, which counts as using the "peer" provider.
+func (a *Agent) authorizePeer(ctx context.Context, model string) error {
+	if !a.consumes() {
+		return fmt.Errorf("%w: this agent runs with --role server and does not send requests to other agents", api.ErrForbidden)
+	}
+	if err := a.policies.checkModel(ctx, model); err != nil {
+		return err
+	}
+	_, err := a.policies.allowedProviders(ctx, model, []string{config.PeerProvider})
+	return err
+}
+
+// allowedProviders filters a provider chain down to what the calling client
+// may use, refusing the request if nothing is left.
+func (p policies) allowedProviders(ctx context.Context, model string, chain []string) ([]string, error) {
+	policy, ok := p[api.ClientIDFromContext(ctx)]
+	if !ok || policy.providers == nil {
+		return chain, nil
+	}
+
+	allowed := make([]string, 0, len(chain))
+	for _, name := range chain {
+		if policy.providers[name] {
+			allowed = append(allowed, name)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("%w: client %s may not use any provider serving model %s", api.ErrForbidden, policy.name, model)
+	}
+	return allowed, nil
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+)
+
+// TestClientPolicies sends chat completions as clients with different
+// restrictions through a chain of two providers, gpt-4 being served by
+// openai with local as its fallback.
+func TestClientPolicies(t *testing.T) {
+	openaiURL, _ := newTestUpstream(t, 0)
+	localURL, _ := newTestUpstream(t, 0)
+
+	clients := []config.ClientKey{
+		{Name: "gpt", Key: "gpt-key", Models: []string{"gpt-4"}},
+		{Name: "local-only", Key: "local-key", Providers: []string{"local"}},
+		{Name: "peers-only", Key: "peers-key", Providers: []string{config.PeerProvider}},
+		{Name: "free", Key: "free-key", Priority: config.PriorityHigh},
+	}
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		model        string
+		wantProvider string
+		wantErr      error
+	}{
+		{name: "API key unrestricted", ctx: context.Background(), model: "gpt-4", wantProvider: "openai"},
+		{name: "client without restrictions", ctx: clientContext("free-key"), model: "gpt-4", wantProvider: "openai"},
+		{name: "allowed model", ctx: clientContext("gpt-key"), model: "gpt-4", wantProvider: "openai"},
+		{name: "model not allowed", ctx: clientContext("gpt-key"), model: "llama3", wantErr: api.ErrForbidden},
+		{name: "chain narrowed to allowed providers", ctx: clientContext("local-key"), model: "gpt-4", wantProvider: "local"},
+		{name: "no allowed provider serves the model", ctx: clientContext("local-key"), model: "llama3", wantErr: api.ErrForbidden},
+		{name: "peers only, none serving", ctx: clientContext("peers-key"), model: "gpt-4", wantErr: api.ErrForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, &config.Config{
+				Role: config.RoleServer,
+				Providers: []config.ProviderConfig{
+					{Name: config.DefaultProvider, BaseURL: openaiURL},
+					{Name: "local", BaseURL: localURL},
+				},
+				Fallbacks: []config.ModelFallback{{Model: "gpt-4", Providers: []string{"local"}}},
+				Clients:   clients,
+			})
+
+			resp, err := a.HandleChatCompletion(tt.ctx, &api.ChatCompletionRequest{
+				Model:    tt.model,
+				Messages: []api.Message{{Role: "user", Content: "hi"}},
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HandleChatCompletion: %v", err)
+			}
+			if resp.Provider != tt.wantProvider {
+				t.Fatalf("served by %q, want %q", resp.Provider, tt.wantProvider)
+			}
+		})
+	}
+}
+
+// TestAuthorizePeer checks what a client may send straight to another agent.
+func TestAuthorizePeer(t *testing.T) {
+	clients := []config.ClientKey{
+		{Name: "gpt", Key: "gpt-key", Models: []string{"gpt-4"}},
+		{Name: "local-only", Key: "local-key", Providers: []string{"local"}},
+		{Name: "peers", Key: "peers-key", Providers: []string{config.PeerProvider}},
+	}
+	tests := []struct {
+		name    string
+		role    string
+		ctx     context.Context
+		model   string
+		wantErr bool
+	}{
+		{name: "API key", ctx: context.Background(), model: "gpt-4"},
+		{name: "allowed model", ctx: clientContext("gpt-key"), model: "gpt-4"},
+		{name: "model not allowed", ctx: clientContext("gpt-key"), model: "llama3", wantErr: true},
+		{name: "peer provider allowed", ctx: clientContext("peers-key"), model: "llama3"},
+		{name: "peer provider not allowed", ctx: clientContext("local-key"), model: "gpt-4", wantErr: true},
+		{name: "server role", role: config.RoleServer, ctx: context.Background(), model: "gpt-4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, &config.Config{
+				Role:      tt.role,
+				Providers: []config.ProviderConfig{{Name: "local", BaseURL: "http://127.0.0.1:0"}},
+				Clients:   clients,
+			})
+			err := a.authorizePeer(tt.ctx, tt.model)
+			if tt.wantErr != errors.Is(err, api.ErrForbidden) {
+				t.Fatalf("got %v, want forbidden %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+)
+
+const (
+	// maxSSELineLen bounds a single server-sent event line from a provider.
+	maxSSELineLen = 1 << 20
+
+	// A provider's model list is read up to maxModelListSize bytes, and at
+	// most maxListedModels of its models are taken.
+	maxModelListSize = 1 << 20
+	maxListedModels  = 256
+)
+
+// Provider is an LLM backend the agent completes chats with. Requests and
+// responses use the OpenAI types whatever the backend speaks; the model in
+// them is the one the client asked for, translated to and from the
+// provider's own name by the provider. Non-2xx answers are returned as an
+// *upstreamError.
+type Provider interface {
+	Config() config.ProviderConfig
+
+	// ChatCompletion returns the provider's complete answer to req. Its
+	// Headers are all the upstream response headers.
+	ChatCompletion(ctx context.Context, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error)
+
+	// ChatCompletionStream asks for a streamed answer to req and calls send
+	// with each chunk, in order. The first chunk carries all the upstream
+	// response headers. A provider that answers with a complete response
+	// instead sends it as a single chunk.
+	ChatCompletionStream(ctx context.Context, req *api.ChatCompletionRequest, send func(*api.ChatCompletionChunk) error) error
+
+	// ListModels returns the names of the models the provider serves.
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// newProvider builds the Provider for cfg's type. Every type other than
+// Ollama is an OpenAI-compatible endpoint told apart by its authentication.
+func newProvider(cfg config.ProviderConfig, client *http.Client) Provider {
+	if cfg.ProviderType() == config.ProviderTypeOllama {
+		return NewOllamaProvider(cfg, client)
+	}
+	return NewOpenAIProvider(cfg, client)
+}
+
+func newProviders(cfgs map[string]config.ProviderConfig, client *http.Client) map[string]Provider {
+	providers := make(map[string]Provider, len(cfgs))
+	for name, cfg := range cfgs {
+		providers[name] = newProvider(cfg, client)
+	}
+	return providers
+}
+
+// OpenAIProvider speaks the OpenAI chat completions API, as OpenAI, Azure
+// OpenAI, Anthropic's compatibility endpoint and most gateways do.
+type OpenAIProvider struct {
+	cfg    config.ProviderConfig
+	client *http.Client
+}
+
+func NewOpenAIProvider(cfg config.ProviderConfig, client *http.Client) *OpenAIProvider {
+	return &OpenAIProvider{cfg: cfg, client: client}
+}
+
+func (p *OpenAIProvider) Config() config.ProviderConfig {
+	return p.cfg
+}
+
+func (p *OpenAIProvider) ChatCompletion(ctx context.Context, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error) {
+	resp, err := p.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var chatResp api.ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("failed to parse %s response: %w", p.cfg.Name, err)
+	}
+	chatResp.Headers = resp.Header
+	return &chatResp, nil
+}
+
+// ChatCompletionStream relays each server-sent event as a chunk, keeping the
+// event's own encoding in Raw.
+func (p *OpenAIProvider) ChatCompletionStream(ctx context.Context, req *api.ChatCompletionRequest, send func(*api.ChatCompletionChunk) error) error {
+	body, headers, err := p.forwardToOpenAIStream(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	if !isEventStream(headers) {
+		return sendResponseChunk(p.cfg.Name, body, headers, send)
+	}
+	return sendEventChunks(p.cfg.Name, body, headers, send)
+}
+
+// forwardToOpenAIStream asks for a streamed answer to req and returns the
+// response body unread, along with the response headers, for the caller to
+// close. It is the provider's event stream unless isEventStream says not.
+func (p *OpenAIProvider) forwardToOpenAIStream(ctx context.Context, req *api.ChatCompletionRequest) (io.ReadCloser, http.Header, error) {
+	streamReq := *req
+	streamReq.Stream = true
+	resp, err := p.post(ctx, &streamReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp.Body, resp.Header, nil
+}
+
+// rawStreamer is a Provider whose event stream can be handed over undecoded.
+type rawStreamer interface {
+	forwardToOpenAIStream(ctx context.Context, req *api.ChatCompletionRequest) (io.ReadCloser, http.Header, error)
+}
+
+// isEventStream reports whether a streamed request was answered with
+// server-sent events rather than a complete response.
+func isEventStream(headers http.Header) bool {
+	return strings.HasPrefix(headers.Get("Content-Type"), "text/event-stream")
+}
+
+// sendResponseChunk sends a complete response read from body as a single
+// chunk.
+func sendResponseChunk(name string, body io.Reader, headers http.Header, send func(*api.ChatCompletionChunk) error) error {
+	var chatResp api.ChatCompletionResponse
+	if err := json.NewDecoder(body).Decode(&chatResp); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", name, err)
+	}
+	chunk := chunkFromResponse(&chatResp)
+	chunk.Headers = headers
+	return send(chunk)
+}
+
+// sendEventChunks sends each event of the stream read from body as a chunk,
+// up to [DONE]. headers go on the first one.
+func sendEventChunks(name string, body io.Reader, headers http.Header, send func(*api.ChatCompletionChunk) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxSSELineLen)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+
+		chunk := api.ChatCompletionChunk{Raw: json.RawMessage(data)}
+		if err := json.Unmarshal(chunk.Raw, &chunk); err != nil {
+			return fmt.Errorf("failed to parse %s stream: %w", name, err)
+		}
+		chunk.Headers, headers = headers, nil
+		if err := send(&chunk); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s stream: %w", name, err)
+	}
+	return nil
+}
+
+// ListModels reads GET /models.
+func (p *OpenAIProvider) ListModels(ctx context.Context) ([]string, error) {
+	var list api.ModelsResponse
+	if err := p.get(ctx, p.endpoint("/models"), &list); err != nil {
+		return nil, err
+	}
+	if len(list.Data) > maxListedModels {
+		list.Data = list.Data[:maxListedModels]
+	}
+
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		if m.ID != "" {
+			models = append(models, m.ID)
+		}
+	}
+	return models, nil
+}
+
+func (p *OpenAIProvider) endpoint(path string) string {
+	return strings.TrimSuffix(p.cfg.BaseURL, "/") + path
+}
+
+// post sends req to the chat completions endpoint, under the provider's name
+// for the model. The response is returned open only for a 2xx answer.
+func (p *OpenAIProvider) post(ctx context.Context, req *api.ChatCompletionRequest) (*http.Response, error) {
+	if model := p.cfg.ProviderModel(req.Model); model != req.Model {
+		translated := *req
+		translated.Model = model
+		req = &translated
+	}
+	body, _ := json.Marshal(req)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", p.endpoint("/chat/completions"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	return p.do(httpReq)
+}
+
+// get reads a JSON document of at most maxModelListSize bytes into v.
+func (p *OpenAIProvider) get(ctx context.Context, url string, v any) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(io.LimitReader(resp.Body, maxModelListSize)).Decode(v)
+}
+
+// do authenticates req the way the provider's type expects and sends it.
+func (p *OpenAIProvider) do(req *http.Request) (*http.Response, error) {
+	switch {
+	case p.cfg.APIKey == "":
+	case p.cfg.ProviderType() == config.ProviderTypeAzure:
+		req.Header.Set("api-key", p.cfg.APIKey)
+	default:
+		req.Header.Set("Authorization", "Bearer "+p.cfg.APIKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLen))
+		return nil, &upstreamError{Provider: p.cfg.Name, Status: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// OllamaProvider is a local or remote Ollama server. Chats go through its
+// OpenAI-compatible API under /v1; models are listed from its own API, which
+// names every model pulled onto the server.
+type OllamaProvider struct {
+	*OpenAIProvider
+	root string // base URL without /v1
+}
+
+// NewOllamaProvider accepts the server's address with or without the /v1
+// suffix, e.g. http://localhost:11434.
+func NewOllamaProvider(cfg config.ProviderConfig, client *http.Client) *OllamaProvider {
+	root := ollamaRoot(cfg.BaseURL)
+	cfg.BaseURL = root + "/v1"
+	return &OllamaProvider{OpenAIProvider: NewOpenAIProvider(cfg, client), root: root}
+}
+
+// ollamaRoot is baseURL without a trailing slash or /v1.
+func ollamaRoot(baseURL string) string {
+	root := strings.TrimSuffix(baseURL, "/")
+	if u, err := url.Parse(root); err == nil && strings.HasSuffix(u.Path, "/v1") {
+		root = strings.TrimSuffix(root, "/v1")
+	}
+	return root
+}
+
+// ListModels reads GET /api/tags.
+func (p *OllamaProvider) ListModels(ctx context.Context) ([]string, error) {
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := p.get(ctx, p.root+"/api/tags", &tags); err != nil {
+		return nil, err
+	}
+	if len(tags.Models) > maxListedModels {
+		tags.Models = tags.Models[:maxListedModels]
+	}
+
+	models := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		if m.Name != "" {
+			models = append(models, m.Name)
+		}
+	}
+	return models, nil
+}
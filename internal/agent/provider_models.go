@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	providerModelsTimeout = 5 * time.Second
+
+	// providerModelsInterval is how often providers configured with
+	// list_models are asked for their models again.
+	providerModelsInterval = 30 * time.Second
+)
+
+// providerModels are the models listed by providers configured with
+// list_models that the catalog doesn't cover, by the provider serving each.
+type providerModels struct {
+	mu     sync.RWMutex
+	models map[string]string
+}
+
+func newProviderModels() *providerModels {
+	return &providerModels{models: make(map[string]string)}
+}
+
+func (p *providerModels) provider(model string) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	name, ok := p.models[model]
+	return name, ok
+}
+
+// list returns the listed models, sorted.
+func (p *providerModels) list() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	models := make([]string, 0, len(p.models))
+	for model := range p.models {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// replace sets the models provider serves, returning those it no longer
+// lists and those new to it. A model another provider listed first stays
+// with that one.
+func (p *providerModels) replace(provider string, models []string) (removed, added []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for model, name := range p.models {
+		if name == provider && !slices.Contains(models, model) {
+			delete(p.models, model)
+			removed = append(removed, model)
+		}
+	}
+	for _, model := range models {
+		if _, taken := p.models[model]; !taken {
+			p.models[model] = provider
+			added = append(added, model)
+		}
+	}
+	return removed, added
+}
+
+// listsModels reports whether any provider is configured with list_models.
+func (a *Agent) listsModels() bool {
+	for _, provider := range a.providers {
+		if provider.Config().ListModels {
+			return true
+		}
+	}
+	return false
+}
+
+// watchProviderModels refreshes the provider model lists straight away and
+// then every providerModelsInterval until ctx is done, so /v1/models and the
+// registration never wait on a provider.
+func (a *Agent) watchProviderModels(ctx context.Context) {
+	ticker := time.NewTicker(providerModelsInterval)
+	defer ticker.Stop()
+
+	for {
+		if a.refreshProviderModels(ctx) {
+			a.advertiseModels(ctx)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshProviderModels asks each provider configured with list_models for
+// the models it serves, and reports whether the models we advertise changed.
+// Listed models outside the catalog are routed to the provider that listed
+// them and advertised as ours; ones it stops listing are withdrawn. A
+// provider that can't be listed is logged and keeps what it listed last.
+func (a *Agent) refreshProviderModels(ctx context.Context) bool {
+	names := make([]string, 0, len(a.providers))
+	for name, provider := range a.providers {
+		if provider.Config().ListModels {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changed := false
+	for _, name := range names {
+		listCtx, cancel := context.WithTimeout(ctx, providerModelsTimeout)
+		models, err := a.providers[name].ListModels(listCtx)
+		cancel()
+		if err != nil {
+			a.logger.Warn("Failed to list provider models",
+				zap.String("provider", name), zap.Error(err))
+			continue
+		}
+
+		models = slices.DeleteFunc(models, func(model string) bool {
+			_, inCatalog := a.config.Route(model)
+			return inCatalog
+		})
+		removed, added := a.providerModels.replace(name, models)
+		if len(removed)+len(added) == 0 {
+			continue
+		}
+		a.logger.Info("Provider models changed",
+			zap.String("provider", name),
+			zap.Strings("added", added),
+			zap.Strings("removed", removed))
+		if a.updateIdentityModels() {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// advertiseModels tells the network about a change to the models we
+// advertise: they are set on the host and the registration is sent again.
+func (a *Agent) advertiseModels(ctx context.Context) {
+	if a.p2pHost == nil || !a.serves() {
+		return
+	}
+	a.identity.mu.RLock()
+	a.p2pHost.SetAdvertisedModels(a.identity.models)
+	a.identity.mu.RUnlock()
+	a.broadcastRegistration(ctx)
+}
+
+// updateIdentityModels sets the models we advertise to the configured ones
+// followed by those providers list, and reports whether they changed.
+func (a *Agent) updateIdentityModels() bool {
+	listed := a.providerModels.list()
+
+	id := a.identity
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	models := slices.Clone(id.configured)
+	for _, m := range listed {
+		if !slices.Contains(models, m) {
+			models = append(models, m)
+		}
+	}
+	if slices.Equal(models, id.models) {
+		return false
+	}
+	id.models = models
+	return true
+}
+
+// modelOwner is what /v1/models reports as owning model: the provider that
+// listed it, else "openai" as before.
+func (a *Agent) modelOwner(model string) string {
+	if name, ok := a.providerModels.provider(model); ok {
+		return name
+	}
+	return "openai"
+}
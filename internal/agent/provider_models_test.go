@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// modelsUpstream serves GET /models listing whatever set was last given, and
+// counts the lists it is asked for.
+type modelsUpstream struct {
+	mu       sync.Mutex
+	models   []string
+	listings atomic.Int32
+}
+
+func newModelsUpstream(t *testing.T, models ...string) (*modelsUpstream, string) {
+	t.Helper()
+	u := &modelsUpstream{models: models}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.listings.Add(1)
+		u.mu.Lock()
+		var resp api.ModelsResponse
+		for _, m := range u.models {
+			resp.Data = append(resp.Data, api.Model{ID: m})
+		}
+		u.mu.Unlock()
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return u, srv.URL
+}
+
+func (u *modelsUpstream) set(models ...string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.models = models
+}
+
+func listedModels(t *testing.T, a *Agent) []string {
+	t.Helper()
+	resp, err := a.HandleListModels(context.Background())
+	if err != nil {
+		t.Fatalf("HandleListModels: %v", err)
+	}
+	var ids []string
+	for _, m := range resp.Data {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// TestProviderModelsMerge checks listed models are added to the configured
+// ones rather than replacing them, both from the catalog and once an admin
+// sets the models, and that /v1/models answers from the last list.
+func TestProviderModelsMerge(t *testing.T) {
+	upstream, url := newModelsUpstream(t, "gpt-4", "llama3")
+	a := newTestAgent(t, &config.Config{
+		Providers: []config.ProviderConfig{{Name: "local", BaseURL: url, ListModels: true}},
+		Models:    []config.ModelRoute{{Name: "gpt-4", Provider: "local"}},
+	})
+	a.p2pHost = newTestPeer(t)
+
+	if !a.refreshProviderModels(context.Background()) {
+		t.Fatal("listing a new model reported no change")
+	}
+	if got := listedModels(t, a); !slices.Equal(got, []string{"gpt-4", "llama3"}) {
+		t.Fatalf("models %v, want the catalog's and the listed one", got)
+	}
+	if upstream.listings.Load() != 1 {
+		t.Fatalf("provider listed %d times, want once", upstream.listings.Load())
+	}
+	if a.refreshProviderModels(context.Background()) {
+		t.Fatal("listing the same models reported a change")
+	}
+
+	if _, err := a.HandleRegister(context.Background(), &api.RegisterRequest{Models: []string{"mistral"}}); err != nil {
+		t.Fatalf("HandleRegister: %v", err)
+	}
+	if got := listedModels(t, a); !slices.Equal(got, []string{"mistral", "llama3"}) {
+		t.Fatalf("after register, models %v, want the registered and the listed one", got)
+	}
+
+	upstream.set("gpt-4")
+	if !a.refreshProviderModels(context.Background()) {
+		t.Fatal("withdrawing a model reported no change")
+	}
+	if got := listedModels(t, a); !slices.Equal(got, []string{"mistral"}) {
+		t.Fatalf("after withdrawal, models %v", got)
+	}
+}
+
+// TestWatchProviderModelsRebroadcasts starts the watcher on a serving agent
+// and checks a connected peer is sent a registration naming a newly listed
+// model.
+func TestWatchProviderModelsRebroadcasts(t *testing.T) {
+	_, url := newModelsUpstream(t, "llama3")
+	a := newTestAgent(t, &config.Config{
+		AgentName: "alpha",
+		Providers: []config.ProviderConfig{{Name: "local", BaseURL: url, ListModels: true}},
+	})
+	a.p2pHost = newTestPeer(t)
+	a.apiServer = api.NewServer(api.Options{}, a, zap.NewNop())
+	if err := a.apiServer.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { a.apiServer.Stop(context.Background()) })
+
+	remote := newTestPeer(t)
+	registered := make(chan []string, 4)
+	remote.SetMessageHandler(func(ctx context.Context, from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+		if msg.Type == p2p.MessageTypeRegister {
+			var payload p2p.RegisterPayload
+			json.Unmarshal(msg.Payload, &payload)
+			select {
+			case registered <- payload.Models:
+			default:
+			}
+		}
+		return &p2p.Message{Type: p2p.MessageTypePong, From: remote.ID().String()}, nil
+	})
+	connectPeer(t, a.p2pHost, remote)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.watchProviderModels(ctx)
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case models := <-registered:
+			if slices.Contains(models, "llama3") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no registration naming the listed model")
+		}
+	}
+}
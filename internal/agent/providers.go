@@ -0,0 +1,365 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	maxErrorBodyLen = 512
+
+	// A request for a model no known agent serves looks up at most
+	// modelDiscoveryLimit agents advertising it in the DHT, giving up after
+	// modelDiscoveryTimeout.
+	modelDiscoveryLimit   = 8
+	modelDiscoveryTimeout = 10 * time.Second
+)
+
+// upstreamError is a non-2xx answer from a provider.
+type upstreamError struct {
+	Provider string
+	Status   int
+	Body     string
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("provider %s returned status %d: %s", e.Provider, e.Status, e.Body)
+}
+
+// isRetryable reports whether a failed call should move on to the next
+// provider in the fallback chain. Server errors, rate limiting, timeouts and
+// network failures do; other client errors don't, since the next provider
+// would reject the same request.
+func isRetryable(err error) bool {
+	var upErr *upstreamError
+	if errors.As(err, &upErr) {
+		return upErr.Status >= 500 || upErr.Status == 429
+	}
+	return true
+}
+
+func buildBreakers(cfg *config.Config, providers map[string]config.ProviderConfig) map[string]*circuitBreaker {
+	breakers := make(map[string]*circuitBreaker, len(providers))
+	for name := range providers {
+		breakers[name] = newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+	return breakers
+}
+
+func buildProviders(cfg *config.Config) map[string]config.ProviderConfig {
+	providers := map[string]config.ProviderConfig{
+		config.DefaultProvider: {
+			Name:    config.DefaultProvider,
+			BaseURL: config.DefaultOpenAIBaseURL,
+			APIKey:  cfg.APIKey,
+		},
+	}
+	for _, p := range cfg.Providers {
+		if p.Name == config.DefaultProvider && p.APIKey == "" && p.ProviderType() == config.ProviderTypeOpenAI {
+			p.APIKey = cfg.APIKey
+		}
+		providers[p.Name] = p
+	}
+
+	// Catalog aliases join the provider's own, copied so cfg is left as is.
+	for _, route := range cfg.Models {
+		for name, alias := range route.Aliases {
+			p, ok := providers[name]
+			if !ok {
+				continue
+			}
+			aliases := make(map[string]string, len(p.ModelAliases)+1)
+			for model, a := range p.ModelAliases {
+				aliases[model] = a
+			}
+			aliases[route.Name] = alias
+			p.ModelAliases = aliases
+			providers[name] = p
+		}
+	}
+	return providers
+}
+
+// providerChain returns the primary provider followed by any fallbacks
+// configured for the model. Outside the catalog, the primary is the provider
+// that listed the model at startup, if any, else "openai".
+func (a *Agent) providerChain(model string) []string {
+	if route, ok := a.config.Route(model); ok {
+		return append([]string{route.Primary()}, route.Fallbacks...)
+	}
+
+	primary := config.DefaultProvider
+	if name, ok := a.providerModels.provider(model); ok {
+		primary = name
+	}
+	chain := []string{primary}
+	for _, fb := range a.config.Fallbacks {
+		if fb.Model == model {
+			chain = append(chain, fb.Providers...)
+			break
+		}
+	}
+	return chain
+}
+
+// completeWithFallback walks the provider chain until one provider answers.
+// The "peer" entry routes to a connected agent serving the model; it is
+// skipped for requests that already arrived over P2P so requests can't bounce
+// around the network. Requests too long for the model's context window are
+// refused before any provider is tried.
+func (a *Agent) completeWithFallback(ctx context.Context, req *api.ChatCompletionRequest, allowPeers bool) (*api.ChatCompletionResponse, error) {
+	if err := a.checkContext(req); err != nil {
+		return nil, err
+	}
+	chain, err := a.policies.allowedProviders(ctx, req.Model, a.providerChain(req.Model))
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for i, name := range chain {
+		var resp *api.ChatCompletionResponse
+		var err error
+
+		if name == config.PeerProvider {
+			if !allowPeers {
+				continue
+			}
+			resp, err = a.completeViaPeer(ctx, req)
+		} else {
+			provider, exists := a.providers[name]
+			if !exists {
+				continue
+			}
+			resp, err = a.completeWithRetry(ctx, name, provider, req)
+			if errors.Is(err, errCircuitOpen) {
+				lastErr = err
+				continue
+			}
+		}
+
+		if err == nil {
+			if i > 0 {
+				a.logger.Info("Request served by fallback provider",
+					zap.String("model", req.Model),
+					zap.String("provider", resp.Provider))
+			}
+			return resp, nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil || !isRetryable(err) {
+			return nil, err
+		}
+		a.logger.Warn("Provider failed, trying next in fallback chain",
+			zap.String("model", req.Model),
+			zap.String("provider", name),
+			zap.Error(err))
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no provider available for model %s", req.Model)
+	}
+	return nil, lastErr
+}
+
+// errCircuitOpen is returned for a provider whose circuit breaker is open.
+var errCircuitOpen = errors.New("unavailable (circuit open)")
+
+// completeWithRetry calls provider as the upstream retry policy says,
+// retrying errors worth retrying while its circuit stays closed. Every
+// attempt counts towards the provider's circuit breaker.
+func (a *Agent) completeWithRetry(ctx context.Context, name string, provider Provider, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error) {
+	breaker := a.breakers[name]
+	var resp *api.ChatCompletionResponse
+	var lastErr error
+	err := a.retries.Upstream.Do(ctx, func(ctx context.Context) error {
+		if !breaker.Allow() {
+			if lastErr != nil {
+				// Our own failures opened it.
+				return retry.Permanent(lastErr)
+			}
+			return retry.Permanent(fmt.Errorf("provider %s is %w", name, errCircuitOpen))
+		}
+
+		var err error
+		resp, err = a.complete(ctx, provider, req)
+		lastErr = err
+		switch {
+		case err == nil:
+			resp.Provider = name
+			a.recordProviderResult(name, true)
+			return nil
+		case ctx.Err() != nil:
+			// The caller went away; that says nothing about the provider.
+			breaker.Abandon()
+			return retry.Permanent(err)
+		case !isRetryable(err):
+			// Client errors still prove the provider is up.
+			a.recordProviderResult(name, true)
+			return retry.Permanent(err)
+		}
+		a.recordProviderResult(name, false)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (a *Agent) completeViaPeer(ctx context.Context, req *api.ChatCompletionRequest) (*api.ChatCompletionResponse, error) {
+	record, ok := a.peerForModel(ctx, req.Model)
+	if !ok {
+		return nil, fmt.Errorf("no connected agent serves model %s", req.Model)
+	}
+
+	resp, err := a.sendChatToPeer(ctx, record.PeerID, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Provider = "peer:" + record.Name
+	return resp, nil
+}
+
+// recordProviderResult feeds a call outcome into the provider's breaker. When
+// the primary provider's health flips, registration is re-broadcast so peers
+// stop (or resume) routing to us.
+func (a *Agent) recordProviderResult(name string, ok bool) {
+	breaker := a.breakers[name]
+
+	var changed bool
+	if ok {
+		changed = breaker.Success()
+	} else {
+		changed = breaker.Failure()
+	}
+	if !changed || name != config.DefaultProvider {
+		return
+	}
+
+	a.logger.Warn("Upstream health changed", zap.String("provider", name), zap.Bool("healthy", breaker.Healthy()))
+	go a.broadcastRegistration(a.ctx)
+}
+
+func (a *Agent) upstreamHealthy() bool {
+	return a.breakers[config.DefaultProvider].Healthy()
+}
+
+// selectPeerForModel picks a connected, registered agent advertising model
+// whose upstream is healthy, preferring pinned peers and then the best
+// reputation.
+func (a *Agent) selectPeerForModel(model string) (*AgentRecord, bool) {
+	var candidates []*AgentRecord
+	for _, record := range a.agentRecords() {
+		if !servesModel(record, model) || !record.UpstreamHealthy {
+			continue
+		}
+		if p, exists := a.p2pHost.GetPeer(record.PeerID); !exists || !p.Connected {
+			continue
+		}
+		candidates = append(candidates, record)
+	}
+	if len(candidates) == 0 {
+		return nil, false
+	}
+
+	now := time.Now()
+	scores := make(map[peer.ID]float64, len(candidates))
+	for _, c := range candidates {
+		scores[c.PeerID], _ = a.reputation.score(c.PeerID, now)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if pi, pj := a.p2pHost.IsPinned(candidates[i].PeerID), a.p2pHost.IsPinned(candidates[j].PeerID); pi != pj {
+			return pi
+		}
+		if si, sj := scores[candidates[i].PeerID], scores[candidates[j].PeerID]; si != sj {
+			return si > sj
+		}
+		return candidates[i].PeerID < candidates[j].PeerID
+	})
+	return candidates[0], true
+}
+
+// peerForModel is selectPeerForModel, falling back to the DHT when no known
+// agent serves model: agents advertising the model there are dialled and
+// probed for their registration, then selection runs again.
+func (a *Agent) peerForModel(ctx context.Context, model string) (*AgentRecord, bool) {
+	if record, ok := a.selectPeerForModel(model); ok {
+		return record, true
+	}
+	if a.discoverModelProviders(ctx, model) == 0 {
+		return nil, false
+	}
+	return a.selectPeerForModel(model)
+}
+
+// discoverModelProviders connects to agents advertising model in the DHT and
+// returns how many answered the probe.
+func (a *Agent) discoverModelProviders(ctx context.Context, model string) int {
+	ctx, cancel := context.WithTimeout(ctx, modelDiscoveryTimeout)
+	defer cancel()
+
+	found, err := a.p2pHost.FindModelProviders(ctx, model, modelDiscoveryLimit)
+	if err != nil {
+		if !errors.Is(err, p2p.ErrDHTDisabled) {
+			a.logger.Debug("DHT model lookup failed", zap.String("model", model), zap.Error(err))
+		}
+		return 0
+	}
+
+	type probeResult struct {
+		peerID peer.ID
+		dialed bool
+		resp   *p2p.Message
+		err    error
+	}
+	results := make(chan probeResult, len(found))
+	for _, info := range found {
+		go func(info peer.AddrInfo) {
+			if err := a.p2pHost.Connect(ctx, info); err != nil {
+				results <- probeResult{peerID: info.ID, err: err}
+				return
+			}
+			resp, err := a.pingPeer(ctx, info.ID)
+			results <- probeResult{peerID: info.ID, dialed: true, resp: resp, err: err}
+		}(info)
+	}
+
+	answered := 0
+	for range found {
+		r := <-results
+		if !r.dialed {
+			a.logger.Debug("Failed to dial model provider", a.p2pHost.PeerField("peer", r.peerID), zap.Error(r.err))
+			continue
+		}
+		a.recordProbe(r.peerID, r.resp, r.err)
+		if r.err == nil {
+			answered++
+		}
+	}
+	a.logger.Debug("Looked up model providers in DHT",
+		zap.String("model", model),
+		zap.Int("found", len(found)),
+		zap.Int("answered", answered))
+	return answered
+}
+
+func servesModel(record *AgentRecord, model string) bool {
+	for _, m := range record.Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
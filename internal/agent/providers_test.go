@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+)
+
+// TestCompleteWithFallbackRetries calls a provider that fails with the given
+// statuses before answering, and checks which failures are retried.
+func TestCompleteWithFallbackRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		threshold    int // breaker threshold, default 5
+		wantRequests int32
+		wantErr      bool
+	}{
+		{name: "server errors retried", statuses: []int{502, 503}, wantRequests: 3},
+		{name: "rate limiting retried", statuses: []int{429}, wantRequests: 2},
+		{name: "client error not retried", statuses: []int{400}, wantRequests: 1, wantErr: true},
+		{name: "gives up after its attempts", statuses: []int{500, 500, 500}, wantRequests: 3, wantErr: true},
+		{name: "stops once the circuit opens", statuses: []int{500, 500}, threshold: 1, wantRequests: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if n := int(requests.Add(1)); n <= len(tt.statuses) {
+					http.Error(w, `{"error":{"message":"failed"}}`, tt.statuses[n-1])
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"id":"chatcmpl-test","object":"chat.completion","model":"gpt-4"}`))
+			}))
+			t.Cleanup(srv.Close)
+
+			threshold := tt.threshold
+			if threshold == 0 {
+				threshold = 5
+			}
+			a := newTestAgent(t, &config.Config{
+				Providers:        []config.ProviderConfig{{Name: config.DefaultProvider, BaseURL: srv.URL}},
+				BreakerThreshold: threshold,
+				BreakerCooldown:  time.Minute,
+				Retry: config.RetryConfig{
+					Upstream: retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+				},
+			})
+
+			resp, err := a.completeWithFallback(context.Background(), &api.ChatCompletionRequest{
+				Model:    "gpt-4",
+				Messages: []api.Message{{Role: "user", Content: "hi"}},
+			}, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %+v, %v; want error %v", resp, err, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Fatalf("provider got %d requests, want %d", got, tt.wantRequests)
+			}
+			var upErr *upstreamError
+			if err != nil && !errors.As(err, &upErr) {
+				t.Fatalf("got %v, want the provider's own error", err)
+			}
+		})
+	}
+}
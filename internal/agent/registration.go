@@ -0,0 +1,215 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	registrationSendTimeout = 10 * time.Second
+
+	// Every registrationCheckInterval, connected peers that have not
+	// acknowledged the current registration are sent it again, backing off
+	// per peer as the registration retry policy says. Every
+	// registrationRefreshInterval all peers are re-sent it as a heartbeat, in
+	// case one lost it (e.g. by restarting while we stayed connected).
+	registrationCheckInterval   = 2 * time.Second
+	registrationRefreshInterval = 5 * time.Minute
+)
+
+type registrationState struct {
+	acked       uint64 // generation last acknowledged, 0 if none
+	failures    int
+	nextAttempt time.Time
+	sending     bool
+}
+
+// registrationTracker records which peers have acknowledged our current
+// registration. The generation moves on whenever the registration is
+// re-broadcast, so every peer needs to acknowledge it again.
+type registrationTracker struct {
+	// retry spaces out re-sends to a peer that failed to acknowledge. With
+	// no attempt limit a connected peer is retried until it acknowledges or
+	// goes away; otherwise it is given up on until the next generation.
+	retry retry.Policy
+
+	mu         sync.Mutex
+	generation uint64
+	peers      map[peer.ID]*registrationState
+}
+
+func newRegistrationTracker(policy retry.Policy) *registrationTracker {
+	return &registrationTracker{
+		retry:      policy,
+		generation: 1,
+		peers:      make(map[peer.ID]*registrationState),
+	}
+}
+
+// bump starts a new generation and clears every peer's backoff.
+func (t *registrationTracker) bump() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.generation++
+	for _, state := range t.peers {
+		state.failures = 0
+		state.nextAttempt = time.Time{}
+	}
+	return t.generation
+}
+
+// due returns the current generation and those of connected that have not
+// acknowledged it and are neither backing off, out of attempts nor already
+// being sent it, marking them as being sent it. Peers missing from connected
+// are forgotten, so they are registered with afresh when they reconnect.
+func (t *registrationTracker) due(connected []peer.ID, now time.Time) (uint64, []peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	live := make(map[peer.ID]bool, len(connected))
+	var due []peer.ID
+	for _, id := range connected {
+		live[id] = true
+		state, ok := t.peers[id]
+		if !ok {
+			state = &registrationState{}
+			t.peers[id] = state
+		}
+		exhausted := t.retry.MaxAttempts > 0 && state.failures >= t.retry.MaxAttempts
+		if state.acked < t.generation && !state.sending && !exhausted && !now.Before(state.nextAttempt) {
+			state.sending = true
+			due = append(due, id)
+		}
+	}
+	for id := range t.peers {
+		if !live[id] {
+			delete(t.peers, id)
+		}
+	}
+	return t.generation, due
+}
+
+func (t *registrationTracker) ack(id peer.ID, generation uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.peers[id]
+	if !ok {
+		state = &registrationState{}
+		t.peers[id] = state
+	}
+	state.acked = max(state.acked, generation)
+	state.sending = false
+	state.failures = 0
+	state.nextAttempt = time.Time{}
+}
+
+func (t *registrationTracker) fail(id peer.ID, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.peers[id]
+	if !ok {
+		state = &registrationState{}
+		t.peers[id] = state
+	}
+	state.sending = false
+	state.failures++
+	state.nextAttempt = now.Add(t.retry.Delay(state.failures))
+}
+
+// registrationTargets are the connected peers that may be agents: peers the
+// probe found not to be are left out.
+func (a *Agent) registrationTargets() []peer.ID {
+	var targets []peer.ID
+	for _, p := range a.p2pHost.GetPeers() {
+		if kind, _ := a.peerKind(p.ID.String()); !p.Connected || kind == api.AgentKindPeer {
+			continue
+		}
+		targets = append(targets, p.ID)
+	}
+	return targets
+}
+
+// broadcastRegistration sends our registration to every connected peer as a
+// new generation. Peers that miss it are retried by maintainRegistration.
+// Nothing is sent while the agent is not ready to serve, or ever under
+// --role client.
+func (a *Agent) broadcastRegistration(ctx context.Context) p2p.BroadcastResult {
+	if !a.serves() {
+		return p2p.BroadcastResult{}
+	}
+	if !a.ready() {
+		a.logger.Warn("Not registering with peers, the API server is not serving")
+		return p2p.BroadcastResult{}
+	}
+
+	generation := a.registrations.bump()
+	_, targets := a.registrations.due(a.registrationTargets(), time.Now())
+
+	result := a.sendRegistration(ctx, generation, targets)
+	a.logger.Debug("Broadcast registration",
+		zap.Int("peers", result.Peers),
+		zap.Int("delivered", result.Delivered))
+	return result
+}
+
+// maintainRegistration retries the registration to peers that have not
+// acknowledged it, including newly connected ones, until ctx is done.
+func (a *Agent) maintainRegistration(ctx context.Context) {
+	ticker := time.NewTicker(registrationCheckInterval)
+	defer ticker.Stop()
+	refresh := time.NewTicker(registrationRefreshInterval)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh.C:
+			a.broadcastRegistration(ctx)
+		case <-ticker.C:
+			if !a.ready() {
+				continue
+			}
+			generation, due := a.registrations.due(a.registrationTargets(), time.Now())
+			if len(due) == 0 {
+				continue
+			}
+			result := a.sendRegistration(ctx, generation, due)
+			a.logger.Debug("Retried registration",
+				zap.Int("peers", result.Peers),
+				zap.Int("delivered", result.Delivered))
+		}
+	}
+}
+
+// sendRegistration sends the registration to peers concurrently and records
+// who acknowledged it with a pong. Failures, including a peer refusing it,
+// back off before the next attempt.
+func (a *Agent) sendRegistration(ctx context.Context, generation uint64, peers []peer.ID) p2p.BroadcastResult {
+	payloadBytes, _ := json.Marshal(a.registrationPayload())
+	msg := &p2p.Message{
+		Type:    p2p.MessageTypeRegister,
+		From:    a.p2pHost.ID().String(),
+		Payload: payloadBytes,
+	}
+
+	return a.p2pHost.SendToPeers(ctx, peers, msg, registrationSendTimeout, func(pid peer.ID, err error) {
+		if err != nil {
+			a.logger.Debug("Failed to send registration", a.p2pHost.PeerField("peer", pid), zap.Error(err))
+			a.registrations.fail(pid, time.Now())
+		} else {
+			a.registrations.ack(pid, generation)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+)
+
+// TestRegistrationTrackerBackoff walks one peer through failed sends: each
+// failure pushes its next attempt out as the registration policy says, and an
+// acknowledgement or a new generation clears the backoff.
+func TestRegistrationTrackerBackoff(t *testing.T) {
+	tracker := newRegistrationTracker(config.DefaultRetry.Registration)
+	id := test.RandPeerIDFatal(t)
+	now := time.Now()
+
+	generation, due := tracker.due([]peer.ID{id}, now)
+	if !slices.Equal(due, []peer.ID{id}) {
+		t.Fatalf("new peer is not due: %v", due)
+	}
+	if _, due := tracker.due([]peer.ID{id}, now); len(due) != 0 {
+		t.Fatalf("peer being sent the registration is due again: %v", due)
+	}
+
+	for failures := 1; failures <= 8; failures++ {
+		tracker.fail(id, now)
+		state := tracker.peers[id]
+		if state.failures != failures {
+			t.Fatalf("recorded %d failures, want %d", state.failures, failures)
+		}
+		// Jitter may shorten the wait by up to its fraction, never lengthen it.
+		full := registrationDelay(failures)
+		wait := state.nextAttempt.Sub(now)
+		if wait > full || wait < full-time.Duration(float64(full)*tracker.retry.Jitter) {
+			t.Fatalf("after %d failures waiting %v, want about %v", failures, wait, full)
+		}
+		if _, due := tracker.due([]peer.ID{id}, now.Add(wait-time.Millisecond)); len(due) != 0 {
+			t.Fatalf("peer due before its backoff ended")
+		}
+		if _, due := tracker.due([]peer.ID{id}, now.Add(wait)); len(due) != 1 {
+			t.Fatalf("peer not due once its backoff ended")
+		}
+	}
+	if wait := tracker.peers[id].nextAttempt.Sub(now); wait > tracker.retry.MaxDelay {
+		t.Fatalf("backoff grew to %v, past the %v cap", wait, tracker.retry.MaxDelay)
+	}
+
+	tracker.ack(id, generation)
+	if state := tracker.peers[id]; state.failures != 0 || !state.nextAttempt.IsZero() {
+		t.Fatalf("ack left backoff %+v", state)
+	}
+	if _, due := tracker.due([]peer.ID{id}, now); len(due) != 0 {
+		t.Fatalf("peer that acknowledged is due: %v", due)
+	}
+
+	// A new generation needs acknowledging again, without waiting out any
+	// backoff from the last one.
+	tracker.fail(id, now)
+	tracker.fail(id, now)
+	tracker.bump()
+	if _, due := tracker.due([]peer.ID{id}, now); !slices.Equal(due, []peer.ID{id}) {
+		t.Fatalf("peer not due after bump: %v", due)
+	}
+}
+
+// registrationDelay is the default registration policy's wait after
+// failures, without jitter.
+func registrationDelay(failures int) time.Duration {
+	p := config.DefaultRetry.Registration
+	p.Jitter = 0
+	return p.Delay(failures)
+}
+
+// TestRegistrationTrackerGivesUp checks a peer is no longer due once it has
+// failed the policy's attempts, until the next generation.
+func TestRegistrationTrackerGivesUp(t *testing.T) {
+	tracker := newRegistrationTracker(retry.Policy{MaxAttempts: 2, BaseDelay: time.Second})
+	id := test.RandPeerIDFatal(t)
+	now := time.Now()
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if _, due := tracker.due([]peer.ID{id}, now); len(due) != 1 {
+			t.Fatalf("attempt %d: peer not due", attempt)
+		}
+		tracker.fail(id, now)
+		now = tracker.peers[id].nextAttempt
+	}
+	if _, due := tracker.due([]peer.ID{id}, now.Add(time.Hour)); len(due) != 0 {
+		t.Fatalf("peer still due after its attempts: %v", due)
+	}
+
+	tracker.bump()
+	if _, due := tracker.due([]peer.ID{id}, now); len(due) != 1 {
+		t.Fatal("peer not due again after bump")
+	}
+}
+
+func TestRegistrationTrackerForgetsDisconnected(t *testing.T) {
+	tracker := newRegistrationTracker(config.DefaultRetry.Registration)
+	a, b := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	now := time.Now()
+
+	generation, _ := tracker.due([]peer.ID{a, b}, now)
+	tracker.ack(a, generation)
+	tracker.fail(b, now)
+
+	// b goes away and comes back: it is registered with at once, its earlier
+	// backoff forgotten.
+	tracker.due([]peer.ID{a}, now)
+	if _, ok := tracker.peers[b]; ok {
+		t.Fatal("disconnected peer still tracked")
+	}
+	if _, due := tracker.due([]peer.ID{a, b}, now); !slices.Equal(due, []peer.ID{b}) {
+		t.Fatalf("due = %v, want only the reconnected peer", due)
+	}
+}
+
+// TestSendRegistrationRetries registers with a peer that refuses until its
+// third attempt, and checks the agent backs off between attempts and stops
+// once the peer acknowledges.
+func TestSendRegistrationRetries(t *testing.T) {
+	a := newTestAgent(t, &config.Config{AgentName: "alpha"})
+	a.p2pHost = newTestPeer(t)
+	remote := newTestPeer(t)
+
+	var attempts atomic.Int32
+	remote.SetMessageHandler(func(ctx context.Context, from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+		if msg.Type != p2p.MessageTypeRegister {
+			return nil, nil
+		}
+		if attempts.Add(1) < 3 {
+			return nil, errors.New("not ready")
+		}
+		return &p2p.Message{Type: p2p.MessageTypePong, From: remote.ID().String()}, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := a.p2pHost.Connect(ctx, peer.AddrInfo{ID: remote.ID(), Addrs: remote.Addrs()}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	connected := []peer.ID{remote.ID()}
+	now := time.Now()
+	for attempt := 1; attempt <= 3; attempt++ {
+		generation, due := a.registrations.due(connected, now)
+		if !slices.Equal(due, connected) {
+			t.Fatalf("attempt %d: peer not due", attempt)
+		}
+		result := a.sendRegistration(ctx, generation, due)
+
+		state := a.registrations.peers[remote.ID()]
+		if attempt < 3 {
+			if result.Delivered != 0 || state.failures != attempt {
+				t.Fatalf("attempt %d: delivered %d with %d failures recorded", attempt, result.Delivered, state.failures)
+			}
+			if _, due := a.registrations.due(connected, now); len(due) != 0 {
+				t.Fatalf("attempt %d: retried without backing off", attempt)
+			}
+			now = state.nextAttempt
+			continue
+		}
+		if result.Delivered != 1 || state.acked != generation || state.failures != 0 {
+			t.Fatalf("attempt %d: delivered %d, state %+v", attempt, result.Delivered, state)
+		}
+	}
+
+	if _, due := a.registrations.due(connected, now.Add(time.Hour)); len(due) != 0 {
+		t.Fatalf("acknowledged peer is due again: %v", due)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Fatalf("peer was sent the registration %d times, want 3", got)
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"go.uber.org/zap"
+)
+
+// runStats accumulates the counters behind the shutdown report.
+type runStats struct {
+	started time.Time
+
+	served     atomic.Int64 // chat completions answered for clients
+	peerServed atomic.Int64 // chat completions answered for peers
+	failed     atomic.Int64
+	peerTokens atomic.Int64 // tokens spent on requests from peers
+	inFlight   atomic.Int64
+}
+
+func newRunStats() *runStats {
+	return &runStats{started: time.Now()}
+}
+
+// track counts a chat completion as in flight until the returned func is
+// called with its outcome.
+func (s *runStats) track(fromPeer bool) func(err error) {
+	s.inFlight.Add(1)
+	return func(err error) {
+		s.inFlight.Add(-1)
+		switch {
+		case err != nil:
+			s.failed.Add(1)
+		case fromPeer:
+			s.peerServed.Add(1)
+		default:
+			s.served.Add(1)
+		}
+	}
+}
+
+func (s *runStats) recordPeerUsage(usage *api.Usage) {
+	if usage != nil {
+		s.peerTokens.Add(int64(usage.TotalTokens))
+	}
+}
+
+// ShutdownReport summarises a run of the agent.
+type ShutdownReport struct {
+	Uptime         time.Duration
+	Requests       int // chat completions served to clients
+	PeerRequests   int // chat completions served to other agents
+	FailedRequests int
+	TotalTokens    int
+	PeersConnected int // at shutdown
+	Dropped        int // requests still in flight when the agent stopped
+}
+
+func (a *Agent) shutdownReport(peersConnected int) ShutdownReport {
+	return ShutdownReport{
+		Uptime:         time.Since(a.stats.started),
+		Requests:       int(a.stats.served.Load()),
+		PeerRequests:   int(a.stats.peerServed.Load()),
+		FailedRequests: int(a.stats.failed.Load()),
+		TotalTokens:    a.usage.Snapshot().Total.TotalTokens + int(a.stats.peerTokens.Load()),
+		PeersConnected: peersConnected,
+		Dropped:        int(a.stats.inFlight.Load()),
+	}
+}
+
+// Stats gathers the node's counters into one summary, as GET /v1/stats
+// serves it.
+func (a *Agent) Stats() *api.StatsResponse {
+	usage := a.usage.Snapshot().Total
+	broadcasts := a.p2pHost.Broadcasts()
+	resp := &api.StatsResponse{
+		Object:        "stats",
+		UptimeSeconds: int64(time.Since(a.stats.started).Seconds()),
+		Requests: api.RequestStats{
+			Served:     int(a.stats.served.Load()),
+			PeerServed: int(a.stats.peerServed.Load()),
+			Failed:     int(a.stats.failed.Load()),
+			InFlight:   int(a.stats.inFlight.Load()),
+		},
+		Tokens: api.TokenStats{
+			Prompt:     usage.PromptTokens,
+			Completion: usage.CompletionTokens,
+			Total:      usage.TotalTokens,
+			Peer:       int(a.stats.peerTokens.Load()),
+		},
+		Peers: api.PeerStats{
+			Connected:  a.connectedPeers(),
+			Known:      len(a.p2pHost.GetPeers()),
+			Registered: len(a.agentRecords()),
+		},
+		Broadcasts: api.BroadcastStats{
+			Sent:      broadcasts.Broadcasts,
+			Delivered: broadcasts.Delivered,
+			Failed:    broadcasts.Failed,
+		},
+		Breakers:    make(map[string]string, len(a.breakers)),
+		Concurrency: a.concurrency.snapshot(),
+	}
+	for name, breaker := range a.breakers {
+		resp.Breakers[name] = string(breaker.State())
+	}
+	return resp
+}
+
+func (a *Agent) HandleStats(ctx context.Context) (*api.StatsResponse, error) {
+	return a.Stats(), nil
+}
+
+func (a *Agent) connectedPeers() int {
+	n := 0
+	for _, p := range a.p2pHost.GetPeers() {
+		if p.Connected {
+			n++
+		}
+	}
+	return n
+}
+
+func (r ShutdownReport) log(logger *zap.Logger) {
+	logger.Info("Shutdown report",
+		zap.Duration("uptime", r.Uptime),
+		zap.Int("requests", r.Requests),
+		zap.Int("peer_requests", r.PeerRequests),
+		zap.Int("failed_requests", r.FailedRequests),
+		zap.Int("total_tokens", r.TotalTokens),
+		zap.Int("peers_connected", r.PeersConnected),
+		zap.Int("dropped_requests", r.Dropped))
+}
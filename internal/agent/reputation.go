@@ -0,0 +1,278 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	reputationGossipInterval = time.Minute
+	reputationSendTimeout    = 10 * time.Second
+
+	// Gossiped scores halve in weight every reputationHalfLife and are
+	// dropped after reputationMaxAge.
+	reputationHalfLife = 10 * time.Minute
+	reputationMaxAge   = time.Hour
+
+	// reputationMaxSkew bounds how far a report's IssuedAt may be from now.
+	reputationMaxSkew = 5 * time.Minute
+
+	// A single source's report counts as at most gossipSamplesPerSource
+	// observations, and all gossip about a peer together as at most
+	// maxGossipWeight, so a crowd of fake sources can't outvote what we have
+	// seen ourselves.
+	gossipSamplesPerSource = 20
+	maxGossipWeight        = 10.0
+
+	// unknownSourceWeight is the trust given to sources we have never called.
+	unknownSourceWeight = 0.5
+
+	maxReportsPerPayload = 256
+	latencyEWMAWeight    = 0.2
+)
+
+// peerStats are our own observations of chat calls to a peer.
+type peerStats struct {
+	successes int
+	failures  int
+	latency   time.Duration // moving average of successful calls
+}
+
+type gossipReport struct {
+	score    float64
+	samples  int
+	received time.Time
+}
+
+// reputationStore combines local observations with scores gossiped by other
+// agents into a per-peer reliability score.
+type reputationStore struct {
+	mu     sync.Mutex
+	local  map[peer.ID]*peerStats
+	gossip map[peer.ID]map[peer.ID]gossipReport // subject -> source -> report
+}
+
+func newReputationStore() *reputationStore {
+	return &reputationStore{
+		local:  make(map[peer.ID]*peerStats),
+		gossip: make(map[peer.ID]map[peer.ID]gossipReport),
+	}
+}
+
+func (r *reputationStore) record(peerID peer.ID, ok bool, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, exists := r.local[peerID]
+	if !exists {
+		stats = &peerStats{}
+		r.local[peerID] = stats
+	}
+	if !ok {
+		stats.failures++
+		return
+	}
+	if stats.successes == 0 {
+		stats.latency = latency
+	} else {
+		stats.latency += time.Duration(latencyEWMAWeight * float64(latency-stats.latency))
+	}
+	stats.successes++
+}
+
+// merge stores the reports source sent. Reports about ourselves or about the
+// source itself are ignored.
+func (r *reputationStore) merge(source, self peer.ID, reports []p2p.ReputationReport, now time.Time) {
+	if len(reports) > maxReportsPerPayload {
+		reports = reports[:maxReportsPerPayload]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, report := range reports {
+		subject, err := peer.Decode(report.PeerID)
+		if err != nil || subject == self || subject == source {
+			continue
+		}
+		if report.Samples <= 0 || report.Score < 0 || report.Score > 1 {
+			continue
+		}
+		if r.gossip[subject] == nil {
+			r.gossip[subject] = make(map[peer.ID]gossipReport)
+		}
+		r.gossip[subject][source] = gossipReport{score: report.Score, samples: report.Samples, received: now}
+	}
+}
+
+// score is the peer's estimated success rate. Local observations and
+// gossip are pooled as (weighted) sample counts on top of a neutral prior;
+// known reports whether there was anything to go on.
+func (r *reputationStore) score(peerID peer.ID, now time.Time) (score float64, known bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	successes, total := 1.0, 2.0 // Laplace prior: 0.5
+	if stats, ok := r.local[peerID]; ok {
+		successes += float64(stats.successes)
+		total += float64(stats.successes + stats.failures)
+		known = true
+	}
+
+	var gossipSuccesses, gossipWeight float64
+	for source, report := range r.gossip[peerID] {
+		age := now.Sub(report.received)
+		if age > reputationMaxAge {
+			continue
+		}
+		weight := r.sourceWeightLocked(source) * math.Pow(0.5, float64(age)/float64(reputationHalfLife)) *
+			float64(min(report.samples, gossipSamplesPerSource))
+		gossipSuccesses += weight * report.score
+		gossipWeight += weight
+		known = true
+	}
+	if gossipWeight > maxGossipWeight {
+		gossipSuccesses *= maxGossipWeight / gossipWeight
+		gossipWeight = maxGossipWeight
+	}
+
+	return (successes + gossipSuccesses) / (total + gossipWeight), known
+}
+
+// sourceWeightLocked trusts a gossip source as much as it has proven reliable
+// to us.
+func (r *reputationStore) sourceWeightLocked(source peer.ID) float64 {
+	stats, ok := r.local[source]
+	if !ok || stats.successes+stats.failures == 0 {
+		return unknownSourceWeight
+	}
+	return float64(stats.successes+1) / float64(stats.successes+stats.failures+2)
+}
+
+// reports is our local view, as gossiped to peers.
+func (r *reputationStore) reports() []p2p.ReputationReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports := make([]p2p.ReputationReport, 0, len(r.local))
+	for peerID, stats := range r.local {
+		samples := stats.successes + stats.failures
+		reports = append(reports, p2p.ReputationReport{
+			PeerID:    peerID.String(),
+			Score:     float64(stats.successes) / float64(samples),
+			LatencyMs: stats.latency.Milliseconds(),
+			Samples:   samples,
+		})
+	}
+	return reports
+}
+
+func (r *reputationStore) prune(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for subject, sources := range r.gossip {
+		for source, report := range sources {
+			if now.Sub(report.received) > reputationMaxAge {
+				delete(sources, source)
+			}
+		}
+		if len(sources) == 0 {
+			delete(r.gossip, subject)
+		}
+	}
+}
+
+// observePeer feeds the outcome of a chat call into the peer's reputation
+// and, if it succeeded, its latency histogram. Calls our own caller abandoned
+// say nothing about the peer.
+func (a *Agent) observePeer(ctx context.Context, peerID peer.ID, started time.Time, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	elapsed := time.Since(started)
+	a.reputation.record(peerID, err == nil, elapsed)
+	if err == nil {
+		a.latency.chat.Record(peerID.String(), elapsed)
+	}
+}
+
+// gossipReputation periodically sends our signed local view to every
+// connected agent.
+func (a *Agent) gossipReputation(ctx context.Context) {
+	ticker := time.NewTicker(reputationGossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.reputation.prune(time.Now())
+			a.broadcastReputation(ctx)
+		}
+	}
+}
+
+func (a *Agent) broadcastReputation(ctx context.Context) {
+	reports := a.reputation.reports()
+	if len(reports) == 0 {
+		return
+	}
+
+	payload := p2p.ReputationPayload{Reports: reports, IssuedAt: time.Now().Unix()}
+	unsigned, _ := json.Marshal(payload)
+	sig, err := a.p2pHost.Sign(unsigned)
+	if err != nil {
+		a.logger.Warn("Failed to sign reputation report", zap.Error(err))
+		return
+	}
+	payload.Signature = sig
+	payloadBytes, _ := json.Marshal(payload)
+
+	msg := &p2p.Message{
+		Type:    p2p.MessageTypeReputation,
+		From:    a.p2pHost.ID().String(),
+		Payload: payloadBytes,
+	}
+
+	a.p2pHost.SendToPeers(ctx, a.connectedAgents(), msg, reputationSendTimeout, func(pid peer.ID, err error) {
+		if err != nil {
+			a.logger.Debug("Failed to gossip reputation", a.p2pHost.PeerField("peer", pid), zap.Error(err))
+		}
+	})
+}
+
+func (a *Agent) handleReputation(from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+	var payload p2p.ReputationPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return nil, err
+	}
+
+	sig := payload.Signature
+	payload.Signature = nil
+	unsigned, _ := json.Marshal(payload)
+	if err := p2p.Verify(from, unsigned, sig); err != nil {
+		a.logger.Warn("Rejecting unsigned reputation report", a.p2pHost.PeerField("from", from), zap.Error(err))
+		return nil, err
+	}
+
+	now := time.Now()
+	if skew := now.Sub(time.Unix(payload.IssuedAt, 0)).Abs(); skew > reputationMaxSkew {
+		return nil, fmt.Errorf("reputation report issued %s away from local time", skew.Round(time.Second))
+	}
+
+	a.reputation.merge(from, a.p2pHost.ID(), payload.Reports, now)
+	return &p2p.Message{
+		Type: p2p.MessageTypePong,
+		From: a.p2pHost.ID().String(),
+	}, nil
+}
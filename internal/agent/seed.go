@@ -0,0 +1,25 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// handleSeedMessage answers peers while running as a --bootstrap-node. A seed
+// only helps others find each other: it acknowledges registrations and
+// announcements without tracking them and refuses everything else. Refusing
+// the agent probe (ping) is what makes agents list it as a plain peer.
+func (a *Agent) handleSeedMessage(ctx context.Context, from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+	switch msg.Type {
+	case p2p.MessageTypeRegister, p2p.MessageTypeAnnounce:
+		return &p2p.Message{
+			Type: p2p.MessageTypePong,
+			From: a.p2pHost.ID().String(),
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: bootstrap node does not handle %s messages", p2p.ErrUnsupportedMessage, msg.Type)
+	}
+}
@@ -0,0 +1,451 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const chunkObject = "chat.completion.chunk"
+
+func (a *Agent) HandleChatCompletionStream(ctx context.Context, req *api.ChatCompletionRequest, send func(*api.ChatCompletionChunk) error, pipe func(*api.EventStream) error) (err error) {
+	done := a.stats.track(false)
+	defer func() { done(err) }()
+
+	if err := a.policies.checkModel(ctx, req.Model); err != nil {
+		return err
+	}
+	if err := a.concurrency.acquire(ctx); err != nil {
+		return err
+	}
+	defer a.concurrency.release(ctx)
+	if err := a.admission.acquire(ctx); err != nil {
+		return err
+	}
+	defer a.admission.release()
+
+	return a.streamWithFallback(ctx, req, a.consumes(), a.recordStreamUsage(ctx, req.Model, send), a.recordPipedUsage(ctx, req.Model, pipe))
+}
+
+func (a *Agent) HandleSendToAgentStream(ctx context.Context, agentID string, req *api.ChatCompletionRequest, send func(*api.ChatCompletionChunk) error) (err error) {
+	done := a.stats.track(false)
+	defer func() { done(err) }()
+
+	if err := a.authorizePeer(ctx, req.Model); err != nil {
+		return err
+	}
+	if err := a.checkContext(req); err != nil {
+		return err
+	}
+	peerID, err := a.ResolveAgent(agentID)
+	if err != nil {
+		return err
+	}
+	if err := a.concurrency.acquire(ctx); err != nil {
+		return err
+	}
+	defer a.concurrency.release(ctx)
+	return a.streamChatFromPeer(ctx, peerID, req, a.recordStreamUsage(ctx, req.Model, send))
+}
+
+// recordStreamUsage wraps send so that usage reported on a chunk, usually the
+// last one, is counted against the client.
+func (a *Agent) recordStreamUsage(ctx context.Context, model string, send func(*api.ChatCompletionChunk) error) func(*api.ChatCompletionChunk) error {
+	clientID := api.ClientIDFromContext(ctx)
+	return func(chunk *api.ChatCompletionChunk) error {
+		if chunk.Usage != nil {
+			a.usage.Record(clientID, model, *chunk.Usage)
+		}
+		return send(chunk)
+	}
+}
+
+// recordPipedUsage is recordStreamUsage for piped event streams, picking the
+// usage out of the events as they are copied. A nil pipe stays nil.
+func (a *Agent) recordPipedUsage(ctx context.Context, model string, pipe func(*api.EventStream) error) func(*api.EventStream) error {
+	if pipe == nil {
+		return nil
+	}
+	clientID := api.ClientIDFromContext(ctx)
+	return func(events *api.EventStream) error {
+		tap := &usageTap{}
+		events.Body = io.TeeReader(events.Body, tap)
+		err := pipe(events)
+		if tap.usage != nil {
+			a.usage.Record(clientID, model, *tap.usage)
+		}
+		return err
+	}
+}
+
+// streamWithFallback is completeWithFallback for streaming requests. The
+// next provider is only tried while nothing has been sent; once a chunk has
+// reached the client a failure ends the stream. pipe, if not nil, is given a
+// local provider's events whenever they can go out unchanged.
+func (a *Agent) streamWithFallback(ctx context.Context, req *api.ChatCompletionRequest, allowPeers bool, send func(*api.ChatCompletionChunk) error, pipe func(*api.EventStream) error) error {
+	if err := a.checkContext(req); err != nil {
+		return err
+	}
+	chain, err := a.policies.allowedProviders(ctx, req.Model, a.providerChain(req.Model))
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for i, name := range chain {
+		served := name
+		started := false
+		relay := func(chunk *api.ChatCompletionChunk) error {
+			if !started {
+				started = true
+				chunk.Provider = served
+			}
+			return send(chunk)
+		}
+		var relayPipe func(*api.EventStream) error
+		if pipe != nil {
+			relayPipe = func(events *api.EventStream) error {
+				started = true
+				events.Provider = served
+				return pipe(events)
+			}
+		}
+
+		var err error
+		if name == config.PeerProvider {
+			if !allowPeers {
+				continue
+			}
+			record, ok := a.peerForModel(ctx, req.Model)
+			if !ok {
+				err = fmt.Errorf("no connected agent serves model %s", req.Model)
+			} else {
+				served = "peer:" + record.Name
+				err = a.streamChatFromPeer(ctx, record.PeerID, req, relay)
+			}
+		} else {
+			provider, exists := a.providers[name]
+			if !exists {
+				continue
+			}
+			breaker := a.breakers[name]
+			if !breaker.Allow() {
+				lastErr = fmt.Errorf("provider %s is unavailable (circuit open)", name)
+				continue
+			}
+
+			err = a.streamFromProvider(ctx, provider, req, relay, relayPipe)
+			switch {
+			case err == nil:
+				a.recordProviderResult(name, true)
+			case ctx.Err() != nil:
+				breaker.Abandon()
+			default:
+				a.recordProviderResult(name, !isRetryable(err))
+			}
+		}
+
+		if err == nil {
+			if i > 0 {
+				a.logger.Info("Stream served by fallback provider",
+					zap.String("model", req.Model),
+					zap.String("provider", served))
+			}
+			return nil
+		}
+
+		lastErr = err
+		if started || ctx.Err() != nil || !isRetryable(err) {
+			return err
+		}
+		a.logger.Warn("Provider failed, trying next in fallback chain",
+			zap.String("model", req.Model),
+			zap.String("provider", name),
+			zap.Error(err))
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no provider available for model %s", req.Model)
+	}
+	return lastErr
+}
+
+// streamFromProvider relays provider's streamed completion under the stream
+// timeout, keeping each event's own encoding and renaming only the model
+// where it has to be. When pipe is set and no event needs renaming, the
+// provider's event stream is handed to pipe instead, undecoded.
+func (a *Agent) streamFromProvider(ctx context.Context, provider Provider, req *api.ChatCompletionRequest, send func(*api.ChatCompletionChunk) error, pipe func(*api.EventStream) error) error {
+	if timeout := a.config.UpstreamStreamTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cfg := provider.Config()
+	started := time.Now()
+	first := true
+	relay := func(chunk *api.ChatCompletionChunk) error {
+		if first {
+			first = false
+			a.latency.firstChunk.Record(cfg.Name, time.Since(started))
+			chunk.Headers = a.passthroughHeaders(chunk.Headers)
+		}
+		if model := cfg.ReportedModel(req.Model, chunk.Model); model != chunk.Model {
+			chunk.Model = model
+			chunk.Raw = withModel(chunk.Raw, model)
+		}
+		return send(chunk)
+	}
+
+	raw, ok := provider.(rawStreamer)
+	if !ok || pipe == nil || !reportsUpstreamModel(cfg, req.Model) {
+		return provider.ChatCompletionStream(ctx, req, relay)
+	}
+	body, headers, err := raw.forwardToOpenAIStream(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if !isEventStream(headers) {
+		return sendResponseChunk(cfg.Name, body, headers, relay)
+	}
+	return pipe(&api.EventStream{
+		Body: &firstReadTimer{Reader: body, read: func() {
+			a.latency.firstChunk.Record(cfg.Name, time.Since(started))
+		}},
+		Headers: a.passthroughHeaders(headers),
+	})
+}
+
+// reportsUpstreamModel reports whether cfg's events can reach the client
+// under the model name the upstream gives them: the client asked for the
+// provider's own name, and the provider isn't set to report the requested
+// one. Piped events that give no model are passed on without one.
+func reportsUpstreamModel(cfg config.ProviderConfig, model string) bool {
+	return cfg.ProviderModel(model) == model && cfg.ResponseModel != config.ResponseModelRequested
+}
+
+// withModel returns the chunk encoded in raw with its model set to model and
+// every other field as it was, or nil, to have the chunk re-encoded, if raw
+// isn't a JSON object.
+func withModel(raw json.RawMessage, model string) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil
+	}
+	fields["model"], _ = json.Marshal(model)
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return rewritten
+}
+
+// firstReadTimer calls read once, on the first read that returns data.
+type firstReadTimer struct {
+	io.Reader
+	read func()
+}
+
+func (r *firstReadTimer) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 && r.read != nil {
+		r.read()
+		r.read = nil
+	}
+	return n, err
+}
+
+// usageTap is written a copy of a piped event stream and keeps the usage
+// reported by its events, holding no more of the stream than the current
+// line.
+type usageTap struct {
+	line     []byte
+	overlong bool
+	usage    *api.Usage
+}
+
+func (t *usageTap) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			t.append(p)
+			break
+		}
+		t.append(p[:i])
+		if !t.overlong {
+			t.scan(t.line)
+		}
+		t.line, t.overlong = t.line[:0], false
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// append adds p to the current line, giving up on lines longer than
+// maxSSELineLen.
+func (t *usageTap) append(p []byte) {
+	if t.overlong || len(t.line)+len(p) > maxSSELineLen {
+		t.line, t.overlong = t.line[:0], true
+		return
+	}
+	t.line = append(t.line, p...)
+}
+
+// scan decodes line only when it is an event mentioning usage, so the
+// deltas that make up most of a stream are passed over unparsed.
+func (t *usageTap) scan(line []byte) {
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok || !bytes.Contains(data, []byte(`"usage"`)) {
+		return
+	}
+	var event struct {
+		Usage *api.Usage `json:"usage"`
+	}
+	if json.Unmarshal(data, &event) == nil && event.Usage != nil {
+		t.usage = event.Usage
+	}
+}
+
+// streamChatFromPeer sends a streaming chat request to peerID and relays the
+// remote agent's chunks as their frames arrive. An error frame after some
+// chunks went out is reported as the agent failing mid-stream.
+func (a *Agent) streamChatFromPeer(ctx context.Context, peerID peer.ID, req *api.ChatCompletionRequest, send func(*api.ChatCompletionChunk) error) error {
+	streamReq := *req
+	streamReq.Stream = true
+	payload, _ := json.Marshal(&streamReq)
+	msg := &p2p.Message{
+		Type:      p2p.MessageTypeChat,
+		From:      a.p2pHost.ID().String(),
+		To:        peerID.String(),
+		RequestID: uuid.New().String(),
+		Payload:   payload,
+	}
+
+	started := time.Now()
+	relayed := 0
+	err := a.p2pHost.SendStream(ctx, peerID, msg, func(frame *p2p.Message) error {
+		if frame.Type != p2p.MessageTypeChunk {
+			return fmt.Errorf("unexpected %s frame from agent", frame.Type)
+		}
+		chunk, err := decodeChunk(frame.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to parse agent response: %w", err)
+		}
+		relayed++
+		return send(chunk)
+	})
+	var peerErr *p2p.PeerError
+	if relayed > 0 && errors.As(err, &peerErr) {
+		err = fmt.Errorf("agent failed mid-stream after %d chunks: %w", relayed, err)
+	}
+	a.observePeer(ctx, peerID, started, err)
+	return err
+}
+
+// handleP2PStream answers streaming chat requests from peers with one
+// MessageTypeChunk frame per chunk; the host ends the stream, with an error
+// frame if the completion fails partway. Other streaming requests get the
+// usual single response.
+func (a *Agent) handleP2PStream(ctx context.Context, from peer.ID, msg *p2p.Message, send func(*p2p.Message) error) error {
+	if msg.Type != p2p.MessageTypeChat {
+		resp, err := a.handleP2PMessage(ctx, from, msg)
+		if err != nil || resp == nil {
+			return err
+		}
+		return send(resp)
+	}
+	if !a.serves() {
+		return send(a.refuseChat(from))
+	}
+
+	var chatReq api.ChatCompletionRequest
+	if err := json.Unmarshal(msg.Payload, &chatReq); err != nil {
+		return err
+	}
+
+	done := a.stats.track(true)
+	err := a.streamWithFallback(ctx, &chatReq, false, func(chunk *api.ChatCompletionChunk) error {
+		a.stats.recordPeerUsage(chunk.Usage)
+		payload := chunk.Raw
+		if payload == nil {
+			payload, _ = json.Marshal(chunk)
+		}
+		return send(&p2p.Message{
+			Type:      p2p.MessageTypeChunk,
+			From:      a.p2pHost.ID().String(),
+			RequestID: msg.RequestID,
+			Payload:   payload,
+		})
+	}, nil)
+	done(err)
+	return err
+}
+
+// decodeChunk parses a frame payload. Agents that predate streaming answer
+// with a complete response, which becomes a single chunk.
+func decodeChunk(payload json.RawMessage) (*api.ChatCompletionChunk, error) {
+	var probe struct {
+		Object string `json:"object"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, err
+	}
+
+	if probe.Object == chunkObject {
+		chunk := api.ChatCompletionChunk{Raw: payload}
+		if err := json.Unmarshal(payload, &chunk); err != nil {
+			return nil, err
+		}
+		return &chunk, nil
+	}
+
+	var resp api.ChatCompletionResponse
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return nil, err
+	}
+	return chunkFromResponse(&resp), nil
+}
+
+// chunkFromResponse turns a complete response into one chunk carrying the
+// whole message as its delta.
+func chunkFromResponse(resp *api.ChatCompletionResponse) *api.ChatCompletionChunk {
+	usage := resp.Usage
+	chunk := &api.ChatCompletionChunk{
+		ID:      resp.ID,
+		Object:  chunkObject,
+		Created: resp.Created,
+		Model:   resp.Model,
+		Usage:   &usage,
+	}
+	for _, choice := range resp.Choices {
+		finish := choice.FinishReason
+		delta := api.Delta{Role: choice.Message.Role, Content: choice.Message.Content}
+		for i, call := range choice.Message.ToolCalls {
+			delta.ToolCalls = append(delta.ToolCalls, api.ToolCallDelta{
+				Index:    i,
+				ID:       call.ID,
+				Type:     call.Type,
+				Function: api.FunctionCallDelta{Name: call.Function.Name, Arguments: call.Function.Arguments},
+			})
+		}
+		chunk.Choices = append(chunk.Choices, api.ChunkChoice{
+			Index:        choice.Index,
+			Delta:        delta,
+			FinishReason: &finish,
+		})
+	}
+	return chunk
+}
@@ -0,0 +1,320 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// newTestPeer starts a P2P host without a DHT, closed when the test ends.
+func newTestPeer(t *testing.T) *p2p.Host {
+	t.Helper()
+	h, err := p2p.NewHost(context.Background(), p2p.Options{NoDHT: true}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewHost: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func toolCallResponse() *api.ChatCompletionResponse {
+	return &api.ChatCompletionResponse{
+		ID:      "chatcmpl-1",
+		Object:  "chat.completion",
+		Created: 1,
+		Model:   "gpt-4",
+		Choices: []api.Choice{{
+			Message: api.Message{
+				Role: "assistant",
+				ToolCalls: []api.ToolCall{
+					{ID: "call_weather", Type: "function", Function: api.FunctionCall{Name: "get_weather", Arguments: `{"city":"Oslo"}`}},
+					{ID: "call_time", Type: "function", Function: api.FunctionCall{Name: "get_time", Arguments: `{"tz":"CET"}`}},
+				},
+			},
+			FinishReason: "tool_calls",
+		}},
+		Usage: api.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}
+}
+
+// streamedToolCalls are the chunks of a provider streaming the two calls of
+// toolCallResponse, arguments split across chunks.
+func streamedToolCalls() []string {
+	return []string{
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_weather","type":"function","function":{"name":"get_weather","arguments":""}}]},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":\"Oslo\"}"}}]},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_time","type":"function","function":{"name":"get_time","arguments":""}}]},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{\"tz\":\"CET\"}"}}]},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	}
+}
+
+// TestSendToAgentStreamRelaysToolCalls streams a tool-calling completion
+// from a client through one agent to the peer that answers it, and checks
+// the client gets both calls in order under their own index, whether the
+// peer streams or answers the way agents that predate streaming do.
+func TestSendToAgentStreamRelaysToolCalls(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(remote *p2p.Host)
+		wantChunks int
+	}{
+		{
+			// Agents without a stream handler answer a streaming request
+			// with their usual single response.
+			name: "complete response",
+			setup: func(remote *p2p.Host) {
+				remote.SetMessageHandler(func(ctx context.Context, from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+					payload, _ := json.Marshal(toolCallResponse())
+					return &p2p.Message{Type: p2p.MessageTypeComplete, From: remote.ID().String(), Payload: payload}, nil
+				})
+			},
+			wantChunks: 1,
+		},
+		{
+			// Over the legacy protocol the same response arrives as a chunk.
+			name: "complete response as a chunk",
+			setup: func(remote *p2p.Host) {
+				remote.SetStreamHandler(func(ctx context.Context, from peer.ID, msg *p2p.Message, send func(*p2p.Message) error) error {
+					payload, _ := json.Marshal(toolCallResponse())
+					return send(&p2p.Message{Type: p2p.MessageTypeChunk, From: remote.ID().String(), Payload: payload})
+				})
+			},
+			wantChunks: 1,
+		},
+		{
+			name: "streamed deltas",
+			setup: func(remote *p2p.Host) {
+				remote.SetStreamHandler(func(ctx context.Context, from peer.ID, msg *p2p.Message, send func(*p2p.Message) error) error {
+					for _, chunk := range streamedToolCalls() {
+						if err := send(&p2p.Message{Type: p2p.MessageTypeChunk, From: remote.ID().String(), Payload: json.RawMessage(chunk)}); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+			wantChunks: len(streamedToolCalls()),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, &config.Config{})
+			a.p2pHost = newTestPeer(t)
+			remote := newTestPeer(t)
+			tt.setup(remote)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := a.p2pHost.Connect(ctx, peer.AddrInfo{ID: remote.ID(), Addrs: remote.Addrs()}); err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+
+			var chunks []*api.ChatCompletionChunk
+			req := &api.ChatCompletionRequest{
+				Model:    "gpt-4",
+				Messages: []api.Message{{Role: "user", Content: "Weather and time in Oslo?"}},
+			}
+			err := a.HandleSendToAgentStream(ctx, remote.ID().String(), req, func(chunk *api.ChatCompletionChunk) error {
+				chunks = append(chunks, chunk)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("HandleSendToAgentStream: %v", err)
+			}
+			if len(chunks) != tt.wantChunks {
+				t.Fatalf("relayed %d chunks, want %d", len(chunks), tt.wantChunks)
+			}
+
+			// Put the calls back together the way a client would, by index.
+			type call struct {
+				id, name, args string
+			}
+			var calls []call
+			var finish string
+			for _, chunk := range chunks {
+				if len(chunk.Choices) != 1 {
+					t.Fatalf("chunk has %d choices, want 1", len(chunk.Choices))
+				}
+				choice := chunk.Choices[0]
+				for _, delta := range choice.Delta.ToolCalls {
+					switch {
+					case delta.Index == len(calls):
+						calls = append(calls, call{id: delta.ID, name: delta.Function.Name})
+					case delta.Index != len(calls)-1:
+						t.Fatalf("tool call delta with index %d after %d calls", delta.Index, len(calls))
+					}
+					calls[delta.Index].args += delta.Function.Arguments
+				}
+				if choice.FinishReason != nil {
+					finish = *choice.FinishReason
+				}
+			}
+
+			want := []call{
+				{id: "call_weather", name: "get_weather", args: `{"city":"Oslo"}`},
+				{id: "call_time", name: "get_time", args: `{"tz":"CET"}`},
+			}
+			if len(calls) != len(want) {
+				t.Fatalf("got tool calls %+v, want %+v", calls, want)
+			}
+			for i := range want {
+				if calls[i] != want[i] {
+					t.Errorf("tool call %d is %+v, want %+v", i, calls[i], want[i])
+				}
+			}
+			if finish != "tool_calls" {
+				t.Errorf("finish_reason is %q, want tool_calls", finish)
+			}
+		})
+	}
+}
+
+func TestDecodeChunkFromResponse(t *testing.T) {
+	payload, _ := json.Marshal(toolCallResponse())
+	chunk, err := decodeChunk(payload)
+	if err != nil {
+		t.Fatalf("decodeChunk: %v", err)
+	}
+
+	encoded, _ := json.Marshal(chunk)
+	var got struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Delta struct {
+				Role      string `json:"role"`
+				ToolCalls []struct {
+					Index    *int   `json:"index"`
+					ID       string `json:"id"`
+					Function struct {
+						Name string `json:"name"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"delta"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("chunk does not encode as JSON: %v", err)
+	}
+	if got.Object != chunkObject || len(got.Choices) != 1 {
+		t.Fatalf("got %s", encoded)
+	}
+	choice := got.Choices[0]
+	if choice.Delta.Role != "assistant" || choice.FinishReason != "tool_calls" || len(choice.Delta.ToolCalls) != 2 {
+		t.Fatalf("got %s", encoded)
+	}
+	for i, call := range choice.Delta.ToolCalls {
+		if call.Index == nil || *call.Index != i {
+			t.Errorf("tool call %d has index %v in %s", i, call.Index, encoded)
+		}
+	}
+	if choice.Delta.ToolCalls[0].ID != "call_weather" || choice.Delta.ToolCalls[1].Function.Name != "get_time" {
+		t.Errorf("tool calls out of order in %s", encoded)
+	}
+}
+
+// TestHandleChatCompletionStreamPipes streams from a local provider and
+// checks its events are piped through untouched when the model needs no
+// renaming, with the usage they report still counted, and that a renamed
+// model is rewritten in each event's own encoding otherwise.
+func TestHandleChatCompletionStreamPipes(t *testing.T) {
+	events := []string{
+		`{"id":"c1","object":"chat.completion.chunk","created":1,"model":"gpt-4-0613","choices":[{"index":0,"delta":{"content":"hi"},"finish_reason":null}],"x_provider":"kept"}`,
+		`{"id":"c1","object":"chat.completion.chunk","created":1,"model":"gpt-4-0613","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`,
+	}
+	var stream strings.Builder
+	for _, event := range events {
+		stream.WriteString("data: " + event + "\n\n")
+	}
+	stream.WriteString("data: [DONE]\n\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, stream.String())
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name      string
+		aliases   map[string]string
+		wantPiped bool
+	}{
+		{name: "piped", wantPiped: true},
+		{name: "renamed", aliases: map[string]string{"gpt-4": "gpt-4-0613"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAgent(t, &config.Config{
+				Providers: []config.ProviderConfig{{Name: config.DefaultProvider, BaseURL: srv.URL, ModelAliases: tt.aliases}},
+			})
+
+			var piped string
+			var chunks []*api.ChatCompletionChunk
+			err := a.HandleChatCompletionStream(context.Background(), &api.ChatCompletionRequest{
+				Model:    "gpt-4",
+				Messages: []api.Message{{Role: "user", Content: "hi"}},
+			}, func(chunk *api.ChatCompletionChunk) error {
+				chunks = append(chunks, chunk)
+				return nil
+			}, func(events *api.EventStream) error {
+				if events.Provider != config.DefaultProvider {
+					t.Errorf("piped from %q, want %s", events.Provider, config.DefaultProvider)
+				}
+				body, err := io.ReadAll(events.Body)
+				piped = string(body)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("HandleChatCompletionStream: %v", err)
+			}
+
+			if tt.wantPiped {
+				if piped != stream.String() || chunks != nil {
+					t.Fatalf("piped %q and sent %d chunks, want the upstream's stream only", piped, len(chunks))
+				}
+			} else {
+				if piped != "" || len(chunks) != len(events) {
+					t.Fatalf("piped %q and sent %d chunks, want %d chunks", piped, len(chunks), len(events))
+				}
+				for _, chunk := range chunks {
+					var fields map[string]any
+					json.Unmarshal(chunk.Raw, &fields)
+					if fields["model"] != "gpt-4" || chunk.Model != "gpt-4" {
+						t.Errorf("chunk %s reports model %q, want gpt-4", chunk.Raw, chunk.Model)
+					}
+				}
+				if !strings.Contains(string(chunks[0].Raw), `"x_provider":"kept"`) {
+					t.Errorf("renaming dropped fields: %s", chunks[0].Raw)
+				}
+			}
+			if total := a.usage.Snapshot().Total; total.Requests != 1 || total.TotalTokens != 4 {
+				t.Errorf("usage %+v, want the stream's 4 tokens", total)
+			}
+		})
+	}
+}
+
+// TestUsageTap checks usage is found in an event split across writes, and
+// that an overlong line is skipped without losing the events after it.
+func TestUsageTap(t *testing.T) {
+	stream := "data: " + strings.Repeat("x", maxSSELineLen) + "\n\n" +
+		`data: {"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}` + "\n\ndata: [DONE]\n\n"
+	tap := &usageTap{}
+	for i := 0; i < len(stream); i += 7 {
+		tap.Write([]byte(stream[i:min(i+7, len(stream))]))
+	}
+	if tap.usage == nil || tap.usage.TotalTokens != 4 {
+		t.Fatalf("usage %+v, want 4 tokens", tap.usage)
+	}
+}
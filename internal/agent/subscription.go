@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"sync"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"go.uber.org/zap"
+)
+
+// subscription is the set of announcement types and tags this agent cares
+// about. Announcements outside it are still relayed, so gossip keeps
+// reaching the rest of the network, but not logged or published as events.
+type subscription struct {
+	mu    sync.RWMutex
+	types tagVocabulary
+	tags  tagVocabulary
+}
+
+func newSubscription(types, tags []string) *subscription {
+	s := &subscription{}
+	s.set(types, tags)
+	return s
+}
+
+// set replaces the subscription. Types and tags are normalized like tags;
+// an empty list matches anything.
+func (s *subscription) set(types, tags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.types = newTagVocabulary(types)
+	s.tags = newTagVocabulary(tags)
+}
+
+// matches reports whether an announcement has a subscribed type and at least
+// one subscribed tag.
+func (s *subscription) matches(p *p2p.AnnouncePayload) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.types != nil && !s.types[normalizeTag(p.Type)] {
+		return false
+	}
+	if s.tags == nil {
+		return true
+	}
+	for _, tag := range p.Tags {
+		if s.tags[normalizeTag(tag)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *subscription) info() *api.AnnouncementSubscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &api.AnnouncementSubscription{
+		Types: s.types.list(),
+		Tags:  s.tags.list(),
+	}
+}
+
+func (a *Agent) HandleGetSubscription(ctx context.Context) (*api.AnnouncementSubscription, error) {
+	return a.subscribed.info(), nil
+}
+
+func (a *Agent) HandleSetSubscription(ctx context.Context, req *api.AnnouncementSubscription) (*api.AnnouncementSubscription, error) {
+	a.subscribed.set(req.Types, req.Tags)
+	info := a.subscribed.info()
+	a.logger.Info("Updated announcement subscription", zap.Strings("types", info.Types), zap.Strings("tags", info.Tags))
+	return info, nil
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	tiktoken "github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+const (
+	// Each message costs tokensPerMessage for its role and framing, and
+	// every reply tokensPerReply to prime it, as in OpenAI's chat format.
+	tokensPerMessage = 4
+	tokensPerReply   = 3
+
+	// Models of a family with no tokenizer here, Llama, Mistral or Claude
+	// say, are counted with fallbackEncoding instead. That count is only
+	// close, and their often smaller vocabularies split text into more
+	// tokens, so it is raised by fallbackMarginPercent.
+	fallbackEncoding      = tiktoken.MODEL_CL100K_BASE
+	fallbackMarginPercent = 15
+
+	// Should an encoding fail to load, a token is taken to be bytesPerToken
+	// bytes of text, the usual average for English, with the same margin.
+	bytesPerToken = 4
+)
+
+// encodings holds each tokenizer once built, as building one takes a while.
+// They are read from the encodings embedded in the binary, never downloaded.
+var encodings = struct {
+	once   sync.Once
+	mu     sync.Mutex
+	byName map[string]*tiktoken.Tiktoken
+}{byName: make(map[string]*tiktoken.Tiktoken)}
+
+// encoding returns the tokenizer for the named encoding.
+func encoding(name string) (*tiktoken.Tiktoken, error) {
+	encodings.once.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	})
+	encodings.mu.Lock()
+	defer encodings.mu.Unlock()
+	if enc, ok := encodings.byName[name]; ok {
+		return enc, nil
+	}
+	enc, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	encodings.byName[name] = enc
+	return enc, nil
+}
+
+// tokenizerFor returns the tokenizer of model's family, and whether it is
+// the model's own rather than fallbackEncoding. It looks the family up as
+// tiktoken.EncodingForModel does, preferring the longest matching prefix,
+// but keeps the tokenizer rather than building a new one each time.
+func tokenizerFor(model string) (*tiktoken.Tiktoken, bool, error) {
+	name, exact := fallbackEncoding, false
+	if id, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		name, exact = id, true
+	} else {
+		matched := ""
+		for prefix, id := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+			if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+				matched, name, exact = prefix, id, true
+			}
+		}
+	}
+	enc, err := encoding(name)
+	return enc, exact, err
+}
+
+// promptTexts are the parts of req's messages and tools that take up tokens.
+func promptTexts(req *api.ChatCompletionRequest) []string {
+	texts := []string{string(req.Tools)}
+	for _, m := range req.Messages {
+		texts = append(texts, m.Role, m.Content, m.ToolCallID)
+		for _, call := range m.ToolCalls {
+			texts = append(texts, call.Function.Name, call.Function.Arguments)
+		}
+	}
+	return texts
+}
+
+// countPromptTokens counts the tokens req's messages and tools take up for
+// model, the name the provider knows the model by. Counts made without the
+// model's own tokenizer include the fallback margin.
+func countPromptTokens(model string, req *api.ChatCompletionRequest) int {
+	n := 0
+	enc, exact, err := tokenizerFor(model)
+	for _, text := range promptTexts(req) {
+		switch {
+		case text == "":
+		case err != nil:
+			n += (len(text) + bytesPerToken - 1) / bytesPerToken
+		default:
+			n += len(enc.EncodeOrdinary(text))
+		}
+	}
+	if !exact || err != nil {
+		n += (n*fallbackMarginPercent + 99) / 100
+	}
+	return tokensPerReply + len(req.Messages)*tokensPerMessage + n
+}
+
+// tokenizerModel is the model name to choose a tokenizer by: the name the
+// first provider for model knows it by, as a client's name may be an alias.
+func (a *Agent) tokenizerModel(model string) string {
+	if provider, ok := a.providers[a.providerChain(model)[0]]; ok {
+		return provider.Config().ProviderModel(model)
+	}
+	return model
+}
+
+// checkContext refuses requests the model's configured context window can't
+// hold, counting the max_tokens asked for, rather than have the provider
+// reject them after a round trip.
+func (a *Agent) checkContext(req *api.ChatCompletionRequest) error {
+	limit := a.config.MaxContext(req.Model)
+	if limit == 0 {
+		return nil
+	}
+
+	prompt := countPromptTokens(a.tokenizerModel(req.Model), req)
+	switch {
+	case prompt > limit:
+		return fmt.Errorf("%w: prompt is about %d tokens, over the %d token context window of model %s",
+			api.ErrInvalidRequest, prompt, limit, req.Model)
+	case req.MaxTokens > 0 && prompt+req.MaxTokens > limit:
+		return fmt.Errorf("%w: prompt of about %d tokens plus max_tokens %d exceeds the %d token context window of model %s",
+			api.ErrInvalidRequest, prompt, req.MaxTokens, limit, req.Model)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+)
+
+func TestCountPromptTokens(t *testing.T) {
+	// "hello world" is two tokens in both cl100k_base and o200k_base.
+	req := &api.ChatCompletionRequest{Messages: []api.Message{{Role: "user", Content: "hello world"}}}
+	framing := tokensPerReply + tokensPerMessage + 1 // the role is one token
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{model: "gpt-4", want: framing + 2},
+		{model: "gpt-4o-2024-08-06", want: framing + 2},
+		{model: "llama3", want: framing + 3}, // counted as cl100k_base, plus the margin
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := countPromptTokens(tt.model, req); got != tt.want {
+				t.Fatalf("counted %d tokens, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCheckContext checks the window is enforced by the tokenizer of the
+// model the provider gets, and that counting is safe from many requests at
+// once.
+func TestCheckContext(t *testing.T) {
+	a := newTestAgent(t, &config.Config{
+		Providers: []config.ProviderConfig{{Name: config.DefaultProvider, BaseURL: "http://localhost:1"}},
+		Models: []config.ModelRoute{
+			{Name: "fast", MaxContext: 100, Aliases: map[string]string{config.DefaultProvider: "gpt-4o-mini"}},
+		},
+	})
+	if got := a.tokenizerModel("fast"); got != "gpt-4o-mini" {
+		t.Fatalf("tokenizer chosen by %q, want the provider's gpt-4o-mini", got)
+	}
+
+	// Repeated words are one token each, where bytes/4 would count 1.5.
+	fits := &api.ChatCompletionRequest{Model: "fast", Messages: []api.Message{{Role: "user", Content: strings.Repeat(" hello", 80)}}}
+	tooLong := &api.ChatCompletionRequest{Model: "fast", Messages: []api.Message{{Role: "user", Content: strings.Repeat(" hello", 100)}}}
+	withReply := &api.ChatCompletionRequest{Model: "fast", MaxTokens: 50, Messages: fits.Messages}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.checkContext(fits); err != nil {
+				t.Errorf("refused a prompt that fits: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := a.checkContext(tooLong); err == nil || !strings.Contains(err.Error(), "100 token context window of model fast") {
+		t.Errorf("got %v, want the window named", err)
+	}
+	if err := a.checkContext(withReply); err == nil || !strings.Contains(err.Error(), "max_tokens 50") {
+		t.Errorf("got %v, want max_tokens counted", err)
+	}
+}
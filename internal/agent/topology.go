@@ -0,0 +1,371 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	topologyGossipInterval = time.Minute
+	topologySendTimeout    = 10 * time.Second
+
+	// Neighbor lists are dropped once topologyMaxAge old, and refused if
+	// issued further than that from now.
+	topologyMaxAge = 5 * time.Minute
+
+	maxListsPerPayload  = 64
+	maxNeighborsPerList = 128
+	maxTopologyLists    = 512
+)
+
+type topologyEntry struct {
+	list p2p.NeighborList
+	hops int // from us: 1 for a direct peer's own list
+}
+
+// topologyStore holds the neighbor lists gossiped by agents up to
+// --topology-depth hops away, by origin peer ID.
+type topologyStore struct {
+	mu    sync.Mutex
+	lists map[peer.ID]topologyEntry
+}
+
+func newTopologyStore() *topologyStore {
+	return &topologyStore{lists: make(map[peer.ID]topologyEntry)}
+}
+
+// merge stores the lists source sent that are within depth hops of us and
+// signed by their origin. A newer list replaces an older one, and of two
+// copies of the same list the one from fewer hops away is kept.
+func (t *topologyStore) merge(source, self peer.ID, lists []p2p.NeighborList, depth int, now time.Time) {
+	if len(lists) > maxListsPerPayload {
+		lists = lists[:maxListsPerPayload]
+	}
+
+	for _, list := range lists {
+		origin, err := peer.Decode(list.PeerID)
+		if err != nil || origin == self || list.Hops < 0 || list.Hops+1 > depth {
+			continue
+		}
+		// Only the source itself is zero hops from the source.
+		if (origin == source) != (list.Hops == 0) {
+			continue
+		}
+		if now.Sub(time.Unix(list.IssuedAt, 0)).Abs() > topologyMaxAge || len(list.Neighbors) > maxNeighborsPerList {
+			continue
+		}
+		if err := verifyNeighborList(origin, list); err != nil {
+			continue
+		}
+		hops := list.Hops + 1
+
+		t.mu.Lock()
+		existing, exists := t.lists[origin]
+		switch {
+		case !exists && len(t.lists) >= maxTopologyLists:
+		case exists && (existing.list.IssuedAt > list.IssuedAt || (existing.list.IssuedAt == list.IssuedAt && existing.hops <= hops)):
+		default:
+			list.Hops = 0
+			t.lists[origin] = topologyEntry{list: list, hops: hops}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// relayable are the stored lists to pass on to peers, which see them one hop
+// further away: those less than depth hops from us, nearest first.
+func (t *topologyStore) relayable(depth int) []p2p.NeighborList {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var entries []topologyEntry
+	for _, entry := range t.lists {
+		if entry.hops < depth {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].hops < entries[j].hops
+	})
+
+	lists := make([]p2p.NeighborList, 0, len(entries))
+	for _, entry := range entries {
+		list := entry.list
+		list.Hops = entry.hops
+		lists = append(lists, list)
+	}
+	return lists
+}
+
+func (t *topologyStore) entries() []topologyEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]topologyEntry, 0, len(t.lists))
+	for _, entry := range t.lists {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (t *topologyStore) prune(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for origin, entry := range t.lists {
+		if now.Sub(time.Unix(entry.list.IssuedAt, 0)) > topologyMaxAge {
+			delete(t.lists, origin)
+		}
+	}
+}
+
+func verifyNeighborList(origin peer.ID, list p2p.NeighborList) error {
+	sig := list.Signature
+	list.Hops = 0
+	list.Signature = nil
+	unsigned, _ := json.Marshal(list)
+	return p2p.Verify(origin, unsigned, sig)
+}
+
+// connectedAgents are the registered agents we are connected to.
+func (a *Agent) connectedAgents() []peer.ID {
+	var peers []peer.ID
+	for _, record := range a.agentRecords() {
+		if p, exists := a.p2pHost.GetPeer(record.PeerID); exists && p.Connected {
+			peers = append(peers, record.PeerID)
+		}
+	}
+	return peers
+}
+
+// neighborList is our own signed neighbor list: the agents we are connected
+// to, and what we advertise.
+func (a *Agent) neighborList() (p2p.NeighborList, error) {
+	neighbors := make([]string, 0)
+	for _, pid := range a.connectedAgents() {
+		neighbors = append(neighbors, pid.String())
+	}
+	sort.Strings(neighbors)
+	if len(neighbors) > maxNeighborsPerList {
+		neighbors = neighbors[:maxNeighborsPerList]
+	}
+
+	a.identity.mu.RLock()
+	list := p2p.NeighborList{
+		PeerID:    a.p2pHost.ID().String(),
+		Name:      a.identity.name,
+		Labels:    a.identity.labels,
+		Neighbors: neighbors,
+		IssuedAt:  time.Now().Unix(),
+	}
+	if a.serves() {
+		list.Models = a.identity.models
+	}
+	a.identity.mu.RUnlock()
+
+	unsigned, _ := json.Marshal(list)
+	sig, err := a.p2pHost.Sign(unsigned)
+	if err != nil {
+		return p2p.NeighborList{}, err
+	}
+	list.Signature = sig
+	return list, nil
+}
+
+// gossipTopology periodically sends our neighbor list, and those learned
+// from peers less than --topology-depth hops away, to every connected agent.
+// It is only run with a depth set.
+func (a *Agent) gossipTopology(ctx context.Context) {
+	ticker := time.NewTicker(topologyGossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.topology.prune(time.Now())
+			a.broadcastTopology(ctx)
+		}
+	}
+}
+
+func (a *Agent) broadcastTopology(ctx context.Context) {
+	targets := a.connectedAgents()
+	if len(targets) == 0 {
+		return
+	}
+
+	own, err := a.neighborList()
+	if err != nil {
+		a.logger.Warn("Failed to sign neighbor list", zap.Error(err))
+		return
+	}
+	lists := append([]p2p.NeighborList{own}, a.topology.relayable(a.config.TopologyDepth)...)
+	if len(lists) > maxListsPerPayload {
+		lists = lists[:maxListsPerPayload]
+	}
+
+	payload, _ := json.Marshal(p2p.TopologyPayload{Lists: lists})
+	msg := &p2p.Message{
+		Type:    p2p.MessageTypeTopology,
+		From:    a.p2pHost.ID().String(),
+		Payload: payload,
+	}
+
+	a.p2pHost.SendToPeers(ctx, targets, msg, topologySendTimeout, func(pid peer.ID, err error) {
+		if err != nil {
+			a.logger.Debug("Failed to gossip topology", a.p2pHost.PeerField("peer", pid), zap.Error(err))
+		}
+	})
+}
+
+// handleTopology stores a peer's neighbor lists. Agents without
+// --topology-depth acknowledge them without keeping anything.
+func (a *Agent) handleTopology(from peer.ID, msg *p2p.Message) (*p2p.Message, error) {
+	if a.config.TopologyDepth > 0 {
+		var payload p2p.TopologyPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return nil, err
+		}
+		a.topology.merge(from, a.p2pHost.ID(), payload.Lists, a.config.TopologyDepth, time.Now())
+	}
+
+	return &p2p.Message{
+		Type: p2p.MessageTypePong,
+		From: a.p2pHost.ID().String(),
+	}, nil
+}
+
+func (a *Agent) HandleTopology(ctx context.Context) (*api.TopologyResponse, error) {
+	self := a.p2pHost.ID().String()
+	nodes := make(map[string]*api.TopologyNode)
+	edges := make(map[[2]string]*api.TopologyEdge)
+
+	node := func(id string) *api.TopologyNode {
+		if n, ok := nodes[id]; ok {
+			return n
+		}
+		n := &api.TopologyNode{ID: id, Kind: api.AgentKindUnknown}
+		if record, exists := a.agentRecord(id); exists {
+			n.Kind = api.AgentKindAgent
+			n.Name = record.Name
+			n.Models = record.Models
+			n.Labels = record.Labels
+		} else if kind, probed := a.peerKind(id); probed {
+			n.Kind = kind
+		}
+		nodes[id] = n
+		return n
+	}
+	edge := func(from, to string, gossiped bool) {
+		if from == to {
+			return
+		}
+		key := [2]string{min(from, to), max(from, to)}
+		if e, ok := edges[key]; ok {
+			e.Gossiped = e.Gossiped && gossiped
+			return
+		}
+		edges[key] = &api.TopologyEdge{Source: key[0], Target: key[1], Gossiped: gossiped}
+	}
+
+	a.identity.mu.RLock()
+	nodes[self] = &api.TopologyNode{
+		ID:     self,
+		Name:   a.identity.name,
+		Kind:   api.AgentKindAgent,
+		Models: a.identity.models,
+		Labels: a.identity.labels,
+	}
+	a.identity.mu.RUnlock()
+
+	for _, p := range a.p2pHost.GetPeers() {
+		n := node(p.ID.String())
+		if p.Connected {
+			n.Connected = true
+			edge(self, n.ID, false)
+		}
+	}
+
+	for _, entry := range a.topology.entries() {
+		origin := node(entry.list.PeerID)
+		if origin.Kind == api.AgentKindUnknown {
+			origin.Kind = api.AgentKindAgent
+			origin.Name = entry.list.Name
+			origin.Models = entry.list.Models
+			origin.Labels = entry.list.Labels
+		}
+		for _, neighbor := range entry.list.Neighbors {
+			node(neighbor)
+			edge(origin.ID, neighbor, true)
+		}
+	}
+
+	resp := &api.TopologyResponse{
+		Object: "topology",
+		Self:   self,
+		Depth:  a.config.TopologyDepth,
+		Nodes:  make([]api.TopologyNode, 0, len(nodes)),
+		Edges:  make([]api.TopologyEdge, 0, len(edges)),
+	}
+	for hops, ids := range topologyHops(self, edges) {
+		for _, id := range ids {
+			h := hops
+			nodes[id].Hops = &h
+		}
+	}
+	for _, n := range nodes {
+		resp.Nodes = append(resp.Nodes, *n)
+	}
+	sort.Slice(resp.Nodes, func(i, j int) bool {
+		return resp.Nodes[i].ID < resp.Nodes[j].ID
+	})
+	for _, e := range edges {
+		resp.Edges = append(resp.Edges, *e)
+	}
+	sort.Slice(resp.Edges, func(i, j int) bool {
+		if resp.Edges[i].Source != resp.Edges[j].Source {
+			return resp.Edges[i].Source < resp.Edges[j].Source
+		}
+		return resp.Edges[i].Target < resp.Edges[j].Target
+	})
+
+	return resp, nil
+}
+
+// topologyHops groups the nodes reachable from self by their distance from
+// it, found breadth first over edges.
+func topologyHops(self string, edges map[[2]string]*api.TopologyEdge) [][]string {
+	adjacent := make(map[string][]string)
+	for key := range edges {
+		adjacent[key[0]] = append(adjacent[key[0]], key[1])
+		adjacent[key[1]] = append(adjacent[key[1]], key[0])
+	}
+
+	seen := map[string]bool{self: true}
+	levels := [][]string{{self}}
+	for {
+		var next []string
+		for _, id := range levels[len(levels)-1] {
+			for _, n := range adjacent[id] {
+				if !seen[n] {
+					seen[n] = true
+					next = append(next, n)
+				}
+			}
+		}
+		if len(next) == 0 {
+			return levels
+		}
+		levels = append(levels, next)
+	}
+}
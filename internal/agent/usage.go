@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"go.uber.org/zap"
+)
+
+// usageTracker accumulates token usage and estimated cost per client.
+type usageTracker struct {
+	mu      sync.Mutex
+	pricing map[string]config.ModelPrice
+	clients map[string]*api.ClientUsage
+	warned  map[string]bool
+	logger  *zap.Logger
+}
+
+func newUsageTracker(prices []config.ModelPrice, logger *zap.Logger) *usageTracker {
+	pricing := make(map[string]config.ModelPrice, len(prices))
+	for _, price := range prices {
+		pricing[price.Model] = price
+	}
+
+	return &usageTracker{
+		pricing: pricing,
+		clients: make(map[string]*api.ClientUsage),
+		warned:  make(map[string]bool),
+		logger:  logger,
+	}
+}
+
+func (t *usageTracker) Record(clientID, model string, usage api.Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if clientID == "" {
+		clientID = "local"
+	}
+	entry, exists := t.clients[clientID]
+	if !exists {
+		entry = &api.ClientUsage{ClientID: clientID}
+		t.clients[clientID] = entry
+	}
+
+	entry.Requests++
+	entry.PromptTokens += usage.PromptTokens
+	entry.CompletionTokens += usage.CompletionTokens
+	entry.TotalTokens += usage.TotalTokens
+	entry.EstimatedCost += t.costLocked(model, usage)
+}
+
+// costLocked prices a request in USD. Models missing from the price table
+// cost nothing, with a one-time warning so the gap gets noticed.
+func (t *usageTracker) costLocked(model string, usage api.Usage) float64 {
+	price, exists := t.pricing[model]
+	if !exists {
+		if !t.warned[model] {
+			t.warned[model] = true
+			t.logger.Warn("No pricing configured for model, reporting zero cost", zap.String("model", model))
+		}
+		return 0
+	}
+	return float64(usage.PromptTokens)/1000*price.Input +
+		float64(usage.CompletionTokens)/1000*price.Output
+}
+
+func (t *usageTracker) Snapshot() *api.UsageResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	resp := &api.UsageResponse{
+		Object: "usage",
+		Data:   make([]api.ClientUsage, 0, len(t.clients)),
+	}
+	for _, entry := range t.clients {
+		resp.Data = append(resp.Data, *entry)
+		resp.Total.Requests += entry.Requests
+		resp.Total.PromptTokens += entry.PromptTokens
+		resp.Total.CompletionTokens += entry.CompletionTokens
+		resp.Total.TotalTokens += entry.TotalTokens
+		resp.Total.EstimatedCost += entry.EstimatedCost
+	}
+	resp.Total.ClientID = "total"
+
+	sort.Slice(resp.Data, func(i, j int) bool {
+		return resp.Data[i].ClientID < resp.Data[j].ClientID
+	})
+	return resp
+}
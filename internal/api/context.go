@@ -0,0 +1,19 @@
+package api
+
+import "context"
+
+type contextKey int
+
+const clientIDContextKey contextKey = iota
+
+// WithClientID returns a context carrying the authenticated client identity.
+func WithClientID(ctx context.Context, clientID string) context.Context {
+	return context.WithValue(ctx, clientIDContextKey, clientID)
+}
+
+// ClientIDFromContext returns the authenticated client identity, or "" for
+// requests that didn't come through the authenticated API.
+func ClientIDFromContext(ctx context.Context) string {
+	clientID, _ := ctx.Value(clientIDContextKey).(string)
+	return clientID
+}
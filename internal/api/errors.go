@@ -0,0 +1,17 @@
+package api
+
+import "errors"
+
+var (
+	ErrAgentNotFound    = errors.New("agent not found")
+	ErrAgentUnreachable = errors.New("agent unreachable")
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrNotFound         = errors.New("not found")
+	ErrConflict         = errors.New("conflict")
+	ErrForbidden        = errors.New("forbidden")
+	ErrTooManyRequests  = errors.New("too many requests")
+
+	// ErrIdempotencyMismatch is an Idempotency-Key reused for a different
+	// request.
+	ErrIdempotencyMismatch = errors.New("idempotency key reused")
+)
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyEntries caps the cache. When it is full the entry nearest to
+// expiry makes room, and if every entry is still in flight the request runs
+// without being remembered.
+const maxIdempotencyEntries = 10000
+
+// idempotencyCache remembers chat completion results per (client, key) for a
+// TTL. A retry that arrives while the original request is still in flight
+// waits for it instead of hitting the upstream a second time. Failed requests
+// are not remembered so the client can retry them.
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	timeout time.Duration
+	entries map[string]*idempotencyEntry
+
+	hits, misses atomic.Int64
+}
+
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	done        chan struct{}
+	resp        *ChatCompletionResponse
+	err         error
+	expires     time.Time
+}
+
+// newIdempotencyCache keeps results for ttl. timeout, if set, bounds each
+// request, which runs detached from its client's cancellation so that a
+// retry waiting on it isn't failed by the first client hanging up.
+func newIdempotencyCache(ttl, timeout time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		timeout: timeout,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// Do runs fn at most once per key within the TTL. body identifies the
+// request; reusing a key for a different one is refused with
+// ErrIdempotencyMismatch. A caller waiting on an earlier call gives up when
+// ctx ends. replayed reports whether the result came from an earlier call.
+func (c *idempotencyCache) Do(ctx context.Context, key string, body []byte, fn func(context.Context) (*ChatCompletionResponse, error)) (resp *ChatCompletionResponse, replayed bool, err error) {
+	fingerprint := sha256.Sum256(body)
+
+	c.mu.Lock()
+	c.evictExpiredLocked()
+	if entry, exists := c.entries[key]; exists {
+		c.mu.Unlock()
+		if entry.fingerprint != fingerprint {
+			return nil, false, fmt.Errorf("%w: %s was used for a different request", ErrIdempotencyMismatch, IdempotencyKeyHeader)
+		}
+		c.hits.Add(1)
+		select {
+		case <-entry.done:
+			return entry.resp, true, entry.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+	c.misses.Add(1)
+
+	if len(c.entries) >= maxIdempotencyEntries && !c.evictOneLocked() {
+		c.mu.Unlock()
+		resp, err := fn(ctx)
+		return resp, false, err
+	}
+	entry := &idempotencyEntry{fingerprint: fingerprint, done: make(chan struct{})}
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	runCtx := context.WithoutCancel(ctx)
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(runCtx, c.timeout)
+		defer cancel()
+	}
+	entry.resp, entry.err = fn(runCtx)
+
+	c.mu.Lock()
+	if entry.err != nil {
+		delete(c.entries, key)
+	} else {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	c.mu.Unlock()
+	close(entry.done)
+
+	return entry.resp, false, entry.err
+}
+
+func (c *idempotencyCache) evictExpiredLocked() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// evictOneLocked drops the finished entry nearest to expiry, reporting
+// whether there was one.
+func (c *idempotencyCache) evictOneLocked() bool {
+	var oldest string
+	for key, entry := range c.entries {
+		if entry.expires.IsZero() {
+			continue
+		}
+		if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+			oldest = key
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	delete(c.entries, oldest)
+	return true
+}
+
+func (c *idempotencyCache) stats() *CacheStats {
+	stats := &CacheStats{Hits: int(c.hits.Load()), Misses: int(c.misses.Load())}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	return stats
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyCacheDo(t *testing.T) {
+	failure := errors.New("upstream failed")
+
+	type call struct {
+		key, body    string
+		err          error // what fn returns if it runs
+		wantRun      bool
+		wantReplayed bool
+		wantErr      error
+	}
+	tests := []struct {
+		name  string
+		calls []call
+	}{
+		{
+			name: "same key replays",
+			calls: []call{
+				{key: "k", body: "a", wantRun: true},
+				{key: "k", body: "a", wantReplayed: true},
+			},
+		},
+		{
+			name: "different keys both run",
+			calls: []call{
+				{key: "k1", body: "a", wantRun: true},
+				{key: "k2", body: "a", wantRun: true},
+			},
+		},
+		{
+			name: "key reused for another request",
+			calls: []call{
+				{key: "k", body: "a", wantRun: true},
+				{key: "k", body: "b", wantErr: ErrIdempotencyMismatch},
+				{key: "k", body: "a", wantReplayed: true},
+			},
+		},
+		{
+			name: "failures are not remembered",
+			calls: []call{
+				{key: "k", body: "a", err: failure, wantRun: true, wantErr: failure},
+				{key: "k", body: "a", wantRun: true},
+				{key: "k", body: "a", wantReplayed: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newIdempotencyCache(time.Minute, 0)
+			for i, call := range tt.calls {
+				ran := false
+				resp, replayed, err := c.Do(context.Background(), call.key, []byte(call.body), func(ctx context.Context) (*ChatCompletionResponse, error) {
+					ran = true
+					if call.err != nil {
+						return nil, call.err
+					}
+					return &ChatCompletionResponse{ID: fmt.Sprintf("%s-%d", call.key, i)}, nil
+				})
+				if ran != call.wantRun || replayed != call.wantReplayed || !errors.Is(err, call.wantErr) {
+					t.Fatalf("call %d: ran %v, replayed %v, err %v; want %v, %v, %v",
+						i, ran, replayed, err, call.wantRun, call.wantReplayed, call.wantErr)
+				}
+				if err == nil && resp == nil {
+					t.Fatalf("call %d: no response", i)
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotencyCacheExpiry(t *testing.T) {
+	c := newIdempotencyCache(time.Millisecond, 0)
+	var runs int
+	fn := func(ctx context.Context) (*ChatCompletionResponse, error) {
+		runs++
+		return &ChatCompletionResponse{}, nil
+	}
+
+	c.Do(context.Background(), "k", nil, fn)
+	time.Sleep(5 * time.Millisecond)
+	if _, replayed, _ := c.Do(context.Background(), "k", nil, fn); replayed || runs != 2 {
+		t.Fatalf("replayed %v after the TTL, %d runs", replayed, runs)
+	}
+}
+
+// TestIdempotencyCacheConcurrent races retries against an in-flight request:
+// fn runs once, every caller gets its response, and the request isn't
+// cancelled when the caller that started it goes away.
+func TestIdempotencyCacheConcurrent(t *testing.T) {
+	c := newIdempotencyCache(time.Minute, 0)
+	release := make(chan struct{})
+	var runs atomic.Int32
+	fn := func(ctx context.Context) (*ChatCompletionResponse, error) {
+		runs.Add(1)
+		<-release
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return &ChatCompletionResponse{ID: "only"}, nil
+	}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := c.Do(firstCtx, "k", nil, fn)
+		first <- err
+	}()
+	for runs.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, replayed, err := c.Do(context.Background(), "k", nil, fn)
+			if err != nil || !replayed || resp.ID != "only" {
+				t.Errorf("retry got %+v, replayed %v, %v", resp, replayed, err)
+			}
+		}()
+	}
+	cancelFirst()
+	close(release)
+	wg.Wait()
+
+	if err := <-first; err != nil {
+		t.Fatalf("first caller: %v", err)
+	}
+	if got := runs.Load(); got != 1 {
+		t.Fatalf("fn ran %d times, want 1", got)
+	}
+	if stats := c.stats(); stats.Hits != 8 || stats.Misses != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestIdempotencyCacheWaiterGivesUp(t *testing.T) {
+	c := newIdempotencyCache(time.Minute, 0)
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go c.Do(context.Background(), "k", nil, func(ctx context.Context) (*ChatCompletionResponse, error) {
+		close(started)
+		<-release
+		return &ChatCompletionResponse{}, nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.Do(ctx, "k", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiter got %v, want its own deadline", err)
+	}
+}
+
+func TestIdempotencyCacheTimeout(t *testing.T) {
+	c := newIdempotencyCache(time.Minute, 10*time.Millisecond)
+	_, _, err := c.Do(context.Background(), "k", nil, func(ctx context.Context) (*ChatCompletionResponse, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the cache's timeout", err)
+	}
+}
+
+func TestIdempotencyCacheFull(t *testing.T) {
+	c := newIdempotencyCache(time.Minute, 0)
+	ok := func(ctx context.Context) (*ChatCompletionResponse, error) {
+		return &ChatCompletionResponse{}, nil
+	}
+	now := time.Now()
+	for i := 0; i < maxIdempotencyEntries; i++ {
+		c.entries[fmt.Sprint(i)] = &idempotencyEntry{done: make(chan struct{}), expires: now.Add(time.Duration(i+1) * time.Second)}
+	}
+
+	// The entry nearest to expiry makes room for the new one.
+	c.Do(context.Background(), "new", nil, ok)
+	if _, kept := c.entries["0"]; kept {
+		t.Fatal("entry nearest to expiry was not evicted")
+	}
+	if _, added := c.entries["new"]; !added || len(c.entries) != maxIdempotencyEntries {
+		t.Fatalf("new entry added %v, %d entries", added, len(c.entries))
+	}
+
+	// With every entry in flight there is nothing to evict, and the request
+	// runs unremembered.
+	for _, entry := range c.entries {
+		entry.expires = time.Time{}
+	}
+	if _, replayed, err := c.Do(context.Background(), "unremembered", nil, ok); err != nil || replayed {
+		t.Fatalf("got replayed %v, %v", replayed, err)
+	}
+	if _, added := c.entries["unremembered"]; added {
+		t.Fatal("request remembered in a full cache")
+	}
+}
@@ -2,46 +2,130 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/denizumutdereli/agents-p2p-network/internal/events"
+	"github.com/denizumutdereli/agents-p2p-network/internal/logstream"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type Server struct {
-	router     *gin.Engine
-	httpServer *http.Server
-	logger     *zap.Logger
-	apiKey     string
-	handler    RequestHandler
+	router      *gin.Engine
+	httpServer  *http.Server
+	logger      *zap.Logger
+	apiKey      string
+	adminKey    string
+	clientKeys  map[string]bool
+	handler     RequestHandler
+	idempotency *idempotencyCache
+	serving     atomic.Bool
+}
+
+type Options struct {
+	Port           int
+	APIKey         string
+	AdminKey       string        // guards /v1/admin, empty = APIKey
+	ClientKeys     []string      // further keys accepted alongside APIKey
+	IdempotencyTTL time.Duration // 0 disables Idempotency-Key handling
+	MaxBodySize    int64         // request body limit in bytes, 0 = unlimited
+
+	// IdempotencyTimeout bounds a request sent with an Idempotency-Key,
+	// which keeps running for the retries waiting on it after its own
+	// client goes away. 0 leaves it to the handler's own timeouts.
+	IdempotencyTimeout time.Duration
+
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration // lifted for streaming responses
+	IdleTimeout       time.Duration
+	HTTP2             bool // accept cleartext HTTP/2 (h2c)
 }
 
 type RequestHandler interface {
 	HandleChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error)
+	// HandleChatCompletionStream streams the answer to req through send, or,
+	// when a provider's events can go out unchanged, hands them to pipe.
+	HandleChatCompletionStream(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error, pipe func(*EventStream) error) error
 	HandleListModels(ctx context.Context) (*ModelsResponse, error)
+	HandleListNetworkModels(ctx context.Context) (*ModelsResponse, error)
 	HandleListAgents(ctx context.Context) (*AgentsResponse, error)
+	HandleGetAgent(ctx context.Context, agentID string) (*AgentInfo, error)
+	HandleConnectPeer(ctx context.Context, req *ConnectPeerRequest) (*ConnectPeerResponse, error)
 	HandleSendToAgent(ctx context.Context, agentID string, req *ChatCompletionRequest) (*ChatCompletionResponse, error)
-	HandleAnnounce(ctx context.Context, req *AnnounceRequest) error
+	HandleSendToAgentStream(ctx context.Context, agentID string, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error
+	HandleAnnounce(ctx context.Context, req *AnnounceRequest) (*AnnounceResponse, error)
+	HandleWithdrawAnnouncement(ctx context.Context, id string) error
+	HandleGetSubscription(ctx context.Context) (*AnnouncementSubscription, error)
+	HandleSetSubscription(ctx context.Context, req *AnnouncementSubscription) (*AnnouncementSubscription, error)
+	HandleUsage(ctx context.Context) (*UsageResponse, error)
+	HandleLatency(ctx context.Context) (*LatencyResponse, error)
+	HandleTopology(ctx context.Context) (*TopologyResponse, error)
+	HandleStats(ctx context.Context) (*StatsResponse, error)
+	HandleHealthCheck(ctx context.Context) (*HealthCheckResponse, error)
+	SubscribeEvents() (<-chan events.Event, func())
+	SubscribeLogs(min zapcore.Level) ([]logstream.Entry, <-chan logstream.Entry, func())
+	HandleRediscover(ctx context.Context) error
+	HandleRegister(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error)
 }
 
-func NewServer(port int, apiKey string, handler RequestHandler, logger *zap.Logger) *Server {
+const (
+	sseKeepAliveInterval = 30 * time.Second
+
+	ServedByHeader = "X-Served-By"
+)
+
+func NewServer(opts Options, handler RequestHandler, logger *zap.Logger) *Server {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	router.Use(gin.Recovery())
+	if opts.MaxBodySize > 0 {
+		router.Use(bodyLimitMiddleware(opts.MaxBodySize))
+	}
+
+	var h http.Handler = router
+	if opts.HTTP2 {
+		// The API is served without TLS, so HTTP/2 has to be h2c.
+		h = h2c.NewHandler(router, &http2.Server{IdleTimeout: opts.IdleTimeout})
+	}
 
 	s := &Server{
-		router:  router,
-		logger:  logger,
-		apiKey:  apiKey,
-		handler: handler,
+		router:   router,
+		logger:   logger,
+		apiKey:   opts.APIKey,
+		adminKey: opts.AdminKey,
+		handler:  handler,
 		httpServer: &http.Server{
-			Addr:    fmt.Sprintf(":%d", port),
-			Handler: router,
+			Addr:              fmt.Sprintf(":%d", opts.Port),
+			Handler:           h,
+			ReadHeaderTimeout: opts.ReadHeaderTimeout,
+			WriteTimeout:      opts.WriteTimeout,
+			IdleTimeout:       opts.IdleTimeout,
 		},
 	}
+	if opts.IdempotencyTTL > 0 {
+		s.idempotency = newIdempotencyCache(opts.IdempotencyTTL, opts.IdempotencyTimeout)
+	}
+	if len(opts.ClientKeys) > 0 {
+		s.clientKeys = make(map[string]bool, len(opts.ClientKeys))
+		for _, key := range opts.ClientKeys {
+			s.clientKeys[key] = true
+		}
+	}
 
 	s.setupRoutes()
 	return s
@@ -49,6 +133,7 @@ func NewServer(port int, apiKey string, handler RequestHandler, logger *zap.Logg
 
 func (s *Server) setupRoutes() {
 	s.router.GET("/health", s.healthCheck)
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	v1 := s.router.Group("/v1")
 	v1.Use(s.authMiddleware())
@@ -57,19 +142,45 @@ func (s *Server) setupRoutes() {
 		v1.POST("/chat/completions", s.chatCompletions)
 
 		v1.GET("/agents", s.listAgents)
+		v1.GET("/agents/:agent_id", s.getAgent)
 		v1.POST("/agents/:agent_id/chat/completions", s.agentChatCompletions)
 
+		v1.POST("/peers/healthcheck", s.peerHealthCheck)
+
 		v1.POST("/announce", s.announce)
+		v1.DELETE("/announcements/:id", s.withdrawAnnouncement)
+		v1.GET("/announcements/subscription", s.getSubscription)
+
+		v1.GET("/usage", s.usage)
+		v1.GET("/stats", s.stats)
+		v1.GET("/events", s.streamEvents)
+		v1.GET("/debug/latency", s.latency)
+		v1.GET("/debug/topology", s.topology)
+	}
+
+	// Operator actions take the admin key rather than a client key.
+	admin := s.router.Group("/v1/admin")
+	admin.Use(s.adminAuthMiddleware())
+	{
+		admin.POST("/rediscover", s.rediscover)
+		admin.POST("/register", s.register)
+		admin.POST("/peers/connect", s.connectPeer)
+		admin.PUT("/announcements/subscription", s.setSubscription)
+		admin.GET("/debug/logs", s.streamLogs)
 	}
 }
 
 func (s *Server) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		auth := c.GetHeader("Authorization")
-		if auth == "" {
+		token, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+
+		if !s.validKey(token) {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": gin.H{
-					"message": "Missing Authorization header",
+					"message": "Invalid API key",
 					"type":    "invalid_request_error",
 				},
 			})
@@ -77,11 +188,36 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token := strings.TrimPrefix(auth, "Bearer ")
-		if token != s.apiKey {
-			c.JSON(http.StatusUnauthorized, gin.H{
+		clientID := ClientIdentity(token)
+		c.Set(clientIDKey, clientID)
+		c.Request = c.Request.WithContext(WithClientID(c.Request.Context(), clientID))
+		c.Next()
+	}
+}
+
+// adminAuthMiddleware accepts only the admin key, or the API key when no
+// admin key is configured. Requests made with a client key are refused with
+// 403 so the caller knows the key is valid but not enough.
+func (s *Server) adminAuthMiddleware() gin.HandlerFunc {
+	adminKey := s.adminKey
+	if adminKey == "" {
+		adminKey = s.apiKey
+	}
+
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+
+		if !keyEqual(token, adminKey) {
+			status, message := http.StatusUnauthorized, "Invalid admin key"
+			if s.validKey(token) {
+				status, message = http.StatusForbidden, "This endpoint requires the admin key"
+			}
+			c.JSON(status, gin.H{
 				"error": gin.H{
-					"message": "Invalid API key",
+					"message": message,
 					"type":    "invalid_request_error",
 				},
 			})
@@ -89,10 +225,60 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		c.Set(clientIDKey, adminClientID)
+		c.Request = c.Request.WithContext(WithClientID(c.Request.Context(), adminClientID))
 		c.Next()
 	}
 }
 
+// validKey reports whether token is the API key or one of the client keys.
+// Every key is compared, in constant time, so how long the check takes
+// doesn't tell a caller how close a guess came.
+func (s *Server) validKey(token string) bool {
+	valid := keyEqual(token, s.apiKey)
+	for key := range s.clientKeys {
+		if keyEqual(token, key) {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func keyEqual(token, key string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1
+}
+
+// bearerToken returns the request's bearer token. Without an Authorization
+// header it answers 401 itself and reports false.
+func bearerToken(c *gin.Context) (string, bool) {
+	auth := c.GetHeader("Authorization")
+	if auth == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": gin.H{
+				"message": "Missing Authorization header",
+				"type":    "invalid_request_error",
+			},
+		})
+		c.Abort()
+		return "", false
+	}
+	return strings.TrimPrefix(auth, "Bearer "), true
+}
+
+const (
+	clientIDKey = "client_id"
+
+	// adminClientID is the client ID of requests made with the admin key.
+	adminClientID = "admin"
+)
+
+// ClientIdentity derives a stable, non-secret identifier for the key a
+// client authenticated with.
+func ClientIdentity(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
 func (s *Server) healthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status": "ok",
@@ -100,8 +286,20 @@ func (s *Server) healthCheck(c *gin.Context) {
 	})
 }
 
+// listModels lists this agent's models, or with ?scope=network the union of
+// models advertised by every connected agent.
 func (s *Server) listModels(c *gin.Context) {
-	resp, err := s.handler.HandleListModels(c.Request.Context())
+	var resp *ModelsResponse
+	var err error
+	switch scope := c.Query("scope"); scope {
+	case "", "local":
+		resp, err = s.handler.HandleListModels(c.Request.Context())
+	case "network":
+		resp, err = s.handler.HandleListNetworkModels(c.Request.Context())
+	default:
+		s.errorResponse(c, http.StatusBadRequest, fmt.Sprintf("Invalid scope %q. Use local or network", scope))
+		return
+	}
 	if err != nil {
 		s.errorResponse(c, http.StatusInternalServerError, err.Error())
 		return
@@ -111,26 +309,185 @@ func (s *Server) listModels(c *gin.Context) {
 
 func (s *Server) chatCompletions(c *gin.Context) {
 	var req ChatCompletionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		s.errorResponse(c, http.StatusBadRequest, "Invalid request body")
+	if !s.bindJSON(c, &req) {
+		return
+	}
+
+	if req.Stream {
+		s.streamCompletion(c, func(send func(*ChatCompletionChunk) error, pipe func(*EventStream) error) error {
+			return s.handler.HandleChatCompletionStream(c.Request.Context(), &req, send, pipe)
+		})
 		return
 	}
 
-	resp, err := s.handler.HandleChatCompletion(c.Request.Context(), &req)
+	complete := func(ctx context.Context) (*ChatCompletionResponse, error) {
+		return s.handler.HandleChatCompletion(ctx, &req)
+	}
+
+	var resp *ChatCompletionResponse
+	var err error
+	if key := c.GetHeader(IdempotencyKeyHeader); key != "" && s.idempotency != nil && !req.Stream {
+		body, _ := json.Marshal(&req)
+		var replayed bool
+		resp, replayed, err = s.idempotency.Do(c.Request.Context(), c.GetString(clientIDKey)+":"+key, body, complete)
+		if replayed {
+			c.Header("Idempotent-Replayed", "true")
+		}
+	} else {
+		resp, err = complete(c.Request.Context())
+	}
 	if err != nil {
-		s.errorResponse(c, http.StatusInternalServerError, err.Error())
+		s.handlerError(c, err)
 		return
 	}
 
+	for name, values := range resp.Headers {
+		for _, v := range values {
+			c.Writer.Header().Add(name, v)
+		}
+	}
+	if resp.Provider != "" {
+		c.Header(ServedByHeader, resp.Provider)
+	}
 	c.JSON(http.StatusOK, resp)
 }
 
+// streamCompletion relays chunks to the client as OpenAI-style server-sent
+// events, flushing each one as it arrives, or copies a piped event stream
+// through, flushing each read. An error before the first chunk gets the
+// usual JSON error response; after that the status is already sent, so it is
+// reported as a final error event.
+func (s *Server) streamCompletion(c *gin.Context, stream func(send func(*ChatCompletionChunk) error, pipe func(*EventStream) error) error) {
+	started, piped := false, false
+	start := func(provider string, headers http.Header) {
+		started = true
+		for name, values := range headers {
+			for _, v := range values {
+				c.Writer.Header().Add(name, v)
+			}
+		}
+		if provider != "" {
+			c.Header(ServedByHeader, provider)
+		}
+		liftWriteDeadline(c)
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(http.StatusOK)
+	}
+
+	send := func(chunk *ChatCompletionChunk) error {
+		if !started {
+			start(chunk.Provider, chunk.Headers)
+		}
+		data := []byte(chunk.Raw)
+		if data == nil {
+			var err error
+			if data, err = json.Marshal(chunk); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+	pipe := func(events *EventStream) error {
+		start(events.Provider, events.Headers)
+		piped = true
+		_, err := io.Copy(flushWriter{c.Writer}, events.Body)
+		return err
+	}
+	err := stream(send, pipe)
+
+	if !started {
+		if err != nil {
+			s.handlerError(c, err)
+			return
+		}
+		start("", nil)
+	}
+	if piped && err == nil {
+		// The provider's own [DONE] has been copied already.
+		return
+	}
+	if err != nil {
+		s.logger.Warn("Streaming completion failed", zap.Error(err))
+		data, _ := json.Marshal(gin.H{"error": gin.H{"message": err.Error(), "type": "api_error"}})
+		fmt.Fprintf(c.Writer, "data: %s\n\n", data)
+	}
+	io.WriteString(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
+
+// flushWriter flushes after every write, so that piped events reach the
+// client as soon as they are read.
+type flushWriter struct {
+	w gin.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.w.Flush()
+	return n, err
+}
+
+// liftWriteDeadline exempts a long-lived streaming response from the
+// server's WriteTimeout.
+func liftWriteDeadline(c *gin.Context) {
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+}
+
 func (s *Server) listAgents(c *gin.Context) {
 	resp, err := s.handler.HandleListAgents(c.Request.Context())
 	if err != nil {
 		s.errorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
+
+	if c.Query("agents_only") == "true" {
+		agents := make([]AgentInfo, 0, len(resp.Data))
+		for _, info := range resp.Data {
+			if info.Kind == AgentKindAgent {
+				agents = append(agents, info)
+			}
+		}
+		resp.Data = agents
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) getAgent(c *gin.Context) {
+	resp, err := s.handler.HandleGetAgent(c.Request.Context(), c.Param("agent_id"))
+	if err != nil {
+		s.handlerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) connectPeer(c *gin.Context) {
+	var req ConnectPeerRequest
+	if !s.bindJSON(c, &req) {
+		return
+	}
+
+	resp, err := s.handler.HandleConnectPeer(c.Request.Context(), &req)
+	if err != nil {
+		s.handlerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) peerHealthCheck(c *gin.Context) {
+	resp, err := s.handler.HandleHealthCheck(c.Request.Context())
+	if err != nil {
+		s.handlerError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, resp)
 }
 
@@ -138,14 +495,20 @@ func (s *Server) agentChatCompletions(c *gin.Context) {
 	agentID := c.Param("agent_id")
 
 	var req ChatCompletionRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		s.errorResponse(c, http.StatusBadRequest, "Invalid request body")
+	if !s.bindJSON(c, &req) {
+		return
+	}
+
+	if req.Stream {
+		s.streamCompletion(c, func(send func(*ChatCompletionChunk) error, _ func(*EventStream) error) error {
+			return s.handler.HandleSendToAgentStream(c.Request.Context(), agentID, &req, send)
+		})
 		return
 	}
 
 	resp, err := s.handler.HandleSendToAgent(c.Request.Context(), agentID, &req)
 	if err != nil {
-		s.errorResponse(c, http.StatusInternalServerError, err.Error())
+		s.handlerError(c, err)
 		return
 	}
 
@@ -154,17 +517,242 @@ func (s *Server) agentChatCompletions(c *gin.Context) {
 
 func (s *Server) announce(c *gin.Context) {
 	var req AnnounceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		s.errorResponse(c, http.StatusBadRequest, "Invalid request body")
+	if !s.bindJSON(c, &req) {
+		return
+	}
+
+	resp, err := s.handler.HandleAnnounce(c.Request.Context(), &req)
+	if err != nil {
+		s.handlerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) withdrawAnnouncement(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.handler.HandleWithdrawAnnouncement(c.Request.Context(), id); err != nil {
+		s.handlerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "withdrawn", "id": id})
+}
+
+func (s *Server) getSubscription(c *gin.Context) {
+	resp, err := s.handler.HandleGetSubscription(c.Request.Context())
+	if err != nil {
+		s.handlerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) setSubscription(c *gin.Context) {
+	var req AnnouncementSubscription
+	if !s.bindJSON(c, &req) {
+		return
+	}
+
+	resp, err := s.handler.HandleSetSubscription(c.Request.Context(), &req)
+	if err != nil {
+		s.handlerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) rediscover(c *gin.Context) {
+	if err := s.handler.HandleRediscover(c.Request.Context()); err != nil {
+		s.handlerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "rediscovered"})
+}
+
+func (s *Server) register(c *gin.Context) {
+	var req RegisterRequest
+	if !s.bindJSON(c, &req) {
+		return
+	}
+
+	resp, err := s.handler.HandleRegister(c.Request.Context(), &req)
+	if err != nil {
+		s.handlerError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) usage(c *gin.Context) {
+	resp, err := s.handler.HandleUsage(c.Request.Context())
+	if err != nil {
+		s.errorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) latency(c *gin.Context) {
+	resp, err := s.handler.HandleLatency(c.Request.Context())
+	if err != nil {
+		s.errorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// stats adds the idempotency cache, which lives here, to the agent's
+// counters.
+func (s *Server) stats(c *gin.Context) {
+	resp, err := s.handler.HandleStats(c.Request.Context())
+	if err != nil {
+		s.errorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if s.idempotency != nil {
+		resp.Cache = s.idempotency.stats()
+	}
+	c.JSON(http.StatusOK, resp)
+}
 
-	if err := s.handler.HandleAnnounce(c.Request.Context(), &req); err != nil {
+func (s *Server) topology(c *gin.Context) {
+	resp, err := s.handler.HandleTopology(c.Request.Context())
+	if err != nil {
 		s.errorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) streamEvents(c *gin.Context) {
+	ch, unsubscribe := s.handler.SubscribeEvents()
+	defer unsubscribe()
+
+	liftWriteDeadline(c)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Send the headers now so clients see the stream open before the first
+	// event or keep-alive.
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case e, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(e.Type), e)
+			return true
+		}
+	})
+}
+
+// streamLogs sends buffered log entries at or above ?level (default info),
+// then follows live ones. With ?follow=false it returns the buffer as JSON.
+func (s *Server) streamLogs(c *gin.Context) {
+	level := zapcore.InfoLevel
+	if q := c.Query("level"); q != "" {
+		parsed, err := zapcore.ParseLevel(q)
+		if err != nil {
+			s.errorResponse(c, http.StatusBadRequest, fmt.Sprintf("Invalid log level %q", q))
+			return
+		}
+		level = parsed
+	}
+
+	recent, ch, unsubscribe := s.handler.SubscribeLogs(level)
+	defer unsubscribe()
+
+	if c.Query("follow") == "false" {
+		c.JSON(http.StatusOK, gin.H{"object": "list", "data": recent})
+		return
+	}
+
+	liftWriteDeadline(c)
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
 
-	c.JSON(http.StatusOK, gin.H{"status": "announced", "peers_notified": true})
+	for _, e := range recent {
+		c.SSEvent("log", e)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+			return true
+		case e, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent("log", e)
+			return true
+		}
+	})
+}
+
+// bodyLimitMiddleware rejects requests whose declared length exceeds limit and
+// caps the body reader for those that don't declare one (chunked uploads).
+func bodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, bodyTooLarge(limit))
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+func bodyTooLarge(limit int64) gin.H {
+	return gin.H{
+		"error": gin.H{
+			"message": fmt.Sprintf("Request body exceeds %d bytes", limit),
+			"type":    "request_too_large",
+		},
+	}
+}
+
+// bindJSON decodes the request body into req, answering 400 for malformed
+// JSON and 413 when the body limit is hit mid-read. It reports whether the
+// handler should continue.
+func (s *Server) bindJSON(c *gin.Context, req interface{}) bool {
+	err := c.ShouldBindJSON(req)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, bodyTooLarge(tooLarge.Limit))
+		return false
+	}
+
+	s.errorResponse(c, http.StatusBadRequest, "Invalid request body")
+	return false
 }
 
 func (s *Server) errorResponse(c *gin.Context, status int, message string) {
@@ -176,15 +764,60 @@ func (s *Server) errorResponse(c *gin.Context, status int, message string) {
 	})
 }
 
+// handlerError maps errors returned by the RequestHandler onto an HTTP status
+// and a machine-readable error type.
+func (s *Server) handlerError(c *gin.Context, err error) {
+	status, errType := http.StatusInternalServerError, "api_error"
+	switch {
+	case errors.Is(err, ErrAgentNotFound):
+		status, errType = http.StatusNotFound, "agent_not_found"
+	case errors.Is(err, ErrAgentUnreachable):
+		status, errType = http.StatusNotFound, "agent_unreachable"
+	case errors.Is(err, ErrInvalidRequest):
+		status, errType = http.StatusBadRequest, "invalid_request_error"
+	case errors.Is(err, ErrNotFound):
+		status, errType = http.StatusNotFound, "not_found_error"
+	case errors.Is(err, ErrConflict):
+		status, errType = http.StatusConflict, "conflict_error"
+	case errors.Is(err, ErrForbidden):
+		status, errType = http.StatusForbidden, "permission_error"
+	case errors.Is(err, ErrTooManyRequests):
+		status, errType = http.StatusTooManyRequests, "rate_limit_error"
+	case errors.Is(err, ErrIdempotencyMismatch):
+		status, errType = http.StatusUnprocessableEntity, "idempotency_error"
+	}
+
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": err.Error(),
+			"type":    errType,
+		},
+	})
+}
+
+// Start binds the HTTP port and serves in the background. A port that can't
+// be bound is reported here rather than logged later.
 func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+
+	s.serving.Store(true)
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		defer s.serving.Store(false)
+		if err := s.httpServer.Serve(ln); err != nil && err != http.ErrServerClosed {
 			s.logger.Error("HTTP server error", zap.Error(err))
 		}
 	}()
 	return nil
 }
 
+// Serving reports whether the server is accepting connections.
+func (s *Server) Serving() bool {
+	return s.serving.Load()
+}
+
 func (s *Server) Stop(ctx context.Context) error {
 	return s.httpServer.Shutdown(ctx)
 }
@@ -0,0 +1,593 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/events"
+	"go.uber.org/zap"
+)
+
+const testAPIKey = "test-api-key-0123456789"
+
+// fakeHandler is a RequestHandler whose methods are set per test. Calling
+// one that isn't set panics on the embedded nil interface.
+type fakeHandler struct {
+	RequestHandler
+	stream     func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error
+	pipe       func(ctx context.Context, req *ChatCompletionRequest, pipe func(*EventStream) error) error
+	complete   func(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error)
+	rediscover func(ctx context.Context) error
+	bus        *events.Bus
+
+	// unsubscribed, if set, is closed when an events subscriber cancels.
+	unsubscribed chan struct{}
+}
+
+func (f *fakeHandler) HandleChatCompletionStream(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error, pipe func(*EventStream) error) error {
+	if f.pipe != nil {
+		return f.pipe(ctx, req, pipe)
+	}
+	return f.stream(ctx, req, send)
+}
+
+func (f *fakeHandler) HandleChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return f.complete(ctx, req)
+}
+
+func (f *fakeHandler) HandleRediscover(ctx context.Context) error {
+	return f.rediscover(ctx)
+}
+
+func (f *fakeHandler) SubscribeEvents() (<-chan events.Event, func()) {
+	ch, cancel := f.bus.Subscribe()
+	return ch, func() {
+		cancel()
+		if f.unsubscribed != nil {
+			close(f.unsubscribed)
+		}
+	}
+}
+
+// newTestServer builds a server that is never started, taking testAPIKey
+// unless opts sets another.
+func newTestServer(opts Options, handler RequestHandler) *Server {
+	if opts.APIKey == "" {
+		opts.APIKey = testAPIKey
+	}
+	return NewServer(opts, handler, zap.NewNop())
+}
+
+// serve sends one request through s's routes and records the response.
+// headers are name, value pairs to set on the request.
+func serve(s *Server, method, path, key, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+const streamRequest = `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+
+func TestChatCompletionsStream(t *testing.T) {
+	raw := `{"id":"c1","object":"chat.completion.chunk","created":1,"model":"gpt-4","choices":[{"index":0,"delta":{"content":"Hel"},"finish_reason":null}],"x_provider":"kept"}`
+	decoded := &ChatCompletionChunk{ID: "c1", Object: "chat.completion.chunk", Created: 1, Model: "gpt-4", Choices: []ChunkChoice{{Delta: Delta{Content: "lo"}}}}
+	encoded, _ := json.Marshal(decoded)
+
+	tests := []struct {
+		name       string
+		stream     func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error
+		wantStatus int
+		wantSSE    bool
+		wantBody   string
+	}{
+		{
+			name: "chunks",
+			stream: func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error {
+				if err := send(&ChatCompletionChunk{Provider: "local", Raw: json.RawMessage(raw)}); err != nil {
+					return err
+				}
+				return send(decoded)
+			},
+			wantStatus: http.StatusOK,
+			wantSSE:    true,
+			wantBody:   "data: " + raw + "\n\ndata: " + string(encoded) + "\n\ndata: [DONE]\n\n",
+		},
+		{
+			name: "no chunks",
+			stream: func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error {
+				return nil
+			},
+			wantStatus: http.StatusOK,
+			wantSSE:    true,
+			wantBody:   "data: [DONE]\n\n",
+		},
+		{
+			name: "error before the first chunk",
+			stream: func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error {
+				return fmt.Errorf("%w: no such model", ErrInvalidRequest)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":{"message":"invalid request: no such model","type":"invalid_request_error"}}`,
+		},
+		{
+			name: "error mid-stream",
+			stream: func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error {
+				if err := send(decoded); err != nil {
+					return err
+				}
+				return errors.New("upstream went away")
+			},
+			wantStatus: http.StatusOK,
+			wantSSE:    true,
+			wantBody:   "data: " + string(encoded) + "\n\n" + `data: {"error":{"message":"upstream went away","type":"api_error"}}` + "\n\ndata: [DONE]\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *ChatCompletionRequest
+			s := newTestServer(Options{}, &fakeHandler{stream: func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error {
+				got = req
+				return tt.stream(ctx, req, send)
+			}})
+
+			rec := serve(s, http.MethodPost, "/v1/chat/completions", testAPIKey, streamRequest)
+			if got == nil || !got.Stream {
+				t.Fatalf("stream handler got %+v", got)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if sse := rec.Header().Get("Content-Type") == "text/event-stream"; sse != tt.wantSSE {
+				t.Fatalf("Content-Type %q", rec.Header().Get("Content-Type"))
+			}
+			if body := rec.Body.String(); body != tt.wantBody {
+				t.Fatalf("body\n%s\nwant\n%s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsStreamServedBy(t *testing.T) {
+	s := newTestServer(Options{}, &fakeHandler{stream: func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error {
+		return send(&ChatCompletionChunk{Provider: "azure", Headers: http.Header{"X-Request-Id": {"req-1"}}})
+	}})
+
+	rec := serve(s, http.MethodPost, "/v1/chat/completions", testAPIKey, streamRequest)
+	if got := rec.Header().Get(ServedByHeader); got != "azure" {
+		t.Errorf("%s is %q, want azure", ServedByHeader, got)
+	}
+	if got := rec.Header().Get("X-Request-Id"); got != "req-1" {
+		t.Errorf("X-Request-Id is %q, want req-1", got)
+	}
+}
+
+// TestChatCompletionsStreamPipe checks a piped event stream is copied to the
+// client byte for byte, with no [DONE] of its own after the provider's, and
+// that a failed copy still ends in an error event.
+func TestChatCompletionsStreamPipe(t *testing.T) {
+	const events = "data: {\"id\":\"c1\",\"x_provider\":\"kept\"}\n\n: keep-alive\n\ndata: [DONE]\n\n"
+	tests := []struct {
+		name     string
+		body     io.Reader
+		wantBody string
+	}{
+		{name: "copied", body: strings.NewReader(events), wantBody: events},
+		{
+			name:     "read fails",
+			body:     io.MultiReader(strings.NewReader("data: {}\n\n"), iotest.ErrReader(errors.New("upstream went away"))),
+			wantBody: "data: {}\n\n" + `data: {"error":{"message":"upstream went away","type":"api_error"}}` + "\n\ndata: [DONE]\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(Options{}, &fakeHandler{pipe: func(ctx context.Context, req *ChatCompletionRequest, pipe func(*EventStream) error) error {
+				return pipe(&EventStream{Body: tt.body, Provider: "local", Headers: http.Header{"X-Request-Id": {"req-1"}}})
+			}})
+
+			rec := serve(s, http.MethodPost, "/v1/chat/completions", testAPIKey, streamRequest)
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" {
+				t.Fatalf("status %d, Content-Type %q", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			if rec.Header().Get(ServedByHeader) != "local" || rec.Header().Get("X-Request-Id") != "req-1" {
+				t.Fatalf("headers %v", rec.Header())
+			}
+			if body := rec.Body.String(); body != tt.wantBody {
+				t.Fatalf("body\n%s\nwant\n%s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestChatCompletionsStreamClientDisconnect checks a client that goes away
+// mid-stream cancels the context the completion runs on, so the upstream
+// request is abandoned rather than read to the end.
+func TestChatCompletionsStreamClientDisconnect(t *testing.T) {
+	cancelled := make(chan struct{})
+	s := newTestServer(Options{}, &fakeHandler{stream: func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error {
+		if err := send(&ChatCompletionChunk{ID: "c1", Object: "chat.completion.chunk"}); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}})
+	srv := httptest.NewServer(s.router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(streamRequest))
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "data: ") {
+		t.Fatalf("first event %q, %v", line, err)
+	}
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("completion kept running after the client disconnected")
+	}
+}
+
+// TestStreamEvents subscribes to /v1/events and checks published events
+// arrive as named SSE events, and that the subscription ends with the request.
+func TestStreamEvents(t *testing.T) {
+	bus := events.NewBus(8)
+	handler := &fakeHandler{bus: bus, unsubscribed: make(chan struct{})}
+	s := newTestServer(Options{}, handler)
+	srv := httptest.NewServer(s.router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer "+testAPIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type %q", got)
+	}
+
+	sent := []events.Event{
+		{Type: events.TypePeerConnected, PeerID: "peer-a", Time: 1},
+		{Type: events.TypeAgentRegistered, PeerID: "peer-a", Time: 2, Data: map[string]interface{}{"name": "alpha"}},
+		{Type: events.TypePeerDisconnected, PeerID: "peer-a", Time: 3},
+	}
+	for _, e := range sent {
+		bus.Publish(e)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	for _, want := range sent {
+		event, data := readEvent(t, r)
+		encoded, _ := json.Marshal(want)
+		if event != string(want.Type) || data != string(encoded) {
+			t.Fatalf("got event %q data %s, want %q %s", event, data, want.Type, encoded)
+		}
+	}
+
+	cancel()
+	select {
+	case <-handler.unsubscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription outlived the request")
+	}
+}
+
+// readEvent reads one SSE event from r, skipping keep-alive comments.
+func readEvent(t *testing.T, r *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading event: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case line == "" && event != "":
+			return event, data
+		}
+	}
+}
+
+func TestStreamEventsRequiresKey(t *testing.T) {
+	s := newTestServer(Options{}, &fakeHandler{bus: events.NewBus(1)})
+	if rec := serve(s, http.MethodGet, "/v1/events", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d without a key, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+// TestChatCompletionsIdempotency sends chat completions with Idempotency-Key
+// headers and checks which reach the handler: a key is remembered per client,
+// and only for non-streaming requests.
+func TestChatCompletionsIdempotency(t *testing.T) {
+	const (
+		otherKey = "other-client-key-0123456789"
+		request  = `{"model":"gpt-4","messages":[{"role":"user","content":"hi"}]}`
+	)
+
+	type send struct {
+		key, idempotencyKey, body string
+		wantStatus                int
+		wantReplayed              bool
+	}
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		sends     []send
+		wantCalls int
+	}{
+		{
+			name: "retry replayed",
+			ttl:  time.Minute,
+			sends: []send{
+				{key: testAPIKey, idempotencyKey: "k", body: request, wantStatus: http.StatusOK},
+				{key: testAPIKey, idempotencyKey: "k", body: request, wantStatus: http.StatusOK, wantReplayed: true},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "keys are per client",
+			ttl:  time.Minute,
+			sends: []send{
+				{key: testAPIKey, idempotencyKey: "k", body: request, wantStatus: http.StatusOK},
+				{key: otherKey, idempotencyKey: "k", body: request, wantStatus: http.StatusOK},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "no key",
+			ttl:  time.Minute,
+			sends: []send{
+				{key: testAPIKey, body: request, wantStatus: http.StatusOK},
+				{key: testAPIKey, body: request, wantStatus: http.StatusOK},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "key reused for another request",
+			ttl:  time.Minute,
+			sends: []send{
+				{key: testAPIKey, idempotencyKey: "k", body: request, wantStatus: http.StatusOK},
+				{key: testAPIKey, idempotencyKey: "k", body: strings.Replace(request, "hi", "bye", 1), wantStatus: http.StatusUnprocessableEntity},
+			},
+			wantCalls: 1,
+		},
+		{
+			name: "disabled",
+			sends: []send{
+				{key: testAPIKey, idempotencyKey: "k", body: request, wantStatus: http.StatusOK},
+				{key: testAPIKey, idempotencyKey: "k", body: request, wantStatus: http.StatusOK},
+			},
+			wantCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			s := newTestServer(Options{IdempotencyTTL: tt.ttl, ClientKeys: []string{otherKey}}, &fakeHandler{
+				complete: func(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+					calls++
+					return &ChatCompletionResponse{ID: fmt.Sprintf("c%d", calls), Object: "chat.completion"}, nil
+				},
+			})
+
+			for i, send := range tt.sends {
+				var headers []string
+				if send.idempotencyKey != "" {
+					headers = []string{IdempotencyKeyHeader, send.idempotencyKey}
+				}
+				rec := serve(s, http.MethodPost, "/v1/chat/completions", send.key, send.body, headers...)
+				if rec.Code != send.wantStatus {
+					t.Fatalf("send %d: status %d, want %d: %s", i, rec.Code, send.wantStatus, rec.Body)
+				}
+				if replayed := rec.Header().Get("Idempotent-Replayed") == "true"; replayed != send.wantReplayed {
+					t.Fatalf("send %d: replayed %v, want %v", i, replayed, send.wantReplayed)
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsIdempotencySkipsStreams(t *testing.T) {
+	var calls int
+	s := newTestServer(Options{IdempotencyTTL: time.Minute}, &fakeHandler{stream: func(ctx context.Context, req *ChatCompletionRequest, send func(*ChatCompletionChunk) error) error {
+		calls++
+		return nil
+	}})
+	for i := 0; i < 2; i++ {
+		serve(s, http.MethodPost, "/v1/chat/completions", testAPIKey, streamRequest, IdempotencyKeyHeader, "k")
+	}
+	if calls != 2 {
+		t.Fatalf("stream handler called %d times, want 2", calls)
+	}
+}
+
+// TestBodyLimit posts chat completions around a 256 byte limit, both with a
+// declared length and chunked, and checks oversized ones never reach the
+// handler.
+func TestBodyLimit(t *testing.T) {
+	const limit = 256
+	small := `{"model":"gpt-4","messages":[{"role":"user","content":"hi"}]}`
+	large := `{"model":"gpt-4","messages":[{"role":"user","content":"` + strings.Repeat("x", limit) + `"}]}`
+
+	tests := []struct {
+		name       string
+		limit      int64
+		body       string
+		chunked    bool
+		wantStatus int
+	}{
+		{name: "within the limit", limit: limit, body: small, wantStatus: http.StatusOK},
+		{name: "declared length over the limit", limit: limit, body: large, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "chunked within the limit", limit: limit, body: small, chunked: true, wantStatus: http.StatusOK},
+		{name: "chunked over the limit", limit: limit, body: large, chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "no limit", body: large, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var called bool
+			s := newTestServer(Options{MaxBodySize: tt.limit}, &fakeHandler{
+				complete: func(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+					called = true
+					return &ChatCompletionResponse{Object: "chat.completion"}, nil
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+testAPIKey)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			s.router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Fatalf("handler called %v", called)
+			}
+			if tt.wantStatus == http.StatusRequestEntityTooLarge {
+				var body struct {
+					Error struct{ Message, Type string }
+				}
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if body.Error.Type != "request_too_large" || !strings.Contains(body.Error.Message, "256 bytes") {
+					t.Fatalf("error body %s", rec.Body)
+				}
+			}
+		})
+	}
+}
+
+// TestAuth sends requests with each kind of key to a client route and an
+// admin one, with and without a separate admin key configured.
+func TestAuth(t *testing.T) {
+	const (
+		clientKey = "client-key-0123456789"
+		adminKey  = "admin-key-0123456789"
+	)
+
+	tests := []struct {
+		name       string
+		adminKey   string
+		path       string
+		key        string
+		wantStatus int
+		wantClient string
+	}{
+		{name: "client route without a key", path: "/v1/chat/completions", wantStatus: http.StatusUnauthorized},
+		{name: "client route with a wrong key", path: "/v1/chat/completions", key: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "client route with the API key", path: "/v1/chat/completions", key: testAPIKey, wantStatus: http.StatusOK, wantClient: ClientIdentity(testAPIKey)},
+		{name: "client route with a client key", path: "/v1/chat/completions", key: clientKey, wantStatus: http.StatusOK, wantClient: ClientIdentity(clientKey)},
+		{name: "client route with the admin key", adminKey: adminKey, path: "/v1/chat/completions", key: adminKey, wantStatus: http.StatusUnauthorized},
+
+		{name: "admin route without a key", path: "/v1/admin/rediscover", wantStatus: http.StatusUnauthorized},
+		{name: "admin route with a wrong key", path: "/v1/admin/rediscover", key: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "admin route with a client key", path: "/v1/admin/rediscover", key: clientKey, wantStatus: http.StatusForbidden},
+		{name: "admin route falls back to the API key", path: "/v1/admin/rediscover", key: testAPIKey, wantStatus: http.StatusOK, wantClient: adminClientID},
+		{name: "admin route refuses the API key once an admin key is set", adminKey: adminKey, path: "/v1/admin/rediscover", key: testAPIKey, wantStatus: http.StatusForbidden},
+		{name: "admin route with the admin key", adminKey: adminKey, path: "/v1/admin/rediscover", key: adminKey, wantStatus: http.StatusOK, wantClient: adminClientID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var client string
+			called := false
+			s := newTestServer(Options{AdminKey: tt.adminKey, ClientKeys: []string{clientKey}}, &fakeHandler{
+				complete: func(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+					called, client = true, ClientIDFromContext(ctx)
+					return &ChatCompletionResponse{}, nil
+				},
+				rediscover: func(ctx context.Context) error {
+					called, client = true, ClientIDFromContext(ctx)
+					return nil
+				},
+			})
+
+			rec := serve(s, http.MethodPost, tt.path, tt.key, `{"model":"gpt-4","messages":[]}`)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if called != (tt.wantStatus == http.StatusOK) || client != tt.wantClient {
+				t.Fatalf("handler called %v as %q, want client %q", called, client, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestHandlerError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantType   string
+	}{
+		{err: fmt.Errorf("%w: alpha", ErrAgentNotFound), wantStatus: http.StatusNotFound, wantType: "agent_not_found"},
+		{err: fmt.Errorf("%w: alpha", ErrAgentUnreachable), wantStatus: http.StatusNotFound, wantType: "agent_unreachable"},
+		{err: fmt.Errorf("%w: bad model", ErrInvalidRequest), wantStatus: http.StatusBadRequest, wantType: "invalid_request_error"},
+		{err: fmt.Errorf("%w: announcement", ErrNotFound), wantStatus: http.StatusNotFound, wantType: "not_found_error"},
+		{err: fmt.Errorf("%w: name taken", ErrConflict), wantStatus: http.StatusConflict, wantType: "conflict_error"},
+		{err: fmt.Errorf("%w: model not allowed", ErrForbidden), wantStatus: http.StatusForbidden, wantType: "permission_error"},
+		{err: fmt.Errorf("%w: slow down", ErrTooManyRequests), wantStatus: http.StatusTooManyRequests, wantType: "rate_limit_error"},
+		{err: ErrIdempotencyMismatch, wantStatus: http.StatusUnprocessableEntity, wantType: "idempotency_error"},
+		{err: errors.New("boom"), wantStatus: http.StatusInternalServerError, wantType: "api_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantType, func(t *testing.T) {
+			s := newTestServer(Options{}, &fakeHandler{rediscover: func(ctx context.Context) error {
+				return tt.err
+			}})
+
+			rec := serve(s, http.MethodPost, "/v1/admin/rediscover", testAPIKey, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body struct {
+				Error struct{ Message, Type string }
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body, err)
+			}
+			if body.Error.Type != tt.wantType || body.Error.Message != tt.err.Error() {
+				t.Fatalf("error %+v, want type %s and message %q", body.Error, tt.wantType, tt.err)
+			}
+		})
+	}
+}
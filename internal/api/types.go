@@ -1,16 +1,42 @@
 package api
 
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
 type ChatCompletionRequest struct {
 	Model       string    `json:"model"`
 	Messages    []Message `json:"messages"`
 	Temperature float64   `json:"temperature,omitempty"`
 	MaxTokens   int       `json:"max_tokens,omitempty"`
 	Stream      bool      `json:"stream,omitempty"`
+
+	// Tools and ToolChoice are passed to the provider as given.
+	Tools      json.RawMessage `json:"tools,omitempty"`
+	ToolChoice json.RawMessage `json:"tool_choice,omitempty"`
 }
 
 type Message struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+
+	// ToolCalls are made by assistant messages; ToolCallID names the call a
+	// "tool" message answers.
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON, as generated by the model
 }
 
 type ChatCompletionResponse struct {
@@ -20,6 +46,74 @@ type ChatCompletionResponse struct {
 	Model   string   `json:"model"`
 	Choices []Choice `json:"choices"`
 	Usage   Usage    `json:"usage"`
+
+	// Provider names whoever actually served the request. It is reported in
+	// the X-Served-By header rather than in the OpenAI-shaped body.
+	Provider string `json:"-"`
+
+	// Headers are upstream response headers to pass through to the client,
+	// such as x-request-id and the x-ratelimit-* family.
+	Headers http.Header `json:"-"`
+}
+
+// ChatCompletionChunk is one server-sent event of a streaming completion.
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+
+	// Usage is only present on the final chunk, and only from providers
+	// that report it for streams.
+	Usage *Usage `json:"usage,omitempty"`
+
+	// Provider and Headers mean the same as on ChatCompletionResponse. They
+	// are read from the first chunk, before the response headers are sent.
+	Provider string      `json:"-"`
+	Headers  http.Header `json:"-"`
+
+	// Raw is the chunk as the provider or agent sent it, with at most its
+	// model renamed. When set it is relayed verbatim rather than re-encoded,
+	// so fields this type doesn't model reach the client too.
+	Raw json.RawMessage `json:"-"`
+}
+
+// EventStream is a provider's server-sent events, to be copied to the client
+// as they are, [DONE] included. Provider and Headers mean the same as on
+// ChatCompletionChunk.
+type EventStream struct {
+	Body     io.Reader
+	Provider string
+	Headers  http.Header
+}
+
+type ChunkChoice struct {
+	Index        int     `json:"index"`
+	Delta        Delta   `json:"delta"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+type Delta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+// ToolCallDelta is a fragment of a streamed tool call. Fragments with the
+// same Index belong to the same call: the first carries its ID, type and
+// function name, later ones append to the arguments. Relays must keep Index
+// as they received it.
+type ToolCallDelta struct {
+	Index    int               `json:"index"`
+	ID       string            `json:"id,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	Function FunctionCallDelta `json:"function"`
+}
+
+type FunctionCallDelta struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
 }
 
 type Choice struct {
@@ -44,20 +138,44 @@ type Model struct {
 	Object  string `json:"object"`
 	Created int64  `json:"created"`
 	OwnedBy string `json:"owned_by"`
+
+	// Agents counts the agents serving the model, including this one. Only
+	// set for ?scope=network listings.
+	Agents int `json:"agents,omitempty"`
 }
 
 type AgentsResponse struct {
-	Object string       `json:"object"`
+	Object string      `json:"object"`
 	Data   []AgentInfo `json:"data"`
 }
 
+const (
+	AgentKindAgent   = "agent"   // registered or answered an agent probe
+	AgentKindPeer    = "peer"    // libp2p peer that doesn't speak the agent protocol
+	AgentKindUnknown = "unknown" // not probed yet
+)
+
 type AgentInfo struct {
-	ID        string   `json:"id"`
-	Name      string   `json:"name"`
-	PeerID    string   `json:"peer_id"`
-	Endpoint  string   `json:"endpoint"`
-	Models    []string `json:"models"`
-	Connected bool     `json:"connected"`
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	Kind            string            `json:"kind"`
+	PeerID          string            `json:"peer_id"`
+	Endpoint        string            `json:"endpoint"`
+	Models          []string          `json:"models"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Registered      bool              `json:"registered"` // false for peers that are connected but haven't registered
+	Connected       bool              `json:"connected"`
+	State           string            `json:"state,omitempty"` // connecting, connected-direct, connected-relay, disconnected
+	Pinned          bool              `json:"pinned,omitempty"`
+	UpstreamHealthy *bool             `json:"upstream_healthy,omitempty"`
+	Reputation      *float64          `json:"reputation,omitempty"` // estimated success rate, local and gossiped
+	Addrs           []string          `json:"addrs,omitempty"`
+	ConnectionType  string            `json:"connection_type,omitempty"` // direct, relay
+	ConnectionAddr  string            `json:"connection_addr,omitempty"` // remote address of the connection in use
+	LastSeen        int64             `json:"last_seen,omitempty"`
+	Flaps           int               `json:"flaps,omitempty"`          // disconnects in the last 5 minutes
+	CooldownUntil   int64             `json:"cooldown_until,omitempty"` // no redials before this while flapping
+	LearnedFrom     string            `json:"learned_from,omitempty"`   // peer ID that shared this peer via peer exchange
 }
 
 type AnnounceRequest struct {
@@ -66,4 +184,218 @@ type AnnounceRequest struct {
 	URL         string   `json:"url"`
 	Description string   `json:"description"`
 	Tags        []string `json:"tags"`
+
+	// Interval, if set (e.g. "5m"), re-broadcasts the announcement until it
+	// is withdrawn via DELETE /v1/announcements/:id.
+	Interval string `json:"interval,omitempty"`
+
+	// Target, if set, limits the broadcast to matching peers.
+	Target *AnnounceTarget `json:"target,omitempty"`
+}
+
+// AnnounceTarget selects the peers an announcement goes to. All set fields
+// must match; Model and Labels only match registered agents.
+type AnnounceTarget struct {
+	Model  string            `json:"model,omitempty"`  // agents serving this model
+	Labels map[string]string `json:"labels,omitempty"` // agents carrying all of these labels
+	Pinned bool              `json:"pinned,omitempty"` // only --pin-peer peers
+}
+
+// AnnouncementSubscription selects the announcements from peers an agent
+// keeps: those with one of Types and at least one of Tags. An empty list
+// matches anything.
+type AnnouncementSubscription struct {
+	Types []string `json:"types"`
+	Tags  []string `json:"tags"`
+}
+
+// RegisterRequest updates this agent's advertised identity. Empty fields are
+// left unchanged; a non-nil Labels replaces all labels.
+type RegisterRequest struct {
+	Name     string            `json:"name,omitempty"`
+	Endpoint string            `json:"endpoint,omitempty"`
+	Models   []string          `json:"models,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+type RegisterResponse struct {
+	Status    string            `json:"status"`
+	Name      string            `json:"name"`
+	Endpoint  string            `json:"endpoint"`
+	Models    []string          `json:"models"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Peers     int               `json:"peers"`
+	Delivered int               `json:"delivered"`
+}
+
+type ConnectPeerRequest struct {
+	Addr string `json:"addr"`
+}
+
+// AnnounceResponse reports how many connected peers received an announcement.
+type AnnounceResponse struct {
+	Status    string `json:"status"`
+	Peers     int    `json:"peers"`
+	Delivered int    `json:"delivered"`
+	Failed    int    `json:"failed"`
+
+	// ID and Interval are set for repeating announcements.
+	ID       string `json:"id,omitempty"`
+	Interval string `json:"interval,omitempty"`
+
+	// Tags are the announced tags after normalization. UnknownTags are those
+	// outside the agent's tag vocabulary, sent anyway unless it is strict.
+	Tags        []string `json:"tags"`
+	UnknownTags []string `json:"unknown_tags,omitempty"`
+}
+
+type ConnectPeerResponse struct {
+	Status string `json:"status"`
+	PeerID string `json:"peer_id"`
+}
+
+type ClientUsage struct {
+	ClientID         string  `json:"client_id"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	EstimatedCost    float64 `json:"estimated_cost_usd"`
+}
+
+type UsageResponse struct {
+	Object string        `json:"object"`
+	Data   []ClientUsage `json:"data"`
+	Total  ClientUsage   `json:"total"`
+}
+
+// LatencyStats summarises a latency histogram in milliseconds.
+type LatencyStats struct {
+	Count int     `json:"count"`
+	P50Ms float64 `json:"p50_ms"`
+	P90Ms float64 `json:"p90_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+type PeerLatency struct {
+	PeerID string        `json:"peer_id"`
+	Name   string        `json:"name,omitempty"`
+	Ping   *LatencyStats `json:"ping,omitempty"`
+	Chat   *LatencyStats `json:"chat,omitempty"`
+}
+
+type ProviderLatency struct {
+	Provider   string        `json:"provider"`
+	Completion *LatencyStats `json:"completion,omitempty"`
+	FirstChunk *LatencyStats `json:"first_chunk,omitempty"`
+}
+
+type LatencyResponse struct {
+	Object    string            `json:"object"`
+	Peers     []PeerLatency     `json:"peers"`
+	Providers []ProviderLatency `json:"providers"`
+}
+
+// StatsResponse is a compact summary of the node since it started, for
+// people and dashboards; /metrics has the detail.
+type StatsResponse struct {
+	Object        string            `json:"object"`
+	UptimeSeconds int64             `json:"uptime_seconds"`
+	Requests      RequestStats      `json:"requests"`
+	Tokens        TokenStats        `json:"tokens"`
+	Peers         PeerStats         `json:"peers"`
+	Broadcasts    BroadcastStats    `json:"broadcasts"`
+	Cache         *CacheStats       `json:"cache,omitempty"` // absent without an idempotency TTL
+	Breakers      map[string]string `json:"breakers"`        // provider -> closed, open or half_open
+
+	// Concurrency lists the clients with chat completions open.
+	Concurrency []ClientConcurrency `json:"concurrency"`
+}
+
+type ClientConcurrency struct {
+	ClientID string `json:"client_id"`
+	Name     string `json:"name,omitempty"`
+	InFlight int    `json:"in_flight"`
+	Limit    int    `json:"limit"` // 0 = unlimited
+}
+
+// RequestStats counts chat completions by outcome. Served and PeerServed
+// succeeded, for clients and for other agents respectively.
+type RequestStats struct {
+	Served     int `json:"served"`
+	PeerServed int `json:"peer_served"`
+	Failed     int `json:"failed"`
+	InFlight   int `json:"in_flight"`
+}
+
+// TokenStats are the tokens clients used, and those spent serving peers.
+type TokenStats struct {
+	Prompt     int `json:"prompt"`
+	Completion int `json:"completion"`
+	Total      int `json:"total"`
+	Peer       int `json:"peer"`
+}
+
+type PeerStats struct {
+	Connected  int `json:"connected"`
+	Known      int `json:"known"`      // connected or not
+	Registered int `json:"registered"` // agents whose registration we hold
+}
+
+type BroadcastStats struct {
+	Sent      int `json:"sent"`
+	Delivered int `json:"delivered"` // per-peer sends
+	Failed    int `json:"failed"`
+}
+
+// CacheStats are the idempotency cache's lookups. HitRate is Hits over all
+// lookups, 0 before the first.
+type CacheStats struct {
+	Hits    int     `json:"hits"`
+	Misses  int     `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// TopologyResponse is this agent's view of the network as a graph. Nodes
+// and edges beyond direct peers are only present with --topology-depth.
+type TopologyResponse struct {
+	Object string         `json:"object"`
+	Self   string         `json:"self"` // this agent's peer ID
+	Depth  int            `json:"depth"`
+	Nodes  []TopologyNode `json:"nodes"`
+	Edges  []TopologyEdge `json:"edges"`
+}
+
+type TopologyNode struct {
+	ID        string            `json:"id"` // peer ID
+	Name      string            `json:"name,omitempty"`
+	Kind      string            `json:"kind"`
+	Models    []string          `json:"models,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Connected bool              `json:"connected"`      // directly connected to this agent
+	Hops      *int              `json:"hops,omitempty"` // 0 for this agent, absent for peers with no known path to it
+}
+
+// TopologyEdge is a connection between two peers. Edges are undirected,
+// with Source < Target. Gossiped edges were reported by other agents.
+type TopologyEdge struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Gossiped bool   `json:"gossiped,omitempty"`
+}
+
+// PeerHealth is one agent's result in a health sweep.
+type PeerHealth struct {
+	Name      string  `json:"name"`
+	Reachable bool    `json:"reachable"`
+	LatencyMs float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthCheckResponse struct {
+	Object      string                `json:"object"`
+	Reachable   int                   `json:"reachable"`
+	Unreachable int                   `json:"unreachable"`
+	Peers       map[string]PeerHealth `json:"peers"` // by peer ID
 }
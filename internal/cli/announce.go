@@ -1,14 +1,13 @@
 package cli
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"time"
 
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 var (
@@ -17,6 +16,12 @@ var (
 	announceURL  string
 	announceDesc string
 	announceTags []string
+
+	announceEvery time.Duration
+
+	announceToModel  string
+	announceToLabels map[string]string
+	announceToPinned bool
 )
 
 var announceCmd = &cobra.Command{
@@ -28,10 +33,24 @@ Example:
   p2p-agent announce --type repo --name "agents-p2p-network" \
     --url "https://github.com/denizumutdereli/agents-p2p-network" \
     --desc "P2P network for AI agents with OpenAI-compatible API" \
-    --tags p2p,ai,agents,openai`,
+    --tags p2p,ai,agents,openai
+
+Use --every to keep re-broadcasting until withdrawn:
+  p2p-agent announce --name my-tool --url https://example.com --every 5m
+  p2p-agent announce withdraw <id>
+
+Use --to-model, --to-label or --to-pinned to reach only matching agents:
+  p2p-agent announce --name gpu-tool --url https://example.com --to-label gpu=a100`,
 	RunE: runAnnounce,
 }
 
+var announceWithdrawCmd = &cobra.Command{
+	Use:   "withdraw <id>",
+	Short: "Stop re-broadcasting a repeating announcement",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAnnounceWithdraw,
+}
+
 func init() {
 	rootCmd.AddCommand(announceCmd)
 
@@ -40,22 +59,18 @@ func init() {
 	announceCmd.Flags().StringVar(&announceURL, "url", "", "Resource URL (required)")
 	announceCmd.Flags().StringVar(&announceDesc, "desc", "", "Resource description")
 	announceCmd.Flags().StringSliceVar(&announceTags, "tags", []string{}, "Tags (comma-separated)")
+	announceCmd.Flags().DurationVar(&announceEvery, "every", 0, "Re-broadcast at this interval until withdrawn (e.g. 5m)")
+	announceCmd.Flags().StringVar(&announceToModel, "to-model", "", "Only announce to agents serving this model")
+	announceCmd.Flags().StringToStringVar(&announceToLabels, "to-label", map[string]string{}, "Only announce to agents with this label, key=value (repeatable)")
+	announceCmd.Flags().BoolVar(&announceToPinned, "to-pinned", false, "Only announce to pinned peers")
 
 	announceCmd.MarkFlagRequired("name")
 	announceCmd.MarkFlagRequired("url")
+
+	announceCmd.AddCommand(announceWithdrawCmd)
 }
 
 func runAnnounce(cmd *cobra.Command, args []string) error {
-	port := viper.GetInt("port")
-	if port == 0 {
-		port = 8080
-	}
-
-	apiKey := viper.GetString("api_key")
-	if apiKey == "" {
-		return fmt.Errorf("API key required. Set via --api-key or P2P_API_KEY env var")
-	}
-
 	payload := map[string]interface{}{
 		"type":        announceType,
 		"name":        announceName,
@@ -63,39 +78,59 @@ func runAnnounce(cmd *cobra.Command, args []string) error {
 		"description": announceDesc,
 		"tags":        announceTags,
 	}
-
-	body, _ := json.Marshal(payload)
-
-	url := fmt.Sprintf("http://localhost:%d/v1/announce", port)
-	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	if announceEvery > 0 {
+		payload["interval"] = announceEvery.String()
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send announce (is agent running?): %w", err)
+	if announceToModel != "" || len(announceToLabels) > 0 || announceToPinned {
+		payload["target"] = api.AnnounceTarget{
+			Model:  announceToModel,
+			Labels: announceToLabels,
+			Pinned: announceToPinned,
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("announce failed with status: %d", resp.StatusCode)
+	var resp api.AnnounceResponse
+	if err := apiPost("/v1/announce", payload, &resp); err != nil {
+		if errors.Is(err, errEndpointNotFound) {
+			return fmt.Errorf("announce failed: %w (restart it with a build that supports announcements)", err)
+		}
+		return fmt.Errorf("announce failed: %w", err)
 	}
 
-	fmt.Printf("📢 Announced to network:\n")
-	fmt.Printf("   Type: %s\n", announceType)
-	fmt.Printf("   Name: %s\n", announceName)
-	fmt.Printf("   URL:  %s\n", announceURL)
-	if announceDesc != "" {
-		fmt.Printf("   Desc: %s\n", announceDesc)
-	}
-	if len(announceTags) > 0 {
-		fmt.Printf("   Tags: %v\n", announceTags)
+	return printResult(resp, func(w io.Writer) {
+		fmt.Fprintf(w, "📢 Announced to network:\n")
+		fmt.Fprintf(w, "   Type: %s\n", announceType)
+		fmt.Fprintf(w, "   Name: %s\n", announceName)
+		fmt.Fprintf(w, "   URL:  %s\n", announceURL)
+		if announceDesc != "" {
+			fmt.Fprintf(w, "   Desc: %s\n", announceDesc)
+		}
+		if len(resp.Tags) > 0 {
+			fmt.Fprintf(w, "   Tags: %v\n", resp.Tags)
+		}
+		if len(resp.UnknownTags) > 0 {
+			fmt.Fprintf(w, "   ⚠️  Not in the agent's tag vocabulary: %v\n", resp.UnknownTags)
+		}
+		peersLabel := "connected peers"
+		if payload["target"] != nil {
+			peersLabel = "matching peers"
+		}
+		fmt.Fprintf(w, "   Delivered to %d of %d %s", resp.Delivered, resp.Peers, peersLabel)
+		if resp.Failed > 0 {
+			fmt.Fprintf(w, " (%d failed)", resp.Failed)
+		}
+		fmt.Fprintln(w)
+		if resp.ID != "" {
+			fmt.Fprintf(w, "   Repeating every %s, withdraw with: p2p-agent announce withdraw %s\n", resp.Interval, resp.ID)
+		}
+	})
+}
+
+func runAnnounceWithdraw(cmd *cobra.Command, args []string) error {
+	if err := apiDelete("/v1/announcements/" + args[0]); err != nil {
+		return fmt.Errorf("withdraw failed: %w", err)
 	}
 
+	fmt.Printf("🛑 Withdrew announcement %s\n", args[0])
 	return nil
 }
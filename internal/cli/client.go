@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// errEndpointNotFound is returned when the running agent doesn't serve the
+// requested route, typically because it is an older build.
+var errEndpointNotFound = errors.New("endpoint not found")
+
+// apiGet calls a GET endpoint on the locally running agent and decodes the
+// JSON response into out.
+func apiGet(path string, out interface{}) error {
+	return apiDo("GET", path, nil, out)
+}
+
+// apiPost sends in as JSON to a POST endpoint on the locally running agent and
+// decodes the JSON response into out, if given.
+func apiPost(path string, in, out interface{}) error {
+	return apiDo("POST", path, in, out)
+}
+
+// apiDelete calls a DELETE endpoint on the locally running agent.
+func apiDelete(path string) error {
+	return apiDo("DELETE", path, nil, nil)
+}
+
+// newAPIRequest builds an authenticated request against the locally running
+// agent. /v1/admin paths are sent with the admin key when one is set.
+func newAPIRequest(method, path string, body io.Reader) (*http.Request, error) {
+	port := viper.GetInt("port")
+	if port == 0 {
+		port = 8080
+	}
+
+	apiKey := viper.GetString("api_key")
+	if adminKey := viper.GetString("admin_key"); adminKey != "" && strings.HasPrefix(path, "/v1/admin/") {
+		apiKey = adminKey
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key required. Set via --api-key or P2P_API_KEY env var")
+	}
+
+	url := fmt.Sprintf("http://localhost:%d%s", port, path)
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	return req, nil
+}
+
+func apiDo(method, path string, in, out interface{}) error {
+	var reqBody io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := newAPIRequest(method, path, reqBody)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed (is agent running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, body)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// responseError turns a non-200 response into an error, preferring the API's
+// own error message.
+func responseError(resp *http.Response, body []byte) error {
+	var apiErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, apiErr.Error.Message)
+	}
+	// gin answers unknown routes with a bare 404 and no JSON error body.
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: the agent at %s does not serve %s %s", errEndpointNotFound, resp.Request.URL.Host, resp.Request.Method, resp.Request.URL.Path)
+	}
+	return fmt.Errorf("request failed with status: %d", resp.StatusCode)
+}
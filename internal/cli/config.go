@@ -2,10 +2,14 @@ package cli
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -29,10 +33,42 @@ var configShowCmd = &cobra.Command{
 	RunE:  runShowConfig,
 }
 
+var configValidateJSON bool
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration without starting the agent",
+	Long: `Validate the configuration 'start' would use, from the config file,
+P2P_* env vars and defaults. With --json, errors are printed as a JSON array of
+{"field", "code", "message"} objects for tooling.`,
+	RunE: runValidateConfig,
+}
+
+var configProvidersCmd = &cobra.Command{
+	Use:   "providers",
+	Short: "Work with the providers file",
+}
+
+var configProvidersValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check a providers file and its model catalog",
+	Long: `Validate a providers file on its own: that it parses with no unknown
+keys, that every provider is well formed and its api_key_file readable, and
+that every model entry names known providers. Without an argument the file
+'start' would load is checked.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runValidateProviders,
+}
+
 func init() {
 	rootCmd.AddCommand(configCmd)
 	configCmd.AddCommand(configSetKeyCmd)
 	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configProvidersCmd)
+	configProvidersCmd.AddCommand(configProvidersValidateCmd)
+
+	configValidateCmd.Flags().BoolVar(&configValidateJSON, "json", false, "Print errors as JSON")
 }
 
 func runSetKey(cmd *cobra.Command, args []string) error {
@@ -56,6 +92,9 @@ func runSetKey(cmd *cobra.Command, args []string) error {
 	viper.Set("api_key", key)
 
 	configPath := getConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
 	if err := viper.WriteConfigAs(configPath); err != nil {
 		if err := viper.SafeWriteConfigAs(configPath); err != nil {
 			return fmt.Errorf("failed to save config: %w", err)
@@ -66,22 +105,124 @@ func runSetKey(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+type shownConfig struct {
+	APIKey    string `json:"api_key"`
+	HTTPPort  int    `json:"port"`
+	P2PPort   int    `json:"p2p_port"`
+	Name      string `json:"name"`
+	Bootstrap string `json:"bootstrap"`
+}
+
 func runShowConfig(cmd *cobra.Command, args []string) error {
-	fmt.Println("Current Configuration:")
-	fmt.Println("─────────────────────────")
+	shown := shownConfig{
+		APIKey:    maskKey(viper.GetString("api_key")),
+		HTTPPort:  viper.GetInt("port"),
+		P2PPort:   viper.GetInt("p2p_port"),
+		Name:      viper.GetString("name"),
+		Bootstrap: viper.GetString("bootstrap"),
+	}
 
-	apiKey := viper.GetString("api_key")
-	if apiKey != "" {
-		masked := apiKey[:7] + "..." + apiKey[len(apiKey)-4:]
-		fmt.Printf("  API Key:    %s\n", masked)
-	} else {
-		fmt.Println("  API Key:    (not set)")
+	return printResult(shown, func(w io.Writer) {
+		fmt.Fprintln(w, "Current Configuration:")
+		fmt.Fprintln(w, "─────────────────────────")
+		fmt.Fprintf(w, "  API Key:    %s\n", shown.APIKey)
+		fmt.Fprintf(w, "  HTTP Port:  %d\n", shown.HTTPPort)
+		fmt.Fprintf(w, "  P2P Port:   %d\n", shown.P2PPort)
+		fmt.Fprintf(w, "  Agent Name: %s\n", shown.Name)
+		fmt.Fprintf(w, "  Bootstrap:  %s\n", shown.Bootstrap)
+	})
+}
+
+// maskKey keeps just enough of a key to tell keys apart.
+func maskKey(key string) string {
+	switch {
+	case key == "":
+		return "(not set)"
+	case len(key) < 12:
+		return "..." + key[len(key)-min(len(key), 2):]
+	default:
+		return key[:7] + "..." + key[len(key)-4:]
 	}
+}
 
-	fmt.Printf("  HTTP Port:  %d\n", viper.GetInt("port"))
-	fmt.Printf("  P2P Port:   %d\n", viper.GetInt("p2p_port"))
-	fmt.Printf("  Agent Name: %s\n", viper.GetString("name"))
-	fmt.Printf("  Bootstrap:  %s\n", viper.GetString("bootstrap"))
+func runValidateConfig(cmd *cobra.Command, args []string) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	errs, warnings := cfg.Validate()
+	return reportValidation(cmd, errs, warnings, configValidateJSON || outputFormat != outputTable, true)
+}
 
+func runValidateProviders(cmd *cobra.Command, args []string) error {
+	path := providersFilePath()
+	if len(args) == 1 {
+		path = args[0]
+	}
+	if path == "" {
+		return fmt.Errorf("no providers file. Pass one, set providers_file or create %s", filepath.Join(configDir(), "providers.yaml"))
+	}
+
+	file, err := config.LoadProvidersFile(path)
+	if err != nil {
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	errs := config.ValidateProvidersFile(file)
+	return reportValidation(cmd, errs, nil, outputFormat != outputTable, true)
+}
+
+// validationResult is one entry of the JSON validation output.
+type validationResult struct {
+	config.ValidationError
+	Severity string `json:"severity"` // "error" or "warning"
+}
+
+// reportValidation prints validation results, as a list of
+// {field, code, message, severity} objects when structured is set (in the
+// --output format, JSON unless YAML was asked for), and returns an error if
+// there were any errors. Warnings are printed but never fail. reportOK also
+// confirms a valid config.
+func reportValidation(cmd *cobra.Command, errs, warnings config.ValidationErrors, structured, reportOK bool) error {
+	if structured {
+		results := []validationResult{}
+		for _, e := range errs {
+			results = append(results, validationResult{e, "error"})
+		}
+		for _, w := range warnings {
+			results = append(results, validationResult{w, "warning"})
+		}
+		if errs.HasErrors() || reportOK {
+			if outputFormat == outputYAML {
+				out, _ := toYAML(results)
+				fmt.Print(string(out))
+			} else {
+				out, _ := json.MarshalIndent(results, "", "  ")
+				fmt.Println(string(out))
+			}
+		}
+	} else {
+		if warnings.HasErrors() {
+			fmt.Println("⚠️  Configuration warnings:")
+			for _, w := range warnings {
+				fmt.Printf("   • %s: %s\n", w.Field, w.Message)
+			}
+		}
+		if errs.HasErrors() {
+			fmt.Println("❌ Configuration errors:")
+			for _, e := range errs {
+				fmt.Printf("   • %s: %s\n", e.Field, e.Message)
+			}
+		} else if reportOK {
+			fmt.Println("✅ Configuration is valid")
+		}
+	}
+
+	if errs.HasErrors() {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("invalid configuration")
+	}
 	return nil
 }
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const validConfig = `
+api_key: sk-test-0123456789abcdef0123456789abcdef
+admin_key: admin-test-0123456789abcdef
+name: alpha
+bootstrap: /ip4/127.0.0.1/tcp/4001/p2p/12D3KooWEyoppNCUx8Yx66oV9fJnriXwCcXwDDUA2kj6vnc6iDEp
+`
+
+// TestValidateJSON runs both ways of validating a config with JSON output
+// and checks the results parse as {field, code, message, severity} objects.
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		args      []string
+		wantCodes []string
+		wantErr   bool
+	}{
+		{
+			name:   "config validate, valid",
+			config: validConfig,
+			args:   []string{"config", "validate", "--json"},
+		},
+		{
+			name:      "config validate, invalid",
+			config:    "role: bogus\nport: 0\n",
+			args:      []string{"config", "validate", "--json"},
+			wantCodes: []string{"api_key_missing", "name_missing", "role_invalid", "port_out_of_range", "bootstrap_missing"},
+			wantErr:   true,
+		},
+		{
+			name:      "start --validate-only, warning only",
+			config:    strings.Replace(validConfig, "bootstrap:", "ignored:", 1),
+			args:      []string{"start", "--validate-only", "--json"},
+			wantCodes: []string{"bootstrap_missing"},
+		},
+		{
+			name:      "start --validate-only, invalid",
+			config:    "api_key: sk-short\n",
+			args:      []string{"start", "--validate-only", "--json"},
+			wantCodes: []string{"api_key_too_short", "name_missing", "bootstrap_missing"},
+			wantErr:   true,
+		},
+		{
+			name:      "--output json",
+			config:    "api_key: sk-short\n",
+			args:      []string{"config", "validate", "-o", "json"},
+			wantCodes: []string{"api_key_too_short", "name_missing", "bootstrap_missing"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			path := writeConfig(t, "config.yaml", tt.config)
+
+			out, err := run(t, append(tt.args, "--config", path)...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+
+			var results []struct {
+				Field, Code, Message, Severity string
+			}
+			if err := json.Unmarshal([]byte(out), &results); err != nil {
+				t.Fatalf("output is not JSON: %v\n%s", err, out)
+			}
+			var codes []string
+			for _, r := range results {
+				if r.Field == "" || r.Message == "" || (r.Severity != "error" && r.Severity != "warning") {
+					t.Fatalf("incomplete result %+v", r)
+				}
+				codes = append(codes, r.Code)
+			}
+			if strings.Join(codes, ",") != strings.Join(tt.wantCodes, ",") {
+				t.Fatalf("codes %v, want %v", codes, tt.wantCodes)
+			}
+		})
+	}
+}
+
+// TestValidateTable checks the human-readable output lists errors and
+// warnings and confirms a valid config.
+func TestValidateTable(t *testing.T) {
+	isolate(t)
+
+	out, err := run(t, "config", "validate", "--config", writeConfig(t, "config.yaml", validConfig))
+	if err != nil || !strings.Contains(out, "Configuration is valid") {
+		t.Fatalf("valid config: %v\n%s", err, out)
+	}
+
+	out, err = run(t, "config", "validate", "--config", writeConfig(t, "config.yaml", "role: bogus\n"))
+	if err == nil || !strings.Contains(out, "Configuration errors") || !strings.Contains(out, "role: Unknown role") {
+		t.Fatalf("invalid config: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/logstream"
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsFollow bool
+	logsLevel  string
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Show the running agent's recent logs",
+	Long: `Print the log entries buffered by the running agent.
+
+Use -f to keep following new entries, and --level to hide noisier ones:
+  p2p-agent logs -f --level warn`,
+	RunE: runLogs,
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep streaming new log entries")
+	logsCmd.Flags().StringVar(&logsLevel, "level", "info", "Minimum level: debug, info, warn, error")
+}
+
+func runLogs(cmd *cobra.Command, args []string) error {
+	query := url.Values{"level": {logsLevel}}
+	if !logsFollow {
+		query.Set("follow", "false")
+
+		var resp struct {
+			Data []logstream.Entry `json:"data"`
+		}
+		if err := apiGet("/v1/admin/debug/logs?"+query.Encode(), &resp); err != nil {
+			return err
+		}
+		for _, e := range resp.Data {
+			printLogEntry(e)
+		}
+		return nil
+	}
+
+	req, err := newAPIRequest("GET", "/v1/admin/debug/logs?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// No client timeout: the stream stays open until interrupted.
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed (is agent running?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return responseError(resp, body)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var e logstream.Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			continue
+		}
+		printLogEntry(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("log stream interrupted: %w", err)
+	}
+	return nil
+}
+
+func printLogEntry(e logstream.Entry) {
+	line := fmt.Sprintf("%s  %-5s  %s", e.Time.Format("2006-01-02T15:04:05.000Z07:00"), strings.ToUpper(e.Level), e.Message)
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		line += fmt.Sprintf("  %s=%v", k, e.Fields[k])
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var modelsNetwork bool
+
+var modelsCmd = &cobra.Command{
+	Use:   "models",
+	Short: "List the models the running agent serves",
+	Long: `List the models the running agent serves. With --network, list every
+model served by a connected agent and how many agents serve it.`,
+	RunE: runModels,
+}
+
+func init() {
+	rootCmd.AddCommand(modelsCmd)
+
+	modelsCmd.Flags().BoolVar(&modelsNetwork, "network", false, "Include models served by connected agents")
+}
+
+func runModels(cmd *cobra.Command, args []string) error {
+	path := "/v1/models"
+	if modelsNetwork {
+		path += "?scope=network"
+	}
+
+	var resp api.ModelsResponse
+	if err := apiGet(path, &resp); err != nil {
+		return err
+	}
+
+	return printResult(resp, func(w io.Writer) {
+		if len(resp.Data) == 0 {
+			fmt.Fprintln(w, "No models")
+			return
+		}
+		headers := []string{"MODEL", "OWNED BY"}
+		if modelsNetwork {
+			headers = append(headers, "AGENTS")
+		}
+		tw := newTable(w, headers...)
+		for _, m := range resp.Data {
+			if modelsNetwork {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", m.ID, m.OwnedBy, strconv.Itoa(m.Agents))
+			} else {
+				fmt.Fprintf(tw, "%s\t%s\n", m.ID, m.OwnedBy)
+			}
+		}
+		tw.Flush()
+	})
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Formats accepted by --output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputFormat string
+
+func validateOutputFormat() error {
+	switch outputFormat {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q. Use table, json or yaml", outputFormat)
+}
+
+// printResult writes v to stdout as JSON or YAML when --output asks for it,
+// with the same field names as the API. For table output it calls table to
+// print v for a human instead.
+func printResult(v interface{}, table func(w io.Writer)) error {
+	switch outputFormat {
+	case outputJSON:
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode output: %w", err)
+		}
+		fmt.Println(string(out))
+		return nil
+	case outputYAML:
+		out, err := toYAML(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode output: %w", err)
+		}
+		fmt.Print(string(out))
+		return nil
+	default:
+		table(os.Stdout)
+		return nil
+	}
+}
+
+// toYAML encodes v via its JSON form, so keys follow the json tags and keep
+// their order rather than being renamed and sorted by the YAML encoder.
+func toYAML(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	blockStyle(&node)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	enc.Close()
+	return buf.Bytes(), nil
+}
+
+// blockStyle drops the flow (JSON-like) style the parser recorded.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// newTable starts aligned columns under the given headers. Rows are written
+// tab-separated and the table is printed on Flush.
+func newTable(w io.Writer, headers ...string) *tabwriter.Writer {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	return tw
+}
@@ -2,7 +2,11 @@ package cli
 
 import (
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
 	"github.com/spf13/cobra"
 )
 
@@ -14,7 +18,7 @@ var peersCmd = &cobra.Command{
 
 var peersListCmd = &cobra.Command{
 	Use:   "list",
-	Short: "List connected peers",
+	Short: "List known peers",
 	RunE:  runPeersList,
 }
 
@@ -24,17 +28,37 @@ var peersDiscoverCmd = &cobra.Command{
 	RunE:  runPeersDiscover,
 }
 
+var peersInfoCmd = &cobra.Command{
+	Use:   "info <peer-id>",
+	Short: "Show details for a single peer",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runPeersInfo,
+}
+
 func init() {
 	rootCmd.AddCommand(peersCmd)
 	peersCmd.AddCommand(peersListCmd)
 	peersCmd.AddCommand(peersDiscoverCmd)
+	peersCmd.AddCommand(peersInfoCmd)
 }
 
 func runPeersList(cmd *cobra.Command, args []string) error {
-	fmt.Println("Connected Peers:")
-	fmt.Println("─────────────────────────")
-	fmt.Println("  (Agent must be running. Use 'p2p-agent start' first)")
-	return nil
+	var resp api.AgentsResponse
+	if err := apiGet("/v1/agents", &resp); err != nil {
+		return err
+	}
+
+	return printResult(resp, func(w io.Writer) {
+		if len(resp.Data) == 0 {
+			fmt.Fprintln(w, "No peers")
+			return
+		}
+		tw := newTable(w, "NAME", "PEER ID", "KIND", "STATUS", "MODELS")
+		for _, info := range resp.Data {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", peerName(info), info.PeerID, info.Kind, peerStatus(info), strings.Join(info.Models, ","))
+		}
+		tw.Flush()
+	})
 }
 
 func runPeersDiscover(cmd *cobra.Command, args []string) error {
@@ -42,3 +66,65 @@ func runPeersDiscover(cmd *cobra.Command, args []string) error {
 	fmt.Println("  (Agent must be running. Use 'p2p-agent start' first)")
 	return nil
 }
+
+func runPeersInfo(cmd *cobra.Command, args []string) error {
+	var info api.AgentInfo
+	if err := apiGet("/v1/agents/"+args[0], &info); err != nil {
+		return err
+	}
+
+	return printResult(info, func(w io.Writer) {
+		fmt.Fprintln(w, "Peer Details:")
+		fmt.Fprintln(w, "─────────────────────────")
+		fmt.Fprintf(w, "  Name:      %s\n", peerName(info))
+		fmt.Fprintf(w, "  Peer ID:   %s\n", info.PeerID)
+		fmt.Fprintf(w, "  Kind:      %s\n", info.Kind)
+		fmt.Fprintf(w, "  Status:    %s\n", peerStatus(info))
+		if info.Endpoint != "" {
+			fmt.Fprintf(w, "  Endpoint:  %s\n", info.Endpoint)
+		}
+		if len(info.Models) > 0 {
+			fmt.Fprintf(w, "  Models:    %s\n", strings.Join(info.Models, ", "))
+		}
+		if info.LastSeen > 0 {
+			ago := time.Since(time.Unix(info.LastSeen, 0)).Round(time.Second)
+			fmt.Fprintf(w, "  Last Seen: %s ago\n", ago)
+		}
+		if info.Flaps > 0 {
+			flaps := fmt.Sprintf("%d disconnects in 5m", info.Flaps)
+			if info.CooldownUntil > 0 {
+				wait := time.Until(time.Unix(info.CooldownUntil, 0)).Round(time.Second)
+				flaps += fmt.Sprintf(", not redialled for %s", wait)
+			}
+			fmt.Fprintf(w, "  Flaps:     %s\n", flaps)
+		}
+		if len(info.Addrs) > 0 {
+			fmt.Fprintln(w, "  Addresses:")
+			for _, addr := range info.Addrs {
+				fmt.Fprintf(w, "    %s\n", addr)
+			}
+		}
+	})
+}
+
+func peerName(info api.AgentInfo) string {
+	if info.Name == "" {
+		return "(not registered)"
+	}
+	return info.Name
+}
+
+func peerStatus(info api.AgentInfo) string {
+	if info.State != "" {
+		return info.State
+	}
+	// Agents that predate connection states only report a bool.
+	status := "disconnected"
+	if info.Connected {
+		status = "connected"
+		if info.ConnectionType != "" {
+			status += " (" + info.ConnectionType + ")"
+		}
+	}
+	return status
+}
@@ -12,6 +12,7 @@ import (
 var (
 	cfgFile    string
 	apiKey     string
+	adminKey   string
 	listenPort int
 	agentName  string
 )
@@ -24,6 +25,9 @@ with each other using OpenAI-compatible endpoints.
 
 Each agent exposes an OpenAI-compatible API and can discover
 and communicate with other agents on the network.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutputFormat()
+	},
 }
 
 func Execute() error {
@@ -33,27 +37,37 @@ func Execute() error {
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.p2p-agent.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is p2p-agent/config.yaml in the user config directory)")
 	rootCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "OpenAI API key for authentication")
+	rootCmd.PersistentFlags().StringVar(&adminKey, "admin-key", "", "Key for the /v1/admin endpoints (default: the API key)")
 	rootCmd.PersistentFlags().IntVar(&listenPort, "port", 8080, "HTTP API port")
 	rootCmd.PersistentFlags().StringVar(&agentName, "name", "", "Agent name for discovery")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputTable, "Output format: table, json or yaml")
 
 	viper.BindPFlag("api_key", rootCmd.PersistentFlags().Lookup("api-key"))
+	viper.BindPFlag("admin_key", rootCmd.PersistentFlags().Lookup("admin-key"))
 	viper.BindPFlag("port", rootCmd.PersistentFlags().Lookup("port"))
 	viper.BindPFlag("name", rootCmd.PersistentFlags().Lookup("name"))
 }
 
+// legacyConfigName is the config file name used before configs moved to the
+// user config directory. It is still read from the working directory.
+const legacyConfigName = ".p2p-agent.yaml"
+
 func initConfig() {
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
-		home, err := os.UserHomeDir()
-		cobra.CheckErr(err)
+		migrateLegacyConfig()
 
-		viper.AddConfigPath(home)
-		viper.AddConfigPath(".")
+		path := getConfigPath()
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if _, err := os.Stat(legacyConfigName); err == nil {
+				path = legacyConfigName
+			}
+		}
+		viper.SetConfigFile(path)
 		viper.SetConfigType("yaml")
-		viper.SetConfigName(".p2p-agent")
 	}
 
 	viper.SetEnvPrefix("P2P")
@@ -64,7 +78,72 @@ func initConfig() {
 	}
 }
 
+// getConfigPath returns the config file to read and write: --config if given,
+// otherwise config.yaml in the config directory.
 func getConfigPath() string {
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".p2p-agent.yaml")
+	if cfgFile != "" {
+		return cfgFile
+	}
+	return filepath.Join(configDir(), "config.yaml")
+}
+
+// configDir is $P2P_CONFIG_DIR, or p2p-agent in the user config directory:
+// $XDG_CONFIG_HOME or ~/.config on Linux, ~/Library/Application Support on
+// macOS and %AppData% on Windows.
+func configDir() string {
+	if dir := os.Getenv("P2P_CONFIG_DIR"); dir != "" {
+		return dir
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		home, err := os.UserHomeDir()
+		cobra.CheckErr(err)
+		base = filepath.Join(home, ".config")
+	}
+	return filepath.Join(base, "p2p-agent")
+}
+
+// migrateLegacyConfig moves ~/.p2p-agent.yaml into the config directory,
+// unless a config is already there.
+func migrateLegacyConfig() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	legacy := filepath.Join(home, legacyConfigName)
+	if _, err := os.Stat(legacy); err != nil {
+		return
+	}
+
+	path := getConfigPath()
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintf(os.Stderr, "Ignoring %s, %s is used instead\n", legacy, path)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to move %s to %s: %v\n", legacy, path, err)
+		return
+	}
+	if err := moveFile(legacy, path); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to move %s to %s: %v\n", legacy, path, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Moved config file %s to %s\n", legacy, path)
+}
+
+// moveFile renames src to dst, copying when they are on different file
+// systems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, data, 0o600); err != nil {
+		return err
+	}
+	return os.Remove(src)
 }
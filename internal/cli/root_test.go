@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// isolate points the config directory and home at fresh temporary
+// directories, so tests never read or move the user's own files.
+func isolate(t *testing.T) (configDir, home string) {
+	t.Helper()
+	configDir, home = t.TempDir(), t.TempDir()
+	t.Setenv("P2P_CONFIG_DIR", configDir)
+	t.Setenv("HOME", home)
+	return configDir, home
+}
+
+// writeConfig writes content to a config file in a fresh temporary directory
+// and returns its path.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// run executes the CLI with args and returns what it printed to stdout,
+// discarding stderr. Flags are put back to their defaults afterwards, since
+// cobra keeps them between runs.
+func run(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	t.Cleanup(func() { resetFlags(rootCmd) })
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, devNull
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, r)
+		close(copied)
+	}()
+
+	rootCmd.SetArgs(args)
+	err = rootCmd.Execute()
+	w.Close()
+	<-copied
+	return out.String(), err
+}
+
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if f.Changed {
+			if sv, ok := f.Value.(pflag.SliceValue); ok {
+				var def []string
+				if trimmed := strings.Trim(f.DefValue, "[]"); trimmed != "" {
+					def = strings.Split(trimmed, ",")
+				}
+				sv.Replace(def)
+			} else {
+				f.Value.Set(f.DefValue)
+			}
+			f.Changed = false
+		}
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+func TestConfigDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG paths are Linux only")
+	}
+	home := t.TempDir()
+	tests := []struct {
+		name, p2pDir, xdg, want string
+	}{
+		{name: "P2P_CONFIG_DIR", p2pDir: "/etc/p2p", xdg: "/xdg", want: "/etc/p2p"},
+		{name: "XDG_CONFIG_HOME", xdg: "/xdg", want: "/xdg/p2p-agent"},
+		{name: "default", want: filepath.Join(home, ".config", "p2p-agent")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", home)
+			t.Setenv("P2P_CONFIG_DIR", tt.p2pDir)
+			t.Setenv("XDG_CONFIG_HOME", tt.xdg)
+			if got := configDir(); got != tt.want {
+				t.Fatalf("configDir() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetConfigPath(t *testing.T) {
+	dir, _ := isolate(t)
+	if got, want := getConfigPath(), filepath.Join(dir, "config.yaml"); got != want {
+		t.Fatalf("getConfigPath() = %s, want %s", got, want)
+	}
+
+	cfgFile = "/srv/agent.yaml"
+	defer func() { cfgFile = "" }()
+	if got := getConfigPath(); got != cfgFile {
+		t.Fatalf("getConfigPath() = %s, want --config %s", got, cfgFile)
+	}
+}
+
+func TestMigrateLegacyConfig(t *testing.T) {
+	const legacyContent, currentContent = "name: legacy\n", "name: current\n"
+	tests := []struct {
+		name        string
+		legacy      bool
+		current     bool
+		wantContent string // of the config in the config directory, "" = none
+		wantLegacy  bool   // ~/.p2p-agent.yaml left in place
+	}{
+		{name: "nothing to migrate"},
+		{name: "moved", legacy: true, wantContent: legacyContent},
+		{name: "config directory wins", legacy: true, current: true, wantContent: currentContent, wantLegacy: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, home := isolate(t)
+			// The directory is created on migration, not assumed to exist.
+			dir = filepath.Join(dir, "nested")
+			t.Setenv("P2P_CONFIG_DIR", dir)
+			legacy, current := filepath.Join(home, legacyConfigName), filepath.Join(dir, "config.yaml")
+			if tt.legacy {
+				os.WriteFile(legacy, []byte(legacyContent), 0o600)
+			}
+			if tt.current {
+				os.MkdirAll(dir, 0o700)
+				os.WriteFile(current, []byte(currentContent), 0o600)
+			}
+
+			migrateLegacyConfig()
+
+			content, _ := os.ReadFile(current)
+			if string(content) != tt.wantContent {
+				t.Fatalf("config is %q, want %q", content, tt.wantContent)
+			}
+			if _, err := os.Stat(legacy); (err == nil) != tt.wantLegacy {
+				t.Fatalf("legacy config left in place: %v, want %v", err == nil, tt.wantLegacy)
+			}
+		})
+	}
+}
+
+// TestLegacyConfigInWorkingDirectory checks a .p2p-agent.yaml in the working
+// directory is still read when the config directory has none.
+func TestLegacyConfigInWorkingDirectory(t *testing.T) {
+	isolate(t)
+	wd, _ := os.Getwd()
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	os.WriteFile(legacyConfigName, []byte(validConfig), 0o600)
+
+	out, err := run(t, "config", "validate", "--json")
+	if err != nil || strings.TrimSpace(out) != "[]" {
+		t.Fatalf("got %v\n%s", err, out)
+	}
+}
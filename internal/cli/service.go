@@ -0,0 +1,272 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	serviceName  = "p2p-agent"
+	launchdLabel = "io.github.denizumutdereli.p2p-agent"
+)
+
+var (
+	servicePrint  bool
+	serviceEnable bool
+	serviceSystem bool
+	serviceForce  bool
+)
+
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service [-- start flags]",
+	Short: "Install the agent as a systemd or launchd service",
+	Long: `Generate a systemd unit (Linux) or launchd plist (macOS) that runs
+"p2p-agent start" with the current flags, and write it where the service
+manager looks for it. The global flags given to this command and the config
+file in use are baked in; flags after -- are passed to start as well.
+
+Example:
+  p2p-agent install-service --name alpha --enable -- --bootstrap /ip4/...
+  p2p-agent install-service --print
+
+User services are installed by default. Use --system for a system-wide
+service, which needs root.`,
+	RunE: runInstallService,
+}
+
+func init() {
+	rootCmd.AddCommand(installServiceCmd)
+
+	installServiceCmd.Flags().BoolVar(&servicePrint, "print", false, "Print the service file instead of writing it")
+	installServiceCmd.Flags().BoolVar(&serviceEnable, "enable", false, "Enable and start the service after writing it")
+	installServiceCmd.Flags().BoolVar(&serviceSystem, "system", false, "Install a system-wide service instead of a user service")
+	installServiceCmd.Flags().BoolVar(&serviceForce, "force", false, "Overwrite an existing service file")
+}
+
+// serviceUnit is what the service file templates are filled with.
+type serviceUnit struct {
+	Label  string
+	Args   []string // executable first
+	Target string
+	LogDir string
+}
+
+var systemdUnit = template.Must(template.New("systemd").Funcs(template.FuncMap{
+	"exec": systemdExec,
+}).Parse(`[Unit]
+Description=P2P agent network node
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart={{exec .Args}}
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy={{.Target}}
+`))
+
+var launchdPlist = template.Must(template.New("launchd").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{xml .LogDir}}/p2p-agent.log</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .LogDir}}/p2p-agent.log</string>
+</dict>
+</plist>
+`))
+
+func runInstallService(cmd *cobra.Command, args []string) error {
+	args, err := serviceArgs(args)
+	if err != nil {
+		return err
+	}
+
+	path, content, err := renderService(args)
+	if err != nil {
+		return err
+	}
+
+	if servicePrint {
+		fmt.Print(content)
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil && !serviceForce {
+		return fmt.Errorf("%s already exists. Use --force to overwrite it", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create service directory: %w", err)
+	}
+	// The file may hold API keys passed as flags.
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		return fmt.Errorf("failed to write service file: %w", err)
+	}
+	fmt.Printf("✅ Service written to %s\n", path)
+
+	commands := enableCommands(path)
+	if !serviceEnable {
+		fmt.Println("\nTo enable and start it, run:")
+		for _, c := range commands {
+			fmt.Printf("  %s\n", strings.Join(c, " "))
+		}
+		return nil
+	}
+
+	for _, c := range commands {
+		run := exec.Command(c[0], c[1:]...)
+		run.Stdout = os.Stdout
+		run.Stderr = os.Stderr
+		if err := run.Run(); err != nil {
+			return fmt.Errorf("%s failed: %w", strings.Join(c, " "), err)
+		}
+	}
+	fmt.Println("✅ Service enabled and started")
+	return nil
+}
+
+// serviceArgs is the command line the service runs: this executable's start
+// command with the global flags that were set, the config file in use and
+// the extra start flags.
+func serviceArgs(extra []string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the p2p-agent executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	args := []string{exe, "start"}
+	if cfgFile == "" {
+		if used := viper.ConfigFileUsed(); used != "" {
+			if _, err := os.Stat(used); err == nil {
+				abs, _ := filepath.Abs(used)
+				args = append(args, "--config", abs)
+			}
+		}
+	}
+	rootCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed || f.Name == "output" {
+			return
+		}
+		value := f.Value.String()
+		if f.Name == "config" {
+			value, _ = filepath.Abs(value)
+		}
+		args = append(args, "--"+f.Name, value)
+	})
+	return append(args, extra...), nil
+}
+
+// renderService returns where the service file goes on this OS and its
+// content.
+func renderService(args []string) (string, string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+
+	unit := serviceUnit{Label: launchdLabel, Args: args}
+	var path string
+	var tmpl *template.Template
+	switch runtime.GOOS {
+	case "linux":
+		tmpl = systemdUnit
+		if serviceSystem {
+			path = filepath.Join("/etc/systemd/system", serviceName+".service")
+			unit.Target = "multi-user.target"
+		} else {
+			base, err := os.UserConfigDir()
+			if err != nil {
+				base = filepath.Join(home, ".config")
+			}
+			path = filepath.Join(base, "systemd/user", serviceName+".service")
+			unit.Target = "default.target"
+		}
+	case "darwin":
+		tmpl = launchdPlist
+		if serviceSystem {
+			path = filepath.Join("/Library/LaunchDaemons", launchdLabel+".plist")
+			unit.LogDir = "/Library/Logs"
+		} else {
+			path = filepath.Join(home, "Library/LaunchAgents", launchdLabel+".plist")
+			unit.LogDir = filepath.Join(home, "Library/Logs")
+		}
+	default:
+		return "", "", fmt.Errorf("services can only be installed on Linux (systemd) and macOS (launchd), not %s", runtime.GOOS)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, unit); err != nil {
+		return "", "", fmt.Errorf("failed to render service file: %w", err)
+	}
+	return path, buf.String(), nil
+}
+
+// enableCommands are the commands that enable and start the service at path.
+func enableCommands(path string) [][]string {
+	if runtime.GOOS == "darwin" {
+		return [][]string{{"launchctl", "load", "-w", path}}
+	}
+	systemctl := []string{"systemctl"}
+	if !serviceSystem {
+		systemctl = append(systemctl, "--user")
+	}
+	return [][]string{
+		append(append([]string{}, systemctl...), "daemon-reload"),
+		append(append([]string{}, systemctl...), "enable", "--now", serviceName),
+	}
+}
+
+// systemdExec quotes args for ExecStart: arguments with spaces or quotes are
+// double-quoted, and % and $ are escaped from specifier and variable
+// expansion.
+func systemdExec(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/denizumutdereli/agents-p2p-network/internal/agent"
 	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -16,6 +20,63 @@ import (
 var (
 	p2pPort       int
 	bootstrapPeer string
+	pinnedPeers   []string
+	blockedPeers  []string
+	security      string
+	dhtMode       string
+	logPeerIDs    string
+	noDHT         bool
+	role          string
+	peerExchange  bool
+	webhooks      []string
+	webhookSecret string
+	strictKeys    bool
+	tagVocabulary []string
+	strictTags    bool
+
+	subscribeTypes []string
+	subscribeTags  []string
+
+	advertiseEndpoint string
+	providersFile     string
+
+	maxStreamsPerPeer int
+	maxMessageSize    int64
+	broadcastFanout   int
+	topologyDepth     int
+	handlerTimeout    time.Duration
+	replayWindow      time.Duration
+	persistentStreams bool
+	natPortMap        bool
+
+	upstreamHeaderTimeout time.Duration
+	upstreamTimeout       time.Duration
+	upstreamStreamTimeout time.Duration
+	upstreamProxy         string
+	upstreamNoProxy       []string
+	passthroughHeaders    []string
+
+	idempotencyTTL time.Duration
+	maxBodySize    int64
+
+	httpReadHeaderTimeout time.Duration
+	httpWriteTimeout      time.Duration
+	httpIdleTimeout       time.Duration
+	http2                 bool
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	maxConcurrentRequests  int
+	maxConcurrentPerClient int
+
+	mockUpstream bool
+
+	bootstrapNode bool
+	relayService  bool
+
+	validateOnly bool
+	validateJSON bool
 )
 
 var startCmd = &cobra.Command{
@@ -30,27 +91,151 @@ func init() {
 
 	startCmd.Flags().IntVar(&p2pPort, "p2p-port", 9000, "P2P network port")
 	startCmd.Flags().StringVar(&bootstrapPeer, "bootstrap", "", "Bootstrap peer multiaddr")
+	startCmd.Flags().StringSliceVar(&pinnedPeers, "pin-peer", []string{}, "Peer ID or multiaddr to keep connected and prefer for routing (repeatable)")
+	startCmd.Flags().StringSliceVar(&blockedPeers, "block-peer", []string{}, "Peer ID never to connect to, whoever names it (repeatable)")
+	startCmd.Flags().StringVar(&advertiseEndpoint, "advertise-endpoint", "", "HTTP API URL advertised to peers (default http://localhost:<port>)")
+	startCmd.Flags().StringVar(&providersFile, "providers-file", "", "Providers and model catalog file (default providers.yaml in the config directory, if present)")
+	startCmd.Flags().StringVar(&security, "security", p2p.SecurityBoth, "Security transport for peer connections: noise, tls or both")
+	startCmd.Flags().StringVar(&dhtMode, "dht-mode", p2p.DHTModeAuto, "DHT mode: client (query only, for NATed or lightweight nodes), server or auto")
+	startCmd.Flags().StringVar(&role, "role", config.RoleBoth, "What this agent does on the network: client (only sends requests to agents), server (only serves them) or both")
+	startCmd.Flags().StringVar(&logPeerIDs, "log-peer-ids", p2p.LogPeerIDsShort, "How peer IDs appear in logs next to agent names: short or full")
+	startCmd.Flags().BoolVar(&noDHT, "no-dht", false, "Don't join the DHT; find peers via mDNS and --bootstrap only (private networks)")
+	startCmd.Flags().BoolVar(&peerExchange, "peer-exchange", true, "Swap lists of connected agents with peers and dial the ones learned")
+	startCmd.Flags().StringSliceVar(&webhooks, "webhook", []string{}, "Webhook URL to notify of network events (repeatable)")
+	startCmd.Flags().StringVar(&webhookSecret, "webhook-secret", "", "Secret used to HMAC-sign webhook payloads")
+	startCmd.Flags().BoolVar(&strictKeys, "strict-keys", false, "Refuse to start when the API, admin and provider keys are not all different")
+	startCmd.Flags().StringSliceVar(&tagVocabulary, "announce-tag", []string{}, "Tag allowed on announcements; unlisted tags get a warning (repeatable, default any tag)")
+	startCmd.Flags().BoolVar(&strictTags, "strict-tags", false, "Refuse announcements with tags outside --announce-tag")
+	startCmd.Flags().StringSliceVar(&subscribeTypes, "subscribe-type", []string{}, "Only keep peer announcements of this type (repeatable, default any type)")
+	startCmd.Flags().StringSliceVar(&subscribeTags, "subscribe-tag", []string{}, "Only keep peer announcements with this tag (repeatable, default any tag)")
+
+	startCmd.Flags().IntVar(&maxStreamsPerPeer, "max-streams-per-peer", p2p.DefaultMaxStreamsPerPeer, "Maximum concurrent inbound streams handled per peer (0 = unlimited)")
+	startCmd.Flags().Int64Var(&maxMessageSize, "max-message-size", p2p.DefaultMaxMessageSize, "Maximum size in bytes of a P2P message sent or received")
+	startCmd.Flags().IntVar(&broadcastFanout, "broadcast-fanout", 0, "Send each broadcast to at most this many random peers and let them relay announcements onward (0 = all peers)")
+	startCmd.Flags().IntVar(&topologyDepth, "topology-depth", 0, fmt.Sprintf("Gossip neighbor lists so /v1/debug/topology shows agents up to this many hops beyond direct peers (0 = off, max %d)", config.MaxTopologyDepth))
+	startCmd.Flags().DurationVar(&handlerTimeout, "handler-timeout", p2p.DefaultHandlerTimeout, "Maximum time to answer a single non-streaming message from a peer (0 = no limit)")
+	startCmd.Flags().BoolVar(&persistentStreams, "persistent-streams", false, "Reuse one long-lived stream per peer for requests, falling back to a stream per message for older peers")
+	startCmd.Flags().BoolVar(&natPortMap, "nat-port-map", true, "Try to open the P2P port on the router via UPnP / NAT-PMP (disable on hosts with a public IP)")
+	startCmd.Flags().DurationVar(&replayWindow, "replay-window", p2p.DefaultReplayWindow, "Maximum age of an inbound P2P message before it is rejected as a replay (0 = disabled)")
+	startCmd.Flags().DurationVar(&upstreamHeaderTimeout, "upstream-header-timeout", 10*time.Second, "Timeout for connecting to the provider and receiving response headers")
+	startCmd.Flags().DurationVar(&upstreamTimeout, "upstream-timeout", 30*time.Second, "Overall timeout for non-streaming provider requests")
+	startCmd.Flags().DurationVar(&upstreamStreamTimeout, "upstream-stream-timeout", 10*time.Minute, "Overall timeout for streaming provider requests (0 = no limit)")
+	startCmd.Flags().StringVar(&upstreamProxy, "upstream-proxy", "", "HTTP(S) proxy for provider calls (default: HTTPS_PROXY from the environment)")
+	startCmd.Flags().StringSliceVar(&upstreamNoProxy, "upstream-no-proxy", []string{}, "Hosts that bypass --upstream-proxy, in NO_PROXY syntax (repeatable)")
+	startCmd.Flags().StringSliceVar(&passthroughHeaders, "passthrough-header", config.DefaultPassthroughHeaders, "Provider response header to copy onto API responses (repeatable)")
+	startCmd.Flags().DurationVar(&idempotencyTTL, "idempotency-ttl", 10*time.Minute, "How long Idempotency-Key results are replayed (0 = disabled)")
+	startCmd.Flags().Int64Var(&maxBodySize, "max-body-size", 4<<20, "Maximum HTTP request body size in bytes")
+	startCmd.Flags().DurationVar(&httpReadHeaderTimeout, "http-read-header-timeout", 10*time.Second, "Time allowed to read a request's headers (0 = no limit)")
+	startCmd.Flags().DurationVar(&httpWriteTimeout, "http-write-timeout", 2*time.Minute, "Time allowed to write a non-streaming response (0 = no limit)")
+	startCmd.Flags().DurationVar(&httpIdleTimeout, "http-idle-timeout", 2*time.Minute, "How long idle keep-alive connections stay open (0 = no limit)")
+	startCmd.Flags().BoolVar(&http2, "http2", true, "Serve cleartext HTTP/2 (h2c) alongside HTTP/1.1")
+	startCmd.Flags().IntVar(&breakerThreshold, "breaker-threshold", 5, "Consecutive provider failures before its circuit opens")
+	startCmd.Flags().DurationVar(&breakerCooldown, "breaker-cooldown", 30*time.Second, "How long an open provider circuit waits before a trial request")
+	startCmd.Flags().IntVar(&maxConcurrentRequests, "max-concurrent-requests", 0, "Chat completions served at once; more wait in a priority queue (0 = unlimited)")
+	startCmd.Flags().IntVar(&maxConcurrentPerClient, "max-concurrent-per-client", 0, "Chat completions one client may have open at once; more get 429 (0 = unlimited)")
+	startCmd.Flags().BoolVar(&bootstrapNode, "bootstrap-node", false, "Run as a seed node: DHT and discovery only, no HTTP API or provider key")
+	startCmd.Flags().BoolVar(&relayService, "relay-service", false, "Relay connections for peers behind NAT (useful with --bootstrap-node)")
+	startCmd.Flags().BoolVar(&mockUpstream, "mock-upstream", false, "Serve deterministic fake completions instead of calling a provider (demo/CI only, accepts mock- API keys)")
+
+	startCmd.Flags().BoolVar(&validateOnly, "validate-only", false, "Validate the configuration and exit without starting")
+	startCmd.Flags().BoolVar(&validateJSON, "json", false, "Print validation errors as JSON")
 
 	viper.BindPFlag("p2p_port", startCmd.Flags().Lookup("p2p-port"))
 	viper.BindPFlag("bootstrap", startCmd.Flags().Lookup("bootstrap"))
+	viper.BindPFlag("pinned_peers", startCmd.Flags().Lookup("pin-peer"))
+	viper.BindPFlag("blocked_peers", startCmd.Flags().Lookup("block-peer"))
+	viper.BindPFlag("advertise_endpoint", startCmd.Flags().Lookup("advertise-endpoint"))
+	viper.BindPFlag("providers_file", startCmd.Flags().Lookup("providers-file"))
+	viper.BindPFlag("security", startCmd.Flags().Lookup("security"))
+	viper.BindPFlag("dht_mode", startCmd.Flags().Lookup("dht-mode"))
+	viper.BindPFlag("role", startCmd.Flags().Lookup("role"))
+	viper.BindPFlag("log_peer_ids", startCmd.Flags().Lookup("log-peer-ids"))
+	viper.BindPFlag("no_dht", startCmd.Flags().Lookup("no-dht"))
+	viper.BindPFlag("peer_exchange", startCmd.Flags().Lookup("peer-exchange"))
+	viper.BindPFlag("webhooks", startCmd.Flags().Lookup("webhook"))
+	viper.BindPFlag("webhook_secret", startCmd.Flags().Lookup("webhook-secret"))
+	viper.BindPFlag("strict_keys", startCmd.Flags().Lookup("strict-keys"))
+	viper.BindPFlag("announce_tags", startCmd.Flags().Lookup("announce-tag"))
+	viper.BindPFlag("strict_tags", startCmd.Flags().Lookup("strict-tags"))
+	viper.BindPFlag("subscribe_types", startCmd.Flags().Lookup("subscribe-type"))
+	viper.BindPFlag("subscribe_tags", startCmd.Flags().Lookup("subscribe-tag"))
+	viper.BindPFlag("max_streams_per_peer", startCmd.Flags().Lookup("max-streams-per-peer"))
+	viper.BindPFlag("max_message_size", startCmd.Flags().Lookup("max-message-size"))
+	viper.BindPFlag("broadcast_fanout", startCmd.Flags().Lookup("broadcast-fanout"))
+	viper.BindPFlag("topology_depth", startCmd.Flags().Lookup("topology-depth"))
+	viper.BindPFlag("handler_timeout", startCmd.Flags().Lookup("handler-timeout"))
+	viper.BindPFlag("persistent_streams", startCmd.Flags().Lookup("persistent-streams"))
+	viper.BindPFlag("nat_port_map", startCmd.Flags().Lookup("nat-port-map"))
+	viper.BindPFlag("replay_window", startCmd.Flags().Lookup("replay-window"))
+	viper.BindPFlag("upstream_header_timeout", startCmd.Flags().Lookup("upstream-header-timeout"))
+	viper.BindPFlag("upstream_timeout", startCmd.Flags().Lookup("upstream-timeout"))
+	viper.BindPFlag("upstream_stream_timeout", startCmd.Flags().Lookup("upstream-stream-timeout"))
+	viper.BindPFlag("upstream_proxy", startCmd.Flags().Lookup("upstream-proxy"))
+	viper.BindPFlag("upstream_no_proxy", startCmd.Flags().Lookup("upstream-no-proxy"))
+	viper.BindPFlag("passthrough_headers", startCmd.Flags().Lookup("passthrough-header"))
+	viper.BindPFlag("idempotency_ttl", startCmd.Flags().Lookup("idempotency-ttl"))
+	viper.BindPFlag("max_body_size", startCmd.Flags().Lookup("max-body-size"))
+	viper.BindPFlag("http_read_header_timeout", startCmd.Flags().Lookup("http-read-header-timeout"))
+	viper.BindPFlag("http_write_timeout", startCmd.Flags().Lookup("http-write-timeout"))
+	viper.BindPFlag("http_idle_timeout", startCmd.Flags().Lookup("http-idle-timeout"))
+	viper.BindPFlag("http2", startCmd.Flags().Lookup("http2"))
+	viper.BindPFlag("breaker_threshold", startCmd.Flags().Lookup("breaker-threshold"))
+	viper.BindPFlag("breaker_cooldown", startCmd.Flags().Lookup("breaker-cooldown"))
+	viper.BindPFlag("max_concurrent_requests", startCmd.Flags().Lookup("max-concurrent-requests"))
+	viper.BindPFlag("max_concurrent_per_client", startCmd.Flags().Lookup("max-concurrent-per-client"))
+	viper.BindPFlag("bootstrap_node", startCmd.Flags().Lookup("bootstrap-node"))
+	viper.BindPFlag("relay_service", startCmd.Flags().Lookup("relay-service"))
+	viper.BindPFlag("mock_upstream", startCmd.Flags().Lookup("mock-upstream"))
+
+	addRetryFlags()
+}
+
+// retryTargets says what each retry policy in config.RetryConfig retries,
+// for its flags' usage.
+var retryTargets = map[string]string{
+	"upstream":     "provider calls",
+	"send":         "opening streams to peers",
+	"session":      "requests on a closed session",
+	"reconnect":    "bootstrap and pinned peer dials",
+	"registration": "registration re-sends",
+	"webhook":      "webhook deliveries",
+}
+
+// addRetryFlags adds --<name>-retry-attempts, -base-delay, -max-delay and
+// -jitter for each retry policy, bound to <name>_retry_attempts and so on.
+// The flap policy is a cooldown rather than a retry and has no attempts.
+func addRetryFlags() {
+	defaults := config.DefaultRetry
+	for _, p := range defaults.Policies() {
+		prefix := p.Name + "-retry-"
+		if p.Name == "flap" {
+			startCmd.Flags().Duration(prefix+"base-delay", p.Policy.BaseDelay, "Redial cooldown of a peer that starts flapping, doubling with each further disconnect")
+			startCmd.Flags().Duration(prefix+"max-delay", p.Policy.MaxDelay, "Longest redial cooldown of a flapping peer (0 = no cap)")
+			startCmd.Flags().Float64(prefix+"jitter", p.Policy.Jitter, "Fraction of a flapping peer's cooldown that is randomised, 0 to 1")
+		} else {
+			what := retryTargets[p.Name]
+			startCmd.Flags().Int(prefix+"attempts", p.Policy.MaxAttempts, fmt.Sprintf("Attempts at %s, the first included (0 = no limit)", what))
+			startCmd.Flags().Duration(prefix+"base-delay", p.Policy.BaseDelay, fmt.Sprintf("Wait before retrying %s, doubling after each failure", what))
+			startCmd.Flags().Duration(prefix+"max-delay", p.Policy.MaxDelay, fmt.Sprintf("Longest wait before retrying %s (0 = no cap)", what))
+			startCmd.Flags().Float64(prefix+"jitter", p.Policy.Jitter, fmt.Sprintf("Fraction of each wait before retrying %s that is randomised, 0 to 1", what))
+			viper.BindPFlag(p.Name+"_retry_attempts", startCmd.Flags().Lookup(prefix+"attempts"))
+		}
+		viper.BindPFlag(p.Name+"_retry_base_delay", startCmd.Flags().Lookup(prefix+"base-delay"))
+		viper.BindPFlag(p.Name+"_retry_max_delay", startCmd.Flags().Lookup(prefix+"max-delay"))
+		viper.BindPFlag(p.Name+"_retry_jitter", startCmd.Flags().Lookup(prefix+"jitter"))
+	}
 }
 
 func runStart(cmd *cobra.Command, args []string) error {
-	cfg := &config.Config{
-		APIKey:        viper.GetString("api_key"),
-		HTTPPort:      viper.GetInt("port"),
-		P2PPort:       viper.GetInt("p2p_port"),
-		AgentName:     viper.GetString("name"),
-		BootstrapPeer: viper.GetString("bootstrap"),
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
 	}
 
 	// Validate configuration
-	if errs := cfg.Validate(); errs.HasErrors() {
-		fmt.Println("❌ Configuration errors:")
-		for _, e := range errs {
-			fmt.Printf("   • %s: %s\n", e.Field, e.Message)
-		}
-		return fmt.Errorf("invalid configuration")
+	errs, warnings := cfg.Validate()
+	if err := reportValidation(cmd, errs, warnings, validateJSON || (validateOnly && outputFormat != outputTable), validateOnly); err != nil || validateOnly {
+		return err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -68,14 +253,158 @@ func runStart(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to start agent: %w", err)
 	}
 
-	fmt.Printf("🚀 Agent '%s' started\n", cfg.AgentName)
-	fmt.Printf("   HTTP API: http://localhost:%d\n", cfg.HTTPPort)
-	fmt.Printf("   P2P Port: %d\n", cfg.P2PPort)
-	fmt.Printf("   Peer ID:  %s\n", ag.PeerID())
+	if cfg.BootstrapNode {
+		fmt.Println("🌱 Bootstrap node started")
+		fmt.Printf("   P2P Port: %d\n", cfg.P2PPort)
+		fmt.Printf("   Peer ID:  %s\n", ag.PeerID())
+		if cfg.RelayService {
+			fmt.Println("   Relay:    enabled")
+		}
+		fmt.Println("   Agents can join with one of:")
+		for _, addr := range ag.MultiAddrs() {
+			fmt.Printf("     --bootstrap %s\n", addr)
+		}
+	} else {
+		fmt.Printf("🚀 Agent '%s' started\n", cfg.AgentName)
+		fmt.Printf("   HTTP API: http://localhost:%d\n", cfg.HTTPPort)
+		fmt.Printf("   P2P Port: %d\n", cfg.P2PPort)
+		fmt.Printf("   Peer ID:  %s\n", ag.PeerID())
+	}
+	if cfg.MockUpstream {
+		fmt.Println("   ⚠️  Mock upstream enabled: responses are fake, not for production")
+	}
 
 	<-sigCh
 	fmt.Println("\n⏹️  Shutting down...")
-	ag.Stop()
+	report := ag.Stop()
+
+	fmt.Printf("   Uptime:    %s\n", report.Uptime.Round(time.Second))
+	if !cfg.BootstrapNode {
+		fmt.Printf("   Requests:  %d served, %d for peers, %d failed\n", report.Requests, report.PeerRequests, report.FailedRequests)
+		fmt.Printf("   Tokens:    %d\n", report.TotalTokens)
+	}
+	fmt.Printf("   Peers:     %d connected\n", report.PeersConnected)
+	if report.Dropped > 0 {
+		fmt.Printf("   ⚠️  %d in-flight requests dropped\n", report.Dropped)
+	}
 
 	return nil
 }
+
+// providersFilePath is the providers file to load: providers_file if set,
+// otherwise providers.yaml in the config directory if it exists.
+func providersFilePath() string {
+	if path := viper.GetString("providers_file"); path != "" {
+		return path
+	}
+	path := filepath.Join(configDir(), "providers.yaml")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// loadConfig builds the agent config from flags, env vars and the config
+// file. It does not validate it.
+func loadConfig() (*config.Config, error) {
+	cfg := &config.Config{
+		APIKey:        viper.GetString("api_key"),
+		AdminKey:      viper.GetString("admin_key"),
+		StrictKeys:    viper.GetBool("strict_keys"),
+		HTTPPort:      viper.GetInt("port"),
+		P2PPort:       viper.GetInt("p2p_port"),
+		AgentName:     viper.GetString("name"),
+		Role:          viper.GetString("role"),
+		BootstrapPeer: viper.GetString("bootstrap"),
+		PinnedPeers:   viper.GetStringSlice("pinned_peers"),
+		BlockedPeers:  viper.GetStringSlice("blocked_peers"),
+		Security:      viper.GetString("security"),
+		DHTMode:       viper.GetString("dht_mode"),
+		LogPeerIDs:    viper.GetString("log_peer_ids"),
+		NoDHT:         viper.GetBool("no_dht"),
+		PeerExchange:  viper.GetBool("peer_exchange"),
+		Webhooks:      viper.GetStringSlice("webhooks"),
+		WebhookSecret: viper.GetString("webhook_secret"),
+		AnnounceTags:  viper.GetStringSlice("announce_tags"),
+		StrictTags:    viper.GetBool("strict_tags"),
+
+		SubscribeTypes: viper.GetStringSlice("subscribe_types"),
+		SubscribeTags:  viper.GetStringSlice("subscribe_tags"),
+
+		AdvertiseEndpoint: viper.GetString("advertise_endpoint"),
+
+		MaxStreamsPerPeer: viper.GetInt("max_streams_per_peer"),
+		MaxMessageSize:    viper.GetInt64("max_message_size"),
+		BroadcastFanout:   viper.GetInt("broadcast_fanout"),
+		TopologyDepth:     viper.GetInt("topology_depth"),
+		HandlerTimeout:    viper.GetDuration("handler_timeout"),
+		ReplayWindow:      viper.GetDuration("replay_window"),
+		PersistentStreams: viper.GetBool("persistent_streams"),
+		NATPortMap:        viper.GetBool("nat_port_map"),
+
+		UpstreamHeaderTimeout: viper.GetDuration("upstream_header_timeout"),
+		UpstreamTimeout:       viper.GetDuration("upstream_timeout"),
+		UpstreamStreamTimeout: viper.GetDuration("upstream_stream_timeout"),
+		UpstreamProxy:         viper.GetString("upstream_proxy"),
+		UpstreamNoProxy:       viper.GetStringSlice("upstream_no_proxy"),
+		PassthroughHeaders:    viper.GetStringSlice("passthrough_headers"),
+
+		IdempotencyTTL: viper.GetDuration("idempotency_ttl"),
+		MaxBodySize:    viper.GetInt64("max_body_size"),
+
+		HTTPReadHeaderTimeout: viper.GetDuration("http_read_header_timeout"),
+		HTTPWriteTimeout:      viper.GetDuration("http_write_timeout"),
+		HTTPIdleTimeout:       viper.GetDuration("http_idle_timeout"),
+		HTTP2:                 viper.GetBool("http2"),
+
+		BreakerThreshold: viper.GetInt("breaker_threshold"),
+		BreakerCooldown:  viper.GetDuration("breaker_cooldown"),
+
+		MaxConcurrentRequests:  viper.GetInt("max_concurrent_requests"),
+		MaxConcurrentPerClient: viper.GetInt("max_concurrent_per_client"),
+
+		BootstrapNode: viper.GetBool("bootstrap_node"),
+		RelayService:  viper.GetBool("relay_service"),
+
+		MockUpstream: viper.GetBool("mock_upstream"),
+	}
+
+	if err := viper.UnmarshalKey("pricing", &cfg.Pricing); err != nil {
+		return nil, fmt.Errorf("invalid pricing config: %w", err)
+	}
+
+	if err := viper.UnmarshalKey("providers", &cfg.Providers); err != nil {
+		return nil, fmt.Errorf("invalid providers config: %w", err)
+	}
+	if err := viper.UnmarshalKey("fallbacks", &cfg.Fallbacks); err != nil {
+		return nil, fmt.Errorf("invalid fallbacks config: %w", err)
+	}
+	if err := config.LoadKeyFiles(cfg.Providers); err != nil {
+		return nil, err
+	}
+	if cfg.ProvidersFile = providersFilePath(); cfg.ProvidersFile != "" {
+		file, err := config.LoadProvidersFile(cfg.ProvidersFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Providers = append(cfg.Providers, file.Providers...)
+		cfg.Models = file.Models
+	}
+	if err := viper.UnmarshalKey("clients", &cfg.Clients); err != nil {
+		return nil, fmt.Errorf("invalid clients config: %w", err)
+	}
+	for _, p := range cfg.Retry.Policies() {
+		*p.Policy = retry.Policy{
+			MaxAttempts: viper.GetInt(p.Name + "_retry_attempts"),
+			BaseDelay:   viper.GetDuration(p.Name + "_retry_base_delay"),
+			MaxDelay:    viper.GetDuration(p.Name + "_retry_max_delay"),
+			Jitter:      viper.GetFloat64(p.Name + "_retry_jitter"),
+		}
+	}
+	cfg.AnnounceLimits = config.DefaultAnnounceLimits
+	if err := viper.UnmarshalKey("announce_limits", &cfg.AnnounceLimits); err != nil {
+		return nil, fmt.Errorf("invalid announce_limits config: %w", err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/config"
+)
+
+const testProvidersFile = `
+providers:
+  - name: local
+    type: ollama
+    base_url: http://localhost:11434
+models:
+  - name: llama3
+    provider: local
+`
+
+// readConfig reads the config file at path as 'start' would.
+func readConfig(t *testing.T, path string) {
+	t.Helper()
+	cfgFile = path
+	t.Cleanup(func() { cfgFile = "" })
+	initConfig()
+}
+
+func TestProvidersFilePath(t *testing.T) {
+	explicit := writeConfig(t, "providers.yaml", testProvidersFile)
+	tests := []struct {
+		name      string
+		config    string
+		inDir     bool // a providers.yaml in the config directory
+		want      string
+		wantInDir bool
+	}{
+		{name: "none"},
+		{name: "in the config directory", inDir: true, wantInDir: true},
+		{name: "providers_file wins", config: "providers_file: " + explicit, inDir: true, want: explicit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, _ := isolate(t)
+			if tt.inDir {
+				os.WriteFile(filepath.Join(dir, "providers.yaml"), []byte(testProvidersFile), 0o600)
+			}
+			readConfig(t, writeConfig(t, "config.yaml", tt.config))
+
+			want := tt.want
+			if tt.wantInDir {
+				want = filepath.Join(dir, "providers.yaml")
+			}
+			if got := providersFilePath(); got != want {
+				t.Fatalf("providersFilePath() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestLoadConfigProvidersFile checks loadConfig adds the providers file's
+// providers to those in the config file and takes its model catalog.
+func TestLoadConfigProvidersFile(t *testing.T) {
+	isolate(t)
+	providers := writeConfig(t, "providers.yaml", testProvidersFile)
+	readConfig(t, writeConfig(t, "config.yaml", `
+providers_file: `+providers+`
+providers:
+  - name: openai
+    base_url: https://api.example.com/v1
+fallbacks:
+  - model: gpt-4
+    providers: [local]
+clients:
+  - name: ui
+    key: ui-key-0123456789
+    priority: high
+`))
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	var names []string
+	for _, p := range cfg.Providers {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, ",") != "openai,local" {
+		t.Fatalf("providers %v, want the config's then the file's", names)
+	}
+	if cfg.ProvidersFile != providers || len(cfg.Models) != 1 || cfg.Models[0].Name != "llama3" {
+		t.Fatalf("providers file %q, models %+v", cfg.ProvidersFile, cfg.Models)
+	}
+	if len(cfg.Fallbacks) != 1 || len(cfg.Clients) != 1 || cfg.Clients[0].Priority != "high" {
+		t.Fatalf("fallbacks %+v, clients %+v", cfg.Fallbacks, cfg.Clients)
+	}
+}
+
+func TestLoadConfigProvidersFileErrors(t *testing.T) {
+	tests := []struct {
+		name, providers, wantErr string
+	}{
+		{name: "missing", wantErr: "no such file"},
+		{name: "unknown key", providers: "providers:\n  - name: local\n    base_uri: http://localhost\n", wantErr: "base_uri"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			path := filepath.Join(t.TempDir(), "providers.yaml")
+			if tt.providers != "" {
+				os.WriteFile(path, []byte(tt.providers), 0o600)
+			}
+			readConfig(t, writeConfig(t, "config.yaml", "providers_file: "+path+"\n"))
+
+			if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestLoadConfigRetry checks each retry policy is read from the config file,
+// env vars and flag defaults.
+func TestLoadConfigRetry(t *testing.T) {
+	isolate(t)
+	t.Setenv("P2P_SEND_RETRY_JITTER", "0.5")
+	readConfig(t, writeConfig(t, "config.yaml", `
+upstream_retry_attempts: 5
+upstream_retry_base_delay: 2s
+flap_retry_max_delay: 1h
+`))
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	want := config.DefaultRetry
+	want.Upstream.MaxAttempts = 5
+	want.Upstream.BaseDelay = 2 * time.Second
+	want.Send.Jitter = 0.5
+	want.Flap.MaxDelay = time.Hour
+	if cfg.Retry != want {
+		t.Fatalf("retry %+v, want %+v", cfg.Retry, want)
+	}
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show which key and agent the CLI talks to",
+	Long: `Show the API key the CLI authenticates with, its client ID as reported
+in /v1/usage, and the agent it sends requests to.`,
+	RunE: runWhoami,
+}
+
+func init() {
+	rootCmd.AddCommand(whoamiCmd)
+}
+
+type whoami struct {
+	APIKey   string `json:"api_key"`
+	ClientID string `json:"client_id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	AgentURL string `json:"agent_url"`
+}
+
+func runWhoami(cmd *cobra.Command, args []string) error {
+	port := viper.GetInt("port")
+	if port == 0 {
+		port = 8080
+	}
+
+	key := viper.GetString("api_key")
+	me := whoami{
+		APIKey:   maskKey(key),
+		Name:     viper.GetString("name"),
+		AgentURL: fmt.Sprintf("http://localhost:%d", port),
+	}
+	if key != "" {
+		me.ClientID = api.ClientIdentity(key)
+	}
+
+	return printResult(me, func(w io.Writer) {
+		fmt.Fprintf(w, "  API Key:    %s\n", me.APIKey)
+		if me.ClientID != "" {
+			fmt.Fprintf(w, "  Client ID:  %s\n", me.ClientID)
+		}
+		if me.Name != "" {
+			fmt.Fprintf(w, "  Agent Name: %s\n", me.Name)
+		}
+		fmt.Fprintf(w, "  Agent URL:  %s\n", me.AgentURL)
+	})
+}
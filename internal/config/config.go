@@ -1,9 +1,366 @@
 package config
 
+import (
+	"strings"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/events"
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+)
+
 type Config struct {
 	APIKey        string
+	AdminKey      string // guards /v1/admin, empty = APIKey
+	StrictKeys    bool   // key overlaps are errors rather than warnings
 	HTTPPort      int
 	P2PPort       int
 	AgentName     string
 	BootstrapPeer string
+	PinnedPeers   []string // peer IDs or multiaddrs
+	BlockedPeers  []string // peer IDs never to connect to
+	Security      string   // noise, tls or both
+	Role          string   // client, server or both
+	DHTMode       string   // client, server or auto
+	LogPeerIDs    string   // short or full peer IDs in log lines
+	NoDHT         bool     // mDNS and bootstrap peers only
+	PeerExchange  bool     // swap connected agent lists with peers
+	Webhooks      []string
+	WebhookSecret string
+
+	// AnnounceTags is the tag vocabulary for announcements, empty = any tag.
+	// With StrictTags, announcements using other tags are refused rather
+	// than sent with a warning.
+	AnnounceTags   []string
+	StrictTags     bool
+	AnnounceLimits AnnounceLimits
+
+	// SubscribeTypes and SubscribeTags filter announcements received from
+	// peers; empty = keep all. They can be changed at runtime over the API.
+	SubscribeTypes []string
+	SubscribeTags  []string
+
+	// AdvertiseEndpoint is the HTTP API URL sent to peers. Empty advertises
+	// http://localhost:<port>, which only works for agents on the same host.
+	AdvertiseEndpoint string
+
+	MaxStreamsPerPeer int
+	MaxMessageSize    int64         // P2P message and session frame limit in bytes
+	BroadcastFanout   int           // peers sampled per broadcast, 0 = all
+	TopologyDepth     int           // hops away neighbor lists are gossiped from, 0 = none
+	HandlerTimeout    time.Duration // limit for answering one inbound peer message, 0 = none
+	ReplayWindow      time.Duration // 0 disables replay protection
+	PersistentStreams bool          // one long-lived stream per peer instead of one per message
+	NATPortMap        bool          // UPnP / NAT-PMP port mapping
+
+	UpstreamHeaderTimeout time.Duration // connect + time to first response byte
+	UpstreamTimeout       time.Duration // overall deadline for non-streaming calls
+	UpstreamStreamTimeout time.Duration // overall deadline for streaming calls, 0 = none
+
+	UpstreamProxy   string   // outbound HTTP(S) proxy for provider calls
+	UpstreamNoProxy []string // hosts that bypass UpstreamProxy, like NO_PROXY
+
+	// PassthroughHeaders are copied from the provider's response onto ours.
+	PassthroughHeaders []string
+
+	IdempotencyTTL time.Duration
+	MaxBodySize    int64 // HTTP request body limit in bytes
+
+	HTTPReadHeaderTimeout time.Duration // 0 = no limit
+	HTTPWriteTimeout      time.Duration // non-streaming responses only, 0 = no limit
+	HTTPIdleTimeout       time.Duration // keep-alive connections, 0 = no limit
+	HTTP2                 bool          // cleartext HTTP/2 (h2c) alongside HTTP/1.1
+
+	Pricing []ModelPrice
+
+	Providers []ProviderConfig
+	Fallbacks []ModelFallback
+
+	// Models is the model catalog from the providers file, see
+	// ProvidersFile. When set, it is also the list of models advertised.
+	Models        []ModelRoute
+	ProvidersFile string
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Retry is how each subsystem retries what fails.
+	Retry RetryConfig
+
+	// Clients are extra API keys, each with a scheduling priority. Once
+	// MaxConcurrentRequests chat completions are running, further ones queue
+	// and higher priorities are admitted first.
+	Clients               []ClientKey
+	MaxConcurrentRequests int // 0 = unlimited, no queue
+
+	// MaxConcurrentPerClient caps each client's open chat completions,
+	// queued ones included, unless its ClientKey sets MaxConcurrent.
+	MaxConcurrentPerClient int // 0 = unlimited
+
+	// BootstrapNode runs only the P2P host (DHT, discovery, optional relay)
+	// as network infrastructure, with no HTTP API and no provider.
+	BootstrapNode bool
+	RelayService  bool
+
+	// MockUpstream serves chat completions from an in-process fake OpenAI
+	// server. For demos and CI only.
+	MockUpstream bool
+}
+
+// RetryConfig holds a retry policy per subsystem. A policy left zero gets its
+// default from DefaultRetry.
+type RetryConfig struct {
+	// Upstream retries a provider call that failed with an error worth
+	// retrying, before moving on to the next provider in the fallback chain.
+	Upstream retry.Policy
+
+	// Send retries opening a stream for a message to a peer, broadcasts
+	// included.
+	Send retry.Policy
+
+	// Session retries a request that met a closed persistent stream.
+	Session retry.Policy
+
+	// Reconnect retries dials to bootstrap and pinned peers.
+	Reconnect retry.Policy
+
+	// Registration spaces out re-sends of our registration to a peer that
+	// has not acknowledged it. Zero attempts keeps trying while the peer
+	// stays connected; otherwise the peer is given up on until the next
+	// heartbeat or change of registration.
+	Registration retry.Policy
+
+	// Webhook retries each webhook delivery.
+	Webhook retry.Policy
+
+	// Flap is the cooldown before a flapping peer is redialled. Its attempts
+	// are unused.
+	Flap retry.Policy
+}
+
+var DefaultRetry = RetryConfig{
+	Upstream:     retry.Policy{MaxAttempts: 2, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second, Jitter: 0.2},
+	Send:         p2p.DefaultSendRetry,
+	Session:      p2p.DefaultSessionRetry,
+	Reconnect:    p2p.DefaultReconnectRetry,
+	Registration: retry.Policy{BaseDelay: 2 * time.Second, MaxDelay: 2 * time.Minute, Jitter: 0.2},
+	Webhook:      events.DefaultWebhookRetry,
+	Flap:         p2p.DefaultFlapBackoff,
+}
+
+// WithDefaults fills in the default for every zero policy.
+func (r RetryConfig) WithDefaults() RetryConfig {
+	orDefault := func(p *retry.Policy, def retry.Policy) {
+		if *p == (retry.Policy{}) {
+			*p = def
+		}
+	}
+	orDefault(&r.Upstream, DefaultRetry.Upstream)
+	orDefault(&r.Send, DefaultRetry.Send)
+	orDefault(&r.Session, DefaultRetry.Session)
+	orDefault(&r.Reconnect, DefaultRetry.Reconnect)
+	orDefault(&r.Registration, DefaultRetry.Registration)
+	orDefault(&r.Webhook, DefaultRetry.Webhook)
+	orDefault(&r.Flap, DefaultRetry.Flap)
+	return r
+}
+
+// Policies pairs each policy with the name its settings are known by, e.g.
+// upstream for --upstream-retry-attempts.
+func (r *RetryConfig) Policies() []NamedPolicy {
+	return []NamedPolicy{
+		{"upstream", &r.Upstream},
+		{"send", &r.Send},
+		{"session", &r.Session},
+		{"reconnect", &r.Reconnect},
+		{"registration", &r.Registration},
+		{"webhook", &r.Webhook},
+		{"flap", &r.Flap},
+	}
+}
+
+type NamedPolicy struct {
+	Name   string
+	Policy *retry.Policy
+}
+
+// DefaultPassthroughHeaders are the OpenAI response headers clients use for
+// request tracing and rate-limit backpressure.
+var DefaultPassthroughHeaders = []string{
+	"x-request-id",
+	"x-ratelimit-limit-requests",
+	"x-ratelimit-limit-tokens",
+	"x-ratelimit-remaining-requests",
+	"x-ratelimit-remaining-tokens",
+	"x-ratelimit-reset-requests",
+	"x-ratelimit-reset-tokens",
+}
+
+const (
+	DefaultProvider      = "openai"
+	DefaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+	// MockKeyPrefix marks API keys that are only accepted with MockUpstream.
+	MockKeyPrefix = "mock-"
+
+	// PeerProvider in a fallback chain routes to a connected agent that
+	// advertises the model.
+	PeerProvider = "peer"
+
+	// MaxTopologyDepth bounds TopologyDepth, as every hop multiplies the
+	// neighbor lists each agent relays.
+	MaxTopologyDepth = 3
+)
+
+// Agent roles. Clients send requests to other agents but never serve them
+// or register; servers serve peers but don't send requests on to them.
+const (
+	RoleClient = "client"
+	RoleServer = "server"
+	RoleBoth   = "both"
+)
+
+// Provider types. All of them are spoken to over the OpenAI chat completions
+// wire format; the type decides authentication and key validation.
+const (
+	ProviderTypeOpenAI     = "openai"
+	ProviderTypeAzure      = "azure"
+	ProviderTypeAnthropic  = "anthropic"
+	ProviderTypeOllama     = "ollama"
+	ProviderTypeCompatible = "compatible" // any other OpenAI-compatible gateway
+)
+
+// ProviderConfig is an OpenAI-compatible upstream. An entry named "openai"
+// overrides the built-in default. Type defaults to "openai". An Ollama
+// BaseURL may leave out the /v1 of its OpenAI-compatible API.
+type ProviderConfig struct {
+	Name    string `mapstructure:"name"`
+	Type    string `mapstructure:"type"`
+	BaseURL string `mapstructure:"base_url"`
+	APIKey  string `mapstructure:"api_key"`
+
+	// APIKeyFile, if set instead of APIKey, is a file holding the key, such
+	// as a mounted secret.
+	APIKeyFile string `mapstructure:"api_key_file"`
+
+	// ModelAliases maps the model names clients ask for to the names this
+	// provider knows them by, e.g. gpt-4 -> claude-3-opus-20240229.
+	ModelAliases map[string]string `mapstructure:"model_aliases"`
+
+	// ResponseModel decides the model reported in responses: "fill" (the
+	// default) reports the requested model when the provider leaves it out,
+	// "requested" always reports the requested model and "upstream" reports
+	// whatever the provider said. Aliased models always report the requested
+	// name. Under "fill", event streams piped through unparsed are left as
+	// the provider sent them.
+	ResponseModel string `mapstructure:"response_model"`
+
+	// ListModels has the agent ask the provider for its models, at startup
+	// and every 30s in the background, and serve and advertise those the
+	// catalog doesn't list alongside the configured ones.
+	ListModels bool `mapstructure:"list_models"`
+}
+
+// ResponseModel values.
+const (
+	ResponseModelFill      = "fill"
+	ResponseModelRequested = "requested"
+	ResponseModelUpstream  = "upstream"
+)
+
+// ProviderModel is the name the provider uses for model. Config keys are
+// lowercased when loaded, so aliases match regardless of case.
+func (p ProviderConfig) ProviderModel(model string) string {
+	if alias, ok := p.ModelAliases[model]; ok {
+		return alias
+	}
+	for name, alias := range p.ModelAliases {
+		if strings.EqualFold(name, model) {
+			return alias
+		}
+	}
+	return model
+}
+
+// ReportedModel is the model to report for a request for model, given the
+// one the provider reported.
+func (p ProviderConfig) ReportedModel(model, upstream string) string {
+	if p.ProviderModel(model) != model {
+		return model
+	}
+	switch p.ResponseModel {
+	case ResponseModelRequested:
+		return model
+	case ResponseModelUpstream:
+		return upstream
+	default:
+		if upstream == "" {
+			return model
+		}
+		return upstream
+	}
+}
+
+func (p ProviderConfig) ProviderType() string {
+	if p.Type == "" {
+		return ProviderTypeOpenAI
+	}
+	return p.Type
+}
+
+// Client priorities. The --api-key itself is PriorityNormal.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// ClientKey is an API key issued to one client, e.g. an interactive UI or a
+// batch job.
+type ClientKey struct {
+	Name     string `mapstructure:"name"`
+	Key      string `mapstructure:"key"`
+	Priority string `mapstructure:"priority"` // high, normal or low; default normal
+
+	// Models and Providers, when set, are all the client may use. Providers
+	// are names from the providers list, "openai" or "peer".
+	Models    []string `mapstructure:"models"`
+	Providers []string `mapstructure:"providers"`
+
+	// MaxConcurrent overrides MaxConcurrentPerClient for this client.
+	MaxConcurrent int `mapstructure:"max_concurrent"`
+}
+
+// AnnounceLimits bound the fields of announcements, both those this agent
+// sends and those it accepts from peers. Lengths are in bytes.
+type AnnounceLimits struct {
+	Name        int `mapstructure:"name"`
+	Description int `mapstructure:"description"`
+	URL         int `mapstructure:"url"`
+	Tags        int `mapstructure:"tags"` // number of tags
+	Tag         int `mapstructure:"tag"`  // length of each tag
+}
+
+var DefaultAnnounceLimits = AnnounceLimits{
+	Name:        128,
+	Description: 1024,
+	URL:         2048,
+	Tags:        20,
+	Tag:         64,
+}
+
+// ModelFallback lists the providers to try, in order, when the primary
+// provider fails for a model.
+type ModelFallback struct {
+	Model     string   `mapstructure:"model"`
+	Providers []string `mapstructure:"providers"`
+}
+
+// ModelPrice is the USD cost per 1K tokens for a model. Prices are a list
+// rather than a map keyed by model because viper splits keys on dots, which
+// model names like "gpt-3.5-turbo" contain.
+type ModelPrice struct {
+	Model  string  `mapstructure:"model"`
+	Input  float64 `mapstructure:"input"`
+	Output float64 `mapstructure:"output"`
 }
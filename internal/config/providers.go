@@ -0,0 +1,203 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// ProvidersFile is the schema of providers.yaml, which describes routing in
+// one place: the upstreams, and a catalog saying which of them serves each
+// model, under what name and at what price.
+//
+//	providers:
+//	  - name: local
+//	    type: ollama
+//	    base_url: http://localhost:11434/v1
+//	models:
+//	  - name: gpt-4
+//	    provider: openai
+//	    fallbacks: [local, peer]
+//	    aliases: {local: "llama3:70b"}
+//	    pricing: {input: 0.03, output: 0.06}
+//	    max_context: 8192
+type ProvidersFile struct {
+	Providers []ProviderConfig `mapstructure:"providers"`
+	Models    []ModelRoute     `mapstructure:"models"`
+}
+
+// ModelRoute is a model catalog entry. Provider is tried first (default
+// "openai"), then Fallbacks in order. Aliases maps provider names to that
+// provider's name for the model. MaxContext is the model's context window in
+// tokens, 0 if unknown; requests counted not to fit are refused up front.
+type ModelRoute struct {
+	Name       string            `mapstructure:"name"`
+	Provider   string            `mapstructure:"provider"`
+	Fallbacks  []string          `mapstructure:"fallbacks"`
+	Aliases    map[string]string `mapstructure:"aliases"`
+	Pricing    *RoutePrice       `mapstructure:"pricing"`
+	MaxContext int               `mapstructure:"max_context"`
+}
+
+// RoutePrice is a catalog entry's USD cost per 1K tokens.
+type RoutePrice struct {
+	Input  float64 `mapstructure:"input"`
+	Output float64 `mapstructure:"output"`
+}
+
+// Primary is the provider tried first for the model.
+func (r ModelRoute) Primary() string {
+	if r.Provider == "" {
+		return DefaultProvider
+	}
+	return r.Provider
+}
+
+// LoadProvidersFile reads a providers file, refusing unknown keys so typos
+// don't pass silently, and reads the providers' api_key_file secrets. The
+// result is not validated; ValidateProvidersFile and Config.Validate do that.
+func LoadProvidersFile(path string) (*ProvidersFile, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read providers file %s: %w", path, err)
+	}
+
+	var file ProvidersFile
+	if err := v.Unmarshal(&file, func(c *mapstructure.DecoderConfig) { c.ErrorUnused = true }); err != nil {
+		return nil, fmt.Errorf("invalid providers file %s: %w", path, err)
+	}
+	if err := LoadKeyFiles(file.Providers); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// LoadKeyFiles sets the api_key of providers configured with an api_key_file
+// from that file, trimmed of surrounding whitespace.
+func LoadKeyFiles(providers []ProviderConfig) error {
+	for i := range providers {
+		p := &providers[i]
+		if p.APIKeyFile == "" {
+			continue
+		}
+		if p.APIKey != "" {
+			return fmt.Errorf("provider %q sets both api_key and api_key_file", p.Name)
+		}
+		data, err := os.ReadFile(p.APIKeyFile)
+		if err != nil {
+			return fmt.Errorf("provider %q: failed to read api_key_file: %w", p.Name, err)
+		}
+		p.APIKey = strings.TrimSpace(string(data))
+		if p.APIKey == "" {
+			return fmt.Errorf("provider %q: api_key_file %s is empty", p.Name, p.APIKeyFile)
+		}
+	}
+	return nil
+}
+
+// ValidateProvidersFile checks a providers file on its own, as
+// "config providers validate" does.
+func ValidateProvidersFile(file *ProvidersFile) ValidationErrors {
+	return validateProviders(file.Providers, nil, file.Models)
+}
+
+// Route returns the catalog entry for model, if any.
+func (c *Config) Route(model string) (ModelRoute, bool) {
+	for _, r := range c.Models {
+		if r.Name == model {
+			return r, true
+		}
+	}
+	return ModelRoute{}, false
+}
+
+// MaxContext is the context window the catalog gives model, 0 if none.
+func (c *Config) MaxContext(model string) int {
+	if r, ok := c.Route(model); ok {
+		return r.MaxContext
+	}
+	return 0
+}
+
+// ModelPrices is the pricing list together with the prices from the model
+// catalog.
+func (c *Config) ModelPrices() []ModelPrice {
+	prices := append([]ModelPrice(nil), c.Pricing...)
+	for _, r := range c.Models {
+		if r.Pricing != nil {
+			prices = append(prices, ModelPrice{Model: r.Name, Input: r.Pricing.Input, Output: r.Pricing.Output})
+		}
+	}
+	return prices
+}
+
+func validateModelRoutes(routes []ModelRoute, known map[string]bool) ValidationErrors {
+	var errors ValidationErrors
+
+	seen := make(map[string]bool, len(routes))
+	for _, r := range routes {
+		field := fmt.Sprintf("models.%s", r.Name)
+		if r.Name == "" {
+			errors = append(errors, ValidationError{Field: "models", Code: "model_name_missing", Message: "Every model entry needs a name"})
+			continue
+		}
+		if seen[r.Name] {
+			errors = append(errors, ValidationError{Field: field, Code: "model_duplicate", Message: fmt.Sprintf("Model %q is listed more than once", r.Name)})
+		}
+		seen[r.Name] = true
+
+		if !known[r.Primary()] {
+			errors = append(errors, ValidationError{
+				Field:   field + ".provider",
+				Code:    "model_provider_unknown",
+				Message: fmt.Sprintf("Model %q is served by unknown provider %q", r.Name, r.Primary()),
+			})
+		}
+		for _, name := range r.Fallbacks {
+			if !known[name] {
+				errors = append(errors, ValidationError{
+					Field:   field + ".fallbacks",
+					Code:    "fallback_provider_unknown",
+					Message: fmt.Sprintf("Fallback for model %q references unknown provider %q", r.Name, name),
+				})
+			}
+		}
+		for provider, alias := range r.Aliases {
+			if !known[provider] || provider == PeerProvider {
+				errors = append(errors, ValidationError{
+					Field:   field + ".aliases",
+					Code:    "model_alias_provider_unknown",
+					Message: fmt.Sprintf("Model %q has an alias for unknown provider %q", r.Name, provider),
+				})
+			}
+			if strings.TrimSpace(alias) == "" {
+				errors = append(errors, ValidationError{
+					Field:   field + ".aliases",
+					Code:    "provider_model_alias_empty",
+					Message: fmt.Sprintf("Model %q has an empty alias for provider %q", r.Name, provider),
+				})
+			}
+		}
+		if r.Pricing != nil && (r.Pricing.Input < 0 || r.Pricing.Output < 0) {
+			errors = append(errors, ValidationError{
+				Field:   field + ".pricing",
+				Code:    "pricing_negative",
+				Message: fmt.Sprintf("Prices for model %q cannot be negative", r.Name),
+			})
+		}
+		if r.MaxContext < 0 {
+			errors = append(errors, ValidationError{
+				Field:   field + ".max_context",
+				Code:    "max_context_negative",
+				Message: fmt.Sprintf("Context window for model %q cannot be negative", r.Name),
+			})
+		}
+	}
+
+	return errors
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testAzureKey = "azure-test-key-0123456789abcdef"
+
+// writeFile writes content to name in a fresh temporary directory and
+// returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func hasCode(errs ValidationErrors, code string) bool {
+	for _, e := range errs {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoadProvidersFile(t *testing.T) {
+	path := writeFile(t, "providers.yaml", `
+providers:
+  - name: local
+    type: ollama
+    base_url: http://localhost:11434
+    list_models: true
+  - name: azure
+    type: azure
+    base_url: https://example.openai.azure.com/openai/v1
+    api_key: `+testAzureKey+`
+    response_model: requested
+models:
+  - name: gpt-4
+    provider: azure
+    fallbacks: [local, peer]
+    aliases: {local: "llama3:70b"}
+    pricing: {input: 0.03, output: 0.06}
+    max_context: 8192
+  - name: llama3
+    provider: local
+`)
+
+	file, err := LoadProvidersFile(path)
+	if err != nil {
+		t.Fatalf("LoadProvidersFile: %v", err)
+	}
+	if errs := ValidateProvidersFile(file); errs.HasErrors() {
+		t.Fatalf("ValidateProvidersFile: %v", errs)
+	}
+
+	if len(file.Providers) != 2 {
+		t.Fatalf("got %d providers, want 2", len(file.Providers))
+	}
+	local, azure := file.Providers[0], file.Providers[1]
+	if local.Name != "local" || local.ProviderType() != ProviderTypeOllama || !local.ListModels {
+		t.Errorf("local provider loaded as %+v", local)
+	}
+	if azure.APIKey != testAzureKey || azure.ResponseModel != ResponseModelRequested {
+		t.Errorf("azure provider loaded as %+v", azure)
+	}
+
+	if len(file.Models) != 2 {
+		t.Fatalf("got %d models, want 2", len(file.Models))
+	}
+	gpt4 := file.Models[0]
+	switch {
+	case gpt4.Name != "gpt-4" || gpt4.Primary() != "azure":
+		t.Errorf("gpt-4 routed as %+v", gpt4)
+	case strings.Join(gpt4.Fallbacks, ",") != "local,peer":
+		t.Errorf("gpt-4 fallbacks are %v", gpt4.Fallbacks)
+	case gpt4.Aliases["local"] != "llama3:70b":
+		t.Errorf("gpt-4 aliases are %v", gpt4.Aliases)
+	case gpt4.Pricing == nil || gpt4.Pricing.Input != 0.03 || gpt4.Pricing.Output != 0.06:
+		t.Errorf("gpt-4 pricing is %+v", gpt4.Pricing)
+	case gpt4.MaxContext != 8192:
+		t.Errorf("gpt-4 max_context is %d", gpt4.MaxContext)
+	}
+	if llama := file.Models[1]; llama.Primary() != "local" || llama.Pricing != nil {
+		t.Errorf("llama3 routed as %+v", llama)
+	}
+}
+
+func TestLoadProvidersFileRejectsUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		key     string
+	}{
+		{name: "top level", content: "providers: []\nmodles: []\n", key: "modles"},
+		{name: "provider", content: "providers:\n  - name: local\n    type: ollama\n    base-url: http://localhost:11434\n", key: "base-url"},
+		{name: "model", content: "models:\n  - name: gpt-4\n    max_ctx: 8192\n", key: "max_ctx"},
+		{name: "pricing", content: "models:\n  - name: gpt-4\n    pricing: {in: 0.03}\n", key: "in"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "providers.yaml", tt.content)
+			_, err := LoadProvidersFile(path)
+			if err == nil {
+				t.Fatal("unknown key accepted")
+			}
+			if !strings.Contains(err.Error(), "invalid providers file") || !strings.Contains(err.Error(), tt.key) {
+				t.Fatalf("error %q does not name the key %q", err, tt.key)
+			}
+		})
+	}
+}
+
+func TestLoadProvidersFileMissing(t *testing.T) {
+	if _, err := LoadProvidersFile(filepath.Join(t.TempDir(), "none.yaml")); err == nil {
+		t.Fatal("missing file loaded")
+	}
+}
+
+func TestLoadProvidersFileKeyFile(t *testing.T) {
+	keyFile := writeFile(t, "azure.key", "  "+testAzureKey+"\n")
+	emptyFile := writeFile(t, "empty.key", " \n")
+
+	provider := func(extra string) string {
+		return "providers:\n  - name: azure\n    type: azure\n    base_url: https://example.openai.azure.com/openai/v1\n" + extra
+	}
+	tests := []struct {
+		name    string
+		content string
+		wantKey string
+		wantErr string
+	}{
+		{name: "read and trimmed", content: provider("    api_key_file: " + keyFile + "\n"), wantKey: testAzureKey},
+		{name: "missing file", content: provider("    api_key_file: " + keyFile + ".missing\n"), wantErr: "failed to read api_key_file"},
+		{name: "empty file", content: provider("    api_key_file: " + emptyFile + "\n"), wantErr: "is empty"},
+		{
+			name:    "both key and file",
+			content: provider("    api_key: " + testAzureKey + "\n    api_key_file: " + keyFile + "\n"),
+			wantErr: "sets both api_key and api_key_file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := LoadProvidersFile(writeFile(t, "providers.yaml", tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadProvidersFile: %v", err)
+			}
+			if got := file.Providers[0].APIKey; got != tt.wantKey {
+				t.Fatalf("api_key is %q, want %q", got, tt.wantKey)
+			}
+		})
+	}
+}
+
+// TestFallbackModelRouted checks a model can't take its fallbacks from both
+// the main config and a providers file catalog entry.
+func TestFallbackModelRouted(t *testing.T) {
+	file, err := LoadProvidersFile(writeFile(t, "providers.yaml", `
+providers:
+  - name: local
+    type: ollama
+    base_url: http://localhost:11434
+models:
+  - name: gpt-4
+    fallbacks: [local]
+`))
+	if err != nil {
+		t.Fatalf("LoadProvidersFile: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		fallbacks []ModelFallback
+		conflict  bool
+	}{
+		{name: "same model", fallbacks: []ModelFallback{{Model: "gpt-4", Providers: []string{"local"}}}, conflict: true},
+		{name: "other model", fallbacks: []ModelFallback{{Model: "gpt-3.5-turbo", Providers: []string{"local"}}}},
+		{name: "no fallbacks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateProviders(file.Providers, tt.fallbacks, file.Models)
+			if got := hasCode(errs, "fallback_model_routed"); got != tt.conflict {
+				t.Fatalf("fallback_model_routed reported: %v, want %v (errors: %v)", got, tt.conflict, errs)
+			}
+		})
+	}
+
+	// The providers file on its own has nothing to conflict with.
+	if errs := ValidateProvidersFile(file); errs.HasErrors() {
+		t.Fatalf("ValidateProvidersFile: %v", errs)
+	}
+}
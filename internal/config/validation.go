@@ -2,13 +2,26 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"net"
+	"net/url"
+	"os"
+	"regexp"
 	"strings"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/p2p"
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
+// genericAgentName matches placeholder names like "agent", "test-2" or "node1".
+var genericAgentName = regexp.MustCompile(`^(agent|node|test|demo|default|localhost)(-?[0-9]+)?$`)
+
 type ValidationError struct {
-	Field   string
-	Message string
+	Field   string `json:"field"`
+	Code    string `json:"code"` // stable, machine-readable, e.g. "name_too_long"
+	Message string `json:"message"`
 }
 
 func (e *ValidationError) Error() string {
@@ -32,11 +45,17 @@ func (e ValidationErrors) HasErrors() bool {
 	return len(e) > 0
 }
 
-func (c *Config) Validate() ValidationErrors {
+// Validate checks the config. Errors stop the agent from starting; warnings
+// flag settings that work but are probably not what was intended.
+func (c *Config) Validate() (ValidationErrors, ValidationErrors) {
+	if c.BootstrapNode {
+		return c.validateBootstrapNode(), nil
+	}
+
 	var errors ValidationErrors
 
 	// API Key validation
-	if err := validateAPIKey(c.APIKey); err != nil {
+	if err := validateAPIKey(c.APIKey, c.MockUpstream, c.defaultProviderType()); err != nil {
 		errors = append(errors, *err)
 	}
 
@@ -45,6 +64,16 @@ func (c *Config) Validate() ValidationErrors {
 		errors = append(errors, *err)
 	}
 
+	switch c.Role {
+	case "", RoleClient, RoleServer, RoleBoth:
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "role",
+			Code:    "role_invalid",
+			Message: fmt.Sprintf("Unknown role %q. Use client, server or both", c.Role),
+		})
+	}
+
 	// Port validation
 	if err := validatePort(c.HTTPPort, "http_port"); err != nil {
 		errors = append(errors, *err)
@@ -57,10 +86,227 @@ func (c *Config) Validate() ValidationErrors {
 	if c.HTTPPort == c.P2PPort {
 		errors = append(errors, ValidationError{
 			Field:   "ports",
+			Code:    "port_conflict",
 			Message: "HTTP port and P2P port cannot be the same",
 		})
 	}
 
+	// Security transport validation
+	switch c.Security {
+	case "noise", "tls", "both":
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "security",
+			Code:    "security_invalid",
+			Message: fmt.Sprintf("Unknown security transport %q. Use noise, tls or both", c.Security),
+		})
+	}
+
+	// DHT mode validation
+	if err := validateDHTMode(c.DHTMode); err != nil {
+		errors = append(errors, *err)
+	}
+
+	switch c.LogPeerIDs {
+	case "", "short", "full":
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "log_peer_ids",
+			Code:    "log_peer_ids_invalid",
+			Message: fmt.Sprintf("Unknown peer ID log format %q. Use short or full", c.LogPeerIDs),
+		})
+	}
+
+	// Pinned peer validation
+	for _, pin := range c.PinnedPeers {
+		if err := validatePinnedPeer(pin); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+
+	// Blocked peer validation
+	for _, id := range c.BlockedPeers {
+		if err := validateBlockedPeer(id); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+
+	// Advertised endpoint validation
+	if c.AdvertiseEndpoint != "" {
+		if err := validateEndpoint(c.AdvertiseEndpoint); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+
+	// Webhook URL validation
+	for _, hook := range c.Webhooks {
+		if err := validateWebhookURL(hook); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+
+	// Upstream timeout validation
+	if c.UpstreamHeaderTimeout <= 0 {
+		errors = append(errors, ValidationError{
+			Field:   "upstream_header_timeout",
+			Code:    "upstream_header_timeout_invalid",
+			Message: "Upstream header timeout must be greater than zero",
+		})
+	}
+	if c.UpstreamTimeout <= 0 {
+		errors = append(errors, ValidationError{
+			Field:   "upstream_timeout",
+			Code:    "upstream_timeout_invalid",
+			Message: "Upstream timeout must be greater than zero",
+		})
+	}
+	if c.UpstreamStreamTimeout < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "upstream_stream_timeout",
+			Code:    "upstream_stream_timeout_invalid",
+			Message: "Upstream stream timeout cannot be negative. Use 0 for no limit",
+		})
+	}
+
+	if c.HandlerTimeout < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "handler_timeout",
+			Code:    "handler_timeout_invalid",
+			Message: "Handler timeout cannot be negative. Use 0 for no limit",
+		})
+	}
+
+	// Upstream proxy validation
+	if c.UpstreamProxy != "" {
+		if u, err := url.Parse(c.UpstreamProxy); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			errors = append(errors, ValidationError{
+				Field:   "upstream_proxy",
+				Code:    "upstream_proxy_invalid",
+				Message: "Upstream proxy must be an absolute http, https or socks5 URL (e.g. http://proxy.corp:3128)",
+			})
+		}
+	}
+
+	if c.TopologyDepth < 0 || c.TopologyDepth > MaxTopologyDepth {
+		errors = append(errors, ValidationError{
+			Field:   "topology_depth",
+			Code:    "topology_depth_invalid",
+			Message: fmt.Sprintf("Topology depth must be between 0 and %d", MaxTopologyDepth),
+		})
+	}
+
+	// Replay window validation
+	if c.ReplayWindow < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "replay_window",
+			Code:    "replay_window_invalid",
+			Message: "Replay window cannot be negative. Use 0 to disable replay protection",
+		})
+	}
+
+	// Request body limit validation
+	if c.MaxBodySize <= 0 {
+		errors = append(errors, ValidationError{
+			Field:   "max_body_size",
+			Code:    "max_body_size_invalid",
+			Message: "Max body size must be greater than zero",
+		})
+	}
+	if c.MaxMessageSize <= 0 || c.MaxMessageSize > math.MaxUint32 {
+		errors = append(errors, ValidationError{
+			Field:   "max_message_size",
+			Code:    "max_message_size_invalid",
+			Message: "Max message size must be greater than zero and below 4 GiB",
+		})
+	}
+
+	// HTTP server timeout validation
+	for _, t := range []struct {
+		field   string
+		timeout time.Duration
+	}{
+		{"http_read_header_timeout", c.HTTPReadHeaderTimeout},
+		{"http_write_timeout", c.HTTPWriteTimeout},
+		{"http_idle_timeout", c.HTTPIdleTimeout},
+	} {
+		if t.timeout < 0 {
+			errors = append(errors, ValidationError{
+				Field:   t.field,
+				Code:    t.field + "_invalid",
+				Message: "HTTP server timeouts cannot be negative. Use 0 for no limit",
+			})
+		}
+	}
+
+	// Circuit breaker validation
+	if c.BreakerThreshold < 1 {
+		errors = append(errors, ValidationError{
+			Field:   "breaker_threshold",
+			Code:    "breaker_threshold_invalid",
+			Message: "Breaker threshold must be at least 1",
+		})
+	}
+	if c.BreakerCooldown <= 0 {
+		errors = append(errors, ValidationError{
+			Field:   "breaker_cooldown",
+			Code:    "breaker_cooldown_invalid",
+			Message: "Breaker cooldown must be greater than zero",
+		})
+	}
+	errors = append(errors, validateRetry(c.Retry)...)
+
+	// Admission queue and client key validation
+	if c.MaxConcurrentRequests < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "max_concurrent_requests",
+			Code:    "max_concurrent_requests_invalid",
+			Message: "Max concurrent requests cannot be negative. Use 0 for no limit",
+		})
+	}
+	if c.MaxConcurrentPerClient < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "max_concurrent_per_client",
+			Code:    "max_concurrent_per_client_invalid",
+			Message: "Max concurrent requests per client cannot be negative. Use 0 for no limit",
+		})
+	}
+	errors = append(errors, validateClients(c.Clients, c.Providers)...)
+
+	limits := c.AnnounceLimits
+	if limits.Name <= 0 || limits.Description <= 0 || limits.URL <= 0 || limits.Tags <= 0 || limits.Tag <= 0 {
+		errors = append(errors, ValidationError{
+			Field:   "announce_limits",
+			Code:    "announce_limits_invalid",
+			Message: "Announce limits must all be positive",
+		})
+	}
+
+	// Pricing validation
+	for _, price := range c.Pricing {
+		if price.Model == "" {
+			errors = append(errors, ValidationError{
+				Field:   "pricing",
+				Code:    "pricing_model_missing",
+				Message: "Every pricing entry needs a model name",
+			})
+		}
+		if price.Input < 0 || price.Output < 0 {
+			errors = append(errors, ValidationError{
+				Field:   "pricing",
+				Code:    "pricing_negative",
+				Message: fmt.Sprintf("Prices for model %q cannot be negative", price.Model),
+			})
+		}
+	}
+
+	// Provider and fallback validation
+	errors = append(errors, validateProviders(c.Providers, c.Fallbacks, c.Models)...)
+
+	// Key separation: only fatal with --strict-keys
+	if c.StrictKeys {
+		errors = append(errors, c.keyOverlaps()...)
+	}
+
 	// Check if ports are available
 	if err := checkPortAvailable(c.HTTPPort, "http_port"); err != nil {
 		errors = append(errors, *err)
@@ -69,21 +315,272 @@ func (c *Config) Validate() ValidationErrors {
 		errors = append(errors, *err)
 	}
 
+	return errors, c.warnings()
+}
+
+// keyOverlaps reports keys that are used for more than one role: the API
+// key, which authenticates clients, the admin key and provider keys. The
+// default provider inheriting the API key and a missing admin key are how
+// agents are usually set up, so they only count under --strict-keys.
+func (c *Config) keyOverlaps() ValidationErrors {
+	var overlaps ValidationErrors
+
+	switch {
+	case c.AdminKey == "" && c.StrictKeys:
+		overlaps = append(overlaps, ValidationError{
+			Field:   "admin_key",
+			Code:    "admin_key_missing",
+			Message: "No admin key set, so any client with the API key can call /v1/admin. Set --admin-key",
+		})
+	case c.AdminKey != "" && c.AdminKey == c.APIKey:
+		overlaps = append(overlaps, ValidationError{
+			Field:   "admin_key",
+			Code:    "admin_key_shared",
+			Message: "The admin key is the same as the API key, so every client can call /v1/admin. Use a different --admin-key",
+		})
+	}
+
+	for _, client := range c.Clients {
+		if client.Key != "" && client.Key == c.AdminKey {
+			overlaps = append(overlaps, ValidationError{
+				Field:   "clients",
+				Code:    "client_key_shared",
+				Message: fmt.Sprintf("Client %q uses the admin key, so it can call /v1/admin. Give it its own key", client.Name),
+			})
+		}
+	}
+
+	// The mock upstream ignores the key it inherits.
+	inherited := !c.MockUpstream && c.APIKey != ""
+	for _, p := range c.Providers {
+		if p.Name == DefaultProvider && (p.APIKey != "" || p.ProviderType() != ProviderTypeOpenAI) {
+			inherited = false
+		}
+		if p.APIKey != "" {
+			overlaps = append(overlaps, providerKeyOverlaps(p, c.APIKey, c.AdminKey)...)
+		}
+	}
+	if inherited && c.StrictKeys {
+		overlaps = append(overlaps, ValidationError{
+			Field:   "providers",
+			Code:    "provider_key_shared",
+			Message: fmt.Sprintf("Provider %q uses --api-key, which also authenticates clients. Give it its own api_key under providers", DefaultProvider),
+		})
+	}
+	return overlaps
+}
+
+func providerKeyOverlaps(p ProviderConfig, apiKey, adminKey string) ValidationErrors {
+	var overlaps ValidationErrors
+	if p.APIKey == apiKey {
+		overlaps = append(overlaps, ValidationError{
+			Field:   "providers",
+			Code:    "provider_key_shared",
+			Message: fmt.Sprintf("Provider %q uses the same key as --api-key, so clients hold a provider credential. Use separate keys", p.Name),
+		})
+	}
+	if adminKey != "" && p.APIKey == adminKey {
+		overlaps = append(overlaps, ValidationError{
+			Field:   "providers",
+			Code:    "provider_key_shared",
+			Message: fmt.Sprintf("Provider %q uses the same key as --admin-key. Use separate keys", p.Name),
+		})
+	}
+	return overlaps
+}
+
+// warnings collects non-fatal config issues for a regular agent.
+func (c *Config) warnings() ValidationErrors {
+	var warnings ValidationErrors
+
+	if !c.StrictKeys {
+		warnings = append(warnings, c.keyOverlaps()...)
+	}
+
+	if isGenericAgentName(c.AgentName) {
+		warnings = append(warnings, ValidationError{
+			Field:   "name",
+			Code:    "name_generic",
+			Message: fmt.Sprintf("Agent name %q is generic and likely to collide with other agents. Pick a unique name", c.AgentName),
+		})
+	}
+
+	if c.BootstrapPeer == "" {
+		warnings = append(warnings, ValidationError{
+			Field:   "bootstrap",
+			Code:    "bootstrap_missing",
+			Message: "No bootstrap peer set. The agent will only find peers via mDNS on the local network",
+		})
+	}
+
+	if c.StrictTags && len(c.AnnounceTags) == 0 {
+		warnings = append(warnings, ValidationError{
+			Field:   "strict_tags",
+			Code:    "strict_tags_without_vocabulary",
+			Message: "Strict tags has no effect without a tag vocabulary. Set announce_tags or --announce-tag",
+		})
+	}
+
+	if c.HandlerTimeout > 0 && c.HandlerTimeout < c.UpstreamTimeout {
+		warnings = append(warnings, ValidationError{
+			Field:   "handler_timeout",
+			Code:    "handler_timeout_short",
+			Message: fmt.Sprintf("Handler timeout %s is shorter than the upstream timeout %s, so slow provider calls for peers will be cut off", c.HandlerTimeout, c.UpstreamTimeout),
+		})
+	}
+
+	if len(c.Webhooks) > 0 && c.WebhookSecret == "" {
+		warnings = append(warnings, ValidationError{
+			Field:   "webhook_secret",
+			Code:    "webhook_unsigned",
+			Message: "Webhooks are configured without a secret, so deliveries will not be signed",
+		})
+	}
+
+	if c.HTTPReadHeaderTimeout == 0 {
+		warnings = append(warnings, ValidationError{
+			Field:   "http_read_header_timeout",
+			Code:    "http_read_header_timeout_disabled",
+			Message: "HTTP read header timeout is disabled, so clients that send headers slowly can hold connections open indefinitely",
+		})
+	}
+
+	if c.HTTPWriteTimeout > 0 && c.HTTPWriteTimeout < c.UpstreamTimeout {
+		warnings = append(warnings, ValidationError{
+			Field:   "http_write_timeout",
+			Code:    "http_write_timeout_short",
+			Message: fmt.Sprintf("HTTP write timeout %s is shorter than the upstream timeout %s, so slow completions will be cut off", c.HTTPWriteTimeout, c.UpstreamTimeout),
+		})
+	}
+
+	if c.ReplayWindow == 0 {
+		warnings = append(warnings, ValidationError{
+			Field:   "replay_window",
+			Code:    "replay_disabled",
+			Message: "Replay protection is disabled. Captured P2P messages can be resent",
+		})
+	}
+
+	return warnings
+}
+
+// isGenericAgentName reports whether name is a placeholder such as "agent",
+// "node-1" or the machine's hostname.
+func isGenericAgentName(name string) bool {
+	if hostname, err := os.Hostname(); err == nil && strings.EqualFold(name, hostname) {
+		return true
+	}
+	return genericAgentName.MatchString(strings.ToLower(name))
+}
+
+// validateBootstrapNode checks the subset of settings a --bootstrap-node
+// uses. It needs no API key or provider and serves no HTTP API.
+func (c *Config) validateBootstrapNode() ValidationErrors {
+	var errors ValidationErrors
+
+	if c.AgentName != "" {
+		if err := validateAgentName(c.AgentName); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+	if err := validatePort(c.P2PPort, "p2p_port"); err != nil {
+		errors = append(errors, *err)
+	}
+	switch c.Security {
+	case "noise", "tls", "both":
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "security",
+			Code:    "security_invalid",
+			Message: fmt.Sprintf("Unknown security transport %q. Use noise, tls or both", c.Security),
+		})
+	}
+	if c.NoDHT {
+		errors = append(errors, ValidationError{
+			Field:   "no_dht",
+			Code:    "no_dht_unsupported",
+			Message: "A bootstrap node serves the DHT and cannot run with --no-dht",
+		})
+	}
+	switch c.DHTMode {
+	case "", "auto", "server":
+	case "client":
+		errors = append(errors, ValidationError{
+			Field:   "dht_mode",
+			Code:    "dht_mode_unsupported",
+			Message: "A bootstrap node always runs the DHT in server mode; drop --dht-mode client",
+		})
+	default:
+		errors = append(errors, *validateDHTMode(c.DHTMode))
+	}
+	if c.ReplayWindow < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "replay_window",
+			Code:    "replay_window_invalid",
+			Message: "Replay window cannot be negative. Use 0 to disable replay protection",
+		})
+	}
+	errors = append(errors, validateRetry(c.Retry)...)
+	if c.MockUpstream {
+		errors = append(errors, ValidationError{
+			Field:   "mock_upstream",
+			Code:    "mock_upstream_unsupported",
+			Message: "--mock-upstream has no effect on a bootstrap node, which serves no completions",
+		})
+	}
+	if err := checkPortAvailable(c.P2PPort, "p2p_port"); err != nil {
+		errors = append(errors, *err)
+	}
+
 	return errors
 }
 
-func validateAPIKey(key string) *ValidationError {
+// defaultProviderType is the type of the provider the top-level API key is
+// sent to, taking an "openai" override in the providers list into account.
+func (c *Config) defaultProviderType() string {
+	for _, p := range c.Providers {
+		if p.Name == DefaultProvider {
+			return p.ProviderType()
+		}
+	}
+	return ProviderTypeOpenAI
+}
+
+// validateAPIKey checks the top-level key. It also authenticates clients of
+// the HTTP API, so it is always required; OpenAI format checks only apply when
+// it is actually sent to OpenAI.
+func validateAPIKey(key string, mock bool, providerType string) *ValidationError {
 	if key == "" {
 		return &ValidationError{
 			Field:   "api_key",
+			Code:    "api_key_missing",
 			Message: "API key is required. Use --api-key flag or set P2P_API_KEY env var",
 		}
 	}
 
+	if strings.HasPrefix(key, MockKeyPrefix) {
+		if !mock {
+			return &ValidationError{
+				Field:   "api_key",
+				Code:    "api_key_mock_not_allowed",
+				Message: "Keys starting with 'mock-' only work with --mock-upstream",
+			}
+		}
+		return nil
+	}
+
+	if providerType != ProviderTypeOpenAI {
+		return nil
+	}
+	return validateOpenAIKey(key, "api_key")
+}
+
+func validateOpenAIKey(key, field string) *ValidationError {
 	// OpenAI API keys start with "sk-"
 	if !strings.HasPrefix(key, "sk-") {
 		return &ValidationError{
-			Field:   "api_key",
+			Field:   field,
+			Code:    "api_key_invalid_format",
 			Message: "Invalid API key format. OpenAI API keys start with 'sk-'",
 		}
 	}
@@ -91,7 +588,8 @@ func validateAPIKey(key string) *ValidationError {
 	// Minimum length check (OpenAI keys are typically 51+ chars)
 	if len(key) < 40 {
 		return &ValidationError{
-			Field:   "api_key",
+			Field:   field,
+			Code:    "api_key_too_short",
 			Message: "API key appears to be too short. Please check your key",
 		}
 	}
@@ -99,10 +597,48 @@ func validateAPIKey(key string) *ValidationError {
 	return nil
 }
 
+// validateProviderKey applies the key rules for a provider's type. Keyless
+// local endpoints (Ollama, generic gateways) may omit the key entirely.
+func validateProviderKey(p ProviderConfig) *ValidationError {
+	field := fmt.Sprintf("providers.%s.api_key", p.Name)
+
+	switch p.ProviderType() {
+	case ProviderTypeOpenAI:
+		if p.APIKey == "" {
+			if p.Name == DefaultProvider {
+				return nil // falls back to the top-level key
+			}
+			return &ValidationError{Field: field, Code: "provider_api_key_missing", Message: "OpenAI providers require an api_key"}
+		}
+		return validateOpenAIKey(p.APIKey, field)
+	case ProviderTypeAzure, ProviderTypeAnthropic:
+		if p.APIKey == "" {
+			return &ValidationError{Field: field, Code: "provider_api_key_missing", Message: fmt.Sprintf("%s providers require an api_key", p.ProviderType())}
+		}
+	case ProviderTypeOllama, ProviderTypeCompatible:
+	default:
+		return &ValidationError{
+			Field:   fmt.Sprintf("providers.%s.type", p.Name),
+			Code:    "provider_type_invalid",
+			Message: fmt.Sprintf("Unknown provider type %q. Use openai, azure, anthropic, ollama or compatible", p.Type),
+		}
+	}
+	return nil
+}
+
+// ValidateAgentName applies the --name rules to a name set at runtime.
+func ValidateAgentName(name string) error {
+	if err := validateAgentName(name); err != nil {
+		return err
+	}
+	return nil
+}
+
 func validateAgentName(name string) *ValidationError {
 	if name == "" {
 		return &ValidationError{
 			Field:   "agent_name",
+			Code:    "name_missing",
 			Message: "Agent name is required. Use --name flag to set it",
 		}
 	}
@@ -112,6 +648,7 @@ func validateAgentName(name string) *ValidationError {
 		if !isValidNameChar(c) {
 			return &ValidationError{
 				Field:   "agent_name",
+				Code:    "name_invalid_chars",
 				Message: "Agent name can only contain letters, numbers, dashes, and underscores",
 			}
 		}
@@ -121,6 +658,7 @@ func validateAgentName(name string) *ValidationError {
 	if len(name) < 2 {
 		return &ValidationError{
 			Field:   "agent_name",
+			Code:    "name_too_short",
 			Message: "Agent name must be at least 2 characters",
 		}
 	}
@@ -128,6 +666,7 @@ func validateAgentName(name string) *ValidationError {
 	if len(name) > 32 {
 		return &ValidationError{
 			Field:   "agent_name",
+			Code:    "name_too_long",
 			Message: "Agent name cannot exceed 32 characters",
 		}
 	}
@@ -146,6 +685,7 @@ func validatePort(port int, field string) *ValidationError {
 	if port < 1 || port > 65535 {
 		return &ValidationError{
 			Field:   field,
+			Code:    "port_out_of_range",
 			Message: "Port must be between 1 and 65535",
 		}
 	}
@@ -153,6 +693,7 @@ func validatePort(port int, field string) *ValidationError {
 	if port < 1024 {
 		return &ValidationError{
 			Field:   field,
+			Code:    "port_privileged",
 			Message: "Port below 1024 requires elevated privileges. Use a port >= 1024",
 		}
 	}
@@ -160,12 +701,266 @@ func validatePort(port int, field string) *ValidationError {
 	return nil
 }
 
+func validatePinnedPeer(raw string) *ValidationError {
+	if _, err := p2p.ParsePinnedPeer(raw); err != nil {
+		return &ValidationError{
+			Field:   "pinned_peers",
+			Code:    "pin_peer_invalid",
+			Message: err.Error(),
+		}
+	}
+	return nil
+}
+
+func validateBlockedPeer(raw string) *ValidationError {
+	if _, err := peer.Decode(strings.TrimSpace(raw)); err != nil {
+		return &ValidationError{
+			Field:   "blocked_peers",
+			Code:    "block_peer_invalid",
+			Message: fmt.Sprintf("%q is not a peer ID. Use the peer's ID, e.g. 12D3KooW...", raw),
+		}
+	}
+	return nil
+}
+
+func validateClients(clients []ClientKey, providers []ProviderConfig) ValidationErrors {
+	var errors ValidationErrors
+	known := map[string]bool{DefaultProvider: true, PeerProvider: true}
+	for _, p := range providers {
+		known[p.Name] = true
+	}
+	seen := make(map[string]bool, len(clients))
+	for i, client := range clients {
+		name := client.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		switch {
+		case client.Key == "":
+			errors = append(errors, ValidationError{
+				Field:   "clients",
+				Code:    "client_key_missing",
+				Message: fmt.Sprintf("Client %s has no key", name),
+			})
+		case seen[client.Key]:
+			errors = append(errors, ValidationError{
+				Field:   "clients",
+				Code:    "client_key_duplicate",
+				Message: fmt.Sprintf("Client %s reuses another client's key", name),
+			})
+		}
+		seen[client.Key] = true
+
+		switch client.Priority {
+		case "", PriorityHigh, PriorityNormal, PriorityLow:
+		default:
+			errors = append(errors, ValidationError{
+				Field:   "clients",
+				Code:    "client_priority_invalid",
+				Message: fmt.Sprintf("Client %s has unknown priority %q. Use high, normal or low", name, client.Priority),
+			})
+		}
+
+		if client.MaxConcurrent < 0 {
+			errors = append(errors, ValidationError{
+				Field:   "clients",
+				Code:    "client_max_concurrent_invalid",
+				Message: fmt.Sprintf("Client %s has a negative max_concurrent", name),
+			})
+		}
+
+		for _, provider := range client.Providers {
+			if !known[provider] {
+				errors = append(errors, ValidationError{
+					Field:   "clients",
+					Code:    "client_provider_unknown",
+					Message: fmt.Sprintf("Client %s is allowed unknown provider %q", name, provider),
+				})
+			}
+		}
+	}
+	return errors
+}
+
+// validateRetry checks every retry policy set; zero ones get defaults.
+func validateRetry(r RetryConfig) ValidationErrors {
+	var errors ValidationErrors
+	for _, named := range r.Policies() {
+		p := *named.Policy
+		if p == (retry.Policy{}) {
+			continue
+		}
+		field := named.Name + "_retry"
+		if p.MaxAttempts < 0 {
+			errors = append(errors, ValidationError{
+				Field:   field + "_attempts",
+				Code:    "retry_attempts_invalid",
+				Message: fmt.Sprintf("Retry attempts for %s cannot be negative. Use 0 for no limit", named.Name),
+			})
+		}
+		if p.BaseDelay < 0 || p.MaxDelay < 0 {
+			errors = append(errors, ValidationError{
+				Field:   field + "_base_delay",
+				Code:    "retry_delay_invalid",
+				Message: fmt.Sprintf("Retry delays for %s cannot be negative", named.Name),
+			})
+		} else if p.MaxDelay > 0 && p.MaxDelay < p.BaseDelay {
+			errors = append(errors, ValidationError{
+				Field:   field + "_max_delay",
+				Code:    "retry_max_delay_too_small",
+				Message: fmt.Sprintf("Retry max delay for %s (%s) is below its base delay (%s)", named.Name, p.MaxDelay, p.BaseDelay),
+			})
+		}
+		if p.Jitter < 0 || p.Jitter > 1 || math.IsNaN(p.Jitter) {
+			errors = append(errors, ValidationError{
+				Field:   field + "_jitter",
+				Code:    "retry_jitter_invalid",
+				Message: fmt.Sprintf("Retry jitter for %s must be between 0 and 1", named.Name),
+			})
+		}
+		// The registration and flap policies only space out work done on
+		// their own schedule; the rest would retry in a tight loop.
+		if p.MaxAttempts == 0 && p.BaseDelay == 0 && named.Name != "registration" && named.Name != "flap" {
+			errors = append(errors, ValidationError{
+				Field:   field + "_base_delay",
+				Code:    "retry_unbounded",
+				Message: fmt.Sprintf("Retries for %s have no attempt limit and no delay. Set --%s-retry-attempts or --%s-retry-base-delay", named.Name, named.Name, named.Name),
+			})
+		}
+	}
+	return errors
+}
+
+func validateDHTMode(mode string) *ValidationError {
+	switch mode {
+	case "", "auto", "client", "server":
+		return nil
+	}
+	return &ValidationError{
+		Field:   "dht_mode",
+		Code:    "dht_mode_invalid",
+		Message: fmt.Sprintf("Unknown DHT mode %q. Use client, server or auto", mode),
+	}
+}
+
+// ValidateEndpoint applies the --advertise-endpoint rules to an endpoint set
+// at runtime.
+func ValidateEndpoint(raw string) error {
+	if err := validateEndpoint(raw); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateEndpoint(raw string) *ValidationError {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ValidationError{
+			Field:   "advertise_endpoint",
+			Code:    "advertise_endpoint_invalid",
+			Message: fmt.Sprintf("Invalid endpoint %q. Use an absolute http:// or https:// URL peers can reach, e.g. https://agent.example.com", raw),
+		}
+	}
+	return nil
+}
+
+func validateWebhookURL(raw string) *ValidationError {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return &ValidationError{
+			Field:   "webhooks",
+			Code:    "webhook_url_invalid",
+			Message: fmt.Sprintf("Invalid webhook URL %q. Use an absolute http:// or https:// URL", raw),
+		}
+	}
+	return nil
+}
+
+func validateProviders(providers []ProviderConfig, fallbacks []ModelFallback, routes []ModelRoute) ValidationErrors {
+	var errors ValidationErrors
+
+	known := map[string]bool{DefaultProvider: true, PeerProvider: true}
+	for _, p := range providers {
+		if p.Name == "" {
+			errors = append(errors, ValidationError{Field: "providers", Code: "provider_name_missing", Message: "Every provider needs a name"})
+			continue
+		}
+		if p.Name == PeerProvider {
+			errors = append(errors, ValidationError{Field: "providers", Code: "provider_name_reserved", Message: fmt.Sprintf("Provider name %q is reserved", PeerProvider)})
+		}
+		if known[p.Name] && p.Name != DefaultProvider {
+			errors = append(errors, ValidationError{Field: "providers", Code: "provider_duplicate", Message: fmt.Sprintf("Provider %q is defined more than once", p.Name)})
+		}
+		known[p.Name] = true
+
+		if err := validateProviderKey(p); err != nil {
+			errors = append(errors, *err)
+		}
+
+		if u, err := url.Parse(p.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errors = append(errors, ValidationError{
+				Field:   "providers",
+				Code:    "provider_base_url_invalid",
+				Message: fmt.Sprintf("Provider %q needs an absolute http(s) base_url", p.Name),
+			})
+		}
+
+		switch p.ResponseModel {
+		case "", ResponseModelFill, ResponseModelRequested, ResponseModelUpstream:
+		default:
+			errors = append(errors, ValidationError{
+				Field:   fmt.Sprintf("providers.%s.response_model", p.Name),
+				Code:    "provider_response_model_invalid",
+				Message: fmt.Sprintf("Unknown response_model %q. Use fill, requested or upstream", p.ResponseModel),
+			})
+		}
+
+		for model, alias := range p.ModelAliases {
+			if strings.TrimSpace(alias) == "" {
+				errors = append(errors, ValidationError{
+					Field:   fmt.Sprintf("providers.%s.model_aliases", p.Name),
+					Code:    "provider_model_alias_empty",
+					Message: fmt.Sprintf("Provider %q maps model %q to an empty name", p.Name, model),
+				})
+			}
+		}
+	}
+
+	for _, fb := range fallbacks {
+		if fb.Model == "" {
+			errors = append(errors, ValidationError{Field: "fallbacks", Code: "fallback_model_missing", Message: "Every fallback entry needs a model name"})
+		}
+		for _, name := range fb.Providers {
+			if !known[name] {
+				errors = append(errors, ValidationError{
+					Field:   "fallbacks",
+					Code:    "fallback_provider_unknown",
+					Message: fmt.Sprintf("Fallback for model %q references unknown provider %q", fb.Model, name),
+				})
+			}
+		}
+		for _, r := range routes {
+			if r.Name == fb.Model {
+				errors = append(errors, ValidationError{
+					Field:   "fallbacks",
+					Code:    "fallback_model_routed",
+					Message: fmt.Sprintf("Model %q has fallbacks and a models entry; move them into the models entry", fb.Model),
+				})
+			}
+		}
+	}
+
+	errors = append(errors, validateModelRoutes(routes, known)...)
+	return errors
+}
+
 func checkPortAvailable(port int, field string) *ValidationError {
 	addr := fmt.Sprintf(":%d", port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return &ValidationError{
 			Field:   field,
+			Code:    "port_in_use",
 			Message: fmt.Sprintf("Port %d is already in use", port),
 		}
 	}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+)
+
+func TestValidateClients(t *testing.T) {
+	tests := []struct {
+		name     string
+		clients  []ClientKey
+		wantCode string // "" = valid
+	}{
+		{name: "none"},
+		{name: "valid", clients: []ClientKey{
+			{Name: "ui", Key: "ui-key", Priority: PriorityHigh},
+			{Name: "batch", Key: "batch-key", Priority: PriorityLow, MaxConcurrent: 2},
+			{Name: "default", Key: "default-key"},
+		}},
+		{name: "missing key", clients: []ClientKey{{Name: "ui"}}, wantCode: "client_key_missing"},
+		{name: "duplicate key", clients: []ClientKey{{Name: "ui", Key: "k"}, {Name: "batch", Key: "k"}}, wantCode: "client_key_duplicate"},
+		{name: "unknown priority", clients: []ClientKey{{Name: "ui", Key: "k", Priority: "urgent"}}, wantCode: "client_priority_invalid"},
+		{name: "negative max_concurrent", clients: []ClientKey{{Name: "ui", Key: "k", MaxConcurrent: -1}}, wantCode: "client_max_concurrent_invalid"},
+		{name: "known providers", clients: []ClientKey{{Name: "ui", Key: "k", Providers: []string{DefaultProvider, PeerProvider, "local"}}}},
+		{name: "unknown provider", clients: []ClientKey{{Name: "ui", Key: "k", Providers: []string{"azure"}}}, wantCode: "client_provider_unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateClients(tt.clients, []ProviderConfig{{Name: "local"}})
+			if tt.wantCode == "" {
+				if len(errs) != 0 {
+					t.Fatalf("got %v, want no errors", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !hasCode(errs, tt.wantCode) {
+				t.Fatalf("got %v, want only %s", errs, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidateRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		retry     RetryConfig
+		wantField string // "" = valid
+		wantCode  string
+	}{
+		{name: "all defaults"},
+		{name: "explicit defaults", retry: DefaultRetry},
+		{name: "no retries", retry: RetryConfig{Upstream: retry.Policy{MaxAttempts: 1}}},
+		{name: "negative attempts", retry: RetryConfig{Send: retry.Policy{MaxAttempts: -1, BaseDelay: time.Second}}, wantField: "send_retry_attempts", wantCode: "retry_attempts_invalid"},
+		{name: "negative delay", retry: RetryConfig{Webhook: retry.Policy{MaxAttempts: 3, BaseDelay: -time.Second}}, wantField: "webhook_retry_base_delay", wantCode: "retry_delay_invalid"},
+		{name: "max below base", retry: RetryConfig{Reconnect: retry.Policy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Second}}, wantField: "reconnect_retry_max_delay", wantCode: "retry_max_delay_too_small"},
+		{name: "jitter above 1", retry: RetryConfig{Upstream: retry.Policy{MaxAttempts: 2, BaseDelay: time.Second, Jitter: 1.5}}, wantField: "upstream_retry_jitter", wantCode: "retry_jitter_invalid"},
+		{name: "unbounded without delay", retry: RetryConfig{Session: retry.Policy{Jitter: 0.1}}, wantField: "session_retry_base_delay", wantCode: "retry_unbounded"},
+		{name: "registration unbounded without delay", retry: RetryConfig{Registration: retry.Policy{Jitter: 0.1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateRetry(tt.retry)
+			if tt.wantCode == "" {
+				if len(errs) != 0 {
+					t.Fatalf("got %v, want no errors", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Code != tt.wantCode || errs[0].Field != tt.wantField {
+				t.Fatalf("got %v, want only %s on %s", errs, tt.wantCode, tt.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	TypePeerConnected    Type = "peer_connected"
+	TypePeerDisconnected Type = "peer_disconnected"
+	TypePeerStateChanged Type = "peer_state_changed"
+	TypeAgentRegistered  Type = "agent_registered"
+	TypeAnnouncement     Type = "announcement"
+)
+
+type Event struct {
+	Type   Type        `json:"type"`
+	PeerID string      `json:"peer_id,omitempty"`
+	Time   int64       `json:"time"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// Bus fans events out to any number of subscribers. Each subscriber gets a
+// buffered channel; a subscriber that falls behind far enough to fill its
+// buffer is dropped rather than allowed to block publishers.
+type Bus struct {
+	mu         sync.Mutex
+	subs       map[*subscriber]struct{}
+	bufferSize int
+}
+
+type subscriber struct {
+	ch   chan Event
+	once sync.Once
+}
+
+func (s *subscriber) close() {
+	s.once.Do(func() { close(s.ch) })
+}
+
+func NewBus(bufferSize int) *Bus {
+	return &Bus{
+		subs:       make(map[*subscriber]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+// Publish delivers e to every subscriber without blocking. A nil Bus is a
+// no-op so callers don't have to guard optional wiring.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			delete(b.subs, sub)
+			sub.close()
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. The returned channel is closed when
+// the subscriber is dropped or the cancel func is called.
+func (b *Bus) Subscribe() (<-chan Event, func()) {
+	sub := &subscriber{ch: make(chan Event, b.bufferSize)}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	cancel := func() {
+		b.mu.Lock()
+		delete(b.subs, sub)
+		b.mu.Unlock()
+		sub.close()
+	}
+	return sub.ch, cancel
+}
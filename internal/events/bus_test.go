@@ -0,0 +1,119 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// receive reads the events waiting on ch without blocking, and reports
+// whether ch has been closed.
+func receive(ch <-chan Event) (got []Event, closed bool) {
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return got, true
+			}
+			got = append(got, e)
+		default:
+			return got, false
+		}
+	}
+}
+
+func TestBusPublish(t *testing.T) {
+	tests := []struct {
+		name        string
+		bufferSize  int
+		publish     int
+		wantEvents  int
+		wantDropped bool
+	}{
+		{name: "nothing published", bufferSize: 2},
+		{name: "within the buffer", bufferSize: 2, publish: 2, wantEvents: 2},
+		{name: "slow subscriber dropped", bufferSize: 2, publish: 3, wantEvents: 2, wantDropped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBus(tt.bufferSize)
+			first, cancelFirst := b.Subscribe()
+			defer cancelFirst()
+			second, cancelSecond := b.Subscribe()
+			defer cancelSecond()
+
+			for i := 0; i < tt.publish; i++ {
+				b.Publish(Event{Type: TypePeerConnected, PeerID: "peer"})
+			}
+
+			for _, ch := range []<-chan Event{first, second} {
+				got, closed := receive(ch)
+				if len(got) != tt.wantEvents || closed != tt.wantDropped {
+					t.Fatalf("got %d events, closed %v; want %d, %v", len(got), closed, tt.wantEvents, tt.wantDropped)
+				}
+				for _, e := range got {
+					if e.Type != TypePeerConnected || e.PeerID != "peer" || e.Time == 0 {
+						t.Fatalf("got event %+v", e)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestBusDroppedSubscriberMissesLaterEvents checks a dropped subscriber is
+// no longer published to while the others keep receiving.
+func TestBusDroppedSubscriberMissesLaterEvents(t *testing.T) {
+	b := NewBus(1)
+	slow, cancelSlow := b.Subscribe()
+	defer cancelSlow()
+
+	b.Publish(Event{Type: TypeAnnouncement})
+	b.Publish(Event{Type: TypeAnnouncement})
+	if got, closed := receive(slow); len(got) != 1 || !closed {
+		t.Fatalf("slow subscriber got %d events, closed %v", len(got), closed)
+	}
+
+	fresh, cancelFresh := b.Subscribe()
+	defer cancelFresh()
+	b.Publish(Event{Type: TypeAgentRegistered})
+	if got, _ := receive(fresh); len(got) != 1 || got[0].Type != TypeAgentRegistered {
+		t.Fatalf("new subscriber got %+v", got)
+	}
+}
+
+func TestBusPublishKeepsTime(t *testing.T) {
+	b := NewBus(1)
+	ch, cancel := b.Subscribe()
+	defer cancel()
+
+	b.Publish(Event{Type: TypePeerDisconnected, Time: 42})
+	if e := <-ch; e.Time != 42 {
+		t.Fatalf("Time = %d, want the publisher's 42", e.Time)
+	}
+
+	before := time.Now().Unix()
+	b.Publish(Event{Type: TypePeerDisconnected})
+	if e := <-ch; e.Time < before {
+		t.Fatalf("Time = %d, want stamped at publish", e.Time)
+	}
+}
+
+func TestBusCancel(t *testing.T) {
+	b := NewBus(1)
+	ch, cancel := b.Subscribe()
+	cancel()
+	cancel() // cancelling twice, or after being dropped, is safe
+
+	if _, ok := <-ch; ok {
+		t.Fatal("channel still open after cancel")
+	}
+	b.Publish(Event{Type: TypePeerConnected})
+	if len(b.subs) != 0 {
+		t.Fatalf("%d subscribers left after cancel", len(b.subs))
+	}
+}
+
+func TestNilBusPublish(t *testing.T) {
+	var b *Bus
+	b.Publish(Event{Type: TypePeerConnected})
+}
@@ -0,0 +1,199 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"go.uber.org/zap"
+)
+
+// DefaultWebhookRetry is how each delivery is retried unless told otherwise:
+// three attempts, a second apart and then two.
+var DefaultWebhookRetry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Second}
+
+const (
+	webhookTimeout = 10 * time.Second
+
+	// Each URL has a queue of webhookQueueSize events, delivered one at a
+	// time. Events that find it full are dropped.
+	webhookQueueSize = 256
+
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+)
+
+// WebhookNotifier POSTs every event on a Bus to a set of URLs. When a secret
+// is configured, each body is signed with HMAC-SHA256 and the hex digest is
+// sent as "sha256=<digest>" in the X-Webhook-Signature header.
+type WebhookNotifier struct {
+	urls   []*webhookQueue
+	secret string
+	retry  retry.Policy
+	client *http.Client
+	logger *zap.Logger
+}
+
+// webhookQueue holds the events waiting for delivery to one URL, so a slow
+// or dead endpoint holds up only its own deliveries.
+type webhookQueue struct {
+	url     string
+	events  chan webhookDelivery
+	dropped atomic.Int64
+	full    atomic.Bool // dropping since the last event that fit
+}
+
+type webhookDelivery struct {
+	eventType Type
+	body      []byte
+}
+
+// NewWebhookNotifier retries failed deliveries as policy says, or as
+// DefaultWebhookRetry does if it is zero.
+func NewWebhookNotifier(urls []string, secret string, policy retry.Policy, logger *zap.Logger) *WebhookNotifier {
+	if policy == (retry.Policy{}) {
+		policy = DefaultWebhookRetry
+	}
+	queues := make([]*webhookQueue, 0, len(urls))
+	for _, url := range urls {
+		queues = append(queues, &webhookQueue{url: url, events: make(chan webhookDelivery, webhookQueueSize)})
+	}
+	return &WebhookNotifier{
+		urls:   queues,
+		secret: secret,
+		retry:  policy,
+		client: &http.Client{Timeout: webhookTimeout},
+		logger: logger,
+	}
+}
+
+// Run delivers events until ctx is cancelled. If the bus drops the notifier
+// for falling behind, it resubscribes and carries on.
+func (n *WebhookNotifier) Run(ctx context.Context, bus *Bus) {
+	for _, q := range n.urls {
+		go n.work(ctx, q)
+	}
+
+	for {
+		ch, cancel := bus.Subscribe()
+		n.drain(ctx, ch)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+		n.logger.Warn("Webhook notifier fell behind, some events were dropped")
+	}
+}
+
+func (n *WebhookNotifier) drain(ctx context.Context, ch <-chan Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			body, err := json.Marshal(e)
+			if err != nil {
+				n.logger.Error("Failed to marshal webhook event", zap.Error(err))
+				continue
+			}
+			for _, q := range n.urls {
+				n.enqueue(q, webhookDelivery{eventType: e.Type, body: body})
+			}
+		}
+	}
+}
+
+// enqueue queues d for q's URL, dropping it if the queue is full.
+func (n *WebhookNotifier) enqueue(q *webhookQueue, d webhookDelivery) {
+	select {
+	case q.events <- d:
+		q.full.Store(false)
+	default:
+		dropped := q.dropped.Add(1)
+		if !q.full.Swap(true) {
+			n.logger.Warn("Webhook queue full, dropping events",
+				zap.String("url", q.url),
+				zap.Int64("dropped", dropped))
+		}
+	}
+}
+
+// work delivers q's events in order until ctx is cancelled.
+func (n *WebhookNotifier) work(ctx context.Context, q *webhookQueue) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-q.events:
+			n.deliver(ctx, q.url, d.eventType, d.body)
+		}
+	}
+}
+
+func (n *WebhookNotifier) deliver(ctx context.Context, url string, eventType Type, body []byte) {
+	err := n.retry.Do(ctx, func(ctx context.Context) error {
+		return n.post(ctx, url, eventType, body)
+	})
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	n.logger.Warn("Webhook delivery failed",
+		zap.String("url", url),
+		zap.String("event", string(eventType)),
+		zap.Error(err))
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, url string, eventType Type, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(eventType))
+	if n.secret != "" {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		if permanentStatus(resp.StatusCode) {
+			return retry.Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// permanentStatus reports whether a webhook answering with status would
+// refuse the same delivery again: client errors other than a timeout or rate
+// limiting.
+func permanentStatus(status int) bool {
+	return status >= 400 && status < 500 &&
+		status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 of body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
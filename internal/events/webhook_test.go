@@ -0,0 +1,196 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"go.uber.org/zap"
+)
+
+// webhookRequest is what a test endpoint saw of one delivery.
+type webhookRequest struct {
+	event     string
+	signature string
+	body      []byte
+}
+
+// webhookEndpoint records the deliveries it gets, answering each with the
+// next of statuses and then with 200 once they run out.
+type webhookEndpoint struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []webhookRequest
+	received chan struct{}
+}
+
+func newWebhookEndpoint(t *testing.T, statuses ...int) (*webhookEndpoint, string) {
+	e := &webhookEndpoint{statuses: statuses, received: make(chan struct{}, 16)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e.mu.Lock()
+		e.requests = append(e.requests, webhookRequest{
+			event:     r.Header.Get(EventHeader),
+			signature: r.Header.Get(SignatureHeader),
+			body:      body,
+		})
+		status := http.StatusOK
+		if len(e.statuses) > 0 {
+			status, e.statuses = e.statuses[0], e.statuses[1:]
+		}
+		e.mu.Unlock()
+		w.WriteHeader(status)
+		e.received <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return e, srv.URL
+}
+
+// wait blocks until the endpoint has seen n requests.
+func (e *webhookEndpoint) wait(t *testing.T, n int) []webhookRequest {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-e.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("endpoint got %d requests, want %d", i, n)
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]webhookRequest(nil), e.requests...)
+}
+
+// fastWebhookRetry is DefaultWebhookRetry with its delays shortened.
+var fastWebhookRetry = retry.Policy{MaxAttempts: DefaultWebhookRetry.MaxAttempts, BaseDelay: time.Millisecond}
+
+func TestWebhookNotifierDelivers(t *testing.T) {
+	event := Event{Type: TypeAgentRegistered, PeerID: "peer", Time: 1}
+	body, _ := json.Marshal(event)
+
+	tests := []struct {
+		name         string
+		secret       string
+		statuses     []int
+		wantRequests int
+	}{
+		{name: "unsigned", wantRequests: 1},
+		{name: "signed", secret: "s3cret", wantRequests: 1},
+		{name: "retried after a server error", statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable}, wantRequests: 3},
+		{name: "retried after rate limiting", statuses: []int{http.StatusTooManyRequests}, wantRequests: 2},
+		{name: "client error not retried", statuses: []int{http.StatusBadRequest}, wantRequests: 1},
+		{name: "gives up after its attempts", statuses: []int{500, 500, 500, 500}, wantRequests: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, url := newWebhookEndpoint(t, tt.statuses...)
+			n := NewWebhookNotifier([]string{url}, tt.secret, fastWebhookRetry, zap.NewNop())
+
+			n.deliver(context.Background(), url, event.Type, body)
+
+			requests := endpoint.wait(t, tt.wantRequests)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("endpoint got %d requests, want %d", len(requests), tt.wantRequests)
+			}
+			for _, r := range requests {
+				if r.event != string(event.Type) || string(r.body) != string(body) {
+					t.Fatalf("got event %q body %s", r.event, r.body)
+				}
+				wantSignature := ""
+				if tt.secret != "" {
+					wantSignature = "sha256=" + Sign(tt.secret, body)
+				}
+				if r.signature != wantSignature {
+					t.Fatalf("signature %q, want %q", r.signature, wantSignature)
+				}
+			}
+		})
+	}
+}
+
+// TestWebhookNotifierRun publishes on a bus and checks every URL gets each
+// event, in order.
+func TestWebhookNotifierRun(t *testing.T) {
+	first, firstURL := newWebhookEndpoint(t)
+	second, secondURL := newWebhookEndpoint(t)
+	bus := NewBus(8)
+	n := NewWebhookNotifier([]string{firstURL, secondURL}, "", retry.Policy{}, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		n.Run(ctx, bus)
+		close(done)
+	}()
+
+	// Run subscribes in the background; wait for it before publishing.
+	sent := []Type{TypePeerConnected, TypeAnnouncement, TypePeerDisconnected}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		bus.mu.Lock()
+		subscribed := len(bus.subs) > 0
+		bus.mu.Unlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("notifier never subscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, typ := range sent {
+		bus.Publish(Event{Type: typ})
+	}
+
+	for _, endpoint := range []*webhookEndpoint{first, second} {
+		requests := endpoint.wait(t, len(sent))
+		for i, r := range requests {
+			if r.event != string(sent[i]) {
+				t.Fatalf("request %d is %q, want %q", i, r.event, sent[i])
+			}
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run kept going after its context was cancelled")
+	}
+}
+
+func TestWebhookEnqueueDropsWhenFull(t *testing.T) {
+	n := NewWebhookNotifier([]string{"http://127.0.0.1:0"}, "", retry.Policy{}, zap.NewNop())
+	q := n.urls[0]
+
+	for i := 0; i < webhookQueueSize+3; i++ {
+		n.enqueue(q, webhookDelivery{eventType: TypeAnnouncement})
+	}
+	if got := len(q.events); got != webhookQueueSize {
+		t.Fatalf("queued %d, want %d", got, webhookQueueSize)
+	}
+	if got := q.dropped.Load(); got != 3 || !q.full.Load() {
+		t.Fatalf("dropped %d, full %v", got, q.full.Load())
+	}
+
+	<-q.events
+	n.enqueue(q, webhookDelivery{eventType: TypeAnnouncement})
+	if q.full.Load() {
+		t.Fatal("queue still marked full after an event fit")
+	}
+}
+
+func TestSign(t *testing.T) {
+	// From RFC 4231, test case 2.
+	got := Sign("Jefe", []byte("what do ya want for nothing?"))
+	want := "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+}
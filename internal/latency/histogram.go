@@ -0,0 +1,158 @@
+// Package latency records durations in HDR-style histograms, so percentiles
+// stay accurate from microseconds to minutes in a fixed amount of memory.
+package latency
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+const (
+	// Values are stored in microseconds. Below subBucketCount they are exact;
+	// above, each power of two is split into subBucketCount/2 linear steps, so
+	// a recorded value is off by at most 1/32 (about 3%).
+	subBucketBits  = 6
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+
+	// maxValue is the largest duration tracked; longer samples are clamped.
+	maxValue = time.Hour
+
+	// maxKeys bounds how many histograms a Tracker holds.
+	maxKeys = 1024
+)
+
+var numBuckets = bucketIndex(uint64(maxValue/time.Microsecond)) + 1
+
+// Histogram counts samples in log-linear buckets. It is not safe for
+// concurrent use; Tracker serialises access.
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	max    time.Duration
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]uint64, numBuckets)}
+}
+
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	top := v >> shift
+	return subBucketCount + (shift-1)*subBucketHalf + int(top-subBucketHalf)
+}
+
+// bucketUpper is the highest value that lands in bucket i.
+func bucketUpper(i int) uint64 {
+	if i < subBucketCount {
+		return uint64(i)
+	}
+	k := i - subBucketCount
+	shift := k/subBucketHalf + 1
+	top := uint64(k%subBucketHalf + subBucketHalf)
+	return (top+1)<<shift - 1
+}
+
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	if d > maxValue {
+		d = maxValue
+	}
+	h.counts[bucketIndex(uint64(d/time.Microsecond))]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Percentile returns the value at or below which p percent of samples fall
+// (the nearest rank), never more than the largest sample seen.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p / 100 * float64(h.total)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return min(time.Duration(bucketUpper(i))*time.Microsecond, h.max)
+		}
+	}
+	return h.max
+}
+
+type Stats struct {
+	Count uint64
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func (h *Histogram) Stats() Stats {
+	return Stats{
+		Count: h.total,
+		P50:   h.Percentile(50),
+		P90:   h.Percentile(90),
+		P99:   h.Percentile(99),
+		Max:   h.max,
+	}
+}
+
+// Tracker keeps one histogram per key, e.g. per peer or per provider. Once
+// maxKeys keys are tracked, samples for new keys are dropped.
+type Tracker struct {
+	mu    sync.Mutex
+	hists map[string]*Histogram
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{hists: make(map[string]*Histogram)}
+}
+
+func (t *Tracker) Record(key string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.hists[key]
+	if !ok {
+		if len(t.hists) >= maxKeys {
+			return
+		}
+		h = NewHistogram()
+		t.hists[key] = h
+	}
+	h.Record(d)
+}
+
+// Snapshot returns the current stats for every key.
+func (t *Tracker) Snapshot() map[string]Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make(map[string]Stats, len(t.hists))
+	for key, h := range t.hists {
+		out[key] = h.Stats()
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package latency
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestBuckets checks every bucket's range: each starts right after the one
+// before it, and a value lands in the bucket whose range holds it.
+func TestBuckets(t *testing.T) {
+	for i := 1; i < numBuckets; i++ {
+		lower := bucketUpper(i-1) + 1
+		upper := bucketUpper(i)
+		if upper < lower {
+			t.Fatalf("bucket %d is empty: [%d, %d]", i, lower, upper)
+		}
+		if bucketIndex(lower) != i || bucketIndex(upper) != i {
+			t.Fatalf("bucket %d [%d, %d] indexes as %d and %d", i, lower, upper, bucketIndex(lower), bucketIndex(upper))
+		}
+		// Past the exact range, a bucket is at most 1/32 of its values wide.
+		if lower >= subBucketCount && (upper-lower+1)*subBucketHalf > lower {
+			t.Fatalf("bucket %d [%d, %d] too wide", i, lower, upper)
+		}
+	}
+	if got := bucketIndex(uint64(maxValue / time.Microsecond)); got != numBuckets-1 {
+		t.Fatalf("maxValue in bucket %d of %d", got, numBuckets)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		p       float64
+		want    time.Duration
+	}{
+		{name: "no samples", p: 50, want: 0},
+		{name: "one sample", samples: []time.Duration{5 * time.Millisecond}, p: 99, want: 5 * time.Millisecond},
+		{name: "exact below 64us", samples: []time.Duration{10 * time.Microsecond, 20 * time.Microsecond, 30 * time.Microsecond}, p: 50, want: 20 * time.Microsecond},
+		{name: "lowest percentile", samples: []time.Duration{time.Microsecond, time.Second}, p: 0, want: time.Microsecond},
+		{name: "negative clamped", samples: []time.Duration{-time.Second}, p: 50, want: 0},
+		{name: "over an hour clamped", samples: []time.Duration{2 * time.Hour}, p: 50, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHistogram()
+			for _, d := range tt.samples {
+				h.Record(d)
+			}
+			if got := h.Percentile(tt.p); got != tt.want {
+				t.Fatalf("p%v = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestStats records 1ms to 1000ms and checks the percentiles are within the
+// histogram's 1/32 precision, never above the largest sample.
+func TestStats(t *testing.T) {
+	h := NewHistogram()
+	for i := 1000; i >= 1; i-- {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	s := h.Stats()
+	if s.Count != 1000 || s.Max != time.Second {
+		t.Fatalf("count %d, max %v", s.Count, s.Max)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want time.Duration
+	}{
+		{"p50", s.P50, 500 * time.Millisecond},
+		{"p90", s.P90, 900 * time.Millisecond},
+		{"p99", s.P99, 990 * time.Millisecond},
+	} {
+		if c.got < c.want || c.got > c.want+c.want/32 || c.got > s.Max {
+			t.Errorf("%s = %v, want %v to within 1/32", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+	tr.Record("a", time.Millisecond)
+	tr.Record("a", 3*time.Millisecond)
+	tr.Record("b", time.Second)
+
+	snap := tr.Snapshot()
+	if len(snap) != 2 || snap["a"].Count != 2 || snap["a"].Max != 3*time.Millisecond || snap["b"].Count != 1 {
+		t.Fatalf("snapshot %+v", snap)
+	}
+}
+
+// TestTrackerKeyLimit checks samples for keys past maxKeys are dropped while
+// keys already tracked keep recording.
+func TestTrackerKeyLimit(t *testing.T) {
+	tr := NewTracker()
+	for i := 0; i < maxKeys; i++ {
+		tr.Record(fmt.Sprint(i), time.Millisecond)
+	}
+	tr.Record("new", time.Millisecond)
+	tr.Record("0", time.Millisecond)
+
+	snap := tr.Snapshot()
+	if _, ok := snap["new"]; ok || len(snap) != maxKeys {
+		t.Fatalf("%d keys tracked, new key tracked %v", len(snap), ok)
+	}
+	if snap["0"].Count != 2 {
+		t.Fatalf("tracked key has %d samples, want 2", snap["0"].Count)
+	}
+}
@@ -0,0 +1,159 @@
+// Package logstream keeps recent log entries in memory and fans live entries
+// out to subscribers, so a daemonized agent's logs can be tailed over HTTP.
+package logstream
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+
+	level zapcore.Level
+}
+
+// Hub holds a ring of the most recent entries and the live subscribers. Like
+// events.Bus, a subscriber that fills its buffer is dropped rather than
+// allowed to block logging.
+type Hub struct {
+	mu      sync.Mutex
+	history []Entry
+	next    int
+	full    bool
+	subs    map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	ch    chan Entry
+	min   zapcore.Level
+	once  sync.Once
+	close func()
+}
+
+const subscriberBuffer = 256
+
+func NewHub(historySize int) *Hub {
+	return &Hub{
+		history: make([]Entry, historySize),
+		subs:    make(map[*subscriber]struct{}),
+	}
+}
+
+// Core returns a zapcore.Core that feeds the hub. Tee it with the regular
+// output core.
+func (h *Hub) Core(enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{hub: h, LevelEnabler: enab}
+}
+
+// Subscribe returns the buffered entries at or above min, oldest first, and a
+// channel of live entries. The channel is closed when cancel is called or the
+// subscriber falls too far behind.
+func (h *Hub) Subscribe(min zapcore.Level) ([]Entry, <-chan Entry, func()) {
+	sub := &subscriber{ch: make(chan Entry, subscriberBuffer), min: min}
+	sub.close = func() { sub.once.Do(func() { close(sub.ch) }) }
+
+	h.mu.Lock()
+	recent := h.recentLocked(min)
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	cancel := func() {
+		h.mu.Lock()
+		delete(h.subs, sub)
+		h.mu.Unlock()
+		sub.close()
+	}
+	return recent, sub.ch, cancel
+}
+
+func (h *Hub) recentLocked(min zapcore.Level) []Entry {
+	var ordered []Entry
+	if h.full {
+		ordered = append(ordered, h.history[h.next:]...)
+	}
+	ordered = append(ordered, h.history[:h.next]...)
+
+	recent := make([]Entry, 0, len(ordered))
+	for _, e := range ordered {
+		if e.level >= min {
+			recent = append(recent, e)
+		}
+	}
+	return recent
+}
+
+func (h *Hub) publish(e Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.history) > 0 {
+		h.history[h.next] = e
+		h.next = (h.next + 1) % len(h.history)
+		h.full = h.full || h.next == 0
+	}
+
+	for sub := range h.subs {
+		if e.level < sub.min {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			delete(h.subs, sub)
+			sub.close()
+		}
+	}
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	hub    *Hub
+	fields []zapcore.Field
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	return &core{
+		LevelEnabler: c.LevelEnabler,
+		hub:          c.hub,
+		fields:       append(append([]zapcore.Field(nil), c.fields...), fields...),
+	}
+}
+
+func (c *core) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *core) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	entry := Entry{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+		level:   e.Level,
+	}
+	if len(enc.Fields) > 0 {
+		entry.Fields = enc.Fields
+	}
+	c.hub.publish(entry)
+	return nil
+}
+
+func (c *core) Sync() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+package logstream
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func messages(entries []Entry) []string {
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Message)
+	}
+	return out
+}
+
+// TestHubHistory logs through a hub's core and checks what a new subscriber
+// is handed: the most recent entries at or above its level, oldest first.
+func TestHubHistory(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		logged int
+		min    zapcore.Level
+		want   string
+	}{
+		{name: "empty", size: 4, min: zapcore.DebugLevel, want: "[]"},
+		{name: "partly filled", size: 4, logged: 2, min: zapcore.DebugLevel, want: "[debug 0 info 1]"},
+		{name: "wrapped", size: 4, logged: 6, min: zapcore.DebugLevel, want: "[warn 2 error 3 debug 4 info 5]"},
+		{name: "exactly full", size: 4, logged: 4, min: zapcore.DebugLevel, want: "[debug 0 info 1 warn 2 error 3]"},
+		{name: "filtered by level", size: 4, logged: 6, min: zapcore.WarnLevel, want: "[warn 2 error 3]"},
+		{name: "no history", size: 0, logged: 3, min: zapcore.DebugLevel, want: "[]"},
+	}
+	levels := []zapcore.Level{zapcore.DebugLevel, zapcore.InfoLevel, zapcore.WarnLevel, zapcore.ErrorLevel}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub(tt.size)
+			logger := zap.New(h.Core(zapcore.DebugLevel))
+			for i := 0; i < tt.logged; i++ {
+				level := levels[i%len(levels)]
+				logger.Check(level, fmt.Sprintf("%s %d", level, i)).Write()
+			}
+
+			recent, _, cancel := h.Subscribe(tt.min)
+			defer cancel()
+			if got := fmt.Sprint(messages(recent)); got != tt.want {
+				t.Fatalf("history %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHubSubscribe(t *testing.T) {
+	h := NewHub(8)
+	logger := zap.New(h.Core(zapcore.InfoLevel)).With(zap.String("component", "test"))
+
+	_, all, cancelAll := h.Subscribe(zapcore.DebugLevel)
+	defer cancelAll()
+	_, warnings, cancelWarnings := h.Subscribe(zapcore.WarnLevel)
+	defer cancelWarnings()
+
+	logger.Debug("below the core's level")
+	logger.Info("started", zap.Int("port", 8080))
+	logger.Warn("slow peer")
+
+	e := <-all
+	if e.Message != "started" || e.Level != "info" || e.Time.IsZero() {
+		t.Fatalf("got %+v", e)
+	}
+	if e.Fields["component"] != "test" || e.Fields["port"] != int64(8080) {
+		t.Fatalf("fields %v", e.Fields)
+	}
+	if e := <-all; e.Message != "slow peer" {
+		t.Fatalf("got %+v", e)
+	}
+	if e := <-warnings; e.Message != "slow peer" {
+		t.Fatalf("warnings subscriber got %+v", e)
+	}
+	select {
+	case e := <-all:
+		t.Fatalf("unexpected entry %+v", e)
+	default:
+	}
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	h := NewHub(0)
+	logger := zap.New(h.Core(zapcore.DebugLevel))
+	_, ch, cancel := h.Subscribe(zapcore.DebugLevel)
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		logger.Info("entry")
+	}
+	for i := 0; i < subscriberBuffer; i++ {
+		<-ch
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("slow subscriber not dropped")
+	}
+	if len(h.subs) != 0 {
+		t.Fatal("dropped subscriber still registered")
+	}
+	cancel() // cancelling a dropped subscriber is safe
+}
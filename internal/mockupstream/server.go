@@ -0,0 +1,229 @@
+// Package mockupstream is an in-process stand-in for the OpenAI API used by
+// --mock-upstream. It returns deterministic responses so demos and CI can run
+// the full P2P routing path without a real API key. Not for production use.
+package mockupstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"go.uber.org/zap"
+)
+
+// fixedCreated keeps responses byte-for-byte reproducible.
+const fixedCreated = 1700000000
+
+type Server struct {
+	listener   net.Listener
+	httpServer *http.Server
+	logger     *zap.Logger
+}
+
+// Start listens on a random loopback port and serves until Close.
+func Start(logger *zap.Logger) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for mock upstream: %w", err)
+	}
+
+	s := &Server{listener: listener, logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/chat/completions", s.chatCompletions)
+	mux.HandleFunc("/v1/models", s.listModels)
+	s.httpServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("Mock upstream error", zap.Error(err))
+		}
+	}()
+
+	return s, nil
+}
+
+// BaseURL is the OpenAI-style base URL (including /v1) to point providers at.
+func (s *Server) BaseURL() string {
+	return "http://" + s.listener.Addr().String() + "/v1"
+}
+
+func (s *Server) Close(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *Server) chatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req api.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":{"message":"invalid request body"}}`, http.StatusBadRequest)
+		return
+	}
+
+	prompt := ""
+	if len(req.Messages) > 0 {
+		prompt = req.Messages[len(req.Messages)-1].Content
+	}
+	id := "chatcmpl-mock-" + digest(req.Model+"\x00"+prompt)
+	message := api.Message{Role: "assistant", Content: "Mock response to: " + prompt}
+	finish := "stop"
+	completionTokens := countTokens(message.Content)
+	if tool := firstTool(req.Tools); tool != "" {
+		args, _ := json.Marshal(map[string]string{"prompt": prompt})
+		message = api.Message{Role: "assistant", ToolCalls: []api.ToolCall{{
+			ID:       "call_mock_" + digest(id),
+			Type:     "function",
+			Function: api.FunctionCall{Name: tool, Arguments: string(args)},
+		}}}
+		finish = "tool_calls"
+		completionTokens = countTokens(string(args))
+	}
+
+	promptTokens := 0
+	for _, m := range req.Messages {
+		promptTokens += countTokens(m.Content)
+	}
+
+	resp := api.ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: fixedCreated,
+		Model:   req.Model,
+		Choices: []api.Choice{{
+			Index:        0,
+			Message:      message,
+			FinishReason: finish,
+		}},
+		Usage: api.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		},
+	}
+
+	w.Header().Set("x-request-id", "req-mock-"+digest(resp.ID))
+	if req.Stream {
+		s.streamCompletion(w, &resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// firstTool is the name of the first function in a request's tools, which
+// the mock always calls. Empty if the request offers none.
+func firstTool(tools json.RawMessage) string {
+	var list []struct {
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if len(tools) == 0 || json.Unmarshal(tools, &list) != nil {
+		return ""
+	}
+	for _, tool := range list {
+		if tool.Function.Name != "" {
+			return tool.Function.Name
+		}
+	}
+	return ""
+}
+
+// streamCompletion sends resp as server-sent events, one word per chunk, with
+// usage on the final chunk. A tool call is sent as OpenAI does: its ID and
+// name first, then its arguments a few bytes at a time.
+func (s *Server) streamCompletion(w http.ResponseWriter, resp *api.ChatCompletionResponse) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+
+	write := func(chunk api.ChatCompletionChunk) {
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	chunk := func(delta api.Delta, finish *string) api.ChatCompletionChunk {
+		return api.ChatCompletionChunk{
+			ID:      resp.ID,
+			Object:  "chat.completion.chunk",
+			Created: resp.Created,
+			Model:   resp.Model,
+			Choices: []api.ChunkChoice{{Index: 0, Delta: delta, FinishReason: finish}},
+		}
+	}
+
+	message := resp.Choices[0].Message
+	if len(message.ToolCalls) > 0 {
+		write(chunk(api.Delta{Role: "assistant"}, nil))
+		for i, call := range message.ToolCalls {
+			write(chunk(api.Delta{ToolCalls: []api.ToolCallDelta{{
+				Index:    i,
+				ID:       call.ID,
+				Type:     call.Type,
+				Function: api.FunctionCallDelta{Name: call.Function.Name},
+			}}}, nil))
+			args := call.Function.Arguments
+			for len(args) > 0 {
+				n := min(len(args), 8)
+				write(chunk(api.Delta{ToolCalls: []api.ToolCallDelta{{
+					Index:    i,
+					Function: api.FunctionCallDelta{Arguments: args[:n]},
+				}}}, nil))
+				args = args[n:]
+			}
+		}
+	} else {
+		words := strings.SplitAfter(message.Content, " ")
+		for i, word := range words {
+			delta := api.Delta{Content: word}
+			if i == 0 {
+				delta.Role = "assistant"
+			}
+			write(chunk(delta, nil))
+		}
+	}
+
+	stop := resp.Choices[0].FinishReason
+	last := chunk(api.Delta{}, &stop)
+	last.Usage = &resp.Usage
+	write(last)
+	io.WriteString(w, "data: [DONE]\n\n")
+}
+
+func (s *Server) listModels(w http.ResponseWriter, r *http.Request) {
+	resp := api.ModelsResponse{
+		Object: "list",
+		Data: []api.Model{
+			{ID: "gpt-4", Object: "model", Created: fixedCreated, OwnedBy: "mock"},
+			{ID: "gpt-3.5-turbo", Object: "model", Created: fixedCreated, OwnedBy: "mock"},
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// countTokens is a whitespace word count; close enough for a mock.
+func countTokens(s string) int {
+	return len(strings.Fields(s))
+}
+
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,203 @@
+package mockupstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/api"
+	"go.uber.org/zap"
+)
+
+func startServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := Start(zap.NewNop())
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s
+}
+
+func post(t *testing.T, s *Server, body string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(s.BaseURL()+"/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestChatCompletions(t *testing.T) {
+	s := startServer(t)
+
+	tests := []struct {
+		name        string
+		body        string
+		wantContent string
+		wantTool    string
+		wantFinish  string
+		wantUsage   api.Usage
+	}{
+		{
+			name:        "reply",
+			body:        `{"model":"gpt-4","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hello there"}]}`,
+			wantContent: "Mock response to: hello there",
+			wantFinish:  "stop",
+			wantUsage:   api.Usage{PromptTokens: 4, CompletionTokens: 5, TotalTokens: 9},
+		},
+		{
+			name:       "tool call",
+			body:       `{"model":"gpt-4","messages":[{"role":"user","content":"weather?"}],"tools":[{"type":"function","function":{"name":"get_weather"}}]}`,
+			wantTool:   "get_weather",
+			wantFinish: "tool_calls",
+			wantUsage:  api.Usage{PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first api.ChatCompletionResponse
+			for i := 0; i < 2; i++ {
+				resp := post(t, s, tt.body)
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("status %d", resp.StatusCode)
+				}
+				var got api.ChatCompletionResponse
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					t.Fatalf("decoding: %v", err)
+				}
+				if i == 0 {
+					first = got
+					if resp.Header.Get("x-request-id") == "" {
+						t.Fatal("no x-request-id")
+					}
+					continue
+				}
+				if got.ID != first.ID || got.Created != fixedCreated {
+					t.Fatalf("second response %s at %d, want the same %s", got.ID, got.Created, first.ID)
+				}
+			}
+
+			choice := first.Choices[0]
+			if choice.Message.Content != tt.wantContent || choice.FinishReason != tt.wantFinish || first.Usage != tt.wantUsage {
+				t.Fatalf("got %+v, usage %+v", choice, first.Usage)
+			}
+			if tt.wantTool != "" {
+				if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Name != tt.wantTool {
+					t.Fatalf("tool calls %+v", choice.Message.ToolCalls)
+				}
+				if args := choice.Message.ToolCalls[0].Function.Arguments; args != `{"prompt":"weather?"}` {
+					t.Fatalf("arguments %s", args)
+				}
+			}
+		})
+	}
+}
+
+// TestChatCompletionsStream checks a streamed reply reassembles into the
+// non-streamed one, with usage on the last chunk before [DONE].
+func TestChatCompletionsStream(t *testing.T) {
+	s := startServer(t)
+	const request = `"model":"gpt-4","messages":[{"role":"user","content":"hello there"}]`
+	tools := `,"tools":[{"type":"function","function":{"name":"get_weather"}}]`
+
+	for _, tt := range []struct{ name, extra string }{{"reply", ""}, {"tool call", tools}} {
+		t.Run(tt.name, func(t *testing.T) {
+			var want api.ChatCompletionResponse
+			json.NewDecoder(post(t, s, "{"+request+tt.extra+"}").Body).Decode(&want)
+
+			resp := post(t, s, "{"+request+tt.extra+`,"stream":true}`)
+			if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+				t.Fatalf("Content-Type %q", got)
+			}
+
+			var content, args, finish string
+			var usage *api.Usage
+			done := false
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+				if !ok {
+					continue
+				}
+				if data == "[DONE]" {
+					done = true
+					break
+				}
+				var chunk api.ChatCompletionChunk
+				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+					t.Fatalf("chunk %s: %v", data, err)
+				}
+				if chunk.ID != want.ID || usage != nil {
+					t.Fatalf("chunk %s after usage %v", data, usage)
+				}
+				delta := chunk.Choices[0].Delta
+				content += delta.Content
+				for _, call := range delta.ToolCalls {
+					args += call.Function.Arguments
+				}
+				if chunk.Choices[0].FinishReason != nil {
+					finish = *chunk.Choices[0].FinishReason
+				}
+				usage = chunk.Usage
+			}
+			if !done || usage == nil || *usage != want.Usage {
+				t.Fatalf("done %v, usage %v, want %+v", done, usage, want.Usage)
+			}
+
+			message := want.Choices[0].Message
+			wantArgs := ""
+			if len(message.ToolCalls) > 0 {
+				wantArgs = message.ToolCalls[0].Function.Arguments
+			}
+			if content != message.Content || args != wantArgs || finish != want.Choices[0].FinishReason {
+				t.Fatalf("streamed %q args %q finish %q, want %q %q %q",
+					content, args, finish, message.Content, wantArgs, want.Choices[0].FinishReason)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsErrors(t *testing.T) {
+	s := startServer(t)
+
+	resp := post(t, s, "{not json")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad body: status %d", resp.StatusCode)
+	}
+
+	get, err := http.Get(s.BaseURL() + "/chat/completions")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	get.Body.Close()
+	if get.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET: status %d", get.StatusCode)
+	}
+}
+
+func TestListModels(t *testing.T) {
+	s := startServer(t)
+
+	resp, err := http.Get(s.BaseURL() + "/models")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var models api.ModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	var ids []string
+	for _, m := range models.Data {
+		ids = append(ids, m.ID)
+	}
+	if got := strings.Join(ids, ","); got != "gpt-4,gpt-3.5-turbo" {
+		t.Fatalf("models %s", got)
+	}
+}
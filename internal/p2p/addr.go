@@ -0,0 +1,229 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const ExamplePeerAddr = "/ip4/192.168.1.100/tcp/9000/p2p/12D3KooW..."
+
+// AddrError describes a peer address that couldn't be used, along with a hint
+// about what the user most likely meant.
+type AddrError struct {
+	Addr   string
+	Reason string
+	Hint   string
+}
+
+func (e *AddrError) Error() string {
+	msg := fmt.Sprintf("invalid peer address %q: %s", e.Addr, e.Reason)
+	if e.Hint != "" {
+		msg += ". " + e.Hint
+	}
+	return msg
+}
+
+// ParsePeerAddr validates a full peer multiaddr (transport address plus
+// /p2p/<peer-id>). /dnsaddr addresses may omit the peer ID since it is
+// published in their TXT records. Common mistakes are reported as *AddrError
+// with a suggestion of the correct format.
+func ParsePeerAddr(addr string) (multiaddr.Multiaddr, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return nil, &AddrError{Addr: addr, Reason: "address is empty", Hint: "Expected format: " + ExamplePeerAddr}
+	}
+
+	if !strings.HasPrefix(addr, "/") {
+		return nil, &AddrError{Addr: addr, Reason: "not a multiaddr", Hint: suggestMultiaddr(addr)}
+	}
+
+	ma, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return nil, &AddrError{Addr: addr, Reason: err.Error(), Hint: "Expected format: " + ExamplePeerAddr}
+	}
+
+	if isDNSAddr(ma) {
+		return ma, nil
+	}
+
+	if _, err := ma.ValueForProtocol(multiaddr.P_P2P); err != nil {
+		return nil, &AddrError{
+			Addr:   addr,
+			Reason: "missing /p2p/<peer-id> suffix",
+			Hint:   fmt.Sprintf("Append the peer ID shown when the remote agent starts, e.g. %s/p2p/12D3KooW...", addr),
+		}
+	}
+
+	if !hasTransport(ma) {
+		return nil, &AddrError{
+			Addr:   addr,
+			Reason: "no supported transport",
+			Hint:   "Agents listen on TCP; use /tcp/<port>, e.g. " + ExamplePeerAddr,
+		}
+	}
+
+	return ma, nil
+}
+
+// resolvePeerAddr expands a parsed address into dialable peer infos. /dns4
+// and /dns6 components are resolved by the swarm at dial time; /dnsaddr has
+// to be resolved up front because it can name several peers.
+func resolvePeerAddr(ctx context.Context, ma multiaddr.Multiaddr) ([]peer.AddrInfo, error) {
+	addrs := []multiaddr.Multiaddr{ma}
+	if isDNSAddr(ma) {
+		resolved, err := madns.DefaultResolver.Resolve(ctx, ma)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", ma, err)
+		}
+
+		addrs = addrs[:0]
+		for _, r := range resolved {
+			if _, err := r.ValueForProtocol(multiaddr.P_P2P); err == nil {
+				addrs = append(addrs, r)
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, &AddrError{Addr: ma.String(), Reason: "dnsaddr record lists no peers"}
+		}
+	}
+
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, &AddrError{Addr: ma.String(), Reason: err.Error(), Hint: "Expected format: " + ExamplePeerAddr}
+	}
+	return infos, nil
+}
+
+func isDNSAddr(ma multiaddr.Multiaddr) bool {
+	_, err := ma.ValueForProtocol(multiaddr.P_DNSADDR)
+	return err == nil
+}
+
+func hasTransport(ma multiaddr.Multiaddr) bool {
+	for _, code := range []int{multiaddr.P_TCP, multiaddr.P_QUIC_V1, multiaddr.P_WS, multiaddr.P_CIRCUIT} {
+		if _, err := ma.ValueForProtocol(code); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestMultiaddr turns "1.2.3.4:9000" or "[::1]:9000" style input into a
+// multiaddr hint.
+func suggestMultiaddr(addr string) string {
+	trimmed := addr
+	if i := strings.Index(trimmed, "://"); i >= 0 {
+		trimmed = trimmed[i+3:]
+	}
+	trimmed = strings.TrimSuffix(trimmed, "/")
+
+	host, port, err := net.SplitHostPort(trimmed)
+	if err != nil {
+		host, port = strings.TrimSuffix(strings.TrimPrefix(trimmed, "["), "]"), "9000"
+	}
+
+	proto := "dns4"
+	if ip := net.ParseIP(host); ip != nil {
+		proto = "ip4"
+		if ip.To4() == nil {
+			proto = "ip6"
+		}
+	}
+	return fmt.Sprintf("Did you mean /%s/%s/tcp/%s/p2p/<peer-id>?", proto, host, port)
+}
+
+// sameSubnetHeadStart is how long addresses on one of our own subnets are
+// dialled before any other. Peers on the same LAN answer well within it.
+const sameSubnetHeadStart = 100 * time.Millisecond
+
+// localFirstDialRanker dials addresses on one of our own subnets at once and
+// holds the libp2p default schedule for the rest back by sameSubnetHeadStart,
+// so agents behind the same NAT connect over the LAN rather than a public or
+// relayed path.
+func localFirstDialRanker(addrs []multiaddr.Multiaddr) []network.AddrDelay {
+	local, rest := splitLocalAddrs(addrs, localSubnets())
+	if len(local) == 0 {
+		return swarm.DefaultDialRanker(addrs)
+	}
+
+	ranked := make([]network.AddrDelay, 0, len(addrs))
+	for _, addr := range local {
+		ranked = append(ranked, network.AddrDelay{Addr: addr})
+	}
+	for _, d := range swarm.DefaultDialRanker(rest) {
+		d.Delay += sameSubnetHeadStart
+		ranked = append(ranked, d)
+	}
+	return ranked
+}
+
+// orderAddrs drops duplicate addresses and moves those on one of our own
+// subnets to the front.
+func orderAddrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	seen := make(map[string]bool, len(addrs))
+	unique := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if key := addr.String(); !seen[key] {
+			seen[key] = true
+			unique = append(unique, addr)
+		}
+	}
+
+	local, rest := splitLocalAddrs(unique, localSubnets())
+	return append(local, rest...)
+}
+
+func splitLocalAddrs(addrs []multiaddr.Multiaddr, subnets []*net.IPNet) (local, rest []multiaddr.Multiaddr) {
+	for _, addr := range addrs {
+		if onSubnet(addr, subnets) {
+			local = append(local, addr)
+		} else {
+			rest = append(rest, addr)
+		}
+	}
+	return local, rest
+}
+
+// onSubnet reports whether addr is a direct address inside one of subnets.
+// Relayed addresses never are, whatever the relay's own address.
+func onSubnet(addr multiaddr.Multiaddr, subnets []*net.IPNet) bool {
+	if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+		return false
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return false
+	}
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// localSubnets lists the networks of this machine's interface addresses,
+// loopback included.
+func localSubnets() []*net.IPNet {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var subnets []*net.IPNet
+	for _, a := range ifaceAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok {
+			subnets = append(subnets, ipNet)
+		}
+	}
+	return subnets
+}
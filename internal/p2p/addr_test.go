@@ -0,0 +1,91 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestParsePeerAddr(t *testing.T) {
+	id := test.RandPeerIDFatal(t).String()
+	tests := []struct {
+		name   string
+		addr   string
+		reason string // empty if the address is accepted
+		hint   string
+	}{
+		{name: "ip4", addr: "/ip4/192.168.1.10/tcp/9000/p2p/" + id},
+		{name: "ip6", addr: "/ip6/::1/tcp/9000/p2p/" + id},
+		{name: "ip6 full", addr: "/ip6/2001:db8::42/tcp/9000/p2p/" + id},
+		{name: "dns4", addr: "/dns4/seed.example.com/tcp/9000/p2p/" + id},
+		{name: "dns6", addr: "/dns6/seed.example.com/tcp/9000/p2p/" + id},
+		{name: "dns", addr: "/dns/seed.example.com/tcp/9000/p2p/" + id},
+		{name: "dnsaddr without peer ID", addr: "/dnsaddr/bootstrap.example.com"},
+		{name: "surrounding space", addr: "  /ip6/::1/tcp/9000/p2p/" + id + "\n"},
+		{name: "empty", addr: " ", reason: "address is empty"},
+		{name: "ip6 missing peer ID", addr: "/ip6/::1/tcp/9000", reason: "missing /p2p/<peer-id> suffix", hint: "/ip6/::1/tcp/9000/p2p/12D3KooW..."},
+		{name: "dns4 missing peer ID", addr: "/dns4/seed.example.com/tcp/9000", reason: "missing /p2p/<peer-id> suffix"},
+		{name: "ip6 without transport", addr: "/ip6/::1/p2p/" + id, reason: "no supported transport"},
+		{name: "malformed ip6", addr: "/ip6/2001:db8::zz/tcp/9000/p2p/" + id, reason: "invalid"},
+		{name: "bracketed ip6 host:port", addr: "[::1]:9000", reason: "not a multiaddr", hint: "/ip6/::1/tcp/9000/p2p/<peer-id>"},
+		{name: "bracketed ip6 without port", addr: "[2001:db8::42]", reason: "not a multiaddr", hint: "/ip6/2001:db8::42/tcp/9000/p2p/<peer-id>"},
+		{name: "bare ip6", addr: "2001:db8::42", reason: "not a multiaddr", hint: "/ip6/2001:db8::42/tcp/9000/p2p/<peer-id>"},
+		{name: "ip4 host:port", addr: "192.168.1.10:9000", reason: "not a multiaddr", hint: "/ip4/192.168.1.10/tcp/9000/p2p/<peer-id>"},
+		{name: "hostname with scheme", addr: "tcp://seed.example.com:9001/", reason: "not a multiaddr", hint: "/dns4/seed.example.com/tcp/9001/p2p/<peer-id>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ma, err := ParsePeerAddr(tt.addr)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("ParsePeerAddr: %v", err)
+				}
+				if ma.String() != strings.TrimSpace(tt.addr) {
+					t.Fatalf("parsed as %s", ma)
+				}
+				return
+			}
+
+			var addrErr *AddrError
+			if !errors.As(err, &addrErr) {
+				t.Fatalf("want an *AddrError, got %v", err)
+			}
+			if !strings.Contains(addrErr.Reason, tt.reason) {
+				t.Errorf("reason %q does not contain %q", addrErr.Reason, tt.reason)
+			}
+			if !strings.Contains(addrErr.Hint, tt.hint) {
+				t.Errorf("hint %q does not contain %q", addrErr.Hint, tt.hint)
+			}
+		})
+	}
+}
+
+// TestResolvePeerAddr checks /dns4, /dns6 and /ip6 addresses become one
+// peer each without a lookup: the swarm resolves DNS names when it dials.
+func TestResolvePeerAddr(t *testing.T) {
+	id := test.RandPeerIDFatal(t)
+	for _, transport := range []string{
+		"/ip6/::1/tcp/9000",
+		"/dns4/seed.example.com/tcp/9000",
+		"/dns6/seed.example.com/tcp/9000",
+	} {
+		ma, err := ParsePeerAddr(transport + "/p2p/" + id.String())
+		if err != nil {
+			t.Fatalf("ParsePeerAddr: %v", err)
+		}
+		infos, err := resolvePeerAddr(context.Background(), ma)
+		if err != nil {
+			t.Fatalf("%s: resolvePeerAddr: %v", transport, err)
+		}
+		if len(infos) != 1 || infos[0].ID != id || len(infos[0].Addrs) != 1 {
+			t.Fatalf("%s: resolved to %v", transport, infos)
+		}
+		if want := multiaddr.StringCast(transport); !infos[0].Addrs[0].Equal(want) {
+			t.Errorf("%s: dial address is %s", transport, infos[0].Addrs[0])
+		}
+	}
+}
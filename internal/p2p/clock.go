@@ -0,0 +1,128 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// MessageTypeClock asks a peer for its current time. The host answers it
+// itself, before the replay check, since that check is what a skewed clock
+// fails.
+const MessageTypeClock MessageType = "clock"
+
+const (
+	clockSyncTimeout = 5 * time.Second
+
+	// Offsets beyond clockSkewWarn are logged: past the replay window they
+	// would have got the peer's messages rejected.
+	clockSkewWarn = 30 * time.Second
+
+	// A peer whose message is rejected as stale is measured again, at most
+	// once per clockResyncInterval.
+	clockResyncInterval = time.Minute
+)
+
+// clockPayload carries a sender's wall clock in a clock reply.
+type clockPayload struct {
+	UnixNano int64 `json:"unix_nano"`
+}
+
+// clockOffsets are how far ahead of ours each peer's clock runs, measured
+// with a clock exchange when identify finds the peer speaks our protocol.
+// Offsets outlive disconnects, as a peer's clock doesn't change with them.
+type clockOffsets struct {
+	mu    sync.Mutex
+	peers map[peer.ID]clockSample
+}
+
+type clockSample struct {
+	offset   time.Duration
+	measured time.Time
+}
+
+func newClockOffsets() *clockOffsets {
+	return &clockOffsets{peers: make(map[peer.ID]clockSample)}
+}
+
+// offset is the peer's measured clock offset, 0 if it was never measured.
+func (c *clockOffsets) offset(peerID peer.ID) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peers[peerID].offset
+}
+
+// due reports whether peerID may be measured again, and if so marks it as
+// being measured now so concurrent rejections trigger one exchange.
+func (c *clockOffsets) due(peerID peer.ID, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sample, exists := c.peers[peerID]
+	if exists && now.Sub(sample.measured) < clockResyncInterval {
+		return false
+	}
+	sample.measured = now
+	c.peers[peerID] = sample
+	return true
+}
+
+func (c *clockOffsets) set(peerID peer.ID, offset time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peers[peerID] = clockSample{offset: offset, measured: now}
+}
+
+func (h *Host) clockReply() *Message {
+	payload, _ := json.Marshal(clockPayload{UnixNano: time.Now().UnixNano()})
+	return &Message{
+		Type:    MessageTypePong,
+		From:    h.host.ID().String(),
+		Payload: payload,
+	}
+}
+
+// syncClock measures peerID's clock offset, taking the peer's reported time
+// to be from halfway through the round trip. Peers that predate the
+// exchange refuse it and keep an offset of 0.
+func (h *Host) syncClock(peerID peer.ID) {
+	if !h.clock.due(peerID, time.Now()) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, clockSyncTimeout)
+	defer cancel()
+
+	sent := time.Now()
+	resp, err := h.SendMessage(ctx, peerID, &Message{
+		Type: MessageTypeClock,
+		From: h.host.ID().String(),
+	})
+	received := time.Now()
+	if err != nil || resp == nil {
+		if err != nil && !errors.Is(err, ErrUnsupportedMessage) {
+			h.logger.Debug("Clock exchange failed", h.PeerField("peer", peerID), zap.Error(err))
+		}
+		return
+	}
+
+	var payload clockPayload
+	if err := json.Unmarshal(resp.Payload, &payload); err != nil || payload.UnixNano == 0 {
+		return
+	}
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	offset := time.Unix(0, payload.UnixNano).Sub(midpoint)
+	h.clock.set(peerID, offset, received)
+
+	if offset > clockSkewWarn || offset < -clockSkewWarn {
+		h.logger.Warn("Peer clock is skewed, adjusting message freshness checks",
+			h.PeerField("peer", peerID),
+			zap.Duration("offset", offset.Round(time.Millisecond)),
+			zap.Duration("rtt", received.Sub(sent)))
+	}
+}
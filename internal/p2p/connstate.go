@@ -0,0 +1,106 @@
+package p2p
+
+import (
+	"sync/atomic"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/events"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"go.uber.org/zap"
+)
+
+// ConnState is where a tracked peer's connection stands.
+type ConnState string
+
+const (
+	ConnStateConnecting   ConnState = "connecting" // redialling a known peer
+	ConnStateDirect       ConnState = "connected-direct"
+	ConnStateRelay        ConnState = "connected-relay"
+	ConnStateDisconnected ConnState = "disconnected"
+)
+
+// StateChange is the data of a TypePeerStateChanged event.
+type StateChange struct {
+	From ConnState `json:"from,omitempty"`
+	To   ConnState `json:"to"`
+}
+
+// liveState derives a peer's state from its open connections: direct if any
+// of them is, relayed if all go through a circuit relay.
+func (h *Host) liveState(peerID peer.ID) ConnState {
+	switch h.ConnectionType(peerID) {
+	case "direct":
+		return ConnStateDirect
+	case "relay":
+		return ConnStateRelay
+	default:
+		return ConnStateDisconnected
+	}
+}
+
+// setStateLocked moves p to state and publishes the transition. h.peersMu
+// must be held.
+func (h *Host) setStateLocked(p *PeerInfo, state ConnState) {
+	if p.State == state {
+		return
+	}
+	change := StateChange{From: p.State, To: state}
+	p.State = state
+
+	h.logger.Debug("Peer connection state changed",
+		h.PeerField("peer", p.ID),
+		zap.String("from", string(change.From)),
+		zap.String("to", string(change.To)))
+	h.events.Publish(events.Event{Type: events.TypePeerStateChanged, PeerID: p.ID.String(), Data: change})
+}
+
+// markConnecting flags a known, disconnected peer as being redialled.
+// Peers we have never been connected to are not tracked until they connect.
+func (h *Host) markConnecting(peerID peer.ID) {
+	h.peersMu.Lock()
+	defer h.peersMu.Unlock()
+
+	if p, exists := h.peers[peerID]; exists && !p.Connected {
+		h.setStateLocked(p, ConnStateConnecting)
+	}
+}
+
+// refreshState re-derives a tracked peer's state from its live connections,
+// e.g. after a failed dial or once hole punching has upgraded a relayed
+// connection to a direct one.
+func (h *Host) refreshState(peerID peer.ID) {
+	state := h.liveState(peerID)
+
+	h.peersMu.Lock()
+	defer h.peersMu.Unlock()
+
+	if p, exists := h.peers[peerID]; exists {
+		h.setStateLocked(p, state)
+	}
+}
+
+// holePunchTracer logs hole-punch attempts and refreshes the peer's state
+// when one ends. host is set once the Host exists, since libp2p needs the
+// tracer before that.
+type holePunchTracer struct {
+	host atomic.Pointer[Host]
+}
+
+func (t *holePunchTracer) Trace(evt *holepunch.Event) {
+	h := t.host.Load()
+	if h == nil {
+		return
+	}
+
+	switch e := evt.Evt.(type) {
+	case *holepunch.StartHolePunchEvt:
+		h.logger.Debug("Hole punching", h.PeerField("peer", evt.Remote), zap.Strings("addrs", e.RemoteAddrs))
+	case *holepunch.EndHolePunchEvt:
+		if e.Success {
+			h.logger.Info("Hole punch succeeded", h.PeerField("peer", evt.Remote), zap.Duration("elapsed", e.EllapsedTime))
+		} else {
+			h.logger.Debug("Hole punch failed", h.PeerField("peer", evt.Remote), zap.String("error", e.Error))
+		}
+		h.refreshState(evt.Remote)
+	}
+}
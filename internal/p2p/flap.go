@@ -0,0 +1,88 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	// A peer that disconnects more than flapThreshold times within flapWindow
+	// is flapping: we stop dialling it for a cooldown that grows with every
+	// further disconnect, by default from flapBaseCooldown up to
+	// flapMaxCooldown (see RetryPolicies.Flap). Its own dials to us are
+	// still accepted.
+	flapWindow       = 5 * time.Minute
+	flapThreshold    = 3
+	flapBaseCooldown = 10 * time.Second
+	flapMaxCooldown  = 10 * time.Minute
+)
+
+type flapState struct {
+	disconnects   []time.Time // within flapWindow
+	cooldownUntil time.Time
+}
+
+// recordDisconnectLocked counts a disconnect of peerID and starts or extends
+// its cooldown once it is flapping. The caller must hold peersMu.
+func (h *Host) recordDisconnectLocked(peerID peer.ID, now time.Time) {
+	state, ok := h.flaps[peerID]
+	if !ok {
+		state = &flapState{}
+		h.flaps[peerID] = state
+	}
+
+	recent := state.disconnects[:0]
+	for _, t := range state.disconnects {
+		if now.Sub(t) < flapWindow {
+			recent = append(recent, t)
+		}
+	}
+	state.disconnects = append(recent, now)
+
+	excess := len(state.disconnects) - flapThreshold
+	if excess <= 0 {
+		return
+	}
+	cooldown := h.retries.Flap.Delay(excess)
+	state.cooldownUntil = now.Add(cooldown)
+	h.logger.Warn("Peer is flapping, holding off reconnects",
+		h.PeerField("peer", peerID),
+		zap.Int("disconnects", len(state.disconnects)),
+		zap.Duration("window", flapWindow),
+		zap.Duration("cooldown", cooldown))
+}
+
+// fillFlapsLocked copies the peer's flap state into a snapshot. The caller
+// must hold peersMu, for reading at least.
+func (h *Host) fillFlapsLocked(info *PeerInfo, now time.Time) {
+	state, ok := h.flaps[info.ID]
+	if !ok {
+		return
+	}
+	for _, t := range state.disconnects {
+		if now.Sub(t) < flapWindow {
+			info.Flaps++
+		}
+	}
+	if now.Before(state.cooldownUntil) {
+		info.CooldownUntil = state.cooldownUntil
+	}
+}
+
+// flapCooldown returns an error while peerID is cooling down after flapping.
+func (h *Host) flapCooldown(peerID peer.ID) error {
+	h.peersMu.RLock()
+	defer h.peersMu.RUnlock()
+
+	state, ok := h.flaps[peerID]
+	if !ok {
+		return nil
+	}
+	if wait := time.Until(state.cooldownUntil); wait > 0 {
+		return fmt.Errorf("peer %s is flapping, not redialling for another %s", peerID, wait.Round(time.Second))
+	}
+	return nil
+}
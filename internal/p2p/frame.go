@@ -0,0 +1,177 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+var errMessageTooLarge = errors.New("message too large")
+
+// messageTooLarge is the error for a message of n bytes over limit.
+func messageTooLarge(n, limit int64) error {
+	return fmt.Errorf("%w: %d bytes exceeds the %d byte limit", errMessageTooLarge, n, limit)
+}
+
+// writeFrame writes msg as one frame: a 4-byte big-endian length followed by
+// the JSON Message. A message over limit is refused with errMessageTooLarge
+// before anything is written, so the stream stays usable.
+func writeFrame(w io.Writer, msg *Message, limit int64) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return messageTooLarge(int64(len(data)), limit)
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+// readFrame reads one frame. A frame over limit is refused with
+// errMessageTooLarge without reading its body, which leaves the stream
+// unusable.
+func readFrame(r *bufio.Reader, limit int64) (*Message, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if int64(n) > limit {
+		return nil, messageTooLarge(int64(n), limit)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal frame: %w", err)
+	}
+	return &msg, nil
+}
+
+// streamConn reads and writes the messages of a one-shot stream in its
+// protocol's encoding: frames on ProtocolID, bare JSON on LegacyProtocolID.
+type streamConn struct {
+	stream network.Stream
+	framed bool
+	reader *bufio.Reader
+
+	// Legacy streamed responses are a run of JSON values, decoded through
+	// budget so no single one can grow without bound.
+	decoder *json.Decoder
+	budget  *frameBudget
+}
+
+func newStreamConn(s network.Stream, framed bool) *streamConn {
+	conn := &streamConn{stream: s, framed: framed}
+	if framed {
+		conn.reader = bufio.NewReader(s)
+	}
+	return conn
+}
+
+func (c *streamConn) write(msg *Message, limit int64) error {
+	if c.framed {
+		return writeFrame(c.stream, msg, limit)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return messageTooLarge(int64(len(data)), limit)
+	}
+	_, err = c.stream.Write(data)
+	return err
+}
+
+// read reads the stream's one message: a request, or a response that isn't
+// streamed. It returns nil, and no error, if the stream ended without one.
+func (c *streamConn) read(limit int64) (*Message, error) {
+	if c.framed {
+		msg, err := readFrame(c.reader, limit)
+		if err == io.EOF {
+			return nil, nil
+		}
+		return msg, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.stream, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: over the %d byte limit", errMessageTooLarge, limit)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		raw := data
+		if len(raw) > maxRawResponseLen {
+			raw = raw[:maxRawResponseLen]
+		}
+		return nil, fmt.Errorf("failed to unmarshal %q: %w", raw, err)
+	}
+	return &msg, nil
+}
+
+// next reads the next frame of a streamed response, nil once the stream has
+// ended.
+func (c *streamConn) next(limit int64) (*Message, error) {
+	if c.framed {
+		return c.read(limit)
+	}
+
+	if c.decoder == nil {
+		c.budget = &frameBudget{r: c.stream}
+		c.decoder = json.NewDecoder(c.budget)
+	}
+	c.budget.reset(limit)
+	var msg Message
+	if err := c.decoder.Decode(&msg); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// frameBudget fails reads once limit bytes have been read since the last
+// reset. The decoder reads ahead, so a value may be charged for part of the
+// next one; that is at most one buffer's worth.
+type frameBudget struct {
+	r     io.Reader
+	limit int64
+	left  int64
+}
+
+func (b *frameBudget) reset(limit int64) {
+	b.limit, b.left = limit, limit
+}
+
+func (b *frameBudget) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, fmt.Errorf("%w: over the %d byte limit", errMessageTooLarge, b.limit)
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,47 @@
+package p2p
+
+import (
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// peerGater refuses connections to and from blocked peers, whichever way the
+// peer was found. The blocklist is fixed when the host is created.
+type peerGater struct {
+	blocked map[peer.ID]bool
+}
+
+var _ connmgr.ConnectionGater = (*peerGater)(nil)
+
+func newPeerGater(blocked []peer.ID) *peerGater {
+	g := &peerGater{blocked: make(map[peer.ID]bool, len(blocked))}
+	for _, id := range blocked {
+		g.blocked[id] = true
+	}
+	return g
+}
+
+func (g *peerGater) InterceptPeerDial(p peer.ID) bool {
+	return !g.blocked[p]
+}
+
+func (g *peerGater) InterceptAddrDial(p peer.ID, _ multiaddr.Multiaddr) bool {
+	return !g.blocked[p]
+}
+
+// InterceptAccept lets every inbound connection through: the remote peer is
+// only known once it is secured.
+func (g *peerGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *peerGater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return !g.blocked[p]
+}
+
+func (g *peerGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
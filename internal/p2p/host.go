@@ -2,9 +2,15 @@ package p2p
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/denizumutdereli/agents-p2p-network/internal/events"
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/host"
@@ -13,27 +19,153 @@ import (
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap"
 )
 
 const (
-	ProtocolID       = "/p2p-agent/1.0.0"
+	// ProtocolID streams carry one request, and its response or stream
+	// frames, as length-prefixed frames. LegacyProtocolID carries them as
+	// bare JSON, the request read to EOF; it is still served and spoken to
+	// peers that predate framing.
+	ProtocolID       = "/p2p-agent/1.1.0"
+	LegacyProtocolID = "/p2p-agent/1.0.0"
 	AgentServiceName = "p2p-agent-network"
+
+	DefaultMaxStreamsPerPeer = 16
+	DefaultHandlerTimeout    = 2 * time.Minute
+	DefaultMaxMessageSize    = 8 << 20
+
+	// DHT discovery waits for the routing table, polling every
+	// dhtReadyPollInterval and warning after dhtReadyTimeout, then queries
+	// every dhtRetryInterval.
+	dhtReadyPollInterval = time.Second
+	dhtReadyTimeout      = time.Minute
+	dhtRetryInterval     = 10 * time.Second
+
+	// closeTimeout bounds how long Close waits for background loops.
+	closeTimeout = 5 * time.Second
+
+	// agentProtectTag marks connections to registered agents so the
+	// connection manager never trims them under pressure.
+	agentProtectTag = "agent"
+)
+
+// Security transports offered during the connection handshake.
+const (
+	SecurityNoise = "noise"
+	SecurityTLS   = "tls"
+	SecurityBoth  = "both" // libp2p default: TLS preferred, Noise accepted
 )
 
+// DHT modes. Clients query the DHT but never answer queries; auto serves
+// only once the node is found to be publicly reachable.
+const (
+	DHTModeAuto   = "auto"
+	DHTModeClient = "client"
+	DHTModeServer = "server"
+)
+
+// Options are construction-time settings for a Host.
+type Options struct {
+	Port     int
+	Security string // one of the Security* constants, empty = SecurityBoth
+
+	// ReplayWindow is how old (or far in the future) an inbound message's
+	// timestamp may be. 0 disables replay checks.
+	ReplayWindow time.Duration
+
+	NATPortMap   bool   // ask the router for a port mapping via UPnP / NAT-PMP
+	RelayService bool   // act as a circuit relay for other peers
+	DHTMode      string // one of the DHTMode* constants, empty = DHTModeAuto
+
+	// NoDHT skips the DHT entirely, leaving mDNS and explicit bootstrap
+	// peers as the only ways to find others. DHTMode is then ignored.
+	NoDHT bool
+
+	// Sessions sends requests over one persistent stream per peer instead of
+	// a stream per message, for peers that support it. Inbound sessions are
+	// always accepted.
+	Sessions bool
+
+	// PeerExchange shares connected agents with peers that ask and, once
+	// StartPeerExchange is called, asks peers for theirs.
+	PeerExchange bool
+
+	// BlockedPeers are never dialled, and their connections are refused.
+	BlockedPeers []peer.ID
+
+	// Retry is how sends, sessions and dials are retried and flapping
+	// peers held off.
+	Retry RetryPolicies
+}
+
 type Host struct {
 	host       host.Host
 	dht        *dht.IpfsDHT
+	discovery  *drouting.RoutingDiscovery
 	logger     *zap.Logger
 	ctx        context.Context
 	cancel     context.CancelFunc
 	msgHandler MessageHandler
+	streamer   StreamHandler
 	localName  string
+	events     *events.Bus
+	replay     *replayGuard
+	clock      *clockOffsets
+	pex        *peerExchange
+	gater      *peerGater
+	retries    RetryPolicies
+
+	// loops tracks the long-running background goroutines (discovery,
+	// pinned peers, network watch) so Close can wait for them.
+	loops sync.WaitGroup
+
+	bootstrapMu    sync.Mutex
+	bootstrapAddrs []string
 
 	peersMu    sync.RWMutex
 	peers      map[peer.ID]*PeerInfo
 	agentNames map[string]peer.ID // Track agent names to detect duplicates
+	flaps      map[peer.ID]*flapState
+
+	streamsMu         sync.Mutex
+	activeStreams     map[peer.ID]int
+	maxStreamsPerPeer int
+
+	broadcastFanout atomic.Int32
+	handlerTimeout  atomic.Int64 // time.Duration
+	maxMessageSize  atomic.Int64 // bytes, per message or session frame
+
+	// Totals the broadcast metrics count too, kept for Broadcasts.
+	broadcasts         atomic.Int64
+	broadcastDelivered atomic.Int64
+	broadcastFailed    atomic.Int64
+
+	peerNames   sync.Map // peer.ID -> agent name, for PeerField
+	fullPeerIDs atomic.Bool
+
+	modelsMu      sync.Mutex
+	models        []string // advertised in the DHT under ModelRendezvous
+	advertising   bool
+	modelsChanged chan struct{}
+
+	mdnsMu      sync.Mutex
+	mdns        mdns.Service
+	mdnsStarted bool // StartMDNS was called, even if the service failed
+
+	pinMu      sync.Mutex
+	pinned     map[peer.ID]bool
+	pinDialing map[peer.ID]bool
+
+	useSessions bool
+	sessionsMu  sync.Mutex
+	sessions    map[peer.ID]*session
+	noSession   map[peer.ID]bool // peers that only speak one-shot streams
 }
 
 type PeerInfo struct {
@@ -41,54 +173,125 @@ type PeerInfo struct {
 	Name      string
 	Addrs     []multiaddr.Multiaddr
 	Connected bool
+	State     ConnState
+	LastSeen  time.Time
+
+	Flaps         int       // disconnects within flapWindow
+	CooldownUntil time.Time // no redials before this, zero if none
+
+	// LearnedFrom is the peer that told us about this one via peer
+	// exchange, empty if it was found some other way.
+	LearnedFrom peer.ID
 }
 
 type MessageHandler func(ctx context.Context, from peer.ID, msg *Message) (*Message, error)
 
-func NewHost(ctx context.Context, port int, logger *zap.Logger) (*Host, error) {
+// StreamHandler answers a message sent with Stream set, passing each chunk to
+// send as a MessageTypeChunk frame. Frames are written to the stream
+// immediately and arrive in the order they were sent since they share one
+// stream. Returning nil ends the stream with a MessageTypeComplete frame, and
+// returning an error ends it with a MessageTypeError frame instead, even
+// after chunks have gone out.
+type StreamHandler func(ctx context.Context, from peer.ID, msg *Message, send func(*Message) error) error
+
+func NewHost(ctx context.Context, opts Options, logger *zap.Logger) (*Host, error) {
+	security, err := securityOptions(opts.Security)
+	if err != nil {
+		return nil, err
+	}
+	dhtMode, err := dhtModeOption(opts.DHTMode)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
-	listenAddr := fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port)
+	listenAddrs := []string{
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", opts.Port),
+		fmt.Sprintf("/ip6/::/tcp/%d", opts.Port),
+	}
 
-	h, err := libp2p.New(
-		libp2p.ListenAddrStrings(listenAddr),
+	tracer := &holePunchTracer{}
+	gater := newPeerGater(opts.BlockedPeers)
+	libp2pOpts := append([]libp2p.Option{
+		libp2p.ListenAddrStrings(listenAddrs...),
+		libp2p.ConnectionGater(gater),
 		libp2p.EnableRelay(),
-		libp2p.EnableHolePunching(),
-		libp2p.NATPortMap(),
-	)
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
+		libp2p.EnableHolePunching(holepunch.WithTracer(tracer)),
+		libp2p.SwarmOpts(swarm.WithDialRanker(localFirstDialRanker)),
+	}, security...)
+	if opts.NATPortMap {
+		libp2pOpts = append(libp2pOpts, libp2p.NATPortMap())
 	}
-
-	kadDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeAutoServer))
-	if err != nil {
-		h.Close()
-		cancel()
-		return nil, fmt.Errorf("failed to create DHT: %w", err)
+	if opts.RelayService {
+		libp2pOpts = append(libp2pOpts, libp2p.EnableRelayService())
 	}
 
-	if err := kadDHT.Bootstrap(ctx); err != nil {
-		h.Close()
+	h, err := libp2p.New(libp2pOpts...)
+	if err != nil {
 		cancel()
-		return nil, fmt.Errorf("failed to bootstrap DHT: %w", err)
+		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
 	}
 
 	p2pHost := &Host{
 		host:       h,
-		dht:        kadDHT,
 		logger:     logger,
 		ctx:        ctx,
 		cancel:     cancel,
+		replay:     newReplayGuard(opts.ReplayWindow),
+		clock:      newClockOffsets(),
+		pex:        newPeerExchange(opts.PeerExchange),
+		gater:      gater,
+		retries:    opts.Retry.withDefaults(),
 		peers:      make(map[peer.ID]*PeerInfo),
 		agentNames: make(map[string]peer.ID),
+		flaps:      make(map[peer.ID]*flapState),
+
+		activeStreams:     make(map[peer.ID]int),
+		maxStreamsPerPeer: DefaultMaxStreamsPerPeer,
+
+		modelsChanged: make(chan struct{}, 1),
+
+		pinned:     make(map[peer.ID]bool),
+		pinDialing: make(map[peer.ID]bool),
+
+		useSessions: opts.Sessions,
+		sessions:    make(map[peer.ID]*session),
+		noSession:   make(map[peer.ID]bool),
+	}
+	p2pHost.handlerTimeout.Store(int64(DefaultHandlerTimeout))
+	p2pHost.maxMessageSize.Store(DefaultMaxMessageSize)
+	tracer.host.Store(p2pHost)
+
+	if opts.NoDHT {
+		logger.Info("DHT disabled, discovering peers via mDNS and bootstrap peers only")
+	} else {
+		kadDHT, err := dht.New(ctx, h, dht.Mode(dhtMode))
+		if err != nil {
+			h.Close()
+			cancel()
+			return nil, fmt.Errorf("failed to create DHT: %w", err)
+		}
+
+		if err := kadDHT.Bootstrap(ctx); err != nil {
+			kadDHT.Close()
+			h.Close()
+			cancel()
+			return nil, fmt.Errorf("failed to bootstrap DHT: %w", err)
+		}
+
+		p2pHost.dht = kadDHT
+		p2pHost.discovery = drouting.NewRoutingDiscovery(kadDHT)
 	}
 
 	h.SetStreamHandler(protocol.ID(ProtocolID), p2pHost.handleStream)
+	h.SetStreamHandler(protocol.ID(LegacyProtocolID), p2pHost.handleLegacyStream)
+	h.SetStreamHandler(protocol.ID(SessionProtocolID), p2pHost.handleSession)
 
+	p2pHost.watchIdentify()
 	h.Network().Notify(&network.NotifyBundle{
 		ConnectedF: func(n network.Network, c network.Conn) {
-			p2pHost.onPeerConnected(c.RemotePeer())
+			p2pHost.onPeerConnected(c.RemotePeer(), c.RemoteMultiaddr())
 		},
 		DisconnectedF: func(n network.Network, c network.Conn) {
 			p2pHost.onPeerDisconnected(c.RemotePeer())
@@ -98,6 +301,34 @@ func NewHost(ctx context.Context, port int, logger *zap.Logger) (*Host, error) {
 	return p2pHost, nil
 }
 
+// securityOptions maps a --security value to libp2p options. "both" keeps the
+// libp2p defaults so existing deployments behave as before.
+func securityOptions(security string) ([]libp2p.Option, error) {
+	switch security {
+	case "", SecurityBoth:
+		return nil, nil
+	case SecurityNoise:
+		return []libp2p.Option{libp2p.Security(noise.ID, noise.New)}, nil
+	case SecurityTLS:
+		return []libp2p.Option{libp2p.Security(libp2ptls.ID, libp2ptls.New)}, nil
+	default:
+		return nil, fmt.Errorf("unknown security transport %q", security)
+	}
+}
+
+func dhtModeOption(mode string) (dht.ModeOpt, error) {
+	switch mode {
+	case "", DHTModeAuto:
+		return dht.ModeAutoServer, nil
+	case DHTModeClient:
+		return dht.ModeClient, nil
+	case DHTModeServer:
+		return dht.ModeServer, nil
+	default:
+		return 0, fmt.Errorf("unknown DHT mode %q", mode)
+	}
+}
+
 func (h *Host) ID() peer.ID {
 	return h.host.ID()
 }
@@ -115,58 +346,104 @@ func (h *Host) MultiAddrs() []string {
 	return result
 }
 
+// Sign signs data with this host's identity key.
+func (h *Host) Sign(data []byte) ([]byte, error) {
+	key := h.host.Peerstore().PrivKey(h.host.ID())
+	if key == nil {
+		return nil, fmt.Errorf("no private key for local peer")
+	}
+	return key.Sign(data)
+}
+
+// Verify checks that sig is peerID's identity-key signature over data.
+func Verify(peerID peer.ID, data, sig []byte) error {
+	pub, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to extract public key: %w", err)
+	}
+	ok, err := pub.Verify(data, sig)
+	if err != nil {
+		return fmt.Errorf("failed to verify signature: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("invalid signature from %s", peerID)
+	}
+	return nil
+}
+
 func (h *Host) SetMessageHandler(handler MessageHandler) {
 	h.msgHandler = handler
 }
 
-func (h *Host) SetLocalName(name string) {
-	h.localName = name
+// SetStreamHandler sets the handler for streaming requests. Without one,
+// they are answered by the message handler with a single frame.
+func (h *Host) SetStreamHandler(handler StreamHandler) {
+	h.streamer = handler
 }
 
-func (h *Host) RegisterAgentName(name string, peerID peer.ID) error {
-	h.peersMu.Lock()
-	defer h.peersMu.Unlock()
+func (h *Host) SetEventBus(bus *events.Bus) {
+	h.events = bus
+}
 
-	if existingPeer, exists := h.agentNames[name]; exists {
-		if existingPeer != peerID {
-			return fmt.Errorf("agent name '%s' is already taken by peer %s", name, existingPeer.String()[:12])
-		}
-	}
+// SetMaxStreamsPerPeer caps how many inbound streams from a single peer are
+// handled concurrently. Zero or less disables the cap.
+func (h *Host) SetMaxStreamsPerPeer(n int) {
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+	h.maxStreamsPerPeer = n
+}
 
-	h.agentNames[name] = peerID
-	return nil
+// SetBroadcastFanout caps how many peers a broadcast is sent to; when more
+// match, a random sample of n is picked. Zero or less sends to all of them.
+func (h *Host) SetBroadcastFanout(n int) {
+	h.broadcastFanout.Store(int32(n))
 }
 
-func (h *Host) IsNameTaken(name string) (bool, peer.ID) {
-	h.peersMu.RLock()
-	defer h.peersMu.RUnlock()
+// SetHandlerTimeout bounds how long the message handler may take to answer a
+// single inbound message. Zero or less means no limit.
+func (h *Host) SetHandlerTimeout(d time.Duration) {
+	h.handlerTimeout.Store(int64(d))
+}
 
-	if peerID, exists := h.agentNames[name]; exists {
-		return true, peerID
+// SetMaxMessageSize bounds the encoded size of a message, sent or received,
+// on either protocol. Zero or less restores DefaultMaxMessageSize.
+func (h *Host) SetMaxMessageSize(n int64) {
+	if n <= 0 {
+		n = DefaultMaxMessageSize
 	}
-	return false, ""
+	h.maxMessageSize.Store(n)
+}
+
+func (h *Host) SetLocalName(name string) {
+	h.localName = name
 }
 
 func (h *Host) StartMDNS() error {
-	mdnsService := mdns.NewMdnsService(h.host, AgentServiceName, &mdnsNotifee{host: h})
-	return mdnsService.Start()
+	h.mdnsMu.Lock()
+	defer h.mdnsMu.Unlock()
+
+	h.mdnsStarted = true
+	return h.startMDNSLocked()
 }
 
+// StartDHTDiscovery looks for other agents through the DHT. The find loop
+// only starts once the DHT has someone to ask; until then queries just fail.
+// It does nothing when the DHT is disabled.
 func (h *Host) StartDHTDiscovery() {
-	routingDiscovery := drouting.NewRoutingDiscovery(h.dht)
+	if h.dht == nil {
+		return
+	}
 
-	go func() {
-		for {
-			select {
-			case <-h.ctx.Done():
-				return
-			default:
-				peerChan, err := routingDiscovery.FindPeers(h.ctx, AgentServiceName)
-				if err != nil {
-					h.logger.Debug("DHT discovery error", zap.Error(err))
-					continue
-				}
+	h.goLoop(func() {
+		if !h.waitForDHT() {
+			return
+		}
 
+		for {
+			peerChan, err := h.discovery.FindPeers(h.ctx, AgentServiceName)
+			if err != nil {
+				h.logger.Debug("DHT discovery error", zap.Error(err))
+			} else {
 				for p := range peerChan {
 					if p.ID == h.host.ID() || len(p.Addrs) == 0 {
 						continue
@@ -174,8 +451,65 @@ func (h *Host) StartDHTDiscovery() {
 					h.Connect(h.ctx, p)
 				}
 			}
+
+			select {
+			case <-h.ctx.Done():
+				return
+			case <-time.After(dhtRetryInterval):
+			}
 		}
-	}()
+	})
+}
+
+// waitForDHT blocks until the DHT is usable, warning once if that takes
+// longer than dhtReadyTimeout. It returns false if the host closes first.
+func (h *Host) waitForDHT() bool {
+	ticker := time.NewTicker(dhtReadyPollInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(dhtReadyTimeout)
+	defer timeout.Stop()
+
+	for !h.dhtReady() {
+		select {
+		case <-h.ctx.Done():
+			return false
+		case <-timeout.C:
+			h.logger.Warn("DHT has not bootstrapped; agent discovery via DHT will start once a DHT peer connects",
+				zap.Duration("waited", dhtReadyTimeout),
+				zap.Int("bootstrap_peers", len(h.bootstrapPeerIDs())))
+		case <-ticker.C:
+		}
+	}
+
+	h.logger.Debug("DHT bootstrapped, starting discovery", zap.Int("routing_table", h.dht.RoutingTable().Size()))
+	return true
+}
+
+// dhtReady reports whether the routing table has peers or a bootstrap peer
+// is connected.
+func (h *Host) dhtReady() bool {
+	if h.dht.RoutingTable().Size() > 0 {
+		return true
+	}
+	for _, id := range h.bootstrapPeerIDs() {
+		if h.host.Network().Connectedness(id) == network.Connected {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Host) bootstrapPeerIDs() []peer.ID {
+	h.bootstrapMu.Lock()
+	defer h.bootstrapMu.Unlock()
+
+	var ids []peer.ID
+	for _, addr := range h.bootstrapAddrs {
+		if info, err := peer.AddrInfoFromString(addr); err == nil {
+			ids = append(ids, info.ID)
+		}
+	}
+	return ids
 }
 
 func (h *Host) Connect(ctx context.Context, pi peer.AddrInfo) error {
@@ -183,11 +517,22 @@ func (h *Host) Connect(ctx context.Context, pi peer.AddrInfo) error {
 		return nil
 	}
 
+	if !h.gater.InterceptPeerDial(pi.ID) {
+		return fmt.Errorf("peer %s is blocked", pi.ID)
+	}
+	if err := h.flapCooldown(pi.ID); err != nil {
+		return err
+	}
+
+	pi.Addrs = orderAddrs(pi.Addrs)
+	h.markConnecting(pi.ID)
 	if err := h.host.Connect(ctx, pi); err != nil {
+		h.refreshState(pi.ID)
 		return fmt.Errorf("failed to connect to peer %s: %w", pi.ID, err)
 	}
+	h.refreshAddrs(pi.ID)
 
-	h.logger.Info("Connected to peer", zap.String("peer_id", pi.ID.String()))
+	h.logger.Info("Connected to peer", h.PeerField("peer", pi.ID))
 	return nil
 }
 
@@ -196,63 +541,310 @@ func (h *Host) ConnectBootstrap(addr string) error {
 		return nil
 	}
 
-	ma, err := multiaddr.NewMultiaddr(addr)
+	h.bootstrapMu.Lock()
+	known := false
+	for _, a := range h.bootstrapAddrs {
+		known = known || a == addr
+	}
+	if !known {
+		h.bootstrapAddrs = append(h.bootstrapAddrs, addr)
+	}
+	h.bootstrapMu.Unlock()
+
+	return h.dialBootstrap(h.ctx, addr)
+}
+
+// dialBootstrap connects to a bootstrap peer as the reconnect retry policy
+// says. An address that doesn't parse is not retried.
+func (h *Host) dialBootstrap(ctx context.Context, addr string) error {
+	return h.retries.Reconnect.Do(ctx, func(ctx context.Context) error {
+		_, err := h.ConnectAddr(ctx, addr)
+		var addrErr *AddrError
+		if errors.As(err, &addrErr) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+}
+
+// Rediscover kicks discovery without dropping live connections: it closes
+// connections on addresses this machine no longer has, restarts mDNS on the
+// current interfaces, re-runs the DHT bootstrap, advertises the agent service
+// and served models again and redials the bootstrap peers. Only a failed DHT bootstrap is
+// returned as an error; advertise and dial failures are logged, since they
+// are expected while the network is still small. Without a DHT the bootstrap
+// and advertise steps are skipped.
+func (h *Host) Rediscover(ctx context.Context) error {
+	h.dropStaleConns()
+	h.restartMDNS()
+
+	if h.dht != nil {
+		if err := h.dht.Bootstrap(h.ctx); err != nil {
+			return fmt.Errorf("failed to bootstrap DHT: %w", err)
+		}
+
+		if _, err := h.discovery.Advertise(ctx, AgentServiceName); err != nil {
+			h.logger.Warn("Failed to advertise agent service", zap.Error(err))
+		}
+		h.readvertiseModels()
+	}
+
+	h.bootstrapMu.Lock()
+	addrs := append([]string(nil), h.bootstrapAddrs...)
+	h.bootstrapMu.Unlock()
+
+	for _, addr := range addrs {
+		if err := h.dialBootstrap(ctx, addr); err != nil {
+			h.logger.Warn("Failed to reconnect to bootstrap peer", zap.String("addr", addr), zap.Error(err))
+		}
+	}
+
+	h.logger.Info("Rediscovery complete", zap.Int("bootstrap_peers", len(addrs)))
+	return nil
+}
+
+// ConnectAddr dials a peer given its full multiaddr and returns its ID. IPv4,
+// IPv6 and DNS (/dns4, /dns6, /dnsaddr) addresses are supported; a /dnsaddr
+// naming several peers connects to all of them and returns the first.
+func (h *Host) ConnectAddr(ctx context.Context, addr string) (peer.ID, error) {
+	ma, err := ParsePeerAddr(addr)
 	if err != nil {
-		return fmt.Errorf("invalid bootstrap address: %w", err)
+		return "", err
 	}
 
-	pi, err := peer.AddrInfoFromP2pAddr(ma)
+	infos, err := resolvePeerAddr(ctx, ma)
 	if err != nil {
-		return fmt.Errorf("failed to parse bootstrap peer info: %w", err)
+		return "", err
 	}
 
-	return h.Connect(h.ctx, *pi)
+	var connected peer.ID
+	var lastErr error
+	for _, pi := range infos {
+		if err := h.Connect(ctx, pi); err != nil {
+			lastErr = err
+			continue
+		}
+		if connected == "" {
+			connected = pi.ID
+		}
+	}
+	if connected == "" {
+		return "", lastErr
+	}
+	return connected, nil
 }
 
 func (h *Host) GetPeers() []*PeerInfo {
 	h.peersMu.RLock()
 	defer h.peersMu.RUnlock()
 
+	now := time.Now()
 	peers := make([]*PeerInfo, 0, len(h.peers))
 	for _, p := range h.peers {
-		peers = append(peers, p)
+		info := *p
+		h.fillFlapsLocked(&info, now)
+		peers = append(peers, &info)
 	}
+
+	// Map iteration order is random; sort so listings are stable across calls.
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID < peers[j].ID
+	})
 	return peers
 }
 
+// GetPeer returns a snapshot of the tracked peer, if known.
+func (h *Host) GetPeer(peerID peer.ID) (*PeerInfo, bool) {
+	h.peersMu.RLock()
+	defer h.peersMu.RUnlock()
+
+	p, exists := h.peers[peerID]
+	if !exists {
+		return nil, false
+	}
+	info := *p
+	h.fillFlapsLocked(&info, time.Now())
+	return &info, true
+}
+
+// IsReachable reports whether a peer is connected or at least has known
+// addresses we could dial.
+func (h *Host) IsReachable(peerID peer.ID) bool {
+	if h.host.Network().Connectedness(peerID) == network.Connected {
+		return true
+	}
+	return len(h.host.Peerstore().Addrs(peerID)) > 0
+}
+
+// ConnectionType reports whether the live connection to a peer is direct or
+// goes through a circuit relay. It returns an empty string when not connected.
+func (h *Host) ConnectionType(peerID peer.ID) string {
+	conns := h.host.Network().ConnsToPeer(peerID)
+	if len(conns) == 0 {
+		return ""
+	}
+	for _, c := range conns {
+		if _, err := c.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT); err != nil {
+			return "direct"
+		}
+	}
+	return "relay"
+}
+
+// ConnectionAddr is the remote address of the connection used to reach a
+// peer: a direct one on our own subnet if there is one, then any direct one,
+// then a relayed one. It returns an empty string when not connected.
+func (h *Host) ConnectionAddr(peerID peer.ID) string {
+	conns := h.host.Network().ConnsToPeer(peerID)
+	if len(conns) == 0 {
+		return ""
+	}
+
+	subnets := localSubnets()
+	best, bestRank := conns[0].RemoteMultiaddr(), 3
+	for _, c := range conns {
+		addr := c.RemoteMultiaddr()
+		rank := 2
+		if _, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+			rank = 3
+		} else if onSubnet(addr, subnets) {
+			rank = 1
+		}
+		if rank < bestRank {
+			best, bestRank = addr, rank
+		}
+	}
+	return best.String()
+}
+
+func (h *Host) acquireStreamSlot(peerID peer.ID) bool {
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+
+	if h.maxStreamsPerPeer > 0 && h.activeStreams[peerID] >= h.maxStreamsPerPeer {
+		return false
+	}
+	h.activeStreams[peerID]++
+	return true
+}
+
+func (h *Host) releaseStreamSlot(peerID peer.ID) {
+	h.streamsMu.Lock()
+	defer h.streamsMu.Unlock()
+
+	if h.activeStreams[peerID] <= 1 {
+		delete(h.activeStreams, peerID)
+		return
+	}
+	h.activeStreams[peerID]--
+}
+
+func (h *Host) touchPeer(peerID peer.ID) {
+	h.peersMu.Lock()
+	defer h.peersMu.Unlock()
+
+	if p, exists := h.peers[peerID]; exists {
+		p.LastSeen = time.Now()
+	}
+}
+
+// goLoop runs fn in a goroutine that Close waits for. fn must return once
+// h.ctx is done.
+func (h *Host) goLoop(fn func()) {
+	h.loops.Add(1)
+	go func() {
+		defer h.loops.Done()
+		fn()
+	}()
+}
+
+// Close stops the host. Background loops get up to closeTimeout to notice
+// and exit before the DHT and network are torn down under them.
 func (h *Host) Close() error {
 	h.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		h.loops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		h.logger.Warn("Background loops did not exit in time, closing anyway", zap.Duration("timeout", closeTimeout))
+	}
+
+	h.mdnsMu.Lock()
+	if h.mdns != nil {
+		h.mdns.Close()
+	}
+	h.mdnsMu.Unlock()
 	if h.dht != nil {
 		h.dht.Close()
 	}
 	return h.host.Close()
 }
 
-func (h *Host) onPeerConnected(peerID peer.ID) {
+func (h *Host) onPeerConnected(peerID peer.ID, remoteAddr multiaddr.Multiaddr) {
+	state := h.liveState(peerID)
+
 	h.peersMu.Lock()
 	defer h.peersMu.Unlock()
 
-	if _, exists := h.peers[peerID]; !exists {
-		h.peers[peerID] = &PeerInfo{
-			ID:        peerID,
-			Connected: true,
-		}
-	} else {
-		h.peers[peerID].Connected = true
+	p, exists := h.peers[peerID]
+	if !exists {
+		p = &PeerInfo{ID: peerID, LearnedFrom: h.pex.learnedFrom[peerID]}
+		h.peers[peerID] = p
+	}
+	delete(h.pex.learnedFrom, peerID)
+	wasConnected := p.Connected
+	p.Connected = true
+	p.LastSeen = time.Now()
+	h.setAddrsLocked(p, remoteAddr)
+	// Another connection to a peer we already have may still change its
+	// state, e.g. a direct one replacing a relayed one.
+	h.setStateLocked(p, state)
+	if wasConnected {
+		return
 	}
 
-	h.logger.Info("Peer connected", zap.String("peer_id", peerID.String()))
+	if h.isRegisteredLocked(peerID) {
+		h.host.ConnManager().Protect(peerID, agentProtectTag)
+	}
+
+	h.logger.Info("Peer connected", h.PeerField("peer", peerID))
+	h.events.Publish(events.Event{Type: events.TypePeerConnected, PeerID: peerID.String()})
 }
 
 func (h *Host) onPeerDisconnected(peerID peer.ID) {
+	// The notifier fires per connection, and a peer often has several (IPv4,
+	// IPv6, relay). Only the last one going away disconnects the peer.
+	if h.host.Network().Connectedness(peerID) == network.Connected {
+		h.refreshState(peerID)
+		return
+	}
+
+	h.host.ConnManager().Unprotect(peerID, agentProtectTag)
+	h.forgetSessions(peerID)
+	if h.IsPinned(peerID) {
+		go h.reconnectPinned(peerID)
+	}
+
 	h.peersMu.Lock()
 	defer h.peersMu.Unlock()
 
-	if p, exists := h.peers[peerID]; exists {
-		p.Connected = false
+	p, exists := h.peers[peerID]
+	if !exists || !p.Connected {
+		return // the last connections closing at once each notify
 	}
-
-	h.logger.Info("Peer disconnected", zap.String("peer_id", peerID.String()))
+	p.Connected = false
+	p.LastSeen = time.Now()
+	h.setStateLocked(p, ConnStateDisconnected)
+	h.recordDisconnectLocked(peerID, p.LastSeen)
+	h.releaseNameLocked(peerID) // reclaimed by its registration if it comes back
+
+	h.logger.Info("Peer disconnected", h.PeerField("peer", peerID))
+	h.events.Publish(events.Event{Type: events.TypePeerDisconnected, PeerID: peerID.String()})
 }
 
 type mdnsNotifee struct {
@@ -263,6 +855,6 @@ func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
 	if pi.ID == n.host.host.ID() {
 		return
 	}
-	n.host.logger.Debug("Found peer via mDNS", zap.String("peer_id", pi.ID.String()))
+	n.host.logger.Debug("Found peer via mDNS", n.host.PeerField("peer", pi.ID))
 	n.host.Connect(n.host.ctx, pi)
 }
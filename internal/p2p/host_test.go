@@ -0,0 +1,223 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// newTestHost starts a host on a random port without a DHT, closed when the
+// test ends.
+func newTestHost(t *testing.T, opts Options) *Host {
+	t.Helper()
+	opts.NoDHT = true
+	h, err := NewHost(context.Background(), opts, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewHost: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// connectHosts connects a to b and waits until both have seen it.
+func connectHosts(t *testing.T, a, b *Host) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Connect(ctx, peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	for {
+		pa, okA := a.GetPeer(b.ID())
+		pb, okB := b.GetPeer(a.ID())
+		if okA && okB && pa.Connected && pb.Connected {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("hosts did not see each other connect")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestHostCreateClose(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "default"},
+		{name: "sessions and peer exchange", opts: Options{Sessions: true, PeerExchange: true}},
+		{name: "noise", opts: Options{Security: SecurityNoise}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.NoDHT = true
+			h, err := NewHost(context.Background(), opts, zap.NewNop())
+			if err != nil {
+				t.Fatalf("NewHost: %v", err)
+			}
+			h.StartPeerExchange()
+			if len(h.Addrs()) == 0 {
+				t.Error("host has no listen addresses")
+			}
+			if err := h.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewHostRejectsUnknownSecurity(t *testing.T) {
+	if _, err := NewHost(context.Background(), Options{Security: "plaintext", NoDHT: true}, zap.NewNop()); err == nil {
+		t.Fatal("NewHost accepted an unknown security transport")
+	}
+}
+
+func TestSendMessageAndClose(t *testing.T) {
+	for _, sessions := range []bool{false, true} {
+		a := newTestHost(t, Options{Sessions: sessions})
+		b := newTestHost(t, Options{})
+		b.SetMessageHandler(func(ctx context.Context, from peer.ID, msg *Message) (*Message, error) {
+			return &Message{Type: MessageTypePong, From: b.ID().String()}, nil
+		})
+		connectHosts(t, a, b)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		resp, err := a.SendMessage(ctx, b.ID(), &Message{Type: MessageTypePing, From: a.ID().String()})
+		cancel()
+		if err != nil {
+			t.Fatalf("sessions=%v: SendMessage: %v", sessions, err)
+		}
+		if resp == nil || resp.Type != MessageTypePong {
+			t.Fatalf("sessions=%v: got %+v, want a pong", sessions, resp)
+		}
+	}
+}
+
+func TestSendStream(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(b *Host)
+		want     []string // payloads of the frames passed on, all MessageTypeChunk
+		wantPeer bool     // fails with a *PeerError
+	}{
+		{
+			name: "chunks then complete",
+			setup: func(b *Host) {
+				b.SetStreamHandler(func(ctx context.Context, from peer.ID, msg *Message, send func(*Message) error) error {
+					for _, p := range []string{`"one"`, `"two"`} {
+						if err := send(&Message{Type: MessageTypeChunk, Payload: []byte(p)}); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+			},
+			want: []string{`"one"`, `"two"`},
+		},
+		{
+			name: "error after a chunk",
+			setup: func(b *Host) {
+				b.SetStreamHandler(func(ctx context.Context, from peer.ID, msg *Message, send func(*Message) error) error {
+					if err := send(&Message{Type: MessageTypeChunk, Payload: []byte(`"one"`)}); err != nil {
+						return err
+					}
+					return errors.New("upstream went away")
+				})
+			},
+			want:     []string{`"one"`},
+			wantPeer: true,
+		},
+		{
+			name: "no stream handler",
+			setup: func(b *Host) {
+				b.SetMessageHandler(func(ctx context.Context, from peer.ID, msg *Message) (*Message, error) {
+					return &Message{Type: MessageTypeComplete, Payload: []byte(`"whole"`)}, nil
+				})
+			},
+			want: []string{`"whole"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestHost(t, Options{})
+			b := newTestHost(t, Options{})
+			tt.setup(b)
+			connectHosts(t, a, b)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var got []string
+			err := a.SendStream(ctx, b.ID(), &Message{Type: MessageTypeChat, From: a.ID().String()}, func(frame *Message) error {
+				if frame.Type != MessageTypeChunk {
+					t.Errorf("frame of type %s passed on", frame.Type)
+				}
+				got = append(got, string(frame.Payload))
+				return nil
+			})
+
+			var peerErr *PeerError
+			if tt.wantPeer != errors.As(err, &peerErr) {
+				t.Fatalf("SendStream: %v", err)
+			}
+			if !tt.wantPeer && err != nil {
+				t.Fatalf("SendStream: %v", err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("got frames %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSendMessageRetry checks a stream that can't be opened is retried as
+// the send policy says, while a peer's error reply is not.
+func TestSendMessageRetry(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 3, BaseDelay: 50 * time.Millisecond}
+	a := newTestHost(t, Options{Retry: RetryPolicies{Send: policy}})
+
+	gone, err := NewHost(context.Background(), Options{NoDHT: true}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewHost: %v", err)
+	}
+	a.host.Peerstore().AddAddrs(gone.ID(), gone.Addrs(), time.Hour)
+	gone.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	started := time.Now()
+	_, err = a.SendMessage(ctx, gone.ID(), &Message{Type: MessageTypePing, From: a.ID().String()})
+	if !errors.Is(err, errOpenStream) {
+		t.Fatalf("sending to a closed host: %v, want a stream open failure", err)
+	}
+	// Two waits, of the base delay and then double it, less jitter (none).
+	if elapsed := time.Since(started); elapsed < 3*policy.BaseDelay {
+		t.Fatalf("gave up after %v, want at least %v of retries", elapsed, 3*policy.BaseDelay)
+	}
+
+	b := newTestHost(t, Options{})
+	var handled atomic.Int32
+	b.SetMessageHandler(func(ctx context.Context, from peer.ID, msg *Message) (*Message, error) {
+		handled.Add(1)
+		return nil, errors.New("refused")
+	})
+	connectHosts(t, a, b)
+
+	_, err = a.SendMessage(ctx, b.ID(), &Message{Type: MessageTypePing, From: a.ID().String()})
+	var peerErr *PeerError
+	if !errors.As(err, &peerErr) {
+		t.Fatalf("got %v, want the peer's error", err)
+	}
+	if got := handled.Load(); got != 1 {
+		t.Fatalf("peer handled the message %d times, want once", got)
+	}
+}
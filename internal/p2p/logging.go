@@ -0,0 +1,51 @@
+package p2p
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// How peer IDs appear in log lines.
+const (
+	LogPeerIDsShort = "short" // 12D3KooW…a1b2c3
+	LogPeerIDsFull  = "full"
+)
+
+// SetLogPeerIDs picks how peer IDs are written by PeerField, one of the
+// LogPeerIDs* constants. Anything else is treated as LogPeerIDsShort.
+func (h *Host) SetLogPeerIDs(mode string) {
+	h.fullPeerIDs.Store(mode == LogPeerIDsFull)
+}
+
+// PeerField is a log field naming a peer by its agent name, when it has
+// registered one, followed by its peer ID: "my-assistant(12D3KooW…a1b2c3)".
+// The name is looked up when the line is written, so it is safe to use while
+// holding the host's locks.
+func (h *Host) PeerField(key string, id peer.ID) zap.Field {
+	return zap.Stringer(key, peerLabel{host: h, id: id})
+}
+
+type peerLabel struct {
+	host *Host
+	id   peer.ID
+}
+
+func (l peerLabel) String() string {
+	id := l.id.String()
+	if !l.host.fullPeerIDs.Load() && len(id) > 16 {
+		id = id[:8] + "…" + id[len(id)-6:]
+	}
+
+	name := ""
+	if l.id == l.host.host.ID() {
+		name = l.host.localName
+	} else if v, ok := l.host.peerNames.Load(l.id); ok {
+		name = v.(string)
+	}
+	if name == "" {
+		return id
+	}
+	return fmt.Sprintf("%s(%s)", name, id)
+}
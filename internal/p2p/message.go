@@ -1,35 +1,75 @@
 package p2p
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"math/rand"
+	"sync"
+	"time"
 
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"go.uber.org/zap"
 )
 
+const (
+	broadcastSendTimeout = 10 * time.Second
+
+	// maxRawResponseLen caps how much of an unparseable response is quoted in
+	// the error.
+	maxRawResponseLen = 256
+)
+
+// ErrUnsupportedMessage is returned by message handlers for types they don't
+// handle. The sender gets an error reply carrying
+// ErrorCodeUnsupportedMessage, and errors.Is matches its *PeerError against
+// ErrUnsupportedMessage too.
+var ErrUnsupportedMessage = errors.New("unsupported message type")
+
+// errStreamIncomplete is returned by SendStream for a stream the peer closed
+// without ending it.
+var errStreamIncomplete = errors.New("stream closed before it was complete")
+
+// errOpenStream wraps failures to open a stream to a peer. Nothing has been
+// sent yet, so SendMessage retries them.
+var errOpenStream = errors.New("failed to open stream")
+
+// ErrorCodeUnsupportedMessage is the code of error replies to messages the
+// peer does not handle.
+const ErrorCodeUnsupportedMessage = "unsupported_message_type"
+
 type MessageType string
 
 const (
 	MessageTypeChat     MessageType = "chat"
+	MessageTypeChunk    MessageType = "chunk"
 	MessageTypeComplete MessageType = "complete"
 	MessageTypeRegister MessageType = "register"
 	MessageTypePing     MessageType = "ping"
 	MessageTypePong     MessageType = "pong"
 	MessageTypeError    MessageType = "error"
 	MessageTypeAnnounce MessageType = "announce"
+
+	MessageTypeReputation MessageType = "reputation"
+	MessageTypeTopology   MessageType = "topology"
 )
 
 type AnnouncePayload struct {
-	Type        string `json:"type"`        // repo, tool, skill, resource
-	Name        string `json:"name"`        // e.g. "agents-p2p-network"
-	URL         string `json:"url"`         // e.g. "https://github.com/denizumutdereli/agents-p2p-network"
-	Description string `json:"description"` // What it does
-	Tags        []string `json:"tags"`      // e.g. ["p2p", "ai", "agents", "openai"]
+	Type        string   `json:"type"`        // repo, tool, skill, resource
+	Name        string   `json:"name"`        // e.g. "agents-p2p-network"
+	URL         string   `json:"url"`         // e.g. "https://github.com/denizumutdereli/agents-p2p-network"
+	Description string   `json:"description"` // What it does
+	Tags        []string `json:"tags"`        // e.g. ["p2p", "ai", "agents", "openai"]
+
+	// ID, Origin and Hops let an announcement spread beyond the peers the
+	// origin sent it to: a receiver that hasn't seen ID yet re-broadcasts it
+	// with Hops decremented while Hops > 0.
+	ID     string `json:"id,omitempty"`
+	Origin string `json:"origin,omitempty"` // peer ID of the announcing agent
+	Hops   int    `json:"hops,omitempty"`
 }
 
 type Message struct {
@@ -38,6 +78,18 @@ type Message struct {
 	To        string          `json:"to,omitempty"`
 	RequestID string          `json:"request_id,omitempty"`
 	Payload   json.RawMessage `json:"payload"`
+
+	// Timestamp (Unix seconds) and Nonce are stamped on every send and
+	// checked by the receiver to reject replays.
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+
+	// Stream asks for the response as a sequence of MessageTypeChunk frames
+	// written to the same stream and ended by a MessageTypeComplete frame.
+	// On LegacyProtocolID every chunk is a MessageTypeComplete frame instead
+	// and the stream ends when the receiver closes it. Peers that predate
+	// streaming ignore it and answer with one frame.
+	Stream bool `json:"stream,omitempty"`
 }
 
 type ChatRequest struct {
@@ -65,82 +117,547 @@ type RegisterPayload struct {
 	AgentName string   `json:"agent_name"`
 	Endpoint  string   `json:"endpoint"`
 	Models    []string `json:"models"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// UpstreamHealthy is nil from agents that predate health reporting;
+	// treat that as healthy.
+	UpstreamHealthy *bool `json:"upstream_healthy,omitempty"`
+}
+
+// ReputationPayload is a peer's view of how reliable other agents have been
+// for it. Signature is the sender's identity-key signature over the payload
+// with Signature left empty.
+type ReputationPayload struct {
+	Reports   []ReputationReport `json:"reports"`
+	IssuedAt  int64              `json:"issued_at"` // Unix seconds
+	Signature []byte             `json:"signature,omitempty"`
+}
+
+type ReputationReport struct {
+	PeerID    string  `json:"peer_id"`
+	Score     float64 `json:"score"` // success rate, 0 to 1
+	LatencyMs int64   `json:"latency_ms"`
+	Samples   int     `json:"samples"`
+}
+
+// TopologyPayload carries the sender's neighbor list and those it has
+// learned from its own peers, for building a view of the network beyond
+// direct connections.
+type TopologyPayload struct {
+	Lists []NeighborList `json:"lists"`
+}
+
+// NeighborList is an agent's connected peers. Signature is the origin's
+// identity-key signature over the list with Hops and Signature left empty,
+// so relays can pass it on but not alter it. Hops is how far from the
+// sender the origin is, 0 for the sender's own list.
+type NeighborList struct {
+	PeerID    string            `json:"peer_id"`
+	Name      string            `json:"name"`
+	Models    []string          `json:"models,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Neighbors []string          `json:"neighbors"`
+	IssuedAt  int64             `json:"issued_at"` // Unix seconds
+	Hops      int               `json:"hops,omitempty"`
+	Signature []byte            `json:"signature,omitempty"`
 }
 
+// handleStream serves a one-shot ProtocolID stream, whose messages are
+// length-prefixed frames.
 func (h *Host) handleStream(s network.Stream) {
+	h.serveStream(newStreamConn(s, true))
+}
+
+// handleLegacyStream serves a one-shot LegacyProtocolID stream, for peers
+// that predate framing: the request is bare JSON read to EOF.
+func (h *Host) handleLegacyStream(s network.Stream) {
+	h.serveStream(newStreamConn(s, false))
+}
+
+func (h *Host) serveStream(conn *streamConn) {
+	s := conn.stream
 	defer s.Close()
 
-	reader := bufio.NewReader(s)
-	data, err := io.ReadAll(reader)
+	remote := s.Conn().RemotePeer()
+	if !h.acquireStreamSlot(remote) {
+		h.logger.Warn("Too many concurrent streams from peer, rejecting", h.PeerField("peer", remote))
+		h.writeMessage(conn, h.ErrorMessage("too many concurrent streams"))
+		return
+	}
+	defer h.releaseStreamSlot(remote)
+
+	msg, err := conn.read(h.maxMessageSize.Load())
+	if errors.Is(err, errMessageTooLarge) {
+		h.logger.Warn("Rejecting oversized message", h.PeerField("peer", remote), zap.Error(err))
+		h.writeMessage(conn, h.ErrorMessage(err.Error()))
+		return
+	}
 	if err != nil {
 		h.logger.Error("Failed to read stream", zap.Error(err))
 		return
 	}
+	if msg == nil {
+		return
+	}
 
-	var msg Message
-	if err := json.Unmarshal(data, &msg); err != nil {
-		h.logger.Error("Failed to unmarshal message", zap.Error(err))
+	if msg.Type == MessageTypeClock {
+		h.writeMessage(conn, h.clockReply())
+		return
+	}
+	if reject := h.admit(remote, msg); reject != nil {
+		h.writeMessage(conn, reject)
+		return
+	}
+
+	if msg.Stream && h.streamer != nil {
+		send := func(frame *Message) error {
+			if !conn.framed && frame.Type == MessageTypeChunk {
+				legacy := *frame
+				legacy.Type = MessageTypeComplete
+				frame = &legacy
+			}
+			return h.writeMessage(conn, frame)
+		}
+		if err := h.streamer(h.ctx, remote, msg, send); err != nil {
+			if !errors.Is(err, ErrUnsupportedMessage) {
+				h.logger.Error("Stream handler error", zap.Error(err))
+			}
+			reply := h.errorReply(err)
+			reply.RequestID = msg.RequestID
+			h.writeMessage(conn, reply)
+			return
+		}
+		if conn.framed {
+			h.writeMessage(conn, &Message{
+				Type:      MessageTypeComplete,
+				From:      h.host.ID().String(),
+				RequestID: msg.RequestID,
+			})
+		}
 		return
 	}
 
+	if response := h.dispatch(remote, msg); response != nil {
+		// Echo the RequestID as sessions do, so replies can be matched up.
+		if response.RequestID == "" {
+			response.RequestID = msg.RequestID
+		}
+		if err := h.writeMessage(conn, response); errors.Is(err, errMessageTooLarge) {
+			h.writeMessage(conn, h.ErrorMessage(fmt.Sprintf("response %v", err)))
+		}
+	}
+}
+
+// admit runs the checks every inbound message must pass, returning the error
+// reply for a rejected one.
+func (h *Host) admit(remote peer.ID, msg *Message) *Message {
+	// Reject misrouted or replayed messages meant for another node.
+	if msg.To != "" && msg.To != h.host.ID().String() {
+		h.logger.Warn("Rejecting message addressed to another peer",
+			h.PeerField("from", remote),
+			zap.String("to", msg.To),
+			zap.String("type", string(msg.Type)))
+		return h.ErrorMessage(fmt.Sprintf("message addressed to %s, not %s", msg.To, h.host.ID()))
+	}
+
+	if err := h.replay.check(remote, msg, h.clock.offset(remote)); err != nil {
+		h.logger.Warn("Rejecting replayed message",
+			h.PeerField("from", remote),
+			zap.String("type", string(msg.Type)),
+			zap.Error(err))
+		if errors.Is(err, errStale) {
+			// Likely a skewed clock rather than a replay; measure it so
+			// the peer's retry gets through.
+			go h.syncClock(remote)
+		}
+		return h.ErrorMessage(err.Error())
+	}
+
+	h.touchPeer(remote)
+	return nil
+}
+
+// dispatch returns the reply to an admitted message, if any. The host answers
+// peer exchange requests itself while peer exchange is enabled; everything
+// else goes to the message handler. A handler still running after the
+// handler timeout has its context cancelled and is abandoned, and the caller
+// gets an error reply instead of waiting on a stuck stream.
+func (h *Host) dispatch(remote peer.ID, msg *Message) *Message {
+	if msg.Type == MessageTypePeerExchange && h.pex.enabled {
+		return h.peerExchangeReply(remote)
+	}
 	if h.msgHandler == nil {
 		h.logger.Warn("No message handler set")
-		return
+		return h.handlerReply(nil, fmt.Errorf("%w: %s", ErrUnsupportedMessage, msg.Type))
 	}
 
-	response, err := h.msgHandler(h.ctx, s.Conn().RemotePeer(), &msg)
-	if err != nil {
-		h.logger.Error("Message handler error", zap.Error(err))
-		return
+	timeout := time.Duration(h.handlerTimeout.Load())
+	if timeout <= 0 {
+		return h.handlerReply(h.msgHandler(h.ctx, remote, msg))
 	}
 
-	if response != nil {
-		respData, err := json.Marshal(response)
-		if err != nil {
-			h.logger.Error("Failed to marshal response", zap.Error(err))
-			return
+	ctx, cancel := context.WithTimeout(h.ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		response *Message
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		response, err := h.msgHandler(ctx, remote, msg)
+		done <- result{response, err}
+	}()
+
+	select {
+	case r := <-done:
+		return h.handlerReply(r.response, r.err)
+	case <-ctx.Done():
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil
 		}
-		s.Write(respData)
+		h.logger.Warn("Message handler timed out",
+			h.PeerField("from", remote),
+			zap.String("type", string(msg.Type)),
+			zap.Duration("timeout", timeout))
+		return h.ErrorMessage(fmt.Sprintf("handler timed out after %s", timeout))
 	}
 }
 
+func (h *Host) handlerReply(response *Message, err error) *Message {
+	if err != nil {
+		if !errors.Is(err, ErrUnsupportedMessage) {
+			h.logger.Error("Message handler error", zap.Error(err))
+		}
+		return h.errorReply(err)
+	}
+	return response
+}
+
+// errorReply is the error reply for a failed handler, coded when the sender
+// can act on the reason.
+func (h *Host) errorReply(err error) *Message {
+	if errors.Is(err, ErrUnsupportedMessage) {
+		return h.codedErrorMessage(ErrorCodeUnsupportedMessage, err.Error())
+	}
+	return h.ErrorMessage(err.Error())
+}
+
+// writeMessage writes one response or stream frame. One over the message
+// size limit is not written; errMessageTooLarge is returned instead.
+func (h *Host) writeMessage(conn *streamConn, msg *Message) error {
+	err := conn.write(msg, h.maxMessageSize.Load())
+	switch {
+	case errors.Is(err, errMessageTooLarge):
+		h.logger.Warn("Response too large", zap.String("type", string(msg.Type)), zap.Error(err))
+	case err != nil:
+		h.logger.Debug("Failed to write response", zap.Error(err))
+	}
+	return err
+}
+
+// errorPayload is the payload of a MessageTypeError reply. Code is set for
+// errors the sender may want to tell apart.
+type errorPayload struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// ErrorMessage is a MessageTypeError reply from this host giving reason, for
+// handlers that answer a peer with an error instead of failing.
+func (h *Host) ErrorMessage(reason string) *Message {
+	return h.codedErrorMessage("", reason)
+}
+
+func (h *Host) codedErrorMessage(code, reason string) *Message {
+	payload, _ := json.Marshal(errorPayload{Error: reason, Code: code})
+	return &Message{
+		Type:    MessageTypeError,
+		From:    h.host.ID().String(),
+		Payload: payload,
+	}
+}
+
+// SendMessage sends msg and waits for the peer's response, which is nil if
+// the peer sent none. With sessions enabled the request goes over the peer's
+// persistent stream, falling back to a one-shot stream for older peers. A
+// stream that can't be opened is retried as the send retry policy says.
 func (h *Host) SendMessage(ctx context.Context, peerID peer.ID, msg *Message) (*Message, error) {
-	s, err := h.host.NewStream(ctx, peerID, ProtocolID)
+	var resp *Message
+	err := h.retries.Send.Do(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = h.sendMessage(ctx, peerID, msg)
+		if err != nil && !errors.Is(err, errOpenStream) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to open stream: %w", err)
+		return nil, err
 	}
-	defer s.Close()
+	return resp, nil
+}
+
+// sendMessage is one attempt at SendMessage.
+func (h *Host) sendMessage(ctx context.Context, peerID peer.ID, msg *Message) (*Message, error) {
+	if h.useSessions {
+		resp, err := h.sendViaSession(ctx, peerID, msg)
+		if !errors.Is(err, errSessionUnsupported) {
+			return resp, err
+		}
+	}
+
+	conn, err := h.send(ctx, peerID, msg)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.stream.Close()
+
+	response, err := conn.read(h.maxMessageSize.Load())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if response == nil {
+		return nil, nil
+	}
+
+	if response.Type == MessageTypeError {
+		return nil, peerError(peerID, response.Payload)
+	}
+
+	return response, nil
+}
+
+// SendStream sends msg as a streaming request and calls onFrame for each
+// frame the peer writes back, in order and as it arrives, until the
+// MessageTypeComplete frame that ends the stream. Chunks from
+// LegacyProtocolID peers are passed on as MessageTypeChunk frames too, and
+// their stream is complete when it closes. A MessageTypeError frame ends the
+// exchange with a *PeerError, and an error from onFrame aborts it. A stream
+// that is reset, or closed before it is complete, fails with an error. No
+// frame may be larger than the message size limit.
+func (h *Host) SendStream(ctx context.Context, peerID peer.ID, msg *Message, onFrame func(*Message) error) error {
+	out := *msg
+	out.Stream = true
+
+	conn, err := h.send(ctx, peerID, &out)
+	if err != nil {
+		return err
+	}
+	defer conn.stream.Close()
+
+	// A stream may stay open far longer than one response; reset it as soon
+	// as the caller gives up, deadline or not.
+	stop := context.AfterFunc(ctx, func() { conn.stream.Reset() })
+	defer stop()
+
+	limit := h.maxMessageSize.Load()
+	for {
+		frame, err := conn.next(limit)
+		if err == nil && frame == nil {
+			if conn.framed {
+				return errStreamIncomplete
+			}
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to read frame: %w", err)
+		}
 
-	data, err := json.Marshal(msg)
+		// A framed Complete ends the stream. One with a payload is the single
+		// response of a host that has no stream handler, and is the last
+		// chunk as well.
+		last := conn.framed && frame.Type == MessageTypeComplete
+		switch {
+		case frame.Type == MessageTypeError:
+			return peerError(peerID, frame.Payload)
+		case last && (len(frame.Payload) == 0 || string(frame.Payload) == "null"):
+			return nil
+		case frame.Type == MessageTypeComplete:
+			frame.Type = MessageTypeChunk
+		}
+		if err := onFrame(frame); err != nil || last {
+			return err
+		}
+	}
+}
+
+// send opens a stream to peerID, writes msg and closes the write side,
+// leaving the stream open for the response. ProtocolID is preferred, and
+// LegacyProtocolID used with peers that only speak that.
+func (h *Host) send(ctx context.Context, peerID peer.ID, msg *Message) (*streamConn, error) {
+	s, err := h.host.NewStream(ctx, peerID, ProtocolID, LegacyProtocolID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal message: %w", err)
+		return nil, fmt.Errorf("%w: %w", errOpenStream, err)
+	}
+	conn := newStreamConn(s, s.Protocol() == ProtocolID)
+
+	// Stream reads and writes don't watch ctx; carry its deadline over so a
+	// peer that never answers can't block the caller forever.
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
 	}
 
-	if _, err := s.Write(data); err != nil {
+	// Stamp a copy: Broadcast shares msg between concurrent sends, and every
+	// send needs its own nonce.
+	out := *msg
+	out.Timestamp = time.Now().Unix()
+	out.Nonce = newNonce()
+
+	if err := conn.write(&out, h.maxMessageSize.Load()); err != nil {
+		s.Reset()
+		if errors.Is(err, errMessageTooLarge) {
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to write message: %w", err)
 	}
 
 	s.CloseWrite()
+	return conn, nil
+}
 
-	reader := bufio.NewReader(s)
-	respData, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+// PeerError is an error reported by the remote peer in a MessageTypeError
+// response. Code is empty unless the peer sent one.
+type PeerError struct {
+	Peer   peer.ID
+	Reason string
+	Code   string
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("peer %s: %s", e.Peer, e.Reason)
+}
+
+// Is matches the errors the peer's code stands for.
+func (e *PeerError) Is(target error) bool {
+	return target == ErrUnsupportedMessage && e.Code == ErrorCodeUnsupportedMessage
+}
+
+func peerError(peerID peer.ID, payload json.RawMessage) *PeerError {
+	var body errorPayload
+	reason := string(payload)
+	if json.Unmarshal(payload, &body) == nil && body.Error != "" {
+		reason = body.Error
 	}
+	if reason == "" {
+		reason = "unknown error"
+	}
+	return &PeerError{Peer: peerID, Reason: reason, Code: body.Code}
+}
 
-	if len(respData) == 0 {
-		return nil, nil
+// BroadcastResult reports how a Broadcast went.
+type BroadcastResult struct {
+	Peers     int // connected peers the message was sent to
+	Delivered int
+	Failed    int
+}
+
+// Broadcast sends msg to every connected peer concurrently and waits for all
+// sends to finish. Each send is bounded by broadcastSendTimeout, so a hung peer
+// costs at most that long and never leaks its goroutine. Cancelling ctx
+// doesn't stop the sends; see SendToPeers.
+func (h *Host) Broadcast(ctx context.Context, msg *Message) BroadcastResult {
+	return h.BroadcastTo(ctx, msg, nil)
+}
+
+// BroadcastTo is Broadcast limited to the connected peers filter accepts. A
+// nil filter accepts every peer. filter is called without locks held, on a
+// copy of each peer's info. If more peers match than the broadcast fanout, a
+// random sample is sent to.
+func (h *Host) BroadcastTo(ctx context.Context, msg *Message, filter func(*PeerInfo) bool) BroadcastResult {
+	h.peersMu.RLock()
+	candidates := make([]PeerInfo, 0, len(h.peers))
+	for _, info := range h.peers {
+		if info.Connected {
+			candidates = append(candidates, *info)
+		}
+	}
+	h.peersMu.RUnlock()
+
+	peers := make([]peer.ID, 0, len(candidates))
+	for i := range candidates {
+		if filter == nil || filter(&candidates[i]) {
+			peers = append(peers, candidates[i].ID)
+		}
 	}
+	if fanout := int(h.broadcastFanout.Load()); fanout > 0 && len(peers) > fanout {
+		rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+		peers = peers[:fanout]
+	}
+
+	return h.SendToPeers(ctx, peers, msg, broadcastSendTimeout, func(pid peer.ID, err error) {
+		if err != nil {
+			h.logger.Debug("Failed to broadcast to peer", h.PeerField("peer", pid), zap.Error(err))
+		}
+	})
+}
+
+// SendToPeers sends msg to peers concurrently, each send bounded by timeout,
+// and waits for all of them. done, if set, is called with each peer's
+// result as it comes in. The fan-out is timed and counted in the broadcast
+// metrics and traced as one span with a child span per send.
+//
+// Sends are detached from ctx's cancellation, keeping only its values: a
+// broadcast made for an HTTP request would otherwise be cut off for the peers
+// not yet reached when the client hangs up. They end on their timeout or
+// when the host closes instead.
+func (h *Host) SendToPeers(ctx context.Context, peers []peer.ID, msg *Message, timeout time.Duration, done func(peer.ID, error)) BroadcastResult {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stop := context.AfterFunc(h.ctx, cancel)
+	defer stop()
+
+	started := time.Now()
+	ctx, span := startBroadcastSpan(ctx, msg, len(peers))
+
+	result := BroadcastResult{Peers: len(peers)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peerID := range peers {
+		wg.Add(1)
+		go func(pid peer.ID) {
+			defer wg.Done()
+			sendCtx, sendSpan := startSendSpan(ctx, pid)
+			sendCtx, cancel := context.WithTimeout(sendCtx, timeout)
+			defer cancel()
+
+			_, err := h.SendMessage(sendCtx, pid, msg)
+			endSpan(sendSpan, err)
+			if done != nil {
+				done(pid, err)
+			}
 
-	var response Message
-	if err := json.Unmarshal(respData, &response); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Failed++
+			} else {
+				result.Delivered++
+			}
+		}(peerID)
 	}
+	wg.Wait()
 
-	return &response, nil
+	h.observeBroadcast(span, msg, started, result)
+	return result
 }
 
-func (h *Host) Broadcast(ctx context.Context, msg *Message) error {
+// BroadcastCollect sends msg to every connected peer, fanout or not, under
+// one RequestID shared by all the sends, and gathers the replies by peer.
+// Each send is bounded by broadcastSendTimeout and the whole collection by
+// ctx: if ctx ends first, the replies received so far are returned with
+// ctx's error. Peers that fail, time out or send no reply are left out.
+// Unlike SendToPeers, the sends are cancelled with ctx.
+func (h *Host) BroadcastCollect(ctx context.Context, msg *Message) (map[peer.ID]*Message, error) {
+	out := *msg
+	if out.RequestID == "" {
+		out.RequestID = newNonce()
+	}
+
 	h.peersMu.RLock()
 	peers := make([]peer.ID, 0, len(h.peers))
 	for id, info := range h.peers {
@@ -150,13 +667,66 @@ func (h *Host) Broadcast(ctx context.Context, msg *Message) error {
 	}
 	h.peersMu.RUnlock()
 
+	started := time.Now()
+	ctx, span := startBroadcastSpan(ctx, &out, len(peers))
+
+	type reply struct {
+		peerID peer.ID
+		resp   *Message
+		err    error
+	}
+	replies := make(chan reply, len(peers))
 	for _, peerID := range peers {
 		go func(pid peer.ID) {
-			if _, err := h.SendMessage(ctx, pid, msg); err != nil {
-				h.logger.Debug("Failed to broadcast to peer", zap.String("peer", pid.String()), zap.Error(err))
+			sendCtx, sendSpan := startSendSpan(ctx, pid)
+			sendCtx, cancel := context.WithTimeout(sendCtx, broadcastSendTimeout)
+			defer cancel()
+
+			resp, err := h.SendMessage(sendCtx, pid, &out)
+			if err == nil && resp != nil && resp.RequestID != "" && resp.RequestID != out.RequestID {
+				err = fmt.Errorf("reply is for request %s, not %s", resp.RequestID, out.RequestID)
 			}
+			endSpan(sendSpan, err)
+			replies <- reply{peerID: pid, resp: resp, err: err}
 		}(peerID)
 	}
 
-	return nil
+	result := BroadcastResult{Peers: len(peers)}
+	responses := make(map[peer.ID]*Message, len(peers))
+	var err error
+	for pending := len(peers); pending > 0 && err == nil; pending-- {
+		select {
+		case r := <-replies:
+			if r.err != nil {
+				result.Failed++
+				h.logger.Debug("No reply to broadcast from peer", h.PeerField("peer", r.peerID), zap.Error(r.err))
+				continue
+			}
+			result.Delivered++
+			if r.resp != nil {
+				responses[r.peerID] = r.resp
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+			result.Failed += pending
+		}
+	}
+
+	h.observeBroadcast(span, &out, started, result)
+	return responses, err
+}
+
+// BroadcastTotals add up every fan-out since the host started.
+type BroadcastTotals struct {
+	Broadcasts int
+	Delivered  int // per-peer sends
+	Failed     int
+}
+
+func (h *Host) Broadcasts() BroadcastTotals {
+	return BroadcastTotals{
+		Broadcasts: int(h.broadcasts.Load()),
+		Delivered:  int(h.broadcastDelivered.Load()),
+		Failed:     int(h.broadcastFailed.Load()),
+	}
 }
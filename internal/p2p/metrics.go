@@ -0,0 +1,86 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Broadcast metrics, served on /metrics with the libp2p ones. They are
+// labelled by message type only, so they stay small on large networks.
+var (
+	broadcastsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "p2p_agent_broadcasts_total",
+		Help: "Broadcasts sent, by message type.",
+	}, []string{"type"})
+
+	broadcastSendsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "p2p_agent_broadcast_sends_total",
+		Help: "Per-peer sends made by broadcasts, by message type and result (delivered or failed).",
+	}, []string{"type", "result"})
+
+	broadcastDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "p2p_agent_broadcast_duration_seconds",
+		Help:    "Time for a broadcast to reach every peer it was sent to, by message type.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"type"})
+
+	broadcastPeers = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "p2p_agent_broadcast_peers",
+		Help:    "Peers a broadcast was sent to, by message type.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"type"})
+)
+
+// tracer records broadcast spans with the globally registered OpenTelemetry
+// provider, which is a no-op until a program embedding the host installs one.
+var tracer = otel.Tracer("github.com/denizumutdereli/agents-p2p-network/internal/p2p")
+
+// startBroadcastSpan starts the span covering a whole fan-out.
+func startBroadcastSpan(ctx context.Context, msg *Message, peers int) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "p2p.broadcast", trace.WithAttributes(
+		attribute.String("p2p.message_type", string(msg.Type)),
+		attribute.Int("p2p.peers", peers),
+	))
+}
+
+// startSendSpan starts the child span of one peer send in a fan-out.
+func startSendSpan(ctx context.Context, peerID peer.ID) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "p2p.send", trace.WithAttributes(
+		attribute.String("p2p.peer_id", peerID.String()),
+	))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (h *Host) observeBroadcast(span trace.Span, msg *Message, started time.Time, result BroadcastResult) {
+	h.broadcasts.Add(1)
+	h.broadcastDelivered.Add(int64(result.Delivered))
+	h.broadcastFailed.Add(int64(result.Failed))
+
+	msgType := string(msg.Type)
+	broadcastsTotal.WithLabelValues(msgType).Inc()
+	broadcastSendsTotal.WithLabelValues(msgType, "delivered").Add(float64(result.Delivered))
+	broadcastSendsTotal.WithLabelValues(msgType, "failed").Add(float64(result.Failed))
+	broadcastDuration.WithLabelValues(msgType).Observe(time.Since(started).Seconds())
+	broadcastPeers.WithLabelValues(msgType).Observe(float64(result.Peers))
+
+	span.SetAttributes(
+		attribute.Int("p2p.delivered", result.Delivered),
+		attribute.Int("p2p.failed", result.Failed),
+	)
+	span.End()
+}
@@ -0,0 +1,123 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// ModelRendezvousPrefix namespaces the per-model rendezvous strings agents
+// advertise in the DHT, e.g. "agent-network/model/gpt-4".
+const ModelRendezvousPrefix = "agent-network/model/"
+
+const (
+	// Models are re-advertised before their DHT records expire, at least
+	// every modelReadvertiseInterval, and after modelAdvertiseRetry when an
+	// advertisement failed.
+	modelReadvertiseInterval = time.Hour
+	modelAdvertiseRetry      = time.Minute
+)
+
+// ErrDHTDisabled is returned by DHT lookups on a host started without one.
+var ErrDHTDisabled = errors.New("DHT is disabled")
+
+func ModelRendezvous(model string) string {
+	return ModelRendezvousPrefix + model
+}
+
+// AdvertiseModel announces once in the DHT that this host serves model and
+// returns how long the announcement stays valid.
+func (h *Host) AdvertiseModel(ctx context.Context, model string) (time.Duration, error) {
+	if h.dht == nil {
+		return 0, ErrDHTDisabled
+	}
+	return h.discovery.Advertise(ctx, ModelRendezvous(model))
+}
+
+// FindModelProviders asks the DHT for up to limit other peers advertising
+// model.
+func (h *Host) FindModelProviders(ctx context.Context, model string, limit int) ([]peer.AddrInfo, error) {
+	if h.dht == nil {
+		return nil, ErrDHTDisabled
+	}
+
+	peerChan, err := h.discovery.FindPeers(ctx, ModelRendezvous(model), discovery.Limit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	var found []peer.AddrInfo
+	for p := range peerChan {
+		if p.ID == h.host.ID() || len(p.Addrs) == 0 {
+			continue
+		}
+		found = append(found, p)
+	}
+	return found, nil
+}
+
+// SetAdvertisedModels replaces the models this host keeps advertised in the
+// DHT. Advertising starts once the DHT is ready; records for models that are
+// dropped are not withdrawn but expire on their own. It does nothing when the
+// DHT is disabled.
+func (h *Host) SetAdvertisedModels(models []string) {
+	if h.dht == nil {
+		return
+	}
+
+	h.modelsMu.Lock()
+	h.models = append([]string(nil), models...)
+	start := !h.advertising
+	h.advertising = true
+	h.modelsMu.Unlock()
+
+	if start {
+		h.goLoop(h.advertiseModels)
+	} else {
+		h.readvertiseModels()
+	}
+}
+
+// readvertiseModels wakes the advertise loop without waiting for its timer.
+func (h *Host) readvertiseModels() {
+	select {
+	case h.modelsChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (h *Host) advertisedModels() []string {
+	h.modelsMu.Lock()
+	defer h.modelsMu.Unlock()
+	return append([]string(nil), h.models...)
+}
+
+func (h *Host) advertiseModels() {
+	if !h.waitForDHT() {
+		return
+	}
+
+	for {
+		wait := modelReadvertiseInterval
+		for _, model := range h.advertisedModels() {
+			ttl, err := h.AdvertiseModel(h.ctx, model)
+			if err != nil {
+				h.logger.Debug("Failed to advertise model", zap.String("model", model), zap.Error(err))
+				wait = min(wait, modelAdvertiseRetry)
+				continue
+			}
+			wait = min(wait, ttl*7/8)
+		}
+
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-h.modelsChanged:
+		case <-time.After(wait):
+		}
+	}
+}
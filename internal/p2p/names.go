@@ -0,0 +1,78 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrNameTaken is returned by ClaimName when another peer holds the name.
+var ErrNameTaken = errors.New("agent name is already taken")
+
+// ClaimName gives name to peerID, releasing any other name it held. Claiming
+// a name the peer already holds is a no-op, so registrations can be retried.
+// A peer only holds a name while it is connected: claims from peers that are
+// not fail, and disconnecting releases the name.
+func (h *Host) ClaimName(name string, peerID peer.ID) error {
+	h.peersMu.Lock()
+	defer h.peersMu.Unlock()
+
+	if p, exists := h.peers[peerID]; !exists || !p.Connected {
+		return fmt.Errorf("cannot give agent name '%s' to peer %s, it is not connected", name, peerID)
+	}
+	if owner, exists := h.agentNames[name]; exists && owner != peerID {
+		return fmt.Errorf("%w: '%s' belongs to peer %s", ErrNameTaken, name, owner.String()[:12])
+	}
+
+	h.releaseNameLocked(peerID)
+	h.agentNames[name] = peerID
+	h.peerNames.Store(peerID, name)
+	h.host.ConnManager().Protect(peerID, agentProtectTag)
+	return nil
+}
+
+// ReleaseName frees the name peerID holds, if any, and returns it. A name
+// claimed by another peer in the meantime is left alone.
+func (h *Host) ReleaseName(peerID peer.ID) string {
+	h.peersMu.Lock()
+	defer h.peersMu.Unlock()
+
+	name := h.releaseNameLocked(peerID)
+	if name != "" {
+		h.host.ConnManager().Unprotect(peerID, agentProtectTag)
+	}
+	return name
+}
+
+// releaseNameLocked frees peerID's name. The caller must hold peersMu.
+func (h *Host) releaseNameLocked(peerID peer.ID) string {
+	for name, owner := range h.agentNames {
+		if owner == peerID {
+			delete(h.agentNames, name)
+			return name
+		}
+	}
+	return ""
+}
+
+// isRegisteredLocked reports whether peerID owns a registered agent name. The
+// caller must hold peersMu.
+func (h *Host) isRegisteredLocked(peerID peer.ID) bool {
+	for _, owner := range h.agentNames {
+		if owner == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Host) IsNameTaken(name string) (bool, peer.ID) {
+	h.peersMu.RLock()
+	defer h.peersMu.RUnlock()
+
+	if peerID, exists := h.agentNames[name]; exists {
+		return true, peerID
+	}
+	return false, ""
+}
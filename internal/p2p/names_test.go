@@ -0,0 +1,116 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// fakePeers makes n peer IDs the host believes are connected, without any
+// real connection.
+func fakePeers(t *testing.T, h *Host, n int) []peer.ID {
+	t.Helper()
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/1")
+	ids := make([]peer.ID, n)
+	for i := range ids {
+		ids[i] = test.RandPeerIDFatal(t)
+		h.onPeerConnected(ids[i], addr)
+	}
+	return ids
+}
+
+func TestClaimNameConcurrent(t *testing.T) {
+	h := newTestHost(t, Options{})
+	peers := fakePeers(t, h, 16)
+
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, id := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = h.ClaimName("shared", id)
+		}()
+	}
+	wg.Wait()
+
+	var winner peer.ID
+	for i, err := range errs {
+		switch {
+		case err == nil && winner != "":
+			t.Fatalf("both %s and %s were given the name", winner, peers[i])
+		case err == nil:
+			winner = peers[i]
+		case !errors.Is(err, ErrNameTaken):
+			t.Fatalf("ClaimName: %v", err)
+		}
+	}
+	if winner == "" {
+		t.Fatal("nobody was given the name")
+	}
+	if taken, owner := h.IsNameTaken("shared"); !taken || owner != winner {
+		t.Fatalf("IsNameTaken = %v, %s; want true, %s", taken, owner, winner)
+	}
+}
+
+func TestClaimNameRequiresConnection(t *testing.T) {
+	h := newTestHost(t, Options{})
+	id := fakePeers(t, h, 1)[0]
+	h.onPeerDisconnected(id)
+
+	if err := h.ClaimName("gone", id); err == nil {
+		t.Fatal("a disconnected peer was given a name")
+	}
+}
+
+// TestPeerMapConcurrentUpdates churns connections, name claims and lookups
+// at once. Run with -race; afterwards every claimed name must belong to a
+// connected peer, as disconnecting releases it under the same lock.
+func TestPeerMapConcurrentUpdates(t *testing.T) {
+	h := newTestHost(t, Options{})
+	peers := fakePeers(t, h, 8)
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/1")
+
+	var wg sync.WaitGroup
+	for i, id := range peers {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				h.onPeerDisconnected(id)
+				h.onPeerConnected(id, addr)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				h.ClaimName(fmt.Sprintf("agent-%d", i%3), id)
+				if n%5 == 0 {
+					h.ReleaseName(id)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				h.GetPeers()
+				h.GetPeer(id)
+				h.IsNameTaken(fmt.Sprintf("agent-%d", n%3))
+			}
+		}()
+	}
+	wg.Wait()
+
+	h.peersMu.RLock()
+	defer h.peersMu.RUnlock()
+	for name, owner := range h.agentNames {
+		if p, ok := h.peers[owner]; !ok || !p.Connected {
+			t.Errorf("name %q belongs to %s, which is not connected", name, owner)
+		}
+	}
+}
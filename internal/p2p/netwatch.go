@@ -0,0 +1,131 @@
+package p2p
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.uber.org/zap"
+)
+
+const (
+	netWatchInterval = 5 * time.Second
+
+	// A tick that arrives this much later than scheduled means the machine
+	// was suspended; its connections are likely dead even if the addresses
+	// came back unchanged.
+	netWatchSleepGap = 30 * time.Second
+)
+
+// WatchNetwork calls onChange whenever this machine's interface addresses
+// change or it resumes from sleep, until the host closes. onChange is
+// expected to run Rediscover.
+func (h *Host) WatchNetwork(onChange func()) {
+	h.goLoop(func() {
+		ticker := time.NewTicker(netWatchInterval)
+		defer ticker.Stop()
+
+		last := interfaceIPs()
+		// Round(0) drops the monotonic reading, which stops while suspended.
+		lastTick := time.Now().Round(0)
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			now := time.Now().Round(0)
+			resumed := now.Sub(lastTick) > netWatchInterval+netWatchSleepGap
+			lastTick = now
+
+			current := interfaceIPs()
+			changed := !sameIPs(last, current)
+			last = current
+			if !changed && !resumed {
+				continue
+			}
+
+			h.logger.Info("Network change detected, rediscovering peers",
+				zap.Bool("resumed", resumed),
+				zap.Strings("addrs", sortedIPs(current)))
+			onChange()
+		}
+	})
+}
+
+// interfaceIPs is the set of this machine's interface addresses.
+func interfaceIPs() map[string]bool {
+	ips := make(map[string]bool)
+	for _, subnet := range localSubnets() {
+		ips[subnet.IP.String()] = true
+	}
+	return ips
+}
+
+func sameIPs(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for ip := range a {
+		if !b[ip] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedIPs(ips map[string]bool) []string {
+	out := make([]string, 0, len(ips))
+	for ip := range ips {
+		out = append(out, ip)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// dropStaleConns closes connections bound to a local address this machine no
+// longer has. They would otherwise linger until keep-alives time out, keeping
+// pinned peers from being re-dialled.
+func (h *Host) dropStaleConns() {
+	ips := interfaceIPs()
+	for _, conn := range h.host.Network().Conns() {
+		ip, err := manet.ToIP(conn.LocalMultiaddr())
+		if err != nil || ip.IsUnspecified() || ips[ip.String()] {
+			continue
+		}
+		h.logger.Info("Closing connection on vanished address",
+			h.PeerField("peer", conn.RemotePeer()),
+			zap.String("local_addr", conn.LocalMultiaddr().String()))
+		conn.Close()
+	}
+}
+
+// restartMDNS restarts mDNS, if it was started, so it announces on the
+// current interfaces. The service binds to the interfaces present when it
+// starts.
+func (h *Host) restartMDNS() {
+	h.mdnsMu.Lock()
+	defer h.mdnsMu.Unlock()
+
+	if !h.mdnsStarted {
+		return
+	}
+	if h.mdns != nil {
+		h.mdns.Close()
+		h.mdns = nil
+	}
+	if err := h.startMDNSLocked(); err != nil {
+		h.logger.Warn("Failed to restart mDNS discovery", zap.Error(err))
+	}
+}
+
+func (h *Host) startMDNSLocked() error {
+	service := mdns.NewMdnsService(h.host, AgentServiceName, &mdnsNotifee{host: h})
+	if err := service.Start(); err != nil {
+		return err
+	}
+	h.mdns = service
+	return nil
+}
@@ -0,0 +1,63 @@
+package p2p
+
+import (
+	"slices"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// setAddrsLocked replaces a tracked peer's addresses with those in the
+// peerstore plus extra, e.g. the address of the connection that just opened,
+// which an inbound peer has not necessarily told us it listens on. Addresses
+// it knew before and the peerstore has since dropped are kept while the peer
+// has no new ones. h.peersMu must be held.
+func (h *Host) setAddrsLocked(p *PeerInfo, extra ...multiaddr.Multiaddr) {
+	addrs := append(h.host.Peerstore().Addrs(p.ID), extra...)
+	if len(addrs) == 0 {
+		return
+	}
+	p.Addrs = orderAddrs(addrs)
+}
+
+// refreshAddrs re-reads a tracked peer's addresses from the peerstore.
+func (h *Host) refreshAddrs(peerID peer.ID) {
+	h.peersMu.Lock()
+	defer h.peersMu.Unlock()
+
+	if p, exists := h.peers[peerID]; exists {
+		h.setAddrsLocked(p)
+	}
+}
+
+// watchIdentify refreshes a peer's addresses whenever identify completes
+// with it, which is how we learn the addresses it listens on. Peers that
+// speak our protocol also get their clock measured.
+func (h *Host) watchIdentify() {
+	sub, err := h.host.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		h.logger.Warn("Failed to subscribe to identify events, peer addresses will not be refreshed", zap.Error(err))
+		return
+	}
+
+	h.goLoop(func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				evt := e.(event.EvtPeerIdentificationCompleted)
+				h.refreshAddrs(evt.Peer)
+				if slices.Contains(evt.Protocols, ProtocolID) || slices.Contains(evt.Protocols, LegacyProtocolID) {
+					go h.syncClock(evt.Peer)
+				}
+			}
+		}
+	})
+}
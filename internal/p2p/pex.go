@@ -0,0 +1,286 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// MessageTypePeerExchange asks a peer for the agents it is connected to. The
+// host answers it itself, so seed nodes take part too.
+const MessageTypePeerExchange MessageType = "peer_exchange"
+
+const (
+	pexInterval = time.Minute
+	pexTimeout  = 10 * time.Second
+
+	// Each round asks pexFanout random peers and dials at most pexMaxDials
+	// of the agents they name. Replies carry at most pexMaxPeers agents with
+	// pexMaxAddrs addresses each.
+	pexFanout   = 3
+	pexMaxDials = 8
+	pexMaxPeers = 32
+	pexMaxAddrs = 8
+
+	// A peer asking more often than pexMinInterval gets an empty reply.
+	pexMinInterval = 20 * time.Second
+
+	// Agents we failed to dial are not tried again for pexRetryInterval.
+	pexRetryInterval = 10 * time.Minute
+)
+
+// PeerExchangePayload is the reply to a MessageTypePeerExchange request.
+type PeerExchangePayload struct {
+	Peers []ExchangedPeer `json:"peers"`
+}
+
+type ExchangedPeer struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+// peerExchange is the state of peer exchange. learnedFrom records who told
+// us about agents being dialled, so their PeerInfo can say so once they
+// connect; it is guarded by Host.peersMu.
+type peerExchange struct {
+	enabled bool
+
+	mu     sync.Mutex
+	served map[peer.ID]time.Time // when each peer was last answered
+	failed map[peer.ID]time.Time // when dialling each learned agent last failed
+
+	learnedFrom map[peer.ID]peer.ID
+}
+
+func newPeerExchange(enabled bool) *peerExchange {
+	return &peerExchange{
+		enabled:     enabled,
+		served:      make(map[peer.ID]time.Time),
+		failed:      make(map[peer.ID]time.Time),
+		learnedFrom: make(map[peer.ID]peer.ID),
+	}
+}
+
+// allowServe reports whether from may be answered now, and marks it as
+// answered if so.
+func (x *peerExchange) allowServe(from peer.ID, now time.Time) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if last, ok := x.served[from]; ok && now.Sub(last) < pexMinInterval {
+		return false
+	}
+	x.served[from] = now
+	return true
+}
+
+func (x *peerExchange) recentlyFailed(peerID peer.ID, now time.Time) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	last, ok := x.failed[peerID]
+	return ok && now.Sub(last) < pexRetryInterval
+}
+
+func (x *peerExchange) recordFailure(peerID peer.ID, now time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.failed[peerID] = now
+}
+
+func (x *peerExchange) prune(now time.Time) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for id, last := range x.served {
+		if now.Sub(last) >= pexMinInterval {
+			delete(x.served, id)
+		}
+	}
+	for id, last := range x.failed {
+		if now.Sub(last) >= pexRetryInterval {
+			delete(x.failed, id)
+		}
+	}
+}
+
+// exchangePeers are the connected peers that speak our protocol, in random
+// order.
+func (h *Host) exchangePeers() []peer.ID {
+	var peers []peer.ID
+	for _, id := range h.host.Network().Peers() {
+		if supported, err := h.host.Peerstore().SupportsProtocols(id, ProtocolID, LegacyProtocolID); err == nil && len(supported) > 0 {
+			peers = append(peers, id)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	return peers
+}
+
+// peerExchangeReply lists up to pexMaxPeers agents we are connected to,
+// other than the one asking.
+func (h *Host) peerExchangeReply(from peer.ID) *Message {
+	var payload PeerExchangePayload
+	if h.pex.allowServe(from, time.Now()) {
+		for _, id := range h.exchangePeers() {
+			if len(payload.Peers) == pexMaxPeers {
+				break
+			}
+			if id == from {
+				continue
+			}
+			shared := ExchangedPeer{ID: id.String()}
+			for _, addr := range orderAddrs(h.host.Peerstore().Addrs(id)) {
+				if len(shared.Addrs) == pexMaxAddrs {
+					break
+				}
+				shared.Addrs = append(shared.Addrs, addr.String())
+			}
+			if len(shared.Addrs) > 0 {
+				payload.Peers = append(payload.Peers, shared)
+			}
+		}
+	}
+
+	data, _ := json.Marshal(payload)
+	return &Message{
+		Type:    MessageTypePong,
+		From:    h.host.ID().String(),
+		Payload: data,
+	}
+}
+
+// StartPeerExchange periodically asks a few connected peers for the agents
+// they know and dials the new ones, which helps the mesh converge where the
+// DHT is slow. It does nothing unless the host was created with
+// Options.PeerExchange.
+func (h *Host) StartPeerExchange() {
+	if !h.pex.enabled {
+		return
+	}
+
+	h.goLoop(func() {
+		ticker := time.NewTicker(pexInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case <-ticker.C:
+				h.pex.prune(time.Now())
+				h.exchangeRound()
+			}
+		}
+	})
+}
+
+// exchangeRound runs one round of peer exchange.
+func (h *Host) exchangeRound() {
+	peers := h.exchangePeers()
+	if len(peers) > pexFanout {
+		peers = peers[:pexFanout]
+	}
+
+	dials := 0
+	for _, from := range peers {
+		learned, err := h.requestPeers(from)
+		if err != nil {
+			h.logger.Debug("Peer exchange failed", h.PeerField("peer", from), zap.Error(err))
+			continue
+		}
+		for _, info := range learned {
+			if dials == pexMaxDials {
+				return
+			}
+			if h.dialLearned(from, info) {
+				dials++
+			}
+		}
+	}
+}
+
+func (h *Host) requestPeers(from peer.ID) ([]peer.AddrInfo, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, pexTimeout)
+	defer cancel()
+
+	resp, err := h.SendMessage(ctx, from, &Message{
+		Type: MessageTypePeerExchange,
+		From: h.host.ID().String(),
+	})
+	if err != nil || resp == nil {
+		return nil, err
+	}
+
+	var payload PeerExchangePayload
+	if err := json.Unmarshal(resp.Payload, &payload); err != nil {
+		return nil, err
+	}
+	if len(payload.Peers) > pexMaxPeers {
+		payload.Peers = payload.Peers[:pexMaxPeers]
+	}
+
+	var learned []peer.AddrInfo
+	for _, shared := range payload.Peers {
+		id, err := peer.Decode(shared.ID)
+		if err != nil || id == h.host.ID() {
+			continue
+		}
+		info := peer.AddrInfo{ID: id}
+		for _, raw := range shared.Addrs {
+			if len(info.Addrs) == pexMaxAddrs {
+				break
+			}
+			if addr, err := multiaddr.NewMultiaddr(raw); err == nil {
+				info.Addrs = append(info.Addrs, addr)
+			}
+		}
+		if len(info.Addrs) > 0 {
+			learned = append(learned, info)
+		}
+	}
+	return learned, nil
+}
+
+// dialLearned dials an agent from's reply named, unless the connection
+// gater refuses it, we are already connected, it is cooling down after
+// flapping or a recent dial failed. It reports whether it dialled.
+func (h *Host) dialLearned(from peer.ID, info peer.AddrInfo) bool {
+	if !h.gater.InterceptPeerDial(info.ID) {
+		return false
+	}
+	now := time.Now()
+	if h.host.Network().Connectedness(info.ID) == network.Connected || h.pex.recentlyFailed(info.ID, now) {
+		return false
+	}
+	if err := h.flapCooldown(info.ID); err != nil {
+		return false
+	}
+
+	h.peersMu.Lock()
+	if _, known := h.peers[info.ID]; !known {
+		h.pex.learnedFrom[info.ID] = from
+	}
+	h.peersMu.Unlock()
+
+	h.logger.Debug("Dialling peer learned via peer exchange",
+		h.PeerField("peer", info.ID),
+		h.PeerField("from", from))
+	ctx, cancel := context.WithTimeout(h.ctx, pexTimeout)
+	defer cancel()
+	if err := h.Connect(ctx, info); err != nil {
+		h.pex.recordFailure(info.ID, now)
+		h.peersMu.Lock()
+		delete(h.pex.learnedFrom, info.ID)
+		h.peersMu.Unlock()
+		h.logger.Debug("Failed to dial peer learned via peer exchange", h.PeerField("peer", info.ID), zap.Error(err))
+	}
+	return true
+}
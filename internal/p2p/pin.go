@@ -0,0 +1,145 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"go.uber.org/zap"
+)
+
+const (
+	// pinProtectTag keeps connections to pinned peers from being trimmed.
+	pinProtectTag = "pinned"
+
+	pinReconnectInterval = 5 * time.Second
+	pinDialTimeout       = 10 * time.Second
+)
+
+var errNoPinnedAddrs = errors.New("no known addresses")
+
+// ParsePinnedPeer accepts a bare peer ID or a full peer multiaddr, whose
+// addresses are then used to reach the peer.
+func ParsePinnedPeer(s string) (peer.AddrInfo, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "/") {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return peer.AddrInfo{}, &AddrError{Addr: s, Reason: "not a peer ID or multiaddr", Hint: "Use a peer ID (12D3KooW...) or e.g. " + ExamplePeerAddr}
+		}
+		return peer.AddrInfo{ID: id}, nil
+	}
+
+	ma, err := ParsePeerAddr(s)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	info, err := peer.AddrInfoFromP2pAddr(ma)
+	if err != nil {
+		return peer.AddrInfo{}, &AddrError{Addr: s, Reason: err.Error(), Hint: "Expected format: " + ExamplePeerAddr}
+	}
+	return *info, nil
+}
+
+// PinPeers marks peers as pinned: their connections are protected from the
+// connection manager and re-dialled whenever they drop.
+func (h *Host) PinPeers(infos []peer.AddrInfo) {
+	if len(infos) == 0 {
+		return
+	}
+
+	h.pinMu.Lock()
+	for _, info := range infos {
+		h.pinned[info.ID] = true
+		if len(info.Addrs) > 0 {
+			h.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.PermanentAddrTTL)
+		}
+		h.host.ConnManager().Protect(info.ID, pinProtectTag)
+	}
+	h.pinMu.Unlock()
+
+	h.goLoop(h.keepPinnedConnected)
+}
+
+func (h *Host) IsPinned(peerID peer.ID) bool {
+	h.pinMu.Lock()
+	defer h.pinMu.Unlock()
+	return h.pinned[peerID]
+}
+
+func (h *Host) pinnedPeers() []peer.ID {
+	h.pinMu.Lock()
+	defer h.pinMu.Unlock()
+
+	ids := make([]peer.ID, 0, len(h.pinned))
+	for id := range h.pinned {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// keepPinnedConnected dials every disconnected pinned peer straight away and
+// then every pinReconnectInterval until the host closes.
+func (h *Host) keepPinnedConnected() {
+	ticker := time.NewTicker(pinReconnectInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, id := range h.pinnedPeers() {
+			if h.host.Network().Connectedness(id) != network.Connected {
+				go h.reconnectPinned(id)
+			}
+		}
+
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconnectPinned dials a pinned peer at its known addresses, asking the DHT
+// (if enabled) for them if there are none, as the reconnect retry policy
+// says. Only one reconnect per peer runs at a time.
+func (h *Host) reconnectPinned(id peer.ID) {
+	h.pinMu.Lock()
+	if h.pinDialing[id] {
+		h.pinMu.Unlock()
+		return
+	}
+	h.pinDialing[id] = true
+	h.pinMu.Unlock()
+	defer func() {
+		h.pinMu.Lock()
+		delete(h.pinDialing, id)
+		h.pinMu.Unlock()
+	}()
+
+	err := h.retries.Reconnect.Do(h.ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, pinDialTimeout)
+		defer cancel()
+
+		info := peer.AddrInfo{ID: id, Addrs: h.host.Peerstore().Addrs(id)}
+		if len(info.Addrs) == 0 {
+			if h.dht == nil {
+				return retry.Permanent(errNoPinnedAddrs)
+			}
+			found, err := h.dht.FindPeer(ctx, id)
+			if err != nil {
+				return fmt.Errorf("not found in DHT: %w", err)
+			}
+			info = found
+		}
+		return h.Connect(ctx, info)
+	})
+	if err != nil {
+		h.logger.Debug("Failed to reconnect pinned peer", h.PeerField("peer", id), zap.Error(err))
+	}
+}
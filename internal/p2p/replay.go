@@ -0,0 +1,106 @@
+package p2p
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	DefaultReplayWindow = 2 * time.Minute
+
+	// noncesPerPeer bounds the remembered nonces for each peer.
+	noncesPerPeer = 1024
+)
+
+// errStale is returned by replayGuard.check for timestamps outside the window.
+var errStale = errors.New("message timestamp outside the replay window")
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// replayGuard rejects messages that are stale or whose nonce was already seen
+// from the same peer.
+type replayGuard struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[peer.ID]*nonceLRU
+}
+
+func newReplayGuard(window time.Duration) *replayGuard {
+	return &replayGuard{
+		window: window,
+		seen:   make(map[peer.ID]*nonceLRU),
+	}
+}
+
+// check returns an error if msg should be rejected as a replay. Messages from
+// agents that predate timestamps carry neither field and are let through.
+// offset is how far ahead of ours the sender's clock runs, and is taken off
+// the timestamp before its age is checked.
+func (g *replayGuard) check(from peer.ID, msg *Message, offset time.Duration) error {
+	if g == nil || g.window <= 0 || (msg.Timestamp == 0 && msg.Nonce == "") {
+		return nil
+	}
+
+	age := time.Since(time.Unix(msg.Timestamp, 0).Add(-offset))
+	if age > g.window || age < -g.window {
+		return fmt.Errorf("%w of %s (age %s)", errStale, g.window, age.Round(time.Second))
+	}
+	if msg.Nonce == "" {
+		return fmt.Errorf("message has a timestamp but no nonce")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	lru, exists := g.seen[from]
+	if !exists {
+		lru = newNonceLRU(noncesPerPeer)
+		g.seen[from] = lru
+	}
+	if !lru.add(msg.Nonce) {
+		return fmt.Errorf("message nonce already seen")
+	}
+	return nil
+}
+
+type nonceLRU struct {
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+func newNonceLRU(capacity int) *nonceLRU {
+	return &nonceLRU{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element, capacity),
+	}
+}
+
+// add records nonce and reports false if it was already present.
+func (l *nonceLRU) add(nonce string) bool {
+	if el, exists := l.items[nonce]; exists {
+		l.order.MoveToFront(el)
+		return false
+	}
+
+	l.items[nonce] = l.order.PushFront(nonce)
+	if l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(string))
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package p2p
+
+import (
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+)
+
+// RetryPolicies are how the host retries what fails. A policy left zero is
+// replaced by its default.
+type RetryPolicies struct {
+	// Send retries opening the stream for a message to a peer, broadcasts
+	// included. Once the message is written it is never sent again, since
+	// the peer may already have acted on it.
+	Send retry.Policy
+
+	// Session retries a request that met a session the peer had already
+	// closed, on a fresh one.
+	Session retry.Policy
+
+	// Reconnect retries dials to bootstrap and pinned peers.
+	Reconnect retry.Policy
+
+	// Flap is the cooldown before a flapping peer is redialled, growing with
+	// each disconnect past flapThreshold. Its attempts are unused.
+	Flap retry.Policy
+}
+
+var (
+	// DefaultSendRetry tries a second time after a quarter of a second.
+	DefaultSendRetry = retry.Policy{MaxAttempts: 2, BaseDelay: 250 * time.Millisecond, MaxDelay: 2 * time.Second, Jitter: 0.2}
+
+	// DefaultSessionRetry allows one immediate retry on a fresh session.
+	DefaultSessionRetry = retry.Policy{MaxAttempts: 2}
+
+	// DefaultReconnectRetry dials three times, from a second apart.
+	DefaultReconnectRetry = retry.Policy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second, Jitter: 0.2}
+
+	// DefaultFlapBackoff holds off for flapBaseCooldown, doubling up to
+	// flapMaxCooldown.
+	DefaultFlapBackoff = retry.Policy{BaseDelay: flapBaseCooldown, MaxDelay: flapMaxCooldown}
+)
+
+// withDefaults fills in the default for every zero policy.
+func (r RetryPolicies) withDefaults() RetryPolicies {
+	orDefault := func(p *retry.Policy, def retry.Policy) {
+		if *p == (retry.Policy{}) {
+			*p = def
+		}
+	}
+	orDefault(&r.Send, DefaultSendRetry)
+	orDefault(&r.Session, DefaultSessionRetry)
+	orDefault(&r.Reconnect, DefaultReconnectRetry)
+	orDefault(&r.Flap, DefaultFlapBackoff)
+	return r
+}
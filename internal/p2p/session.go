@@ -0,0 +1,296 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/denizumutdereli/agents-p2p-network/internal/retry"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multistream"
+	"go.uber.org/zap"
+)
+
+// SessionProtocolID carries many request/response pairs over one long-lived
+// stream per peer, as frames (see writeFrame); responses echo the request's
+// RequestID. A frame with an empty
+// Type means the request had no response.
+const SessionProtocolID = "/p2p-agent/session/1.0.0"
+
+var (
+	errSessionUnsupported = errors.New("peer does not support sessions")
+	errSessionClosed      = errors.New("session closed")
+)
+
+// session is the client side of a SessionProtocolID stream.
+type session struct {
+	stream  network.Stream
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *Message
+	err     error // why the session ended, nil while open
+}
+
+// sendViaSession is SendMessage over the peer's session, opening one if
+// needed. A session that turns out to be dead before the request was written
+// is replaced as the session retry policy says.
+func (h *Host) sendViaSession(ctx context.Context, peerID peer.ID, msg *Message) (*Message, error) {
+	out := *msg
+	out.Timestamp = time.Now().Unix()
+	out.Nonce = newNonce()
+	if out.RequestID == "" {
+		out.RequestID = newNonce()
+	}
+
+	var resp *Message
+	err := h.retries.Session.Do(ctx, func(ctx context.Context) error {
+		sess, err := h.session(ctx, peerID)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+
+		resp, err = sess.request(ctx, &out, h.maxMessageSize.Load())
+		if err != nil && !errors.Is(err, errSessionClosed) {
+			return retry.Permanent(err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Type == "" {
+		return nil, nil
+	}
+	if resp.Type == MessageTypeError {
+		return nil, peerError(peerID, resp.Payload)
+	}
+	return resp, nil
+}
+
+// session returns the open session to peerID, or opens one. It returns
+// errSessionUnsupported for peers that only speak the one-shot protocol.
+func (h *Host) session(ctx context.Context, peerID peer.ID) (*session, error) {
+	h.sessionsMu.Lock()
+	if sess, ok := h.sessions[peerID]; ok {
+		h.sessionsMu.Unlock()
+		return sess, nil
+	}
+	if h.noSession[peerID] {
+		h.sessionsMu.Unlock()
+		return nil, errSessionUnsupported
+	}
+	h.sessionsMu.Unlock()
+
+	stream, err := h.host.NewStream(ctx, peerID, protocol.ID(SessionProtocolID))
+	if err != nil {
+		var notSupported multistream.ErrNotSupported[protocol.ID]
+		if errors.As(err, &notSupported) {
+			h.sessionsMu.Lock()
+			h.noSession[peerID] = true
+			h.sessionsMu.Unlock()
+			h.logger.Debug("Peer does not support sessions, using one-shot streams", h.PeerField("peer", peerID))
+			return nil, errSessionUnsupported
+		}
+		return nil, fmt.Errorf("%w: %w", errOpenStream, err)
+	}
+
+	sess := &session{stream: stream, pending: make(map[string]chan *Message)}
+
+	h.sessionsMu.Lock()
+	if existing, ok := h.sessions[peerID]; ok {
+		// Another caller opened one first; use theirs.
+		h.sessionsMu.Unlock()
+		stream.Reset()
+		return existing, nil
+	}
+	h.sessions[peerID] = sess
+	h.sessionsMu.Unlock()
+
+	go h.readSession(peerID, sess)
+	return sess, nil
+}
+
+// readSession delivers response frames to their waiting requests until the
+// stream fails, then retires the session.
+func (h *Host) readSession(peerID peer.ID, sess *session) {
+	reader := bufio.NewReader(sess.stream)
+	for {
+		frame, err := readFrame(reader, h.maxMessageSize.Load())
+		if err == nil && frame.Type == MessageTypeError && frame.RequestID == "" {
+			// The peer is ending the session, having refused a frame.
+			err = peerError(peerID, frame.Payload)
+		}
+		if err != nil {
+			sess.close(err)
+			h.sessionsMu.Lock()
+			if h.sessions[peerID] == sess {
+				delete(h.sessions, peerID)
+			}
+			h.sessionsMu.Unlock()
+			h.logger.Debug("Session closed", h.PeerField("peer", peerID), zap.Error(err))
+			return
+		}
+
+		sess.mu.Lock()
+		if ch, ok := sess.pending[frame.RequestID]; ok {
+			delete(sess.pending, frame.RequestID)
+			ch <- frame
+		}
+		sess.mu.Unlock()
+	}
+}
+
+// forgetSessions drops what is known about peerID's session support, so a
+// reconnecting peer is probed again.
+func (h *Host) forgetSessions(peerID peer.ID) {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	delete(h.noSession, peerID)
+}
+
+func (s *session) request(ctx context.Context, msg *Message, limit int64) (*Message, error) {
+	ch := make(chan *Message, 1)
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, errSessionClosed
+	}
+	s.pending[msg.RequestID] = ch
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, msg.RequestID)
+		s.mu.Unlock()
+	}()
+
+	s.writeMu.Lock()
+	deadline, _ := ctx.Deadline()
+	s.stream.SetWriteDeadline(deadline)
+	err := writeFrame(s.stream, msg, limit)
+	s.writeMu.Unlock()
+	if errors.Is(err, errMessageTooLarge) {
+		return nil, err
+	}
+	if err != nil {
+		s.close(err)
+		return nil, fmt.Errorf("%w: %v", errSessionClosed, err)
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, fmt.Errorf("failed to read response: %v", s.closeErr())
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to read response: %w", ctx.Err())
+	}
+}
+
+// close ends the session, failing every request still waiting on it.
+func (s *session) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	for id, ch := range s.pending {
+		close(ch)
+		delete(s.pending, id)
+	}
+	s.stream.Reset()
+}
+
+func (s *session) closeErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// handleSession serves a peer's session stream. Requests are handled
+// concurrently, each taking a stream slot, and answered in completion order.
+// Streaming requests are not accepted here; they use one-shot streams.
+func (h *Host) handleSession(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+
+	var writeMu sync.Mutex
+	reply := func(requestID string, msg *Message) {
+		if msg == nil {
+			msg = &Message{}
+		}
+		msg.RequestID = requestID
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		err := writeFrame(s, msg, h.maxMessageSize.Load())
+		if errors.Is(err, errMessageTooLarge) {
+			h.logger.Warn("Session response too large", h.PeerField("peer", remote), zap.Error(err))
+			refusal := h.ErrorMessage(fmt.Sprintf("response %v", err))
+			refusal.RequestID = requestID
+			err = writeFrame(s, refusal, h.maxMessageSize.Load())
+		}
+		if err != nil {
+			h.logger.Debug("Failed to write session response", zap.Error(err))
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer s.Close()
+	defer wg.Wait()
+
+	reader := bufio.NewReader(s)
+	for {
+		msg, err := readFrame(reader, h.maxMessageSize.Load())
+		if errors.Is(err, errMessageTooLarge) {
+			// The frame can't be skipped without reading it, so the session
+			// ends; the error frame tells the peer why.
+			h.logger.Warn("Rejecting oversized session frame", h.PeerField("peer", remote), zap.Error(err))
+			reply("", h.ErrorMessage(err.Error()))
+			return
+		}
+		if err != nil {
+			if err != io.EOF {
+				h.logger.Debug("Session stream ended", h.PeerField("peer", remote), zap.Error(err))
+			}
+			return
+		}
+
+		if msg.Stream {
+			reply(msg.RequestID, h.ErrorMessage("streaming requests are not supported on sessions"))
+			continue
+		}
+		if !h.acquireStreamSlot(remote) {
+			h.logger.Warn("Too many concurrent streams from peer, rejecting", h.PeerField("peer", remote))
+			reply(msg.RequestID, h.ErrorMessage("too many concurrent streams"))
+			continue
+		}
+
+		wg.Add(1)
+		go func(msg *Message) {
+			defer wg.Done()
+			defer h.releaseStreamSlot(remote)
+
+			if msg.Type == MessageTypeClock {
+				reply(msg.RequestID, h.clockReply())
+				return
+			}
+			if reject := h.admit(remote, msg); reject != nil {
+				reply(msg.RequestID, reject)
+				return
+			}
+			reply(msg.RequestID, h.dispatch(remote, msg))
+		}(msg)
+	}
+}
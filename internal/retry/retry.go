@@ -0,0 +1,84 @@
+// Package retry runs operations again after failures, backing off
+// exponentially between attempts, so every subsystem that retries does so
+// the same way.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Policy says how often and how patiently an operation is retried. The wait
+// after the nth failure is BaseDelay doubled n-1 times, capped at MaxDelay
+// when that is set. Jitter, between 0 and 1, is the fraction of each wait
+// that is randomised, so peers failing together don't retry in lockstep.
+type Policy struct {
+	// MaxAttempts bounds the number of calls, the first included. Zero
+	// retries until the context ends.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// Delay is the wait after the given number of consecutive failures, counted
+// from 1.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < 1 {
+		failures = 1
+	}
+	delay := p.BaseDelay << min(failures-1, 16)
+	if p.MaxDelay > 0 {
+		delay = min(delay, p.MaxDelay)
+	}
+	if p.Jitter > 0 && delay > 0 {
+		spread := time.Duration(float64(delay) * min(p.Jitter, 1))
+		delay -= time.Duration(rand.Int63n(int64(spread) + 1))
+	}
+	return delay
+}
+
+// Do calls fn until it succeeds, returns an error marked Permanent, or the
+// policy's attempts run out, and returns fn's last error. If ctx ends while
+// waiting to retry, ctx's error is returned instead.
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(p.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying: Do returns it, unwrapped, at
+// once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
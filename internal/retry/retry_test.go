@@ -0,0 +1,144 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errFlaky = errors.New("flaky")
+
+func TestDelay(t *testing.T) {
+	p := Policy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: time.Second},
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 3, want: 4 * time.Second},
+		{failures: 4, want: 8 * time.Second},
+		{failures: 5, want: 10 * time.Second},
+		{failures: 100, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// Without a cap the doubling stops growing rather than overflowing.
+	uncapped := Policy{BaseDelay: time.Millisecond}
+	if got, want := uncapped.Delay(1000), time.Millisecond<<16; got != want {
+		t.Errorf("uncapped Delay(1000) = %v, want %v", got, want)
+	}
+}
+
+func TestDelayJitter(t *testing.T) {
+	p := Policy{BaseDelay: time.Second, MaxDelay: 4 * time.Second, Jitter: 0.25}
+	for failures := 1; failures <= 4; failures++ {
+		full := Policy{BaseDelay: p.BaseDelay, MaxDelay: p.MaxDelay}.Delay(failures)
+		for i := 0; i < 100; i++ {
+			got := p.Delay(failures)
+			if got > full || got < full-full/4 {
+				t.Fatalf("Delay(%d) = %v, want within [%v, %v]", failures, got, full-full/4, full)
+			}
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    Policy
+		failures  int // calls that fail before one succeeds
+		permanent bool
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "first call succeeds", policy: Policy{MaxAttempts: 3}, wantCalls: 1},
+		{name: "succeeds on a retry", policy: Policy{MaxAttempts: 3}, failures: 2, wantCalls: 3},
+		{name: "attempts run out", policy: Policy{MaxAttempts: 3}, failures: 5, wantCalls: 3, wantErr: true},
+		{name: "single attempt", policy: Policy{MaxAttempts: 1}, failures: 5, wantCalls: 1, wantErr: true},
+		{name: "unbounded", policy: Policy{}, failures: 7, wantCalls: 8},
+		{name: "permanent", policy: Policy{MaxAttempts: 3}, failures: 5, permanent: true, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := tt.policy.Do(context.Background(), func(ctx context.Context) error {
+				calls++
+				if calls > tt.failures {
+					return nil
+				}
+				if tt.permanent {
+					return Permanent(errFlaky)
+				}
+				return errFlaky
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, tt.wantCalls)
+			}
+			switch {
+			case tt.wantErr && err != errFlaky:
+				// Permanent errors come back unwrapped.
+				t.Errorf("Do returned %v, want %v", err, errFlaky)
+			case !tt.wantErr && err != nil:
+				t.Errorf("Do returned %v", err)
+			}
+		})
+	}
+}
+
+func TestDoBacksOff(t *testing.T) {
+	p := Policy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond}
+	var calls []time.Time
+	p.Do(context.Background(), func(ctx context.Context) error {
+		calls = append(calls, time.Now())
+		return errFlaky
+	})
+	if len(calls) != 3 {
+		t.Fatalf("fn called %d times, want 3", len(calls))
+	}
+	for i := 1; i < len(calls); i++ {
+		if waited, want := calls[i].Sub(calls[i-1]), p.Delay(i); waited < want {
+			t.Errorf("waited %v before attempt %d, want at least %v", waited, i+1, want)
+		}
+	}
+}
+
+func TestDoContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{BaseDelay: time.Hour}
+
+	calls := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Do(ctx, func(ctx context.Context) error {
+			calls++
+			return errFlaky
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Do returned %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Do kept waiting after the context ended")
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestPermanentNil(t *testing.T) {
+	if err := Permanent(nil); err != nil {
+		t.Fatalf("Permanent(nil) = %v, want nil", err)
+	}
+}